@@ -28,14 +28,19 @@ func FetchIdToken(ztsURL, svcKeyFile, svcCertFile, svcCACertFile, clientId, redi
 		return "", err
 	}
 
-	//the format of the location header is <redirect-uri>#id_token=<token>&state=<state>
+	return ExtractIdTokenFromLocation(location)
+}
+
+// ExtractIdTokenFromLocation extracts the id token from the location header
+// returned by ZTS which has the <redirect-uri>#id_token=<token>&state=<state> format
+func ExtractIdTokenFromLocation(location string) (string, error) {
 	idTokenLabel := "#id_token="
 	startIdx := strings.Index(location, idTokenLabel)
 	if startIdx == -1 {
 		return "", errors.New("location header does not contain id_token field")
 	}
 	idToken := location[startIdx+len(idTokenLabel):]
-	endIdx := strings.Index(idToken, "&state")
+	endIdx := strings.Index(idToken, "&")
 	if endIdx != -1 {
 		idToken = idToken[:endIdx]
 	}
@@ -52,7 +57,10 @@ func FetchIdTokenExpiryTime(idToken string) (*time.Time, error) {
 	if err != nil {
 		return nil, err
 	}
-	sec := claims["exp"].(float64)
+	sec, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("id token does not contain exp claim")
+	}
 	expiryTime := time.Unix(int64(sec), 0)
 	return &expiryTime, nil
 }
@@ -30,3 +30,27 @@ func TestGetK8SClientAuthCredential(test *testing.T) {
 		test.Errorf("did not receive expected output: %v", output)
 	}
 }
+
+func TestExtractIdTokenFromLocation(test *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		idToken  string
+		valid    bool
+	}{
+		{"token with state", "https://athenz.io#id_token=header.payload.signature&state=abc", "header.payload.signature", true},
+		{"token without state", "https://athenz.io#id_token=header.payload.signature", "header.payload.signature", true},
+		{"missing token", "https://athenz.io#state=abc", "", false},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			idToken, err := ExtractIdTokenFromLocation(tt.location)
+			if tt.valid != (err == nil) {
+				t.Errorf("unexpected error result: %v", err)
+			}
+			if idToken != tt.idToken {
+				t.Errorf("did not receive expected id token: %s", idToken)
+			}
+		})
+	}
+}
@@ -66,6 +66,12 @@ func tlsConfiguration(keypem, certpem, cacertpem []byte) (*tls.Config, error) {
 
 // GenerateAccessTokenRequestString generates and urlencodes an access token string.
 func GenerateAccessTokenRequestString(domain, service, roles, authzDetails, proxyPrincipalSpiffeUris string, expiryTime int) string {
+	return GenerateAccessTokenRequestStringExt(domain, service, roles, authzDetails, proxyPrincipalSpiffeUris, "", expiryTime)
+}
+
+// GenerateAccessTokenRequestStringExt generates and urlencodes an access token string
+// with the optional principal that the caller is requesting the access token for.
+func GenerateAccessTokenRequestStringExt(domain, service, roles, authzDetails, proxyPrincipalSpiffeUris, proxyForPrincipal string, expiryTime int) string {
 
 	params := url.Values{}
 	params.Add("grant_type", "client_credentials")
@@ -94,5 +100,8 @@ func GenerateAccessTokenRequestString(domain, service, roles, authzDetails, prox
 	if proxyPrincipalSpiffeUris != "" {
 		params.Add("proxy_principal_spiffe_uris", proxyPrincipalSpiffeUris)
 	}
+	if proxyForPrincipal != "" {
+		params.Add("proxy_for_principal", proxyForPrincipal)
+	}
 	return params.Encode()
 }
@@ -32,3 +32,26 @@ func TestGenerateAccessTokenRequestString(test *testing.T) {
 		})
 	}
 }
+
+func TestGenerateAccessTokenRequestStringExt(test *testing.T) {
+
+	tests := []struct {
+		name              string
+		domain            string
+		roles             string
+		proxyForPrincipal string
+		expiryTime        int
+		body              string
+	}{
+		{"no-proxy", "sports", "readers", "", 1200, "expires_in=1200&grant_type=client_credentials&scope=sports%3Arole.readers"},
+		{"proxy", "sports", "readers", "user.joe", 1200, "expires_in=1200&grant_type=client_credentials&proxy_for_principal=user.joe&scope=sports%3Arole.readers"},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			body := GenerateAccessTokenRequestStringExt(tt.domain, "", tt.roles, "", "", tt.proxyForPrincipal, tt.expiryTime)
+			if body != tt.body {
+				test.Errorf("invalid body response %s vs %s", body, tt.body)
+			}
+		})
+	}
+}
@@ -16,25 +16,75 @@
 
 package config
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/hook"
+)
 
 // Role models the configuration to be specified in sia_config
 type Role struct {
-	Service string   `json:"service,omitempty"`    // principal service with role access
-	Roles   []string `json:"roles,omitempty"`      // the roles in the domain in which principal is a member
-	Expiry  int      `json:"expires_in,omitempty"` // requested expiry time for access token in seconds
+	Service                  string          `json:"service,omitempty"`                     // principal service with role access
+	Roles                    []string        `json:"roles,omitempty"`                       // the roles in the domain in which principal is a member
+	Expiry                   int             `json:"expires_in,omitempty"`                  // requested expiry time for access token in seconds
+	AuthorizationDetails     json.RawMessage `json:"authorization_details,omitempty"`       // rich authorization details to be included in the access token
+	ProxyPrincipalSpiffeUris []string        `json:"proxy_principal_spiffe_uris,omitempty"` // spiffe uris of the proxy principals allowed to use the access token
+	IdTokenService           string          `json:"id_token_service,omitempty"`            // service in the domain for which an id token is requested along with the access token
+	ProxyForPrincipal        string          `json:"proxy_for_principal,omitempty"`         // principal for which the access token is requested
+	ExpiryThreshold          int             `json:"expiry_threshold,omitempty"`            // refresh the access token if it expires within the given number of minutes
+	Store                    string          `json:"store,omitempty"`                       // store the zts_response or only the access_token in the file
+	Hooks                    []hook.Hook     `json:"hooks,omitempty"`                       // hooks to execute after the access token is updated
 }
 
 // AccessToken is the type that holds information AFTER processing the configuration
 type AccessToken struct {
-	FileName string   // FileName under /var/lib/sia/tokens
-	Service  string   // Principal service that is a member of the roles
-	Domain   string   // Domain in which principal is a member of
-	Roles    []string // Roles under the Domain for which access tokens are being requested
-	User     string   // Owner of the access token file on disc
-	Uid      int      // Uid of the Owner of file on disc
-	Gid      int      // Gid of the file on disc
-	Expiry   int      // Expiry of the access token
+	FileName                 string             // FileName under /var/lib/sia/tokens
+	Service                  string             // Principal service that is a member of the roles
+	Domain                   string             // Domain in which principal is a member of
+	Roles                    []string           // Roles under the Domain for which access tokens are being requested
+	User                     string             // Owner of the access token file on disc
+	Uid                      int                // Uid of the Owner of file on disc
+	Gid                      int                // Gid of the file on disc
+	Expiry                   int                // Expiry of the access token
+	AuthorizationDetails     string             // Authorization details json to be included in the access token
+	ProxyPrincipalSpiffeUris []string           // Spiffe uris of the proxy principals allowed to use the access token
+	IdTokenService           string             // Service for which an id token is requested along with the access token
+	ProxyForPrincipal        string             // Principal for which the access token is requested
+	ExpiryThreshold          int                // Refresh threshold in minutes, overrides the TokenOptions value if set
+	StoreOptions             *StoreTokenOptions // Store token option, overrides the TokenOptions value if set
+	Hooks                    []hook.Hook        // Hooks to execute after the access token is updated
+}
+
+// IdTokenConfig models the id token configuration to be specified in sia_config
+type IdTokenConfig struct {
+	Service     string      `json:"service,omitempty"`      // principal service requesting the id token
+	ClientId    string      `json:"client_id,omitempty"`    // client id (audience) of the id token
+	RedirectUri string      `json:"redirect_uri,omitempty"` // redirect uri registered for the client id
+	Scope       string      `json:"scope,omitempty"`        // requested scope, defaults to openid
+	KeyType     string      `json:"key_type,omitempty"`     // signing key type - RSA or EC
+	FullArn     *bool       `json:"full_arn,omitempty"`     // include the full aws role arn in the groups claim
+	File        string      `json:"file,omitempty"`         // file where the id token is stored
+	User        string      `json:"user,omitempty"`         // owner of the id token file on disc
+	Group       string      `json:"group,omitempty"`        // group of the id token file on disc
+	Hooks       []hook.Hook `json:"hooks,omitempty"`        // hooks to execute after the id token is updated
+}
+
+// IdToken is the type that holds the id token information AFTER processing the configuration
+type IdToken struct {
+	Name        string      // Name of the id token in sia_config
+	FileName    string      // Absolute path of the file where the id token is stored
+	Service     string      // Principal service requesting the id token
+	ClientId    string      // Client id (audience) of the id token
+	RedirectUri string      // Redirect uri registered for the client id
+	Scope       string      // Requested scope
+	KeyType     string      // Signing key type
+	FullArn     *bool       // Include the full aws role arn in the groups claim
+	User        string      // Owner of the id token file on disc
+	Uid         int         // Uid of the Owner of file on disc
+	Gid         int         // Gid of the file on disc
+	Hooks       []hook.Hook // Hooks to execute after the id token is updated
 }
 
 type StoreTokenOptions int
@@ -44,12 +94,25 @@ const (
 	ACCESS_TOKEN_PROP                          // Store only the access_token property
 )
 
+// ParseStoreTokenOptions returns the store token option for the given
+// sia_config value: zts_response or access_token
+func ParseStoreTokenOptions(value string) (StoreTokenOptions, error) {
+	switch value {
+	case "zts_response":
+		return ZTS_RESPONSE, nil
+	case "access_token":
+		return ACCESS_TOKEN_PROP, nil
+	}
+	return ZTS_RESPONSE, fmt.Errorf("invalid store token option: %q", value)
+}
+
 // TokenOptions holds all the configurable options for driving Access Tokens functionality
 type TokenOptions struct {
 	Domain          string            // Domain of the instance
 	Services        []string          // Services set on the instance
 	TokenDir        string            // Directory where tokens will be saved, typically /var/lib/sia/tokens
 	Tokens          []AccessToken     // List of Access Tokens with their configuration
+	IdTokens        []IdToken         // List of ID Tokens with their configuration
 	CertDir         string            // Directory where certs can be found, typically /var/lib/sia/certs
 	KeyDir          string            // Directory where keys can be found, typically /var/lib/sia/keys
 	ZtsUrl          string            // ZTS endpoint
@@ -0,0 +1,161 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tokens
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/athenzutils"
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	siafile "github.com/AthenZ/athenz/libs/go/sia/file"
+	"github.com/AthenZ/athenz/libs/go/sia/hook"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// IdTokensToBeRefreshed returns the list of id tokens that either do not exist
+// or whose age is more than half of their validity
+func IdTokensToBeRefreshed(opts *config.TokenOptions) ([]config.IdToken, []error) {
+	return IdTokensToBeRefreshedBasedOnTime(opts, time.Now())
+}
+
+// IdTokensToBeRefreshedBasedOnTime returns the list of id tokens that either do not
+// exist or whose age is more than half of their validity (given the current time).
+// The id token is stored without its issue time so the modification time of the
+// file is used instead
+func IdTokensToBeRefreshedBasedOnTime(opts *config.TokenOptions, currentTime time.Time) ([]config.IdToken, []error) {
+	refresh := []config.IdToken{}
+	errs := []error{}
+	for _, t := range opts.IdTokens {
+		info, err := os.Stat(t.FileName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				refresh = append(refresh, t)
+			} else {
+				errs = append(errs, fmt.Errorf("unable to stat id token: %s, err: %v", t.FileName, err))
+			}
+			continue
+		}
+		content, err := os.ReadFile(t.FileName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("id token: %s exists, but could not read it, err: %v", t.FileName, err))
+			continue
+		}
+		expiry, err := athenzutils.FetchIdTokenExpiryTime(strings.TrimSpace(string(content)))
+		if err != nil {
+			// invalid token - refresh immediately
+			refresh = append(refresh, t)
+			continue
+		}
+		validityDuration := expiry.Sub(info.ModTime()).Minutes()
+		validityDurationRemaining := expiry.Sub(currentTime).Minutes()
+
+		// refresh the token if it has expired, if it has passed half of
+		// its validity or if it's within the configured threshold
+		if validityDurationRemaining <= 0 || (validityDurationRemaining*2) < validityDuration ||
+			(opts.ExpiryThreshold > 0 && validityDurationRemaining <= float64(opts.ExpiryThreshold)) {
+			refresh = append(refresh, t)
+		}
+	}
+	return refresh, errs
+}
+
+// IdTokenDirs returns an array of folders where the id tokens are stored
+func IdTokenDirs(tokens []config.IdToken) []string {
+	dirs := []string{}
+	for _, t := range tokens {
+		dirs = append(dirs, filepath.Dir(t.FileName))
+	}
+	return dirs
+}
+
+// fetchIdTokens fetches the id tokens that need to be refreshed from ZTS
+// and returns the list of files that were updated
+func fetchIdTokens(opts *config.TokenOptions, tlsConfigs map[string]*tls.Config) ([]string, []error) {
+	errs := []error{}
+	refreshed := []string{}
+
+	toRefresh, e := IdTokensToBeRefreshed(opts)
+	if len(e) != 0 {
+		errs = append(errs, e...)
+	}
+
+	for _, t := range toRefresh {
+		c, ok := tlsConfigs[t.Service]
+		if !ok {
+			err := fmt.Errorf("unable to find identity for principal: %q", t.Service)
+			status.Failure(status.IdToken, t.Name, t.FileName, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		client := zts.NewClient(opts.ZtsUrl, &http.Transport{
+			TLSClientConfig: c,
+		})
+		client.DisableRedirect = true
+		client.AddCredentials(UserAgent, opts.UserAgent)
+
+		idToken, expiry, err := fetchIdToken(&client, t)
+		if err != nil {
+			status.Failure(status.IdToken, t.Name, t.FileName, err)
+			errs = append(errs, fmt.Errorf("unable to fetch id token %s for client id: %q, err: %v", t.Name, t.ClientId, err))
+			continue
+		}
+
+		prevBytes, _ := os.ReadFile(t.FileName)
+		err = siafile.Update(t.FileName, []byte(idToken), t.Uid, t.Gid, 0440, nil)
+		if err != nil {
+			status.Failure(status.IdToken, t.Name, t.FileName, err)
+			errs = append(errs, fmt.Errorf("unable to write to file: %q for id token %s, err: %v", t.FileName, t.Name, err))
+			continue
+		}
+		refreshed = append(refreshed, t.FileName)
+		status.Success(status.IdToken, t.Name, t.FileName, expiry)
+		hook.RunOnChange(t.Name, t.FileName, prevBytes, t.Hooks)
+	}
+
+	return refreshed, errs
+}
+
+func fetchIdToken(client *zts.ZTSClient, t config.IdToken) (string, time.Time, error) {
+	nonce, err := util.Nonce()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	start := time.Now()
+	_, location, err := client.GetOIDCResponse("id_token", zts.ServiceName(t.ClientId), t.RedirectUri, t.Scope, "", zts.EntityName(nonce), zts.SimpleName(t.KeyType), t.FullArn)
+	status.ObserveZtsRequest("GetOIDCResponse", time.Since(start), err)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	idToken, err := athenzutils.ExtractIdTokenFromLocation(location)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiry, err := athenzutils.FetchIdTokenExpiryTime(idToken)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to parse id token: %v", err)
+	}
+	return idToken, *expiry, nil
+}
@@ -0,0 +1,168 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/dimfeld/httptreemux"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeIdToken(t *testing.T, key crypto.PrivateKey, audience string, issuedAt, expiry time.Time) string {
+	claims := &jwt.StandardClaims{
+		Audience:  audience,
+		ExpiresAt: expiry.Unix(),
+		IssuedAt:  issuedAt.Unix(),
+		Issuer:    "athenz",
+		Subject:   "iaas.athens.httpd",
+	}
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+	require.Nil(t, err)
+	return idToken
+}
+
+func TestIdTokensToBeRefreshed(t *testing.T) {
+	tokenDir := t.TempDir()
+	eckey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+
+	writeToken := func(name, content string, modTime time.Time) string {
+		fileName := filepath.Join(tokenDir, name)
+		require.Nil(t, os.WriteFile(fileName, []byte(content), 0400))
+		require.Nil(t, os.Chtimes(fileName, modTime, modTime))
+		return fileName
+	}
+
+	opts := &config.TokenOptions{
+		IdTokens: []config.IdToken{
+			// token doesn't exist
+			{Name: "missing", FileName: filepath.Join(tokenDir, "missing")},
+			// token exists and is valid
+			{Name: "valid", FileName: writeToken("valid", makeIdToken(t, eckey, "athenz.gcp", now, now.Add(time.Hour)), now)},
+			// token exists but has passed half of its validity
+			{Name: "aged", FileName: writeToken("aged", makeIdToken(t, eckey, "athenz.gcp", now.Add(-40*time.Minute), now.Add(20*time.Minute)), now.Add(-40*time.Minute))},
+			// token has expired
+			{Name: "expired", FileName: writeToken("expired", makeIdToken(t, eckey, "athenz.gcp", now.Add(-2*time.Hour), now.Add(-time.Hour)), now.Add(-2*time.Hour))},
+			// token cannot be parsed
+			{Name: "invalid", FileName: writeToken("invalid", "invalid-token", now)},
+		},
+	}
+
+	toRefresh, errs := IdTokensToBeRefreshedBasedOnTime(opts, now)
+	assert.Empty(t, errs)
+	var names []string
+	for _, token := range toRefresh {
+		names = append(names, token.Name)
+	}
+	assert.Equal(t, []string{"missing", "aged", "expired", "invalid"}, names)
+
+	// with the threshold the valid token must be refreshed as well
+	opts.ExpiryThreshold = 90
+	toRefresh, _ = IdTokensToBeRefreshedBasedOnTime(opts, now)
+	assert.Equal(t, 5, len(toRefresh))
+}
+
+func TestFetchIdTokens(t *testing.T) {
+	siaDir := t.TempDir()
+	eckey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var requests int32
+	ztsRouter := httptreemux.New()
+	ztsRouter.GET("/zts/v1/oauth2/auth", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		atomic.AddInt32(&requests, 1)
+		query := r.URL.Query()
+		if query.Get("response_type") != "id_token" || query.Get("scope") != "openid" || query.Get("nonce") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		idToken := makeIdToken(t, eckey, query.Get("client_id"), now, now.Add(time.Hour))
+		w.Header().Set("Location", query.Get("redirect_uri")+"#id_token="+idToken+"&state=")
+		w.WriteHeader(http.StatusFound)
+	})
+
+	ztsServer := &testServer{}
+	ztsServer.start(ztsRouter)
+	defer ztsServer.stop()
+
+	opts := &config.TokenOptions{
+		Domain:   "iaas.athens",
+		Services: []string{"httpd"},
+		CertDir:  filepath.Join(siaDir, "certs"),
+		KeyDir:   filepath.Join(siaDir, "keys"),
+		TokenDir: filepath.Join(siaDir, "tokens"),
+		IdTokens: []config.IdToken{
+			{Name: "gcp", FileName: filepath.Join(siaDir, "tokens", "id_tokens", "gcp"), Service: "httpd", ClientId: "athenz.gcp", RedirectUri: "https://gcp.athenz.io", Scope: "openid", Uid: uid(t), Gid: gid(t)},
+			{Name: "unknown", FileName: filepath.Join(siaDir, "tokens", "id_tokens", "unknown"), Service: "backend", ClientId: "athenz.gcp", RedirectUri: "https://gcp.athenz.io", Scope: "openid", Uid: uid(t), Gid: gid(t)},
+		},
+		ZtsUrl: ztsServer.baseUrl("zts/v1"),
+	}
+	makeSiaDirs(t, opts)
+	require.Nil(t, os.MkdirAll(filepath.Join(siaDir, "tokens", "id_tokens"), 0755))
+	makeIdentity(t, opts)
+
+	refreshed, errs := Fetch(opts)
+	assert.Equal(t, 1, len(errs), "id token for unknown service must fail, errs: %v", errs)
+	assert.Equal(t, []string{opts.IdTokens[0].FileName}, refreshed)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	content, err := os.ReadFile(opts.IdTokens[0].FileName)
+	require.Nil(t, err)
+	claims := &jwt.StandardClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(string(content), claims)
+	require.Nil(t, err)
+	assert.Equal(t, "athenz.gcp", claims.Audience)
+
+	// the id token is still valid so it must not be fetched again
+	refreshed, _ = Fetch(opts)
+	assert.Empty(t, refreshed)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestNewTokenOptionsWithIdTokens(t *testing.T) {
+	siaDir := t.TempDir()
+	opts := &options.Options{
+		Domain:    "iaas.athens",
+		Services:  []options.Service{{Name: "httpd"}},
+		CertDir:   filepath.Join(siaDir, "certs"),
+		KeyDir:    filepath.Join(siaDir, "keys"),
+		BackUpDir: filepath.Join(siaDir, "backup"),
+		TokenDir:  filepath.Join(siaDir, "tokens"),
+		IdTokens: []config.IdToken{
+			{Name: "gcp", FileName: filepath.Join(siaDir, "tokens", "id_tokens", "gcp")},
+		},
+	}
+	tokenOpts, err := NewTokenOptions(opts, "https://zts.athenz.io/zts/v1", "mock-ua")
+	require.Nil(t, err)
+	assert.Equal(t, opts.IdTokens, tokenOpts.IdTokens)
+	assert.Equal(t, 20*time.Minute, tokenOpts.TokenRefresh)
+	assert.DirExists(t, filepath.Join(siaDir, "tokens", "id_tokens"))
+}
@@ -22,19 +22,21 @@ import (
 	"fmt"
 	"gopkg.in/square/go-jose.v2/jwt"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/AthenZ/athenz/libs/go/sia/util"
 
 	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/athenzutils"
 	"github.com/AthenZ/athenz/libs/go/sia/access/config"
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
 	siafile "github.com/AthenZ/athenz/libs/go/sia/file"
 	"github.com/AthenZ/athenz/libs/go/sia/futil"
+	"github.com/AthenZ/athenz/libs/go/sia/hook"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
 	tlsconfig "github.com/AthenZ/athenz/libs/go/tls/config"
 )
 
@@ -42,6 +44,9 @@ const (
 	UserAgent              = "User-Agent"
 	TokenRefreshPeriodProp = "TOKEN_REFRESH_PERIOD"
 	DefaultRefreshDuration = "1.5h"
+	// id tokens are issued with 1 hour validity by default so they
+	// must be checked more often than the access tokens
+	DefaultIdTokenRefreshDuration = "20m"
 )
 
 // ToBeRefreshed looks into /var/lib/sia/tokens folder and returns the list of tokens whose
@@ -79,22 +84,26 @@ func ToBeRefreshedBasedOnTime(opts *config.TokenOptions, currentTime time.Time)
 				continue
 			}
 
-			// if the token is being stored without expiration property (i.e - not AccessTokenResponse
-			// but AccessTokenResponse::Access_token only), we should refresh immediately
-			if opts.StoreOptions != config.ZTS_RESPONSE {
-				refresh = append(refresh, t)
-				continue
-			}
-
 			atr := zts.AccessTokenResponse{}
-			err = json.Unmarshal(content, &atr)
-			if err != nil {
-				errs = append(errs, fmt.Errorf("token: %s not unmarshallable, err: %v", tpath, err))
-				continue
-			}
-			if atr.Expires_in == nil {
-				errs = append(errs, fmt.Errorf("invalid token: %s, expires_in key is not found", tpath))
-				continue
+			if storeOptions(opts, t) != config.ZTS_RESPONSE {
+				// if the token is being stored without expiration property (i.e - not AccessTokenResponse
+				// but AccessTokenResponse::Access_token only), we extract the expiry from the token
+				// itself and refresh immediately if the token cannot be parsed
+				err = json.Unmarshal(content, &atr.Access_token)
+				if err != nil {
+					refresh = append(refresh, t)
+					continue
+				}
+			} else {
+				err = json.Unmarshal(content, &atr)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("token: %s not unmarshallable, err: %v", tpath, err))
+					continue
+				}
+				if atr.Expires_in == nil {
+					errs = append(errs, fmt.Errorf("invalid token: %s, expires_in key is not found", tpath))
+					continue
+				}
 			}
 			//  Extract expiration claim from token and compare with AccessTokenResponse
 			claims, err := GetClaimsFromAccessTokenUnverified(atr)
@@ -110,13 +119,15 @@ func ToBeRefreshedBasedOnTime(opts *config.TokenOptions, currentTime time.Time)
 				continue
 			}
 
-			// If the token age is more than half of token's total validity, refresh the token
-			if (validityDurationRemaining * 2) < validityDuration {
-				refresh = append(refresh, t)
-			}
+			// If the token age is more than half of token's total validity, refresh the token.
 			// if we have a threshold specified, also verify that the token is valid for
 			// the given number of minutes
-			if opts.ExpiryThreshold > 0 && validityDurationRemaining <= float64(opts.ExpiryThreshold) {
+			expiryThreshold := opts.ExpiryThreshold
+			if t.ExpiryThreshold > 0 {
+				expiryThreshold = t.ExpiryThreshold
+			}
+			if (validityDurationRemaining*2) < validityDuration ||
+				(expiryThreshold > 0 && validityDurationRemaining <= float64(expiryThreshold)) {
 				refresh = append(refresh, t)
 			}
 		}
@@ -140,9 +151,14 @@ func Fetch(opts *config.TokenOptions) ([]string, []error) {
 	}
 
 	for _, t := range toRefresh {
+		tokenName := fmt.Sprintf("%s/%s", t.Domain, t.FileName)
+		fileName := filepath.Join(opts.TokenDir, t.Domain, t.FileName)
+
 		c, ok := tlsConfigs[t.Service]
 		if !ok {
-			errs = append(errs, fmt.Errorf("unable to find identity for principal: %q", t.Service))
+			err := fmt.Errorf("unable to find identity for principal: %q", t.Service)
+			status.Failure(status.AccessToken, tokenName, fileName, err)
+			errs = append(errs, err)
 			continue
 		}
 
@@ -151,38 +167,50 @@ func Fetch(opts *config.TokenOptions) ([]string, []error) {
 		}
 		client.AddCredentials(UserAgent, opts.UserAgent)
 
-		res, err := client.PostAccessTokenRequest(zts.AccessTokenRequest(makeTokenRequest(t.Domain, t.Roles, t.Expiry)))
+		start := time.Now()
+		res, err := client.PostAccessTokenRequest(zts.AccessTokenRequest(makeTokenRequest(t)))
+		status.ObserveZtsRequest("PostAccessTokenRequest", time.Since(start), err)
 		if err != nil {
+			status.Failure(status.AccessToken, tokenName, fileName, err)
 			errs = append(errs, fmt.Errorf("unable to post access token request for domain: %q, roles: %v, err: %v", t.Domain, t.Roles, err))
 			continue
 		}
 
-		fileName := filepath.Join(opts.TokenDir, t.Domain, t.FileName)
-
-		tokenBytesToStore := func(res *zts.AccessTokenResponse, opts *config.TokenOptions) ([]byte, error) {
-			if opts.StoreOptions == config.ZTS_RESPONSE {
+		tokenBytesToStore := func(res *zts.AccessTokenResponse, store config.StoreTokenOptions) ([]byte, error) {
+			if store == config.ZTS_RESPONSE {
 				return json.Marshal(res)
 			} else {
 				return json.Marshal(res.Access_token)
 			}
 		}
 
-		bytes, err := tokenBytesToStore(res, opts)
+		bytes, err := tokenBytesToStore(res, storeOptions(opts, t))
 
 		if err != nil {
+			status.Failure(status.AccessToken, tokenName, fileName, err)
 			// note: since it was just unmarshalled into AccessTokenResponse by the ZTS client library, re-marshalling should never be an issue
 			errs = append(errs, fmt.Errorf("unable to marshall the token response for domain: %q, roles: %v, response: %v, err: %v", t.Domain, t.Roles, res, err))
 			continue
 		}
+		prevBytes, _ := os.ReadFile(fileName)
 		err = siafile.Update(fileName, bytes, t.Uid, t.Gid, 0440, nil)
 		if err != nil {
+			status.Failure(status.AccessToken, tokenName, fileName, err)
 			errs = append(errs, fmt.Errorf("unable to write to file: %q for access token request for domain: %q, roles: %v, err: %v", fileName, t.Domain, t.Roles, err))
 			continue
 		} else {
 			refreshed = append(refreshed, fileName)
+			status.Success(status.AccessToken, tokenName, fileName, tokenExpiry(res, start))
+			hook.RunOnChange(tokenName, fileName, prevBytes, t.Hooks)
 		}
 	}
 
+	idTokensRefreshed, e := fetchIdTokens(opts, tlsConfigs)
+	if len(e) != 0 {
+		errs = append(errs, e...)
+	}
+	refreshed = append(refreshed, idTokensRefreshed...)
+
 	return refreshed, errs
 }
 
@@ -204,6 +232,15 @@ func loadSvcCerts(opts *config.TokenOptions) (map[string]*tls.Config, []error) {
 	return configs, errors
 }
 
+// tokenExpiry returns the expiry of the access token based on the
+// expires_in value relative to the time the request was issued
+func tokenExpiry(res *zts.AccessTokenResponse, issued time.Time) time.Time {
+	if res == nil || res.Expires_in == nil {
+		return time.Time{}
+	}
+	return issued.Add(time.Duration(*res.Expires_in) * time.Second)
+}
+
 // TokenDirs returns an array of token folders with domain in them
 func TokenDirs(root string, tokens []config.AccessToken) []string {
 	dirs := []string{}
@@ -213,39 +250,43 @@ func TokenDirs(root string, tokens []config.AccessToken) []string {
 	return dirs
 }
 
-func makeTokenRequest(domain string, roles []string, expiryTime int) string {
-	params := url.Values{}
-	params.Add("grant_type", "client_credentials")
-	params.Add("expires_in", strconv.Itoa(expiryTime))
-
-	var scope string
-	if roles[0] == "*" {
-		scope = domain + ":domain"
-	} else {
-		for idx, role := range roles {
-			if idx != 0 {
-				scope += " "
-			}
-			scope += domain + ":role." + role
-		}
+// storeOptions returns the store option for the given access token
+// with the value configured for the token overriding the global setting
+func storeOptions(opts *config.TokenOptions, t config.AccessToken) config.StoreTokenOptions {
+	if t.StoreOptions != nil {
+		return *t.StoreOptions
 	}
-	params.Add("scope", scope)
-	return params.Encode()
+	return opts.StoreOptions
+}
+
+func makeTokenRequest(t config.AccessToken) string {
+	// the domain scope is requested if the roles are not specified
+	var roles string
+	if len(t.Roles) != 0 && t.Roles[0] != "*" {
+		roles = strings.Join(t.Roles, ",")
+	}
+	return athenzutils.GenerateAccessTokenRequestStringExt(t.Domain, t.IdTokenService, roles, t.AuthorizationDetails,
+		strings.Join(t.ProxyPrincipalSpiffeUris, ","), t.ProxyForPrincipal, t.Expiry)
 }
 
 func NewTokenOptions(options *options.Options, ztsUrl string, userAgent string) (*config.TokenOptions, error) {
-	if options.AccessTokens == nil {
+	if options.AccessTokens == nil && options.IdTokens == nil {
 		return nil, fmt.Errorf("access-token object is not presented")
 	}
 	dirs := []string{options.CertDir, options.KeyDir, options.BackUpDir}
 	dirs = append(dirs, TokenDirs(options.TokenDir, options.AccessTokens)...)
+	dirs = append(dirs, IdTokenDirs(options.IdTokens)...)
 
 	err := futil.MakeDirs(dirs, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create access-token directories, err: %v", err)
 	}
 
-	tokenRefreshPeriod := util.EnvOrDefault(TokenRefreshPeriodProp, DefaultRefreshDuration)
+	defaultRefreshDuration := DefaultRefreshDuration
+	if len(options.IdTokens) != 0 {
+		defaultRefreshDuration = DefaultIdTokenRefreshDuration
+	}
+	tokenRefreshPeriod := util.EnvOrDefault(TokenRefreshPeriodProp, defaultRefreshDuration)
 	tokenRefresh, err := time.ParseDuration(tokenRefreshPeriod)
 	if err != nil {
 		return nil, fmt.Errorf("invalid token refresh period %q, %v", tokenRefreshPeriod, err)
@@ -256,6 +297,7 @@ func NewTokenOptions(options *options.Options, ztsUrl string, userAgent string)
 		Services:        toServiceNames(options.Services),
 		TokenDir:        options.TokenDir,
 		Tokens:          options.AccessTokens,
+		IdTokens:        options.IdTokens,
 		CertDir:         options.CertDir,
 		KeyDir:          options.KeyDir,
 		ZtsUrl:          ztsUrl,
@@ -43,7 +43,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
 
 	"github.com/AthenZ/athenz/clients/go/zts"
 	"github.com/AthenZ/athenz/libs/go/sia/access/config"
@@ -222,6 +222,79 @@ func TestToBeRefreshedWithStoreOption(t *testing.T) {
 	assert.True(t, len(toRefresh) == 1, fmt.Sprint("reader-aged token should be refreshed"))
 }
 
+func TestToBeRefreshedWithTokenOptions(t *testing.T) {
+	tokenDir := t.TempDir()
+	currentUnixTime := time.Now().Unix()
+	domain := "athenz.examples"
+	accessTokenProp := config.ACCESS_TOKEN_PROP
+	ztsResponse := config.ZTS_RESPONSE
+
+	tokens := []config.AccessToken{
+		// valid token stored as full response
+		{FileName: "reader", Domain: domain},
+		// valid token stored as full response with a threshold larger than its validity
+		{FileName: "reader-threshold", Domain: domain, ExpiryThreshold: 120},
+		// valid bare access token
+		{FileName: "reader-bare", Domain: domain, StoreOptions: &accessTokenProp},
+		// bare access token configured to be stored as full response
+		{FileName: "reader-response", Domain: domain, StoreOptions: &ztsResponse},
+	}
+
+	domainDir := filepath.Join(tokenDir, domain)
+	require.Nil(t, os.Mkdir(domainDir, 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(domainDir, "reader"), token(3600, currentUnixTime), 0400))
+	require.Nil(t, os.WriteFile(filepath.Join(domainDir, "reader-threshold"), token(3600, currentUnixTime), 0400))
+
+	atr := zts.AccessTokenResponse{}
+	require.Nil(t, json.Unmarshal(token(3600, currentUnixTime), &atr))
+	bareToken, _ := json.Marshal(atr.Access_token)
+	require.Nil(t, os.WriteFile(filepath.Join(domainDir, "reader-bare"), bareToken, 0400))
+	require.Nil(t, os.WriteFile(filepath.Join(domainDir, "reader-response"), bareToken, 0400))
+
+	opts := config.TokenOptions{
+		TokenDir:     tokenDir,
+		Tokens:       tokens,
+		StoreOptions: config.ACCESS_TOKEN_PROP,
+	}
+	toRefresh, errs := ToBeRefreshedBasedOnTime(&opts, time.Now())
+	assert.Equal(t, 1, len(errs), "bare token must not be parsed as full response")
+	require.Equal(t, 2, len(toRefresh))
+	assert.Equal(t, "reader", toRefresh[0].FileName, "global store option must apply to tokens without their own setting")
+	assert.Equal(t, "reader-threshold", toRefresh[1].FileName)
+
+	opts.StoreOptions = config.ZTS_RESPONSE
+	toRefresh, _ = ToBeRefreshedBasedOnTime(&opts, time.Now())
+	require.Equal(t, 1, len(toRefresh))
+	assert.Equal(t, "reader-threshold", toRefresh[0].FileName)
+}
+
+func TestMakeTokenRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		token config.AccessToken
+		body  string
+	}{
+		{"roles", config.AccessToken{Domain: "sports", Roles: []string{"readers", "writers"}, Expiry: 3600},
+			"expires_in=3600&grant_type=client_credentials&scope=sports%3Arole.readers+sports%3Arole.writers"},
+		{"domain", config.AccessToken{Domain: "sports", Roles: []string{"*"}, Expiry: 3600},
+			"expires_in=3600&grant_type=client_credentials&scope=sports%3Adomain"},
+		{"id-token", config.AccessToken{Domain: "sports", Roles: []string{"readers"}, IdTokenService: "api", Expiry: 3600},
+			"expires_in=3600&grant_type=client_credentials&scope=sports%3Arole.readers+openid+sports%3Aservice.api"},
+		{"proxy", config.AccessToken{Domain: "sports", Roles: []string{"readers"}, Expiry: 3600,
+			AuthorizationDetails:     `[{"type":"msg"}]`,
+			ProxyPrincipalSpiffeUris: []string{"spiffe://athenz/sa/api", "spiffe://athenz/sa/ui"},
+			ProxyForPrincipal:        "user.joe"},
+			"authorization_details=%5B%7B%22type%22%3A%22msg%22%7D%5D&expires_in=3600&grant_type=client_credentials" +
+				"&proxy_for_principal=user.joe&proxy_principal_spiffe_uris=spiffe%3A%2F%2Fathenz%2Fsa%2Fapi%2Cspiffe%3A%2F%2Fathenz%2Fsa%2Fui" +
+				"&scope=sports%3Arole.readers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.body, makeTokenRequest(tt.token))
+		})
+	}
+}
+
 func TestTokenWithStoreOption(t *testing.T) {
 	siaDir, err := os.MkdirTemp("", "sia.")
 	require.Nil(t, err, "should be able to create temp folder for sia")
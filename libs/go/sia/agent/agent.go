@@ -0,0 +1,904 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/access/tokens"
+	"github.com/AthenZ/athenz/libs/go/sia/awscreds"
+	"github.com/AthenZ/athenz/libs/go/sia/futil"
+	"github.com/AthenZ/athenz/libs/go/sia/hook"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/output"
+	"github.com/AthenZ/athenz/libs/go/sia/sds"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/tokenserver"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/ardielle/ardielle-go/rdl"
+	"github.com/cenkalti/backoff"
+)
+
+const siaMainDir = "/var/lib/sia"
+
+func readCertificate(certFile string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	var block *pem.Block
+	block, _ = pem.Decode(data)
+	if block == nil {
+		return nil, nil
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func GetPrevRoleCertDates(certFile string) (*rdl.Timestamp, *rdl.Timestamp, error) {
+	prevRolCert, err := readCertificate(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if prevRolCert == nil {
+		return nil, nil, fmt.Errorf("unable to parse certificate %s", certFile)
+	}
+
+	notBefore := &rdl.Timestamp{
+		Time: prevRolCert.NotBefore,
+	}
+
+	notAfter := &rdl.Timestamp{
+		Time: prevRolCert.NotAfter,
+	}
+
+	log.Printf("Existing cert %s, not before: %s, not after: %s\n", certFile, notBefore.String(), notAfter.String())
+	return notBefore, notAfter, nil
+}
+
+func RoleKey(rotateKey bool, keyType util.KeyType, svcKey string) (crypto.Signer, error) {
+	if rotateKey == true {
+		return util.GenerateKey(keyType)
+	}
+	return util.PrivateKeyFromFile(svcKey)
+}
+
+func GetRoleCertificates(ztsUrl string, opts *options.Options) bool {
+	return getRoleCertificates(ztsUrl, opts.Roles, opts)
+}
+
+func getRoleCertificates(ztsUrl string, roles []options.Role, opts *options.Options) bool {
+
+	//initialize our return state to success
+	failures := 0
+
+	for _, role := range roles {
+		err := getRoleCertificate(ztsUrl, role, opts)
+		recordCertStatus(status.RoleCert, role.Name, util.GetRoleCertFileName(opts.CertDir, role.Filename, role.Name), err)
+		if err != nil {
+			failures += 1
+		}
+	}
+	log.Printf("SIA processed %d (failures %d) role certificate requests\n", len(roles), failures)
+	return failures == 0
+}
+
+func getRoleCertificate(ztsUrl string, role options.Role, opts *options.Options) error {
+
+	svcKeyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, role.Service)
+	svcCertFile := fmt.Sprintf("%s/%s.%s.cert.pem", opts.CertDir, opts.Domain, role.Service)
+
+	client, err := util.ZtsClient(ztsUrl, opts.ZTSServerName, svcKeyFile, svcCertFile, opts.ZTSCACertFile)
+	if err != nil {
+		log.Printf("unable to initialize ZTS Client with url %s for role %s, err: %v\n", ztsUrl, role.Name, err)
+		return err
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+
+	key, err := util.PrivateKeyFromFile(svcKeyFile)
+	if err != nil {
+		log.Printf("unable to read private key from %s for role %s, err: %v\n", svcKeyFile, role.Name, err)
+		return err
+	}
+
+	if opts.GenerateRoleKey {
+		var err error
+		key, err = RoleKey(opts.RotateKey, opts.KeyType, svcKeyFile)
+		if err != nil {
+			log.Printf("unable to read generate/read key from %s, err: %v\n", role.Filename, err)
+			return err
+		}
+	}
+
+	csr, err := generateRoleCertCSR(key, role, opts)
+	if err != nil {
+		log.Printf("unable to generate CSR for %s, err: %v\n", role.Name, err)
+		return err
+	}
+	roleRequest := &zts.RoleCertificateRequest{
+		Csr: csr,
+	}
+	if role.ExpiryTime > 0 {
+		roleRequest.ExpiryTime = int64(role.ExpiryTime)
+	}
+
+	certFilePem := util.GetRoleCertFileName(opts.CertDir, role.Filename, role.Name)
+	notBefore, notAfter, _ := GetPrevRoleCertDates(certFilePem)
+	roleRequest.PrevCertNotBefore = notBefore
+	roleRequest.PrevCertNotAfter = notAfter
+	if notBefore != nil && notAfter != nil {
+		log.Printf("Previous Role Cert Not Before date: %s, Not After date: %s\n", notBefore, notAfter)
+	}
+
+	//"rolename": "athenz.fp:role.readers"
+	//from the rolename, domain is athenz.fp
+	//role is readers
+	start := time.Now()
+	roleCert, err := client.PostRoleCertificateRequestExt(roleRequest)
+	status.ObserveZtsRequest("PostRoleCertificateRequest", time.Since(start), err)
+	if err != nil {
+		log.Printf("PostRoleCertificateRequest failed for %s, err: %v\n", role.Name, err)
+		return err
+	}
+
+	prevCert, _ := os.ReadFile(certFilePem)
+	roleKeyBytes := util.PrivatePem(key)
+	err = SaveRoleCertKey([]byte(roleKeyBytes), []byte(roleCert.X509Certificate), role, opts)
+	if err != nil {
+		return err
+	}
+	err = writeOutputs(role.Name, role.Outputs, []byte(roleKeyBytes), []byte(roleCert.X509Certificate), opts)
+	if err != nil {
+		return err
+	}
+	hook.RunOnChange(role.Name, certFilePem, prevCert, role.Hooks)
+	return nil
+}
+
+// RegisterInstance registers all configured services with ZTS using the
+// attestation data provided by the platform provider
+func RegisterInstance(ztsUrl string, opts *options.Options, docExpiryCheck bool) error {
+
+	//special handling for EC2 instances
+	//before we process our register event we need to check to
+	//see if our timestamp in our document is less than 30 mins
+	//ago otherwise ZTS server will reject the request and there
+	//is no point of processing the request
+	if docExpiryCheck && shouldSkipRegister(opts) {
+		return fmt.Errorf("identity document has expired (30 min timeout). ZTS will not register this instance. Please relaunch or stop and start your instance to refesh its identity document")
+	}
+
+	for _, svc := range opts.Services {
+		err := registerSvc(svc, ztsUrl, opts)
+		recordCertStatus(status.ServiceCert, svc.Name, util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name), err)
+		if err != nil {
+			return fmt.Errorf("unable to register identity for svc: %q, error: %v", svc.Name, err)
+		}
+	}
+	return nil
+}
+
+// RefreshInstance refreshes the identity certificates for all configured
+// services using the attestation data provided by the platform provider
+func RefreshInstance(ztsUrl string, opts *options.Options) error {
+	for _, svc := range opts.Services {
+		err := refreshSvc(svc, ztsUrl, opts)
+		recordCertStatus(status.ServiceCert, svc.Name, util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name), err)
+		if err != nil {
+			return fmt.Errorf("unable to refresh identity for svc: %q, error: %v", svc.Name, err)
+		}
+	}
+	return nil
+}
+
+func registerSvc(svc options.Service, ztsUrl string, opts *options.Options) error {
+
+	key, err := util.GenerateKey(opts.KeyType)
+	if err != nil {
+		return err
+	}
+
+	//if ssh support is enabled then we need to generate the csr
+	//it is also generated for the primary service only
+	var sshHostKeys []sshHostKey
+	var sshCsr string
+	if opts.Ssh && opts.Services[0].Name == svc.Name {
+		sshHostKeys = getSSHHostKeys(opts)
+		sshCsr, err = generateSSHHostCSR(sshHostKeys, svc, opts)
+		if err != nil {
+			return err
+		}
+	}
+	csr, err := generateSvcCertCSR(key, svc, opts)
+	if err != nil {
+		return err
+	}
+	attestData, err := opts.Provider.AttestationData(fmt.Sprintf("%s.%s", opts.Domain, svc.Name), key, nil)
+	if err != nil {
+		return fmt.Errorf("unable to get attestation data: %v", err)
+	}
+
+	athenzJwk := true
+	athenzJwkModified := util.GetAthenzJwkConfModTime(siaMainDir)
+
+	info := &zts.InstanceRegisterInformation{
+		Provider:          zts.ServiceName(opts.Provider.GetName()),
+		Domain:            zts.DomainName(opts.Domain),
+		Service:           zts.SimpleName(svc.Name),
+		Csr:               csr,
+		Ssh:               sshCsr,
+		AttestationData:   attestData,
+		AthenzJWK:         &athenzJwk,
+		AthenzJWKModified: &athenzJwkModified,
+	}
+	if svc.ExpiryTime > 0 {
+		expiryTime := int32(svc.ExpiryTime)
+		info.ExpiryTime = &expiryTime
+	}
+	if opts.SanDnsHostname {
+		if hostname := opts.Provider.GetHostname(); hostname != "" {
+			info.Hostname = zts.DomainName(hostname)
+		}
+	}
+
+	client, err := util.ZtsClient(ztsUrl, opts.ZTSServerName, "", "", opts.ZTSCACertFile)
+	if err != nil {
+		return err
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+
+	start := time.Now()
+	ident, _, err := client.PostInstanceRegisterInformation(info)
+	status.ObserveZtsRequest("PostInstanceRegisterInformation", time.Since(start), err)
+	if err != nil {
+		log.Printf("Unable to do PostInstanceRegisterInformation, err: %v\n", err)
+		return err
+	}
+	sshHostCerts := requestSSHHostCerts(ident, svc, key, sshHostKeys, ztsUrl, opts)
+	svcKeyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+	prevCert, _ := os.ReadFile(certFile)
+	svcKeyBytes := []byte(util.PrivatePem(key))
+	err = util.WriteCertKey(svcKeyBytes, []byte(ident.X509Certificate), svcKeyFile, certFile, svc.Uid, svc.Gid, 0440, opts.BackUpDir)
+	if err != nil {
+		return err
+	}
+
+	if opts.Services[0].Name == svc.Name {
+		err = util.UpdateFile(opts.AthenzCACertFile, []byte(ident.X509CertificateSigner), svc.Uid, svc.Gid, 0444)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeOutputs(svc.Name, svc.Outputs, svcKeyBytes, []byte(ident.X509Certificate), opts)
+	if err != nil {
+		return err
+	}
+	hook.RunOnChange(svc.Name, certFile, prevCert, svc.Hooks)
+	//we're not going to count ssh updates as fatal since the primary
+	//task for sia to get service identity certs but we'll log the failure
+	if len(sshHostCerts) != 0 {
+		err = updateSSH(sshHostCerts, opts.SshConfigFile)
+		if err != nil {
+			log.Printf("Unable to update ssh certificate, err: %v\n", err)
+		}
+	}
+
+	if ident.AthenzJWK != nil {
+		err = util.WriteAthenzJWKFile(ident.AthenzJWK, siaMainDir, svc.Uid, svc.Gid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func refreshSvc(svc options.Service, ztsUrl string, opts *options.Options) error {
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := fmt.Sprintf("%s/%s.%s.cert.pem", opts.CertDir, opts.Domain, svc.Name)
+
+	client, err := util.ZtsClient(ztsUrl, opts.ZTSServerName, keyFile, certFile, opts.ZTSCACertFile)
+	if err != nil {
+		log.Printf("Unable to get ZTS Client for %s, err: %v\n", ztsUrl, err)
+		return err
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+
+	key, err := util.PrivateKey(keyFile, opts.KeyType, opts.RotateKey)
+	if err != nil {
+		log.Printf("Unable to read private key from %s, err: %v\n", keyFile, err)
+		return err
+	}
+	csr, err := generateSvcCertCSR(key, svc, opts)
+	if err != nil {
+		log.Printf("Unable to generate CSR for %s, err: %v\n", opts.Name, err)
+		return err
+	}
+	attestData, err := opts.Provider.AttestationData(fmt.Sprintf("%s.%s", opts.Domain, svc.Name), key, nil)
+	if err != nil {
+		return fmt.Errorf("unable to get attestation data: %v", err)
+	}
+	//if ssh support is enabled then we need to generate the csr
+	//it is also generated for the primary service only
+	var sshHostKeys []sshHostKey
+	var sshCsr string
+	if opts.Ssh && opts.Services[0].Name == svc.Name {
+		sshHostKeys = getSSHHostKeys(opts)
+		sshCsr, err = generateSSHHostCSR(sshHostKeys, svc, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	athenzJwk := true
+	athenzJwkModified := util.GetAthenzJwkConfModTime(siaMainDir)
+
+	info := &zts.InstanceRefreshInformation{
+		AttestationData:   attestData,
+		Csr:               csr,
+		Ssh:               sshCsr,
+		AthenzJWK:         &athenzJwk,
+		AthenzJWKModified: &athenzJwkModified,
+	}
+	if svc.ExpiryTime > 0 {
+		expiryTime := int32(svc.ExpiryTime)
+		info.ExpiryTime = &expiryTime
+	}
+	if opts.SanDnsHostname {
+		if hostname := opts.Provider.GetHostname(); hostname != "" {
+			info.Hostname = zts.DomainName(hostname)
+		}
+	}
+
+	start := time.Now()
+	ident, err := client.PostInstanceRefreshInformation(zts.ServiceName(opts.Provider.GetName()), zts.DomainName(opts.Domain), zts.SimpleName(svc.Name), zts.PathElement(opts.InstanceId), info)
+	status.ObserveZtsRequest("PostInstanceRefreshInformation", time.Since(start), err)
+	if err != nil {
+		log.Printf("Unable to refresh instance service certificate for %s, err: %v\n", opts.Name, err)
+		return err
+	}
+	sshHostCerts := requestSSHHostCerts(ident, svc, key, sshHostKeys, ztsUrl, opts)
+
+	svcCertFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+	prevCert, _ := os.ReadFile(svcCertFile)
+	svcKeyBytes := util.PrivatePem(key)
+	svcCertBytes := []byte(ident.X509Certificate)
+	err = SaveSvcCertKey([]byte(svcKeyBytes), svcCertBytes, svc, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Services[0].Name == svc.Name {
+		err = util.UpdateFile(opts.AthenzCACertFile, []byte(ident.X509CertificateSigner), svc.Uid, svc.Gid, 0444)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeOutputs(svc.Name, svc.Outputs, []byte(svcKeyBytes), svcCertBytes, opts)
+	if err != nil {
+		return err
+	}
+	hook.RunOnChange(svc.Name, svcCertFile, prevCert, svc.Hooks)
+	//we're not going to count ssh updates as fatal since the primary
+	//task for sia to get service identity certs but we'll log the failure
+	if len(sshHostCerts) != 0 {
+		err = updateSSH(sshHostCerts, opts.SshConfigFile)
+		if err != nil {
+			log.Printf("Unable to update ssh certificate, err: %v\n", err)
+		}
+	}
+
+	if ident.AthenzJWK != nil {
+		err = util.WriteAthenzJWKFile(ident.AthenzJWK, siaMainDir, svc.Uid, svc.Gid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SaveSvcCertKey(key, cert []byte, svc options.Service, opts *options.Options) error {
+	prefix := fmt.Sprintf("%s.%s", opts.Domain, svc.Name)
+	return util.SaveCertKey(key, cert, svc.Filename, prefix, prefix, svc.Uid, svc.Gid, svc.FileMode, opts.GenerateRoleKey, opts.RotateKey, opts.KeyType, opts.KeyDir, opts.CertDir, opts.BackUpDir)
+}
+
+func SaveRoleCertKey(key, cert []byte, role options.Role, opts *options.Options) error {
+	certPrefix := role.Name
+	if role.Filename != "" {
+		certPrefix = strings.TrimSuffix(role.Filename, ".cert.pem")
+	}
+	keyPrefix := options.GetRoleKeyPrefix(opts, role)
+	return util.SaveCertKey(key, cert, role.Filename, keyPrefix, certPrefix, role.Uid, role.Gid, role.FileMode, opts.GenerateRoleKey, opts.RotateKey, opts.KeyType, opts.KeyDir, opts.CertDir, opts.BackUpDir)
+}
+
+// writeOutputs generates the additional formats configured for the service
+// or role from its key and certificate along with the athenz ca certificates
+func writeOutputs(name string, outputs []output.Output, key, cert []byte, opts *options.Options) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+	caCerts, err := os.ReadFile(opts.AthenzCACertFile)
+	if err != nil {
+		return fmt.Errorf("unable to read ca certificates from %s for %s outputs, err: %v", opts.AthenzCACertFile, name, err)
+	}
+	return output.Write(name, outputs, key, cert, caCerts, opts.CertDir, opts.BackUpDir)
+}
+
+func RunAgent(siaCmd, siaDir, ztsUrl string, opts *options.Options) {
+	RunAgentWithReload(siaCmd, siaDir, ztsUrl, opts, nil)
+}
+
+// RunAgentWithReload runs the agent same as RunAgent. If the reload function
+// is specified, the agent reloads its configuration when it receives the
+// SIGHUP signal and applies the updated roles, access tokens and refresh
+// settings without restarting.
+func RunAgentWithReload(siaCmd, siaDir, ztsUrl string, opts *options.Options, reload ReloadFunc) {
+
+	//all the identity requests are based on the platform provider
+	if opts.Provider == nil {
+		log.Fatalf("Unable to run agent, the options must include the provider\n")
+	}
+
+	//the doctor command only reports the state of the host so
+	//it must run before we make any changes to the directories
+	//or require a valid attestation document
+	if doctor, jsonOutput := isDoctorCommand(siaCmd); doctor {
+		os.Exit(RunDoctor(os.Stdout, ztsUrl, opts, jsonOutput))
+	}
+
+	//the deregister command is typically executed from a shutdown
+	//hook so it only requires the current service certificates
+	//and not a valid attestation document
+	if deregister, wipe := isDeregisterCommand(siaCmd); deregister {
+		err := DeregisterInstance(ztsUrl, opts, wipe)
+		if err != nil {
+			log.Fatalf("Unable to deregister identity, err: %v\n", err)
+		}
+		log.Printf("identity deregistered for services: %s\n", options.GetSvcNames(opts.Services))
+		return
+	}
+
+	//first, let's determine if we need to drop our privileges
+	//since it requires us to create the directories with the
+	//specified ownership
+	runUid, runGid := options.GetRunsAsUidGid(opts)
+
+	_ = util.SetupSIADirs(siaDir, "", runUid, runGid)
+
+	//restore the key and certificate files if we were interrupted
+	//while replacing them before we start using them
+	if err := futil.RecoverFiles(opts.BackUpDir); err != nil {
+		log.Printf("unable to recover files from %s, error: %v\n", opts.BackUpDir, err)
+	}
+
+	//check to see if we need to drop our privileges and
+	//run as the specific group id
+	if runGid != -1 {
+		if err := util.SyscallSetGid(runGid); err != nil {
+			log.Printf("unable to drop privileges to group %d, error: %v\n", runGid, err)
+		}
+	}
+	// same check for the user id
+	if runUid != -1 {
+		if err := util.SyscallSetUid(runUid); err != nil {
+			log.Printf("unable to drop privileges to user %d, error: %v\n", runUid, err)
+		}
+	}
+
+	svcs := options.GetSvcNames(opts.Services)
+
+	tokenOpts, err := tokenOptions(opts, ztsUrl)
+	if err != nil {
+		log.Printf(err.Error())
+	}
+	switch siaCmd {
+	case "rolecert":
+		GetRoleCertificates(ztsUrl, opts)
+	case "token":
+		if tokenOpts != nil {
+			err := accessTokenRequest(tokenOpts)
+			if err != nil {
+				log.Fatalf("Unable to fetch access token, err: %v\n", err)
+			}
+		} else {
+			log.Print("unable to obtain fetch token, invalid sia_config")
+		}
+	case "post", "register":
+		err := RegisterInstance(ztsUrl, opts, false)
+		if err != nil {
+			log.Fatalf("Unable to register identity, err: %v\n", err)
+		}
+		log.Printf("identity registered for services: %s\n", svcs)
+	case "init":
+		// the init command is used by init containers to establish
+		// the service identity along with the role certificates and
+		// tokens before the application containers are started
+		err := RegisterInstance(ztsUrl, opts, false)
+		if err != nil {
+			log.Fatalf("Unable to register identity, err: %v\n", err)
+		}
+		GetRoleCertificates(ztsUrl, opts)
+		if tokenOpts != nil {
+			if err := accessTokenRequest(tokenOpts); err != nil {
+				log.Printf("Unable to fetch access tokens, err: %v\n", err)
+			}
+		}
+		log.Printf("identity initialized for services: %s\n", svcs)
+	case "rotate", "refresh":
+		err := RefreshInstance(ztsUrl, opts)
+		if err != nil {
+			log.Fatalf("Refresh identity failed, err: %v\n", err)
+		}
+		log.Printf("Identity successfully refreshed for services: %s\n", svcs)
+	default:
+		// if we already have a cert file then we're not going to
+		// prove our identity since most likely it will not succeed
+		// due to boot time check (this could be just a regular
+		// service restart for any reason). Instead, we'll just skip
+		// over and try to rotate the certs
+
+		if files, err := ioutil.ReadDir(opts.CertDir); err != nil || len(files) <= 0 {
+			err := RegisterInstance(ztsUrl, opts, true)
+			if err != nil {
+				log.Fatalf("Register identity failed, error: %v\n", err)
+			}
+
+		} else {
+			log.Println("Identity certificate file already exists. Retrieving identity details...")
+		}
+		log.Printf("Identity established for services: %s\n", svcs)
+
+		stop := make(chan bool, 1)
+		errors := make(chan error, 1)
+		certUpdates := make(chan bool, 1)
+		optsUpdates := make(chan *reloadedConfig, 1)
+		sdsUpdates := make(chan *options.Options, 1)
+		tokenUpdates := make(chan *config.TokenOptions, 1)
+		caBundleUpdates := make(chan *options.Options, 1)
+		awsCredsUpdates := make(chan *options.Options, 1)
+		// the sds listeners cannot be changed on reload so we only
+		// need to check once if the sds server is enabled
+		sdsEnabled := opts.SDSUdsPath != "" || opts.SDSTcpAddress != ""
+
+		registerStatus(opts)
+		go func() {
+			err := status.StartServer(opts.StatusUdsPath, opts.StatusPort)
+			if err != nil {
+				log.Printf("status server failed: %v\n", err)
+			}
+		}()
+
+		var tokenServer *tokenserver.Server
+		if opts.TokenServerUdsPath != "" {
+			tokenServer = tokenserver.NewServer(opts, ztsUrl)
+			go func() {
+				err := tokenServer.Serve(opts.TokenServerUdsPath)
+				if err != nil {
+					log.Printf("token server failed: %v\n", err)
+				}
+			}()
+		}
+
+		go func() {
+			// each service and role certificate is refreshed on its own
+			// schedule based on the lifetime of the certificate on disk.
+			// if we just did our initial setup, the service certificates
+			// are not due yet so only the role certificates are fetched
+			opts := opts
+			tokenOpts := tokenOpts
+			scheduler := newRefreshScheduler(opts)
+			for {
+				log.Printf("Identity being used: %s\n", opts.Name)
+
+				services, roles, next := scheduler.due(opts, time.Now())
+				if len(services) > 0 {
+					// failed refresh requests are retried with backoff
+					// until our current certificates have expired
+					err := refreshIdentityWithBackoff(ztsUrl, services, opts, stop)
+					if err == errRefreshStopped {
+						errors <- nil
+						return
+					}
+					if err != nil {
+						errors <- fmt.Errorf("refresh identity failed: %v\n", err)
+						return
+					}
+					for _, svc := range services {
+						scheduler.refreshed(util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name), time.Now())
+					}
+					log.Printf("identity successfully refreshed for services: %s\n", options.GetSvcNames(services))
+					if tokenOpts != nil {
+						err := accessTokenRequest(tokenOpts)
+						if err != nil {
+							log.Printf("Unable to fetch access token after identity refresh, err: %v\n", err)
+						}
+					} else {
+						log.Print("token config does not exist - do not refresh token")
+					}
+				}
+				if len(roles) > 0 {
+					getRoleCertificates(ztsUrl, roles, opts)
+					for _, role := range roles {
+						scheduler.refreshed(util.GetRoleCertFileName(opts.CertDir, role.Filename, role.Name), time.Now())
+					}
+				}
+				if sdsEnabled && (len(services) > 0 || len(roles) > 0) {
+					certUpdates <- true
+				}
+				if len(services) > 0 || len(roles) > 0 {
+					// pick up any certificates that became due while refreshing
+					continue
+				}
+
+				select {
+				case <-stop:
+					errors <- nil
+					return
+				case update := <-optsUpdates:
+					opts = update.opts
+					tokenOpts = update.tokenOpts
+					scheduler.reload(opts)
+				case <-time.After(time.Until(next)):
+					break
+				}
+			}
+		}()
+
+		// fetch the ca bundles before we start the sds server so
+		// they're available when the first requests are received
+		fetchCABundles(ztsUrl, opts)
+		if opts.SshUserCABundle != "" && os.Geteuid() != 0 {
+			log.Printf("ssh user ca keys from bundle %s are not updated since the agent is not running as root\n", opts.SshUserCABundle)
+		}
+		if err := updateSSHUserCAKeys(ztsUrl, opts); err != nil {
+			log.Printf("unable to update ssh user ca keys, err: %v\n", err)
+		}
+		go func() {
+			opts := opts
+			for {
+				select {
+				case update := <-caBundleUpdates:
+					opts = update
+				case <-time.After(caBundleRefreshInterval(opts)):
+				}
+				if fetchCABundles(ztsUrl, opts) && sdsEnabled {
+					certUpdates <- true
+				}
+				if err := updateSSHUserCAKeys(ztsUrl, opts); err != nil {
+					log.Printf("unable to update ssh user ca keys, err: %v\n", err)
+				}
+			}
+		}()
+
+		// the aws credentials task keeps running even without any
+		// profiles configured so a reload can enable it later
+		awsCreds, err := awscreds.NewManager(opts, ztsUrl)
+		if err != nil {
+			log.Fatalf("Unable to initialize aws credentials manager, err: %v\n", err)
+		}
+		if opts.AWSCredentialsPort != 0 {
+			go func() {
+				err := awsCreds.Serve(opts.AWSCredentialsPort)
+				if err != nil {
+					log.Printf("aws credentials endpoint failed: %v\n", err)
+				}
+			}()
+		}
+		go func() {
+			for {
+				wait := awsCreds.Refresh()
+				select {
+				case update := <-awsCredsUpdates:
+					awsCreds.UpdateOptions(update)
+				case <-time.After(wait):
+				}
+			}
+		}()
+
+		go func() {
+			if sdsEnabled {
+				err := sds.StartGrpcServer(opts, certUpdates, sdsUpdates)
+				if err != nil {
+					log.Printf("failed to start grpc sds server: %v\n", err)
+					stop <- true
+					return
+				}
+			}
+		}()
+
+		go func() {
+			signals := make(chan os.Signal, 2)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			sig := <-signals
+			log.Printf("Received signal %v, stopping rotation\n", sig)
+			stop <- true
+		}()
+
+		if reload != nil {
+			go func() {
+				signals := make(chan os.Signal, 1)
+				signal.Notify(signals, syscall.SIGHUP)
+				current := opts
+				for range signals {
+					log.Println("Received SIGHUP signal, reloading configuration")
+					update, err := reloadConfig(current, reload, ztsUrl)
+					if err != nil {
+						log.Printf("config reload rejected, keeping current config: %v\n", err)
+						continue
+					}
+					current = update.opts
+					sendLatest(optsUpdates, update)
+					sendLatest(tokenUpdates, update.tokenOpts)
+					sendLatest(caBundleUpdates, current)
+					sendLatest(awsCredsUpdates, current)
+					if sdsEnabled {
+						sendLatest(sdsUpdates, current)
+					}
+					if tokenServer != nil {
+						tokenServer.UpdateOptions(current)
+					}
+					log.Println("configuration successfully reloaded")
+				}
+			}()
+		}
+
+		go func() {
+			// the access token task keeps running even without any
+			// tokens configured so a reload can enable it later
+			tokenOpts := tokenOpts
+			var tick <-chan time.Time
+			startTicker := func() *time.Ticker {
+				if tokenOpts == nil || tokenOpts.TokenRefresh == 0 {
+					tick = nil
+					return nil
+				}
+				log.Printf("start refresh access-token task every [%s]", fmt.Sprint(tokenOpts.TokenRefresh))
+				t2 := time.NewTicker(tokenOpts.TokenRefresh)
+				tick = t2.C
+				return t2
+			}
+			t2 := startTicker()
+			defer func() {
+				if t2 != nil {
+					t2.Stop()
+				}
+			}()
+			for {
+				select {
+				case <-tick:
+					log.Printf("refreshing access-token..")
+					err := accessTokenRequest(tokenOpts)
+					if err != nil {
+						log.Printf("refresh access-token task got error: %v\n", err)
+					}
+					// access tokens are also served by the sds server
+					if sdsEnabled {
+						certUpdates <- true
+					}
+				case update := <-tokenUpdates:
+					if t2 != nil {
+						t2.Stop()
+					}
+					tokenOpts = update
+					t2 = startTicker()
+					if tokenOpts != nil {
+						err := accessTokenRequest(tokenOpts)
+						if err != nil {
+							log.Printf("Unable to fetch access token after config reload, err: %v\n", err)
+						}
+						if sdsEnabled {
+							certUpdates <- true
+						}
+					}
+				case <-stop:
+					errors <- nil
+					return
+				}
+			}
+		}()
+
+		err = <-errors
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+	os.Exit(0)
+}
+
+func accessTokenRequest(tokenOpts *config.TokenOptions) error {
+	// getExponentialBackoffToken will return a backoff config with first retry delay of 5s, and backoff retry
+	// until params.tokenRefresh / 4
+	getExponentialBackoffToken := func() *backoff.ExponentialBackOff {
+		b := backoff.NewExponentialBackOff()
+		b.InitialInterval = 5 * time.Second
+		b.Multiplier = 2
+		b.MaxElapsedTime = tokenOpts.TokenRefresh / 4
+		return b
+	}
+
+	notifyOnAccessTokenErr := func(err error, backoffDelay time.Duration) {
+		log.Printf("Failed to create/refresh access token: %s. Retrying in %s", err.Error(), backoffDelay)
+	}
+
+	accessTokenFunc := func() error {
+		return fetchAccessToken(tokenOpts)
+	}
+	err := backoff.RetryNotify(accessTokenFunc, getExponentialBackoffToken(), notifyOnAccessTokenErr)
+
+	if err != nil {
+		log.Printf("access tokens errors: %v", err)
+	}
+	return err
+}
+
+func tokenOptions(opts *options.Options, ztsUrl string) (*config.TokenOptions, error) {
+	providerName := ""
+	if opts.Provider != nil {
+		providerName = opts.Provider.GetName()
+	}
+	userAgent := fmt.Sprintf("%s-%s", providerName, opts.InstanceId)
+	tokenOpts, err := tokens.NewTokenOptions(opts, ztsUrl, userAgent)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create token options: %s", err.Error())
+	}
+	tokenOpts.StoreOptions = config.ACCESS_TOKEN_PROP
+
+	log.Printf("token options created successfully")
+	return tokenOpts, nil
+}
+
+func fetchAccessToken(tokenOpts *config.TokenOptions) error {
+
+	_, errs := tokens.Fetch(tokenOpts)
+	log.Printf("Fetch access token completed successfully with [%d] errors", len(errs))
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		var errsStr []string
+		for _, er := range errs {
+			errsStr = append(errsStr, er.Error())
+		}
+		return fmt.Errorf(strings.Join(errsStr, ","))
+	}
+}
+
+func shouldSkipRegister(opts *options.Options) bool {
+	if opts.EC2StartTime == nil {
+		return false
+	}
+	duration := time.Since(*opts.EC2StartTime)
+	//our server timeout is 30 mins = 1800 secs
+	if duration.Seconds() > 1800 {
+		return true
+	}
+	return false
+}
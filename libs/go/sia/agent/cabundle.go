@@ -0,0 +1,102 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// caBundleRefreshInterval returns how often the CA bundles are fetched
+// from ZTS. Unset or invalid intervals fall back to the default so the
+// refresh loop never spins without waiting.
+func caBundleRefreshInterval(opts *options.Options) time.Duration {
+	interval := opts.CABundleRefreshInterval
+	if interval <= 0 {
+		interval = options.DEFAULT_CA_BUNDLE_REFRESH_INTERVAL
+	}
+	return time.Duration(interval) * time.Minute
+}
+
+// fetchCABundles fetches the CA bundles that are configured with a ZTS
+// bundle name and stores them in the certificate directory. It returns
+// true if any of the bundles has changed
+func fetchCABundles(ztsUrl string, opts *options.Options) bool {
+	changed := false
+	for _, bundle := range opts.CABundles {
+		if bundle.ZTSBundle == "" {
+			continue
+		}
+		updated, err := fetchCABundle(ztsUrl, bundle, opts)
+		if err != nil {
+			log.Printf("unable to fetch ca bundle %s from zts, err: %v\n", bundle.Name, err)
+			continue
+		}
+		if updated {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func fetchCABundle(ztsUrl string, bundle options.CABundle, opts *options.Options) (bool, error) {
+
+	svc := opts.Services[0]
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+
+	client, err := util.ZtsClient(ztsUrl, opts.ZTSServerName, keyFile, certFile, opts.ZTSCACertFile)
+	if err != nil {
+		return false, err
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+
+	start := time.Now()
+	caBundle, err := client.GetCertificateAuthorityBundle(zts.SimpleName(bundle.ZTSBundle))
+	status.ObserveZtsRequest("GetCertificateAuthorityBundle", time.Since(start), err)
+	if err != nil {
+		return false, err
+	}
+
+	// make sure we received valid certificates before replacing
+	// the bundle that we're currently serving
+	certs := []byte(caBundle.Certs)
+	if !x509.NewCertPool().AppendCertsFromPEM(certs) {
+		return false, fmt.Errorf("zts bundle %s does not include any valid certificates", bundle.ZTSBundle)
+	}
+
+	caFile := options.GetZTSCABundleFile(opts, bundle)
+	prevCerts, _ := os.ReadFile(caFile)
+	if bytes.Equal(prevCerts, certs) {
+		return false, nil
+	}
+	err = util.UpdateFile(caFile, certs, svc.Uid, svc.Gid, 0444)
+	if err != nil {
+		return false, err
+	}
+	log.Printf("ca bundle %s updated from zts bundle %s\n", bundle.Name, bundle.ZTSBundle)
+	return true, nil
+}
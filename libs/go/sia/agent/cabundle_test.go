@@ -0,0 +1,69 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchCABundles(test *testing.T) {
+	siaDir := test.TempDir()
+	opts := &options.Options{
+		Domain: "athenz",
+		Services: []options.Service{
+			{
+				Name: "hockey",
+				Uid:  util.ExecIdCommand("-u"),
+				Gid:  util.ExecIdCommand("-g"),
+			},
+		},
+		KeyDir:  siaDir,
+		CertDir: siaDir,
+		CABundles: []options.CABundle{
+			{Name: "default", Files: []string{"devel/data/ca.cert.pem"}},
+			{Name: "roles", ZTSBundle: "athenz"},
+			{Name: "partner", ZTSBundle: "unknown"},
+		},
+	}
+
+	// the first fetch stores the bundle while the unknown bundle is skipped
+	assert.True(test, fetchCABundles("http://127.0.0.1:5084/zts/v1", opts))
+	certs, err := os.ReadFile(options.GetZTSCABundleFile(opts, opts.CABundles[1]))
+	require.Nil(test, err)
+	assert.Contains(test, string(certs), "BEGIN CERTIFICATE")
+	_, err = os.Stat(options.GetZTSCABundleFile(opts, opts.CABundles[2]))
+	assert.True(test, os.IsNotExist(err))
+
+	// the bundle has not changed so there is nothing to update
+	assert.False(test, fetchCABundles("http://127.0.0.1:5084/zts/v1", opts))
+}
+
+func TestCABundleRefreshInterval(test *testing.T) {
+	opts := &options.Options{CABundleRefreshInterval: 30}
+	assert.Equal(test, 30*time.Minute, caBundleRefreshInterval(opts))
+	opts.CABundleRefreshInterval = 0
+	assert.Equal(test, time.Duration(options.DEFAULT_CA_BUNDLE_REFRESH_INTERVAL)*time.Minute, caBundleRefreshInterval(opts))
+	opts.CABundleRefreshInterval = -5
+	assert.Equal(test, time.Duration(options.DEFAULT_CA_BUNDLE_REFRESH_INTERVAL)*time.Minute, caBundleRefreshInterval(opts))
+}
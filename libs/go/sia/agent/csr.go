@@ -0,0 +1,108 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"crypto"
+	"fmt"
+	"log"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// certReqDetails returns the csr subject for the given common name. The
+// provider returns the distinguished name for its certificates while the
+// country and organization names configured in sia_config take precedence.
+func certReqDetails(commonName string, opts *options.Options) util.CertReqDetails {
+	dn := opts.Provider.GetCsrDn()
+	details := util.CertReqDetails{
+		CommonName: commonName,
+		Country:    firstValue(opts.CertCountryName, dn.Country),
+		Province:   firstValue("", dn.Province),
+		Locality:   firstValue("", dn.Locality),
+		Org:        firstValue(opts.CertOrgName, dn.Organization),
+		OrgUnit:    firstValue("", dn.OrganizationalUnit),
+	}
+	return details
+}
+
+// generateSvcCertCSR generates the csr for the given service identity with
+// the san entries provided by the platform provider
+func generateSvcCertCSR(key crypto.Signer, svc options.Service, opts *options.Options) (string, error) {
+
+	log.Println("Generating X.509 Service Certificate CSR...")
+
+	//note: RFC 6125 states that if the SAN (Subject Alternative Name) exists,
+	//it is used, not the CN. So, we will always put the Athenz name in the CN
+	//(it is *not* a DNS domain name), and put the host name into the SAN.
+	principal := fmt.Sprintf("%s.%s", opts.Domain, svc.Name)
+	details := certReqDetails(principal, opts)
+	details.HostList = opts.Provider.GetSanDns(principal, opts.SanDnsWildcard, opts.SanDnsHostname, nil)
+	// for backward compatibility a sanDNS entry with instance id in the hostname
+	if opts.InstanceIdSanDNS {
+		details.HostList = append(details.HostList, fmt.Sprintf("%s.instanceid.athenz.%s", opts.InstanceId, opts.Provider.GetSuffix()))
+	}
+	details.URIs = opts.Provider.GetSanUri(principal, ip.Opts{})
+	for _, sanIp := range opts.Provider.GetSanIp(nil, nil, ip.Opts{}) {
+		details.IpList = append(details.IpList, sanIp.String())
+	}
+	return util.GenerateX509CSR(key, details)
+}
+
+// generateRoleCertCSR generates the csr for the given role certificate. The
+// role name is included in the CN and the spiffe uri while the principal
+// is included in the uri and, if requested, email san entries.
+func generateRoleCertCSR(key crypto.Signer, role options.Role, opts *options.Options) (string, error) {
+
+	log.Println("Generating Role Certificate CSR...")
+
+	domain, roleName, err := util.SplitRoleName(role.Name)
+	if err != nil {
+		return "", err
+	}
+	principal := fmt.Sprintf("%s.%s", opts.Domain, role.Service)
+	details := certReqDetails(role.Name, opts)
+
+	// spiffe uri must always be the first one
+	details.URIs = util.AppendUri(nil, fmt.Sprintf("spiffe://%s/ra/%s", domain, roleName))
+	details.URIs = util.AppendUri(details.URIs, fmt.Sprintf("athenz://instanceid/%s/%s", opts.Provider.GetName(), opts.InstanceId))
+	details.URIs = util.AppendUri(details.URIs, fmt.Sprintf("athenz://principal/%s", principal))
+
+	// for backward compatibility an email with the principal as the local part
+	if opts.RolePrincipalEmail {
+		details.EmailList = opts.Provider.GetEmail(principal)
+	}
+	for _, svc := range opts.Services {
+		if svc.Name != role.Service {
+			continue
+		}
+		svcCert, _ := readCertificate(util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name))
+		if svcCert != nil {
+			details.HostList = opts.Provider.GetRoleDnsNames(svcCert, principal)
+		}
+	}
+	return util.GenerateX509CSR(key, details)
+}
+
+func firstValue(value string, values []string) string {
+	if value != "" || len(values) == 0 {
+		return value
+	}
+	return values[0]
+}
@@ -0,0 +1,93 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseCSR(test *testing.T, csr string) *x509.CertificateRequest {
+	block, _ := pem.Decode([]byte(csr))
+	require.NotNil(test, block)
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	require.Nil(test, err)
+	return req
+}
+
+func TestGenerateSvcCertCSR(test *testing.T) {
+	key, err := util.GenerateKey(util.RSA2048)
+	require.Nil(test, err)
+	opts := &options.Options{
+		Domain:           "sports.prod",
+		Provider:         newTestProvider("athenz.aws.us-west-2", "i-1234", []string{"aws.athenz.cloud", "aws.athenz.io"}),
+		InstanceId:       "i-1234",
+		CertCountryName:  "US",
+		CertOrgName:      "Athenz",
+		SanDnsWildcard:   true,
+		InstanceIdSanDNS: true,
+	}
+	csr, err := generateSvcCertCSR(key, options.Service{Name: "api"}, opts)
+	require.Nil(test, err)
+
+	// the csr must match the one generated with the util function
+	// so the agent requests the same certificates as before
+	expected, err := util.GenerateSvcCertCSR(key, "US", "Athenz", "sports.prod", "api", "sports.prod.api", "i-1234", "athenz.aws.us-west-2", []string{"aws.athenz.cloud", "aws.athenz.io"}, true, false, true)
+	require.Nil(test, err)
+
+	req := parseCSR(test, csr)
+	expectedReq := parseCSR(test, expected)
+	assert.Equal(test, expectedReq.Subject.String(), req.Subject.String())
+	assert.Equal(test, expectedReq.DNSNames, req.DNSNames)
+	assert.Equal(test, expectedReq.URIs, req.URIs)
+}
+
+func TestGenerateRoleCertCSR(test *testing.T) {
+	key, err := util.GenerateKey(util.RSA2048)
+	require.Nil(test, err)
+	opts := &options.Options{
+		Domain:             "sports",
+		Services:           []options.Service{{Name: "api"}},
+		Provider:           newTestProvider("athenz.aws.us-west-2", "i-1234", []string{"aws.athenz.cloud"}),
+		InstanceId:         "i-1234",
+		CertCountryName:    "US",
+		RolePrincipalEmail: true,
+		CertDir:            test.TempDir(),
+	}
+	role := options.Role{Name: "weather:role.readers", Service: "api"}
+	csr, err := generateRoleCertCSR(key, role, opts)
+	require.Nil(test, err)
+
+	expected, err := util.GenerateRoleCertCSR(key, "US", "", "sports", "api", "weather:role.readers", "i-1234", "athenz.aws.us-west-2", "aws.athenz.cloud")
+	require.Nil(test, err)
+
+	req := parseCSR(test, csr)
+	expectedReq := parseCSR(test, expected)
+	assert.Equal(test, expectedReq.Subject.String(), req.Subject.String())
+	assert.Equal(test, expectedReq.EmailAddresses, req.EmailAddresses)
+	assert.Equal(test, expectedReq.URIs, req.URIs)
+	assert.Empty(test, req.DNSNames)
+
+	_, err = generateRoleCertCSR(key, options.Role{Name: "weather.readers", Service: "api"}, opts)
+	assert.NotNil(test, err)
+}
@@ -0,0 +1,159 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/output"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/ardielle/ardielle-go/rdl"
+)
+
+// isDeregisterCommand returns if the given command requests the instance
+// identities to be deleted and if the credentials must also be removed
+// from the host once the identities are deleted
+func isDeregisterCommand(siaCmd string) (bool, bool) {
+	switch siaCmd {
+	case "deregister":
+		return true, false
+	case "deregister-wipe":
+		return true, true
+	}
+	return false, false
+}
+
+// DeregisterInstance deletes the instance identity for all configured
+// services from ZTS so the identity certificates can no longer be refreshed.
+// The command is expected to be called from a shutdown hook (e.g. systemd
+// ExecStop or a spot termination handler) when the instance is going away.
+// If wipe is requested, the keys, certificates and tokens managed by the
+// agent are removed from the host but only if all identities were deleted
+// successfully so that the command can be retried otherwise.
+func DeregisterInstance(ztsUrl string, opts *options.Options, wipe bool) error {
+	// we want to delete as many identities as possible so we
+	// don't stop processing the services after a failure
+	failures := 0
+	var lastErr error
+	for _, svc := range opts.Services {
+		if err := deregisterSvc(svc, ztsUrl, opts); err != nil {
+			log.Printf("unable to deregister identity for svc: %q, error: %v\n", svc.Name, err)
+			failures += 1
+			lastErr = err
+		}
+	}
+	if failures != 0 {
+		return fmt.Errorf("unable to deregister %d of %d services, error: %v", failures, len(opts.Services), lastErr)
+	}
+	if wipe {
+		wipeCredentials(opts)
+	}
+	return nil
+}
+
+func deregisterSvc(svc options.Service, ztsUrl string, opts *options.Options) error {
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+
+	client, err := util.ZtsClient(ztsUrl, opts.ZTSServerName, keyFile, certFile, opts.ZTSCACertFile)
+	if err != nil {
+		log.Printf("Unable to get ZTS Client for %s, err: %v\n", ztsUrl, err)
+		return err
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+
+	start := time.Now()
+	err = client.DeleteInstanceIdentity(zts.ServiceName(opts.Provider.GetName()), zts.DomainName(opts.Domain), zts.SimpleName(svc.Name), zts.PathElement(opts.InstanceId))
+	status.ObserveZtsRequest("DeleteInstanceIdentity", time.Since(start), err)
+
+	// if the instance is not known to ZTS then it has already been
+	// deregistered (e.g. the command is retried) so there is nothing to do
+	var rdlErr rdl.ResourceError
+	if errors.As(err, &rdlErr) && rdlErr.Code == http.StatusNotFound {
+		log.Printf("instance identity for %s.%s is not registered\n", opts.Domain, svc.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("instance identity for %s.%s deleted\n", opts.Domain, svc.Name)
+	return nil
+}
+
+// wipeCredentials removes the service and role keys and certificates along
+// with their backups and additional outputs, the access and id tokens and
+// the aws credentials file managed by the agent. Failures are logged since
+// there is nothing else the caller can do when the host is being shut down.
+func wipeCredentials(opts *options.Options) {
+	var files []string
+	for _, svc := range opts.Services {
+		prefix := fmt.Sprintf("%s.%s", opts.Domain, svc.Name)
+		files = append(files,
+			fmt.Sprintf("%s/%s.key.pem", opts.KeyDir, prefix),
+			util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name))
+		//backups left by the agent versions that kept the previous
+		//key and certificate after rotating the service key
+		if opts.BackUpDir != "" {
+			files = append(files,
+				fmt.Sprintf("%s/%s.key.pem", opts.BackUpDir, prefix),
+				fmt.Sprintf("%s/%s.cert.pem", opts.BackUpDir, prefix))
+		}
+		files = append(files, outputFiles(svc.Outputs, opts)...)
+	}
+	for _, role := range opts.Roles {
+		files = append(files, util.GetRoleCertFileName(opts.CertDir, role.Filename, role.Name))
+		if opts.GenerateRoleKey {
+			files = append(files, fmt.Sprintf("%s/%s.key.pem", opts.KeyDir, options.GetRoleKeyPrefix(opts, role)))
+		}
+		files = append(files, outputFiles(role.Outputs, opts)...)
+	}
+	for _, t := range opts.AccessTokens {
+		files = append(files, filepath.Join(opts.TokenDir, t.Domain, t.FileName))
+	}
+	for _, t := range opts.IdTokens {
+		files = append(files, t.FileName)
+	}
+	if len(opts.AWSCredentials) != 0 && opts.AWSCredentialsFile != "" {
+		files = append(files, opts.AWSCredentialsFile)
+	}
+	for _, file := range files {
+		err := os.Remove(file)
+		if err == nil {
+			log.Printf("removed file: %s\n", file)
+		} else if !os.IsNotExist(err) {
+			log.Printf("unable to remove file: %s, err: %v\n", file, err)
+		}
+	}
+}
+
+// outputFiles returns the additional output files
+func outputFiles(outputs []output.Output, opts *options.Options) []string {
+	var files []string
+	for _, o := range outputs {
+		files = append(files, o.FileName(opts.CertDir))
+	}
+	return files
+}
@@ -0,0 +1,124 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func deregisterTestOptions(test *testing.T, services ...string) *options.Options {
+	siaDir := test.TempDir()
+	opts := &options.Options{
+		Domain:     "athenz",
+		KeyDir:     siaDir,
+		CertDir:    siaDir,
+		BackUpDir:  siaDir + "/backup",
+		TokenDir:   siaDir + "/tokens",
+		Provider:   newTestProvider("athenz.aws.us-west-2", "pod-1234", nil),
+		InstanceId: "pod-1234",
+		Roles: []options.Role{{
+			Name:    "athenz:role.readers",
+			Service: services[0],
+			Outputs: []output.Output{{Format: output.FormatPemBundle, Filename: "athenz:role.readers.bundle.pem"}},
+		}},
+		AccessTokens: []config.AccessToken{
+			{FileName: "readers", Domain: "athenz", Service: services[0], Roles: []string{"readers"}},
+		},
+		IdTokens: []config.IdToken{{Name: "gcp", FileName: siaDir + "/gcp", Service: services[0]}},
+	}
+	files := []string{
+		siaDir + "/athenz:role.readers.cert.pem",
+		siaDir + "/athenz:role.readers.bundle.pem",
+		siaDir + "/tokens/athenz/readers",
+		siaDir + "/gcp",
+	}
+	for _, name := range services {
+		opts.Services = append(opts.Services, options.Service{Name: name})
+		keyFile := fmt.Sprintf("%s/athenz.%s.key.pem", siaDir, name)
+		certFile := fmt.Sprintf("%s/athenz.%s.cert.pem", siaDir, name)
+		require.Nil(test, copyFile("devel/data/key.pem", keyFile))
+		require.Nil(test, copyFile("devel/data/cert.pem", certFile))
+		files = append(files, siaDir+fmt.Sprintf("/backup/athenz.%s.key.pem", name))
+	}
+	require.Nil(test, os.MkdirAll(siaDir+"/backup", 0755))
+	require.Nil(test, os.MkdirAll(siaDir+"/tokens/athenz", 0755))
+	for _, file := range files {
+		require.Nil(test, os.WriteFile(file, []byte("test"), 0400))
+	}
+	return opts
+}
+
+func TestIsDeregisterCommand(test *testing.T) {
+	deregister, wipe := isDeregisterCommand("deregister")
+	assert.True(test, deregister)
+	assert.False(test, wipe)
+	deregister, wipe = isDeregisterCommand("deregister-wipe")
+	assert.True(test, deregister)
+	assert.True(test, wipe)
+	deregister, _ = isDeregisterCommand("register")
+	assert.False(test, deregister)
+}
+
+func TestDeregisterInstance(test *testing.T) {
+	opts := deregisterTestOptions(test, "hockey")
+	err := DeregisterInstance("http://127.0.0.1:5084/zts/v1", opts, false)
+	require.Nil(test, err, "unable to deregister instance: %v", err)
+	assert.FileExists(test, opts.KeyDir+"/athenz.hockey.key.pem")
+	assert.FileExists(test, opts.CertDir+"/athenz.hockey.cert.pem")
+	assert.FileExists(test, opts.TokenDir+"/athenz/readers")
+}
+
+func TestDeregisterInstanceWipe(test *testing.T) {
+	opts := deregisterTestOptions(test, "hockey")
+	err := DeregisterInstance("http://127.0.0.1:5084/zts/v1", opts, true)
+	require.Nil(test, err, "unable to deregister instance: %v", err)
+	assert.NoFileExists(test, opts.KeyDir+"/athenz.hockey.key.pem")
+	assert.NoFileExists(test, opts.CertDir+"/athenz.hockey.cert.pem")
+	assert.NoFileExists(test, opts.CertDir+"/athenz:role.readers.cert.pem")
+	assert.NoFileExists(test, opts.CertDir+"/athenz:role.readers.bundle.pem")
+	assert.NoFileExists(test, opts.BackUpDir+"/athenz.hockey.key.pem")
+	assert.NoFileExists(test, opts.TokenDir+"/athenz/readers")
+	assert.NoFileExists(test, opts.IdTokens[0].FileName)
+}
+
+func TestDeregisterInstanceNotRegistered(test *testing.T) {
+	// the mock zts server returns not found for all services
+	// other than hockey which is treated as already deregistered
+	opts := deregisterTestOptions(test, "hockey", "soccer")
+	err := DeregisterInstance("http://127.0.0.1:5084/zts/v1", opts, true)
+	require.Nil(test, err, "unable to deregister instance: %v", err)
+	assert.NoFileExists(test, opts.KeyDir+"/athenz.soccer.key.pem")
+}
+
+func TestDeregisterInstanceFailure(test *testing.T) {
+	// with an unreachable zts server the credentials must be kept
+	// so the command can be retried
+	opts := deregisterTestOptions(test, "hockey")
+	err := DeregisterInstance("http://127.0.0.1:1/zts/v1", opts, true)
+	require.NotNil(test, err)
+	assert.Contains(test, err.Error(), "unable to deregister 1 of 1 services")
+	assert.FileExists(test, opts.KeyDir+"/athenz.hockey.key.pem")
+	assert.FileExists(test, opts.TokenDir+"/athenz/readers")
+}
@@ -30,6 +30,7 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/AthenZ/athenz/clients/go/zts"
@@ -39,6 +40,9 @@ import (
 var caKeyStr string
 var caCertStr string
 
+// sshUserCAKey is the public key returned in the ssh user ca bundle
+const sshUserCAKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAICEFU0UuJDcj6n0g565Yl3ZdR+p33fRniJRyG3i6fVy+ athenz-ssh-user-ca"
+
 func SetupCA() (string, string) {
 
 	key, err := generateKeyPair()
@@ -100,6 +104,7 @@ func StartZtsServer(endPoint string) {
 			InstanceId:            "pod-1234",
 			X509CertificateSigner: caCertStr,
 			X509Certificate:       cert,
+			SshCertificate:        sshHostCert(data.Ssh),
 		}
 		identityBytes, err := json.Marshal(identity)
 		if err == nil {
@@ -143,6 +148,7 @@ func StartZtsServer(endPoint string) {
 			InstanceId:            "pod-1234",
 			X509CertificateSigner: caCertStr,
 			X509Certificate:       cert,
+			SshCertificate:        sshHostCert(data.Ssh),
 		}
 		identityBytes, err := json.Marshal(identity)
 		if err == nil {
@@ -151,6 +157,18 @@ func StartZtsServer(endPoint string) {
 		}
 	}).Methods("POST")
 
+	router.HandleFunc("/zts/v1/instance/{provider}/{domain}/{service}/{instanceId}", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("instance delete handler called")
+
+		if mux.Vars(r)["service"] != "hockey" {
+			w.WriteHeader(404)
+			io.WriteString(w, `{"code":404,"message":"unknown instance"}`)
+			return
+		}
+		w.WriteHeader(204)
+		log.Println("Successfully processed delete instance request")
+	}).Methods("DELETE")
+
 	router.HandleFunc("/zts/v1/rolecert", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("role certificate handler called")
 
@@ -189,6 +207,63 @@ func StartZtsServer(endPoint string) {
 		}
 	}).Methods("POST")
 
+	router.HandleFunc("/zts/v1/sshcert", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("ssh certificate handler called")
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Fatalln("Could not read the body")
+		}
+		var data *zts.SSHCertRequest
+		err = json.Unmarshal(body, &data)
+		if err != nil {
+			log.Fatalf("Could not parse the body into zts.SSHCertRequest: %v\n", err)
+		}
+		if data.CertRequestMeta == nil || data.CertRequestMeta.CertType != "host" || data.CertRequestMeta.InstanceId != "pod-1234" {
+			w.WriteHeader(403)
+			io.WriteString(w, `{"code":403,"message":"unknown ssh host certificate record"}`)
+			return
+		}
+
+		certs := &zts.SSHCertificates{
+			Certificates: []*zts.SSHCertificate{
+				{Certificate: "cert " + strings.TrimSpace(data.CertRequestData.PublicKey)},
+			},
+		}
+		certsBytes, err := json.Marshal(certs)
+		if err == nil {
+			w.WriteHeader(201)
+			io.WriteString(w, string(certsBytes))
+			log.Println("Successfully processed ssh certificate request")
+		}
+	}).Methods("POST")
+
+	router.HandleFunc("/zts/v1/cacerts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("ca bundle handler called")
+
+		name := mux.Vars(r)["name"]
+		var certs string
+		switch name {
+		case "athenz":
+			certs = caCertStr
+		case "ssh-user":
+			certs = "# athenz ssh user ca\n" + sshUserCAKey + "\n"
+		default:
+			w.WriteHeader(404)
+			io.WriteString(w, `{"code":404,"message":"unknown bundle"}`)
+			return
+		}
+		bundle := &zts.CertificateAuthorityBundle{
+			Name:  zts.SimpleName(name),
+			Certs: certs,
+		}
+		bundleBytes, err := json.Marshal(bundle)
+		if err == nil {
+			io.WriteString(w, string(bundleBytes))
+			log.Println("Successfully processed ca bundle request")
+		}
+	}).Methods("GET")
+
 	err := http.ListenAndServe(endPoint, router)
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err)
@@ -200,6 +275,20 @@ func init() {
 	caKeyStr, caCertStr = SetupCA()
 }
 
+// sshHostCert returns a fake host certificate for the public key in the ssh csr
+func sshHostCert(csr string) string {
+	if csr == "" {
+		return ""
+	}
+	var req struct {
+		Pubkey string `json:"pubkey"`
+	}
+	if err := json.Unmarshal([]byte(csr), &req); err != nil {
+		return ""
+	}
+	return "cert " + strings.TrimSpace(req.Pubkey)
+}
+
 func generateKeyPair() (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(rand.Reader, 2048)
 }
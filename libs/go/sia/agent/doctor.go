@@ -0,0 +1,454 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// doctorStatus is the result of a single doctor check
+type doctorStatus string
+
+const (
+	doctorPass doctorStatus = "pass"
+	doctorWarn doctorStatus = "warn"
+	doctorFail doctorStatus = "fail"
+)
+
+// ztsProbeTimeout is the maximum time to wait for the tls handshake with ZTS
+var ztsProbeTimeout = 10 * time.Second
+
+type doctorCheck struct {
+	Name    string       `json:"name"`
+	Status  doctorStatus `json:"status"`
+	Message string       `json:"message"`
+}
+
+// doctorReport contains the effective options the agent would run with
+// along with the results of all the configuration and connectivity checks
+type doctorReport struct {
+	Healthy  bool             `json:"healthy"`
+	Provider string           `json:"provider,omitempty"`
+	Options  *options.Options `json:"options"`
+	Checks   []doctorCheck    `json:"checks"`
+}
+
+func (r *doctorReport) add(name string, status doctorStatus, format string, args ...interface{}) {
+	if status == doctorFail {
+		r.Healthy = false
+	}
+	r.Checks = append(r.Checks, doctorCheck{
+		Name:    name,
+		Status:  status,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// isDoctorCommand returns true if the given sia command requests the
+// doctor report and whether the report should be generated in json
+func isDoctorCommand(siaCmd string) (bool, bool) {
+	switch siaCmd {
+	case "doctor", "check":
+		return true, false
+	case "doctor-json", "check-json":
+		return true, true
+	}
+	return false, false
+}
+
+// RunDoctor validates the configuration, the files managed by the agent and
+// the connectivity to ZTS without making any changes on the host. The report
+// is written to the given writer either in text or json format and the
+// function returns the exit code for the command: 0 if all checks passed
+// or only reported warnings and 1 otherwise.
+func RunDoctor(w io.Writer, ztsUrl string, opts *options.Options, jsonOutput bool) int {
+	report := runDoctorChecks(ztsUrl, opts)
+	var err error
+	if jsonOutput {
+		err = writeDoctorJson(w, report)
+	} else {
+		err = writeDoctorText(w, report)
+	}
+	if err != nil || !report.Healthy {
+		return 1
+	}
+	return 0
+}
+
+func runDoctorChecks(ztsUrl string, opts *options.Options) *doctorReport {
+	// the provider includes platform specific attestation details
+	// (e.g. the instance identity document) that are not useful in
+	// the report so we only include its name
+	effective := *opts
+	effective.Provider = nil
+
+	report := &doctorReport{
+		Healthy: true,
+		Options: &effective,
+	}
+	if opts.Provider != nil {
+		report.Provider = opts.Provider.GetName()
+	}
+	if !checkDoctorOptions(report, ztsUrl, opts) {
+		return report
+	}
+	checkDoctorDirectories(report, opts)
+	for _, svc := range opts.Services {
+		keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+		certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+		checkDoctorCertificate(report, "service cert "+svc.Name, certFile, keyFile, opts)
+	}
+	for _, role := range opts.Roles {
+		certFile := util.GetRoleCertFileName(opts.CertDir, role.Filename, role.Name)
+		checkDoctorCertificate(report, "role cert "+role.Name, certFile, "", opts)
+	}
+	checkDoctorZts(report, ztsUrl, opts)
+	checkDoctorSds(report, opts)
+	return report
+}
+
+// checkDoctorOptions verifies the effective options and returns false if the
+// options are not valid enough to run any of the remaining checks
+func checkDoctorOptions(report *doctorReport, ztsUrl string, opts *options.Options) bool {
+	var problems []string
+	if opts.Domain == "" {
+		problems = append(problems, "domain is not configured")
+	}
+	if len(opts.Services) == 0 {
+		problems = append(problems, "no services are configured")
+	}
+	if opts.KeyDir == "" || opts.CertDir == "" {
+		problems = append(problems, "key and cert directories are not configured")
+	}
+	if opts.Provider == nil {
+		problems = append(problems, "provider is not configured")
+	}
+	if u, err := url.Parse(ztsUrl); err != nil || u.Scheme != "https" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("invalid zts url: %q", ztsUrl))
+	}
+	if len(problems) != 0 {
+		report.add("options", doctorFail, "%s", strings.Join(problems, ", "))
+		return false
+	}
+	report.add("options", doctorPass, "provider %s, domain %s, services %s, zts %s", opts.Provider.GetName(), opts.Domain, options.GetSvcNames(opts.Services), ztsUrl)
+	return true
+}
+
+// checkDoctorDirectories verifies that the directories managed by the agent are
+// not writable by other users and can be updated by the user the agent runs as
+func checkDoctorDirectories(report *doctorReport, opts *options.Options) {
+	dirs := []string{opts.CertDir, opts.KeyDir, opts.BackUpDir}
+	if len(opts.AccessTokens) != 0 || len(opts.IdTokens) != 0 {
+		dirs = append(dirs, opts.TokenDir)
+	}
+	for _, token := range opts.AccessTokens {
+		dirs = append(dirs, filepath.Join(opts.TokenDir, token.Domain))
+	}
+	for _, token := range opts.IdTokens {
+		dirs = append(dirs, filepath.Dir(token.FileName))
+	}
+
+	runUid, runGid := options.GetRunsAsUidGid(opts)
+	if runUid == -1 {
+		runUid = os.Geteuid()
+	}
+	if runGid == -1 {
+		runGid = os.Getegid()
+	}
+
+	checked := make(map[string]bool)
+	for _, dir := range dirs {
+		if dir == "" || checked[dir] {
+			continue
+		}
+		checked[dir] = true
+		name := "directory " + dir
+		info, err := os.Stat(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				report.add(name, doctorWarn, "directory does not exist and will be created by the agent")
+			} else {
+				report.add(name, doctorFail, "unable to stat directory: %v", err)
+			}
+			continue
+		}
+		if !info.IsDir() {
+			report.add(name, doctorFail, "path is not a directory")
+			continue
+		}
+		mode := info.Mode()
+		uid, gid := util.FileOwner(info)
+		if mode.Perm()&0002 != 0 && mode&os.ModeSticky == 0 {
+			report.add(name, doctorFail, "directory is writable by all users, mode %04o", mode.Perm())
+			continue
+		}
+		if !dirWritable(mode, uid, gid, runUid, runGid) {
+			report.add(name, doctorFail, "directory owned by %d:%d with mode %04o is not writable by %d:%d", uid, gid, mode.Perm(), runUid, runGid)
+			continue
+		}
+		report.add(name, doctorPass, "owner %d:%d, mode %04o", uid, gid, mode.Perm())
+	}
+}
+
+func dirWritable(mode os.FileMode, uid, gid, runUid, runGid int) bool {
+	// if the os does not provide the owner details or we're
+	// running as root, the directory is always writable
+	if uid == -1 || runUid == 0 {
+		return true
+	}
+	perm := mode.Perm()
+	switch {
+	case uid == runUid:
+		return perm&0200 != 0
+	case gid == runGid:
+		return perm&0020 != 0
+	default:
+		return perm&0002 != 0
+	}
+}
+
+// checkDoctorCertificate parses the given certificate and reports its subject,
+// san values and expiry. It verifies that the certificate matches the private
+// key, if one is specified, and that it chains to the Athenz CA certificates
+func checkDoctorCertificate(report *doctorReport, name, certFile, keyFile string, opts *options.Options) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			report.add(name, doctorFail, "certificate %s does not exist", certFile)
+		} else {
+			report.add(name, doctorFail, "unable to read certificate %s: %v", certFile, err)
+		}
+		return
+	}
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		report.add(name, doctorFail, "unable to parse certificate %s: %v", certFile, err)
+		return
+	}
+	x509Cert := certs[0]
+	details := fmt.Sprintf("subject %s, san %s, expires %s", x509Cert.Subject.String(),
+		strings.Join(certSanValues(x509Cert), ","), x509Cert.NotAfter.UTC().Format(time.RFC3339))
+
+	now := time.Now()
+	if now.After(x509Cert.NotAfter) {
+		report.add(name, doctorFail, "certificate %s has expired: %s", certFile, details)
+		return
+	}
+	if now.Before(x509Cert.NotBefore) {
+		report.add(name, doctorFail, "certificate %s is not valid yet: %s", certFile, details)
+		return
+	}
+	if keyFile != "" {
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			report.add(name, doctorFail, "certificate %s does not match private key %s: %v", certFile, keyFile, err)
+			return
+		}
+		if info, err := os.Stat(keyFile); err == nil && info.Mode().Perm()&0004 != 0 {
+			report.add(name, doctorFail, "private key %s is readable by all users, mode %04o", keyFile, info.Mode().Perm())
+			return
+		}
+	}
+	if err := verifyCertificateChain(certs, opts.AthenzCACertFile); err != nil {
+		report.add(name, doctorFail, "certificate %s chain verification failed: %v", certFile, err)
+		return
+	}
+	if opts.CertRefreshRatio > 0 && now.After(lifetimeRefreshTime(x509Cert.NotBefore, x509Cert.NotAfter, 0, opts.CertRefreshRatio, 0)) {
+		report.add(name, doctorWarn, "certificate is past its refresh time: %s", details)
+		return
+	}
+	report.add(name, doctorPass, "%s", details)
+}
+
+func parseCertificates(certPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		x509Cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, x509Cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+func certSanValues(x509Cert *x509.Certificate) []string {
+	var values []string
+	values = append(values, x509Cert.DNSNames...)
+	for _, uri := range x509Cert.URIs {
+		values = append(values, uri.String())
+	}
+	for _, ip := range x509Cert.IPAddresses {
+		values = append(values, ip.String())
+	}
+	values = append(values, x509Cert.EmailAddresses...)
+	sort.Strings(values)
+	return values
+}
+
+// verifyCertificateChain verifies that the leaf certificate chains to the
+// Athenz CA certificates with any remaining certificates used as intermediates
+func verifyCertificateChain(certs []*x509.Certificate, caCertFile string) error {
+	caPEM, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return fmt.Errorf("unable to read ca certificates %s: %v", caCertFile, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no valid ca certificates found in %s", caCertFile)
+	}
+	intermediates := x509.NewCertPool()
+	for _, x509Cert := range certs[1:] {
+		intermediates.AddCert(x509Cert)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// checkDoctorZts verifies that ZTS is reachable and that its server certificate
+// is trusted. The primary service identity is presented if it's available.
+func checkDoctorZts(report *doctorReport, ztsUrl string, opts *options.Options) {
+	u, _ := url.Parse(ztsUrl)
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "443")
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: u.Hostname(),
+	}
+	if opts.ZTSServerName != "" {
+		config.ServerName = opts.ZTSServerName
+	}
+	if opts.ZTSCACertFile != "" {
+		caPEM, err := os.ReadFile(opts.ZTSCACertFile)
+		if err != nil {
+			report.add("zts", doctorFail, "unable to read zts ca certificates %s: %v", opts.ZTSCACertFile, err)
+			return
+		}
+		config.RootCAs = x509.NewCertPool()
+		config.RootCAs.AppendCertsFromPEM(caPEM)
+	}
+	svc := opts.Services[0]
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+	if keyPair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		config.Certificates = []tls.Certificate{keyPair}
+	}
+
+	start := time.Now()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: ztsProbeTimeout}, "tcp", address, config)
+	if err != nil {
+		report.add("zts", doctorFail, "unable to establish tls connection with %s: %v", address, err)
+		return
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	server := state.PeerCertificates[0]
+	report.add("zts", doctorPass, "connected to %s in %v using %s, server certificate %s expires %s", address,
+		time.Since(start).Round(time.Millisecond), tls.VersionName(state.Version), server.Subject.String(),
+		server.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// checkDoctorSds verifies that the sds unix domain socket, if configured,
+// can be created by the agent or is a socket that clients can connect to
+func checkDoctorSds(report *doctorReport, opts *options.Options) {
+	if opts.SDSUdsPath == "" {
+		return
+	}
+	name := "sds socket " + opts.SDSUdsPath
+	info, err := os.Stat(opts.SDSUdsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			report.add(name, doctorFail, "unable to stat socket: %v", err)
+			return
+		}
+		dir := filepath.Dir(opts.SDSUdsPath)
+		if dirInfo, err := os.Stat(dir); err != nil || !dirInfo.IsDir() {
+			report.add(name, doctorFail, "socket directory %s does not exist", dir)
+			return
+		}
+		report.add(name, doctorWarn, "socket does not exist and will be created when the agent starts")
+		return
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		report.add(name, doctorFail, "path exists but is not a socket")
+		return
+	}
+	uid, gid := util.FileOwner(info)
+	if info.Mode().Perm()&0006 != 0006 {
+		report.add(name, doctorWarn, "socket owned by %d:%d with mode %04o is not accessible by all users, sds clients are authorized by uid", uid, gid, info.Mode().Perm())
+		return
+	}
+	report.add(name, doctorPass, "owner %d:%d, mode %04o", uid, gid, info.Mode().Perm())
+}
+
+func writeDoctorJson(w io.Writer, report *doctorReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func writeDoctorText(w io.Writer, report *doctorReport) error {
+	effective, err := json.MarshalIndent(report.Options, "", "  ")
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "effective options:\n%s\n\nchecks:\n", effective)
+	counts := make(map[doctorStatus]int)
+	for _, check := range report.Checks {
+		counts[check.Status]++
+		fmt.Fprintf(&b, "[%s] %s: %s\n", strings.ToUpper(string(check.Status)), check.Name, check.Message)
+	}
+	result := "healthy"
+	if !report.Healthy {
+		result = "unhealthy"
+	}
+	fmt.Fprintf(&b, "\nsummary: %s - %d passed, %d warnings, %d failed\n", result, counts[doctorPass], counts[doctorWarn], counts[doctorFail])
+	_, err = io.WriteString(w, b.String())
+	return err
+}
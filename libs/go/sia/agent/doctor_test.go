@@ -0,0 +1,250 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type doctorTestCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newDoctorTestCA(test *testing.T) *doctorTestCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(test, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Athenz Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(test, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(test, err)
+	return &doctorTestCA{cert: cert, key: key}
+}
+
+// issue writes a certificate and private key signed by the ca
+func (ca *doctorTestCA) issue(test *testing.T, cn, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(test, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"api.athenz.cloud"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.Nil(test, err)
+	require.Nil(test, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0444))
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.Nil(test, err)
+	require.Nil(test, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0400))
+}
+
+func (ca *doctorTestCA) writeCert(test *testing.T, caFile string) {
+	require.Nil(test, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0444))
+}
+
+// startDoctorTestZts starts a tls listener with a certificate issued by the ca
+// and returns the zts url pointing to the listener
+func startDoctorTestZts(test *testing.T, ca *doctorTestCA) string {
+	dir := test.TempDir()
+	ca.issue(test, "zts.athenz.cloud", filepath.Join(dir, "zts.cert.pem"), filepath.Join(dir, "zts.key.pem"))
+	keyPair, err := tls.LoadX509KeyPair(filepath.Join(dir, "zts.cert.pem"), filepath.Join(dir, "zts.key.pem"))
+	require.Nil(test, err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{keyPair}})
+	require.Nil(test, err)
+	test.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return fmt.Sprintf("https://%s/zts/v1", listener.Addr().String())
+}
+
+func doctorTestOptions(test *testing.T, ca *doctorTestCA) *options.Options {
+	siaDir := test.TempDir()
+	opts := &options.Options{
+		Provider:         newTestProvider("athenz.aws.us-west-2", "i-1234", []string{"aws.athenz.cloud"}),
+		Domain:           "athenz",
+		Services:         []options.Service{{Name: "api"}},
+		Roles:            []options.Role{{Name: "athenz:role.readers", Service: "api"}},
+		KeyDir:           filepath.Join(siaDir, "keys"),
+		CertDir:          filepath.Join(siaDir, "certs"),
+		BackUpDir:        filepath.Join(siaDir, "backup"),
+		TokenDir:         filepath.Join(siaDir, "tokens"),
+		AthenzCACertFile: filepath.Join(siaDir, "certs", "ca.cert.pem"),
+		ZTSCACertFile:    filepath.Join(siaDir, "certs", "ca.cert.pem"),
+		SDSUdsPath:       filepath.Join(siaDir, "sds.sock"),
+		CertRefreshRatio: 0.75,
+	}
+	require.Nil(test, os.MkdirAll(opts.KeyDir, 0755))
+	require.Nil(test, os.MkdirAll(opts.CertDir, 0755))
+	require.Nil(test, os.MkdirAll(opts.BackUpDir, 0755))
+	ca.writeCert(test, opts.AthenzCACertFile)
+	ca.issue(test, "athenz.api", filepath.Join(opts.CertDir, "athenz.api.cert.pem"), filepath.Join(opts.KeyDir, "athenz.api.key.pem"))
+	ca.issue(test, "athenz:role.readers", filepath.Join(opts.CertDir, "athenz:role.readers.cert.pem"), filepath.Join(opts.KeyDir, "athenz:role.readers.key.pem"))
+	return opts
+}
+
+func doctorChecks(report *doctorReport) map[string]doctorCheck {
+	checks := make(map[string]doctorCheck)
+	for _, check := range report.Checks {
+		checks[check.Name] = check
+	}
+	return checks
+}
+
+func TestIsDoctorCommand(test *testing.T) {
+	tests := []struct {
+		cmd        string
+		doctor     bool
+		jsonOutput bool
+	}{
+		{"doctor", true, false},
+		{"check", true, false},
+		{"doctor-json", true, true},
+		{"check-json", true, true},
+		{"post", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		doctor, jsonOutput := isDoctorCommand(tt.cmd)
+		assert.Equal(test, tt.doctor, doctor, tt.cmd)
+		assert.Equal(test, tt.jsonOutput, jsonOutput, tt.cmd)
+	}
+}
+
+func TestRunDoctorHealthy(test *testing.T) {
+	ca := newDoctorTestCA(test)
+	opts := doctorTestOptions(test, ca)
+	ztsUrl := startDoctorTestZts(test, ca)
+
+	var output bytes.Buffer
+	assert.Equal(test, 0, RunDoctor(&output, ztsUrl, opts, false))
+	text := output.String()
+	assert.Contains(test, text, "effective options:")
+	assert.Contains(test, text, "[PASS] service cert api: subject CN=athenz.api, san 127.0.0.1,api.athenz.cloud")
+	assert.Contains(test, text, "[PASS] role cert athenz:role.readers")
+	assert.Contains(test, text, "[PASS] zts: connected to 127.0.0.1")
+	assert.Contains(test, text, "[WARN] sds socket")
+	assert.Contains(test, text, "summary: healthy")
+}
+
+func TestRunDoctorProblems(test *testing.T) {
+	ca := newDoctorTestCA(test)
+	opts := doctorTestOptions(test, ca)
+	opts.Roles = append(opts.Roles, options.Role{Name: "athenz:role.writers", Service: "api"})
+
+	// replace the service key so it no longer matches the certificate
+	otherDir := test.TempDir()
+	ca.issue(test, "athenz.api", filepath.Join(otherDir, "cert.pem"), filepath.Join(otherDir, "key.pem"))
+	keyFile := filepath.Join(opts.KeyDir, "athenz.api.key.pem")
+	require.Nil(test, os.Remove(keyFile))
+	require.Nil(test, os.Rename(filepath.Join(otherDir, "key.pem"), keyFile))
+
+	// the role certificate is issued by a different ca
+	newDoctorTestCA(test).issue(test, "athenz:role.readers", filepath.Join(opts.CertDir, "athenz:role.readers.cert.pem"), filepath.Join(otherDir, "role.key.pem"))
+
+	// the token directory is writable by all users
+	opts.AccessTokens = []config.AccessToken{{FileName: "readers", Domain: "athenz", Service: "api", Roles: []string{"readers"}}}
+	require.Nil(test, os.MkdirAll(opts.TokenDir, 0755))
+	require.Nil(test, os.Chmod(opts.TokenDir, 0777))
+
+	// zts is not listening on the given port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(test, err)
+	ztsUrl := fmt.Sprintf("https://%s/zts/v1", listener.Addr().String())
+	listener.Close()
+
+	var output bytes.Buffer
+	assert.Equal(test, 1, RunDoctor(&output, ztsUrl, opts, true))
+
+	var report doctorReport
+	require.Nil(test, json.Unmarshal(output.Bytes(), &report))
+	assert.False(test, report.Healthy)
+	assert.Equal(test, "athenz", report.Options.Domain)
+	assert.Equal(test, "athenz.aws.us-west-2", report.Provider)
+
+	checks := doctorChecks(&report)
+	assert.Equal(test, doctorFail, checks["service cert api"].Status)
+	assert.Contains(test, checks["service cert api"].Message, "does not match private key")
+	assert.Equal(test, doctorFail, checks["role cert athenz:role.readers"].Status)
+	assert.Contains(test, checks["role cert athenz:role.readers"].Message, "chain verification failed")
+	assert.Equal(test, doctorFail, checks["role cert athenz:role.writers"].Status)
+	assert.Contains(test, checks["role cert athenz:role.writers"].Message, "does not exist")
+	assert.Equal(test, doctorFail, checks["zts"].Status)
+	assert.Equal(test, doctorPass, checks["directory "+opts.KeyDir].Status)
+	assert.Equal(test, doctorFail, checks["directory "+opts.TokenDir].Status)
+	assert.Equal(test, doctorWarn, checks["directory "+filepath.Join(opts.TokenDir, "athenz")].Status)
+}
+
+func TestRunDoctorInvalidOptions(test *testing.T) {
+	opts := &options.Options{Services: []options.Service{{Name: "api"}}}
+	var output bytes.Buffer
+	assert.Equal(test, 1, RunDoctor(&output, "http://zts.athenz.cloud/zts/v1", opts, false))
+	assert.Contains(test, output.String(), "[FAIL] options: domain is not configured")
+	assert.Contains(test, output.String(), "invalid zts url")
+	assert.Contains(test, output.String(), "summary: unhealthy - 0 passed, 0 warnings, 1 failed")
+}
+
+func TestDirWritable(test *testing.T) {
+	assert.True(test, dirWritable(0700, 1000, 1000, 1000, 1000))
+	assert.False(test, dirWritable(0500, 1000, 1000, 1000, 1000))
+	assert.True(test, dirWritable(0770, 0, 1000, 1000, 1000))
+	assert.False(test, dirWritable(0750, 0, 1000, 1000, 1000))
+	assert.True(test, dirWritable(0700, 1000, 1000, 0, 0))
+	assert.True(test, dirWritable(0700, -1, -1, 1000, 1000))
+}
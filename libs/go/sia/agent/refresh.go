@@ -0,0 +1,201 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/pki/cert"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/cenkalti/backoff"
+)
+
+// errRefreshStopped is returned when the agent was asked to stop
+// while waiting to retry a failed refresh request
+var errRefreshStopped = errors.New("refresh stopped")
+
+// minRefreshRetryInterval is the shortest time the agent waits before retrying
+// a failed refresh request when the certificates are about to expire
+const minRefreshRetryInterval = 5 * time.Second
+
+// certLifetimeFunc returns the validity period of the certificates being refreshed
+type certLifetimeFunc func() (time.Time, time.Time, error)
+
+// refreshIdentity refreshes the service identity certificates for the
+// given services with new attestation data from the platform provider
+func refreshIdentity(ztsUrl string, services []options.Service, opts *options.Options) error {
+	for _, svc := range opts.Services {
+		if !containsService(services, svc.Name) {
+			continue
+		}
+		err := refreshSvc(svc, ztsUrl, opts)
+		recordCertStatus(status.ServiceCert, svc.Name, util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name), err)
+		if err != nil {
+			return fmt.Errorf("unable to refresh identity for svc: %q, error: %v", svc.Name, err)
+		}
+	}
+	return nil
+}
+
+// refreshIdentityWithBackoff refreshes the service identity certificates
+// for the given services and retries any failures with exponential backoff
+// and jitter for as long as the current certificates are still valid
+func refreshIdentityWithBackoff(ztsUrl string, services []options.Service, opts *options.Options, stop <-chan bool) error {
+	refresh := func() error {
+		return refreshIdentity(ztsUrl, services, opts)
+	}
+	lifetime := func() (time.Time, time.Time, error) {
+		return svcCertLifetime(services, opts)
+	}
+	return supervisedRefresh(refresh, lifetime, newRefreshBackoff(opts), stop)
+}
+
+// supervisedRefresh runs the given refresh function until it succeeds. The
+// function is retried based on the backoff policy as long as the certificates
+// are still valid. The caller can interrupt the retries with the stop channel.
+func supervisedRefresh(refresh func() error, lifetime certLifetimeFunc, b backoff.BackOff, stop <-chan bool) error {
+	b.Reset()
+	for {
+		err := refresh()
+		if err == nil {
+			return nil
+		}
+		notBefore, notAfter, certErr := lifetime()
+		if certErr != nil {
+			return fmt.Errorf("refresh failed: %v, unable to determine certificate expiry: %v", err, certErr)
+		}
+		now := time.Now()
+		remaining := notAfter.Sub(now)
+		if remaining <= 0 {
+			return fmt.Errorf("refresh failed: %v, certificates expired at %s", err, notAfter.Format(time.RFC3339))
+		}
+		delay := b.NextBackOff()
+		if delay == backoff.Stop {
+			return fmt.Errorf("refresh failed: %v, retries exhausted", err)
+		}
+		delay = retryDelay(delay, remaining)
+		log.Print(refreshFailureMessage(refreshFailureSeverity(notBefore, notAfter, now), err, remaining, delay))
+
+		select {
+		case <-stop:
+			return errRefreshStopped
+		case <-time.After(delay):
+		}
+	}
+}
+
+// refreshSeverity indicates how urgent a refresh failure is
+type refreshSeverity int
+
+const (
+	refreshSeverityLow    refreshSeverity = iota //most of the certificate lifetime is remaining
+	refreshSeverityMedium                        //less than half of the lifetime is remaining
+	refreshSeverityHigh                          //the certificates are about to expire
+)
+
+// refreshFailureSeverity returns the severity of a refresh failure
+// based on how much of the certificate lifetime is remaining
+func refreshFailureSeverity(notBefore, notAfter, now time.Time) refreshSeverity {
+	lifetime := notAfter.Sub(notBefore)
+	if lifetime <= 0 {
+		return refreshSeverityHigh
+	}
+	remaining := float64(notAfter.Sub(now)) / float64(lifetime)
+	switch {
+	case remaining > 0.5:
+		return refreshSeverityLow
+	case remaining > 0.2:
+		return refreshSeverityMedium
+	default:
+		return refreshSeverityHigh
+	}
+}
+
+// refreshFailureMessage returns the log message for a refresh failure. The
+// message is escalated as the certificates get closer to their expiry so
+// the failures that require attention stand out in the agent logs.
+func refreshFailureMessage(severity refreshSeverity, err error, remaining, delay time.Duration) string {
+	switch severity {
+	case refreshSeverityLow:
+		return fmt.Sprintf("refresh failed: %v, certificates expire in %s, retrying in %s\n",
+			err, remaining.Round(time.Second), delay.Round(time.Second))
+	case refreshSeverityMedium:
+		return fmt.Sprintf("refresh failed: %v, less than half of the certificate lifetime is remaining, certificates expire in %s, retrying in %s\n",
+			err, remaining.Round(time.Second), delay.Round(time.Second))
+	default:
+		return fmt.Sprintf("refresh failed: %v, certificates are about to expire in %s and must be refreshed, retrying in %s\n",
+			err, remaining.Round(time.Second), delay.Round(time.Second))
+	}
+}
+
+// retryDelay limits the backoff delay to half of the remaining certificate
+// lifetime so we never wait past the expiry without trying again. The delay
+// is never reduced below minRefreshRetryInterval so we don't flood ZTS with
+// requests when the certificates are about to expire.
+func retryDelay(delay, remaining time.Duration) time.Duration {
+	if delay > remaining/2 {
+		delay = remaining / 2
+		if delay < minRefreshRetryInterval {
+			delay = minRefreshRetryInterval
+		}
+	}
+	return delay
+}
+
+// newRefreshBackoff returns the exponential backoff policy for failed
+// refresh requests based on the configured intervals. The elapsed time
+// is not limited since the retries are bound by the certificate expiry.
+func newRefreshBackoff(opts *options.Options) *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = time.Duration(opts.BackoffInitialInterval) * time.Second
+	b.MaxInterval = time.Duration(opts.BackoffMaxInterval) * time.Second
+	b.Multiplier = 2
+	b.MaxElapsedTime = 0
+	return b
+}
+
+// svcCertLifetime returns the validity period of the service
+// certificate that expires first
+func svcCertLifetime(services []options.Service, opts *options.Options) (time.Time, time.Time, error) {
+	var notBefore, notAfter time.Time
+	for _, svc := range services {
+		certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+		x509Cert, err := cert.FromFile(certFile)
+		if err != nil {
+			return notBefore, notAfter, err
+		}
+		if notAfter.IsZero() || x509Cert.NotAfter.Before(notAfter) {
+			notBefore = x509Cert.NotBefore
+			notAfter = x509Cert.NotAfter
+		}
+	}
+	return notBefore, notAfter, nil
+}
+
+func containsService(services []options.Service, name string) bool {
+	for _, svc := range services {
+		if svc.Name == name {
+			return true
+		}
+	}
+	return false
+}
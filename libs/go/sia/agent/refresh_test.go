@@ -0,0 +1,148 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/cenkalti/backoff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validLifetime() (time.Time, time.Time, error) {
+	now := time.Now()
+	return now.Add(-time.Hour), now.Add(time.Hour), nil
+}
+
+func TestSupervisedRefreshRetries(test *testing.T) {
+	attempts := 0
+	refresh := func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("zts unavailable")
+		}
+		return nil
+	}
+	err := supervisedRefresh(refresh, validLifetime, backoff.NewConstantBackOff(time.Millisecond), make(chan bool))
+	require.Nil(test, err)
+	assert.Equal(test, 3, attempts)
+}
+
+func TestSupervisedRefreshExpired(test *testing.T) {
+	attempts := 0
+	refresh := func() error {
+		attempts++
+		return errors.New("zts unavailable")
+	}
+	lifetime := func() (time.Time, time.Time, error) {
+		now := time.Now()
+		return now.Add(-2 * time.Hour), now.Add(-time.Hour), nil
+	}
+	err := supervisedRefresh(refresh, lifetime, backoff.NewConstantBackOff(time.Millisecond), make(chan bool))
+	require.NotNil(test, err)
+	assert.Equal(test, 1, attempts)
+}
+
+func TestSupervisedRefreshCertError(test *testing.T) {
+	refresh := func() error {
+		return errors.New("zts unavailable")
+	}
+	lifetime := func() (time.Time, time.Time, error) {
+		return time.Time{}, time.Time{}, errors.New("no cert file")
+	}
+	err := supervisedRefresh(refresh, lifetime, backoff.NewConstantBackOff(time.Millisecond), make(chan bool))
+	require.NotNil(test, err)
+	assert.NotEqual(test, errRefreshStopped, err)
+}
+
+func TestSupervisedRefreshStopped(test *testing.T) {
+	refresh := func() error {
+		return errors.New("zts unavailable")
+	}
+	stop := make(chan bool, 1)
+	stop <- true
+	err := supervisedRefresh(refresh, validLifetime, backoff.NewConstantBackOff(time.Minute), stop)
+	assert.Equal(test, errRefreshStopped, err)
+}
+
+func TestRefreshFailureSeverity(test *testing.T) {
+	notBefore := time.Now()
+	notAfter := notBefore.Add(100 * time.Hour)
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected refreshSeverity
+	}{
+		{"early", notBefore.Add(10 * time.Hour), refreshSeverityLow},
+		{"half", notBefore.Add(60 * time.Hour), refreshSeverityMedium},
+		{"close", notBefore.Add(90 * time.Hour), refreshSeverityHigh},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, refreshFailureSeverity(notBefore, notAfter, tt.now))
+		})
+	}
+	// certificates without a valid lifetime are always urgent
+	assert.Equal(test, refreshSeverityHigh, refreshFailureSeverity(notAfter, notBefore, notBefore))
+}
+
+func TestRefreshFailureMessage(test *testing.T) {
+	err := errors.New("zts unavailable")
+	assert.Equal(test, "refresh failed: zts unavailable, certificates expire in 80h0m0s, retrying in 30s\n",
+		refreshFailureMessage(refreshSeverityLow, err, 80*time.Hour, 30*time.Second))
+	assert.Equal(test, "refresh failed: zts unavailable, less than half of the certificate lifetime is remaining, certificates expire in 40h0m0s, retrying in 30s\n",
+		refreshFailureMessage(refreshSeverityMedium, err, 40*time.Hour, 30*time.Second))
+	assert.Equal(test, "refresh failed: zts unavailable, certificates are about to expire in 1h0m0s and must be refreshed, retrying in 30s\n",
+		refreshFailureMessage(refreshSeverityHigh, err, time.Hour, 30*time.Second))
+}
+
+func TestRetryDelay(test *testing.T) {
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		remaining time.Duration
+		expected  time.Duration
+	}{
+		{"backoff", 10 * time.Second, time.Hour, 10 * time.Second},
+		{"half-lifetime", 10 * time.Minute, 10 * time.Minute, 5 * time.Minute},
+		{"minimum", time.Minute, 2 * time.Second, minRefreshRetryInterval},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, retryDelay(tt.delay, tt.remaining))
+		})
+	}
+}
+
+func TestNewRefreshBackoff(test *testing.T) {
+	opts := &options.Options{
+		BackoffInitialInterval: 10,
+		BackoffMaxInterval:     60,
+	}
+	b := newRefreshBackoff(opts)
+	assert.Equal(test, 10*time.Second, b.InitialInterval)
+	assert.Equal(test, 60*time.Second, b.MaxInterval)
+	for i := 0; i < 10; i++ {
+		delay := b.NextBackOff()
+		assert.NotEqual(test, backoff.Stop, delay)
+		assert.True(test, delay <= 90*time.Second)
+	}
+}
@@ -0,0 +1,185 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+)
+
+// ReloadFunc re-reads the sia configuration and returns the new options
+// with the same provider specific settings as the options the agent was
+// started with
+type ReloadFunc func() (*options.Options, error)
+
+// reloadedConfig contains the options and the token options generated
+// after the configuration has been successfully reloaded
+type reloadedConfig struct {
+	opts      *options.Options
+	tokenOpts *config.TokenOptions
+}
+
+// reloadConfig re-reads the configuration and verifies that the new options
+// can be applied to the running agent. If the configuration is invalid, an
+// error is returned and the agent continues to run with the current options.
+func reloadConfig(current *options.Options, reload ReloadFunc, ztsUrl string) (*reloadedConfig, error) {
+	updated, err := reload()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load configuration: %v", err)
+	}
+	err = validateReload(current, updated)
+	if err != nil {
+		return nil, err
+	}
+	var tokenOpts *config.TokenOptions
+	if len(updated.AccessTokens) != 0 || len(updated.IdTokens) != 0 {
+		tokenOpts, err = tokenOptions(updated, ztsUrl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	applyReloadChanges(current, updated)
+	return &reloadedConfig{
+		opts:      updated,
+		tokenOpts: tokenOpts,
+	}, nil
+}
+
+// validateReload verifies that the updated options only include changes
+// that can be applied without restarting the agent. New services cannot be
+// added since they must be registered with a fresh attestation document and
+// the listeners cannot be moved without dropping the existing connections.
+func validateReload(current, updated *options.Options) error {
+	if updated.Domain != current.Domain {
+		return fmt.Errorf("domain cannot be changed from %s to %s without restart", current.Domain, updated.Domain)
+	}
+	for _, svc := range updated.Services {
+		if !containsService(current.Services, svc.Name) {
+			return fmt.Errorf("service %s cannot be added without restart", svc.Name)
+		}
+	}
+	if updated.SDSUdsPath != current.SDSUdsPath {
+		return fmt.Errorf("sds uds path cannot be changed without restart")
+	}
+	if updated.SDSTcpAddress != current.SDSTcpAddress {
+		return fmt.Errorf("sds tcp address cannot be changed without restart")
+	}
+	if updated.StatusUdsPath != current.StatusUdsPath || updated.StatusPort != current.StatusPort {
+		return fmt.Errorf("status listener cannot be changed without restart")
+	}
+	if updated.TokenServerUdsPath != current.TokenServerUdsPath {
+		return fmt.Errorf("token server uds path cannot be changed without restart")
+	}
+	if updated.AWSCredentialsPort != current.AWSCredentialsPort {
+		return fmt.Errorf("aws credentials port cannot be changed without restart")
+	}
+	return nil
+}
+
+// applyReloadChanges logs the differences between the current and updated
+// options and updates the status tracker for the added and removed entries
+func applyReloadChanges(current, updated *options.Options) {
+	for _, svc := range current.Services {
+		if !containsService(updated.Services, svc.Name) {
+			log.Printf("reload: service %s removed, certificate will no longer be refreshed\n", svc.Name)
+			status.Unregister(status.ServiceCert, svc.Name)
+		}
+	}
+	for _, role := range current.Roles {
+		if !containsRole(updated.Roles, role.Name) {
+			log.Printf("reload: role %s removed, certificate will no longer be refreshed\n", role.Name)
+			status.Unregister(status.RoleCert, role.Name)
+		}
+	}
+	for _, role := range updated.Roles {
+		if !containsRole(current.Roles, role.Name) {
+			log.Printf("reload: role %s added\n", role.Name)
+		}
+	}
+	for _, token := range current.AccessTokens {
+		if !containsToken(updated.AccessTokens, token) {
+			log.Printf("reload: access token %s/%s removed, token will no longer be refreshed\n", token.Domain, token.FileName)
+			status.Unregister(status.AccessToken, token.Domain+"/"+token.FileName)
+		}
+	}
+	for _, token := range updated.AccessTokens {
+		if !containsToken(current.AccessTokens, token) {
+			log.Printf("reload: access token %s/%s added\n", token.Domain, token.FileName)
+		}
+	}
+	for _, token := range current.IdTokens {
+		if !containsIdToken(updated.IdTokens, token.Name) {
+			log.Printf("reload: id token %s removed, token will no longer be refreshed\n", token.Name)
+			status.Unregister(status.IdToken, token.Name)
+		}
+	}
+	for _, token := range updated.IdTokens {
+		if !containsIdToken(current.IdTokens, token.Name) {
+			log.Printf("reload: id token %s added\n", token.Name)
+		}
+	}
+	registerStatus(updated)
+}
+
+func containsRole(roles []options.Role, name string) bool {
+	for _, role := range roles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsToken(tokens []config.AccessToken, token config.AccessToken) bool {
+	for _, t := range tokens {
+		if t.Domain == token.Domain && t.FileName == token.FileName {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIdToken(tokens []config.IdToken, name string) bool {
+	for _, t := range tokens {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sendLatest stores the value in the single slot channel replacing any
+// value the receiver has not picked up yet. This way the reload handler
+// never blocks on a busy receiver and the receiver always gets the most
+// recent configuration. The channel must have a single sender.
+func sendLatest[T any](updates chan T, value T) {
+	for {
+		select {
+		case updates <- value:
+			return
+		default:
+		}
+		select {
+		case <-updates:
+		default:
+		}
+	}
+}
@@ -0,0 +1,139 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reloadTestOptions() *options.Options {
+	return &options.Options{
+		Domain:     "athenz",
+		Name:       "athenz.api",
+		Services:   []options.Service{{Name: "api"}, {Name: "backend"}},
+		Roles:      []options.Role{{Name: "athenz:role.readers", Service: "api"}},
+		SDSUdsPath: "/var/run/sia/sds.sock",
+	}
+}
+
+func TestValidateReload(test *testing.T) {
+	tests := []struct {
+		name   string
+		update func(opts *options.Options)
+		valid  bool
+	}{
+		{"roles", func(opts *options.Options) {
+			opts.Roles = append(opts.Roles, options.Role{Name: "athenz:role.writers", Service: "api"})
+		}, true},
+		{"service-removed", func(opts *options.Options) {
+			opts.Services = opts.Services[:1]
+		}, true},
+		{"refresh-settings", func(opts *options.Options) {
+			opts.CertRefreshRatio = 0.7
+			opts.RefreshInterval = 60
+		}, true},
+		{"domain", func(opts *options.Options) {
+			opts.Domain = "sports"
+		}, false},
+		{"service-added", func(opts *options.Options) {
+			opts.Services = append(opts.Services, options.Service{Name: "frontend"})
+		}, false},
+		{"sds-path", func(opts *options.Options) {
+			opts.SDSUdsPath = "/tmp/sds.sock"
+		}, false},
+		{"sds-tcp-address", func(opts *options.Options) {
+			opts.SDSTcpAddress = "0.0.0.0:8443"
+		}, false},
+		{"status-port", func(opts *options.Options) {
+			opts.StatusPort = 9090
+		}, false},
+		{"token-server-path", func(opts *options.Options) {
+			opts.TokenServerUdsPath = "/tmp/token.sock"
+		}, false},
+		{"aws-credentials-port", func(opts *options.Options) {
+			opts.AWSCredentialsPort = 4081
+		}, false},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			updated := reloadTestOptions()
+			tt.update(updated)
+			err := validateReload(reloadTestOptions(), updated)
+			assert.Equal(t, tt.valid, err == nil, "unexpected result: %v", err)
+		})
+	}
+}
+
+func TestReloadConfigError(test *testing.T) {
+	reload := func() (*options.Options, error) {
+		return nil, errors.New("invalid json")
+	}
+	_, err := reloadConfig(reloadTestOptions(), reload, "https://zts.athenz.io:4443/zts/v1")
+	require.NotNil(test, err)
+	assert.Contains(test, err.Error(), "invalid json")
+}
+
+func TestReloadConfig(test *testing.T) {
+	current := reloadTestOptions()
+	current.AccessTokens = []config.AccessToken{{FileName: "readers", Domain: "athenz", Service: "api", Roles: []string{"readers"}}}
+	current.IdTokens = []config.IdToken{{Name: "gcp", FileName: test.TempDir() + "/gcp", Service: "api"}}
+	registerStatus(current)
+
+	reload := func() (*options.Options, error) {
+		opts := reloadTestOptions()
+		opts.Services = opts.Services[:1]
+		opts.Roles = []options.Role{{Name: "athenz:role.writers", Service: "api"}}
+		return opts, nil
+	}
+	update, err := reloadConfig(current, reload, "https://zts.athenz.io:4443/zts/v1")
+	require.Nil(test, err)
+	assert.Nil(test, update.tokenOpts)
+	require.Equal(test, 1, len(update.opts.Roles))
+
+	names := map[string]bool{}
+	for _, entry := range status.Default.Status().Entries {
+		names[entry.Name] = true
+	}
+	assert.False(test, names["backend"])
+	assert.False(test, names["athenz:role.readers"])
+	assert.False(test, names["athenz/readers"])
+	assert.False(test, names["gcp"])
+	assert.True(test, names["api"])
+	assert.True(test, names["athenz:role.writers"])
+}
+
+func TestSendLatest(test *testing.T) {
+	updates := make(chan *options.Options, 1)
+	first := reloadTestOptions()
+	second := reloadTestOptions()
+
+	// the pending update is replaced instead of blocking the sender
+	sendLatest(updates, first)
+	sendLatest(updates, second)
+	require.Len(test, updates, 1)
+	assert.Same(test, second, <-updates)
+
+	sendLatest(updates, first)
+	assert.Same(test, first, <-updates)
+}
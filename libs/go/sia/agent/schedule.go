@@ -0,0 +1,151 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/pki/cert"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// minRefreshInterval is the minimum time between two refresh attempts
+// of the same certificate to protect ZTS when a refresh keeps failing
+// or returns a certificate that is already past its threshold
+const minRefreshInterval = time.Minute
+
+// refreshScheduler tracks when each service and role certificate should
+// be refreshed based on the lifetime of the certificate on disk
+type refreshScheduler struct {
+	ratio       float64
+	jitter      float64
+	maxInterval time.Duration
+	schedule    map[string]time.Time
+	lastRefresh map[string]time.Time
+}
+
+func newRefreshScheduler(opts *options.Options) *refreshScheduler {
+	return &refreshScheduler{
+		ratio:       opts.CertRefreshRatio,
+		jitter:      opts.CertRefreshJitter,
+		maxInterval: time.Duration(opts.RefreshInterval) * time.Minute,
+		schedule:    make(map[string]time.Time),
+		lastRefresh: make(map[string]time.Time),
+	}
+}
+
+// reload applies the refresh settings from the updated options. The
+// cached refresh times are discarded so they are recalculated with the
+// new settings while the last refresh times are kept to honor the
+// minimum refresh interval
+func (s *refreshScheduler) reload(opts *options.Options) {
+	s.ratio = opts.CertRefreshRatio
+	s.jitter = opts.CertRefreshJitter
+	s.maxInterval = time.Duration(opts.RefreshInterval) * time.Minute
+	s.schedule = make(map[string]time.Time)
+}
+
+// due returns the services and roles whose certificates must be refreshed
+// now along with the time when the next certificate will be due
+func (s *refreshScheduler) due(opts *options.Options, now time.Time) ([]options.Service, []options.Role, time.Time) {
+	var services []options.Service
+	var roles []options.Role
+	next := now.Add(s.maxInterval)
+	for _, svc := range opts.Services {
+		certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+		refreshAt := s.refreshTime(certFile, svc.Threshold, now)
+		if !refreshAt.After(now) {
+			services = append(services, svc)
+		} else if refreshAt.Before(next) {
+			next = refreshAt
+		}
+	}
+	for _, role := range opts.Roles {
+		certFile := util.GetRoleCertFileName(opts.CertDir, role.Filename, role.Name)
+		refreshAt := s.refreshTime(certFile, role.Threshold, now)
+		if !refreshAt.After(now) {
+			roles = append(roles, role)
+		} else if refreshAt.Before(next) {
+			next = refreshAt
+		}
+	}
+	return services, roles, next
+}
+
+// refreshed records a refresh attempt for the given certificate so that
+// its schedule is computed again from the certificate on disk
+func (s *refreshScheduler) refreshed(certFile string, now time.Time) {
+	delete(s.schedule, certFile)
+	s.lastRefresh[certFile] = now
+}
+
+func (s *refreshScheduler) refreshTime(certFile string, thresholdDays float64, now time.Time) time.Time {
+	if refreshAt, ok := s.schedule[certFile]; ok {
+		return refreshAt
+	}
+	refreshAt := s.certRefreshTime(certFile, thresholdDays, now)
+	if last, ok := s.lastRefresh[certFile]; ok && refreshAt.Before(last.Add(minRefreshInterval)) {
+		refreshAt = last.Add(minRefreshInterval)
+	}
+	s.schedule[certFile] = refreshAt
+	log.Printf("certificate %s scheduled for refresh at %s\n", certFile, refreshAt.Format(time.RFC3339))
+	return refreshAt
+}
+
+// certRefreshTime returns when the given certificate should be refreshed. Missing
+// or invalid certificates and certificates that are already past their threshold
+// are refreshed immediately. The threshold is only considered if it is shorter
+// than the certificate lifetime, otherwise short-lived certificates would be
+// refreshed continuously.
+func (s *refreshScheduler) certRefreshTime(certFile string, thresholdDays float64, now time.Time) time.Time {
+	notBefore, notAfter, err := GetPrevRoleCertDates(certFile)
+	if err != nil {
+		log.Printf("unable to read certificate %s, refreshing now: %v\n", certFile, err)
+		return now
+	}
+	lifetime := notAfter.Time.Sub(notBefore.Time)
+	threshold := time.Duration(thresholdDays * float64(24*time.Hour))
+	if threshold > 0 && threshold < lifetime {
+		afterThreshold, err := cert.IsExpiryAfterThreshold(certFile, thresholdDays)
+		if err == nil && !afterThreshold {
+			log.Printf("certificate %s expires within %v days threshold, refreshing now\n", certFile, thresholdDays)
+			return now
+		}
+	}
+	refreshAt := lifetimeRefreshTime(notBefore.Time, notAfter.Time, threshold, s.ratio, s.jitter)
+	if maxRefresh := now.Add(s.maxInterval); maxRefresh.Before(refreshAt) {
+		refreshAt = maxRefresh
+	}
+	return refreshAt
+}
+
+// lifetimeRefreshTime returns the time after the given ratio of the certificate
+// lifetime has elapsed, moved earlier by a random jitter fraction of the lifetime.
+// The refresh time is never after the threshold before the certificate expiry.
+func lifetimeRefreshTime(notBefore, notAfter time.Time, threshold time.Duration, ratio, jitter float64) time.Time {
+	lifetime := notAfter.Sub(notBefore)
+	refreshAt := notBefore.Add(time.Duration(float64(lifetime) * (ratio - jitter*rand.Float64())))
+	if threshold > 0 && threshold < lifetime {
+		if thresholdTime := notAfter.Add(-threshold); thresholdTime.Before(refreshAt) {
+			refreshAt = thresholdTime
+		}
+	}
+	return refreshAt
+}
@@ -0,0 +1,172 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestCert(t *testing.T, certFile string, notBefore, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "athenz.api",
+		},
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.Nil(t, err)
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0444)
+	require.Nil(t, err)
+}
+
+func TestLifetimeRefreshTime(test *testing.T) {
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		notAfter  time.Time
+		threshold time.Duration
+		jitter    float64
+		expected  time.Time
+	}{
+		{"half-lifetime", notBefore.Add(2 * time.Hour), 0, 0, notBefore.Add(time.Hour)},
+		{"threshold-longer-than-lifetime", notBefore.Add(2 * time.Hour), 15 * 24 * time.Hour, 0, notBefore.Add(time.Hour)},
+		{"threshold-first", notBefore.Add(30 * 24 * time.Hour), 20 * 24 * time.Hour, 0, notBefore.Add(10 * 24 * time.Hour)},
+		{"ratio-first", notBefore.Add(30 * 24 * time.Hour), 5 * 24 * time.Hour, 0, notBefore.Add(15 * 24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, lifetimeRefreshTime(notBefore, tt.notAfter, tt.threshold, 0.5, tt.jitter))
+		})
+	}
+}
+
+func TestLifetimeRefreshTimeJitter(test *testing.T) {
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(10 * time.Hour)
+	for i := 0; i < 20; i++ {
+		refreshAt := lifetimeRefreshTime(notBefore, notAfter, 0, 0.5, 0.1)
+		assert.False(test, refreshAt.Before(notBefore.Add(4*time.Hour)))
+		assert.False(test, refreshAt.After(notBefore.Add(5*time.Hour)))
+	}
+}
+
+func TestRefreshSchedulerDue(test *testing.T) {
+	certDir := test.TempDir()
+	now := time.Now()
+	opts := &options.Options{
+		Domain:            "athenz",
+		CertDir:           certDir,
+		RefreshInterval:   24 * 60,
+		CertRefreshRatio:  0.5,
+		CertRefreshJitter: 0,
+		Services: []options.Service{
+			{Name: "api", Threshold: 15},
+			{Name: "backend", Threshold: 1},
+		},
+		Roles: []options.Role{
+			{Name: "athenz:role.readers", Service: "api", Threshold: 15},
+			{Name: "athenz:role.writers", Service: "api", Threshold: 15},
+		},
+	}
+	// short-lived cert that is not due yet even though the threshold is longer than its lifetime
+	writeTestCert(test, filepath.Join(certDir, "athenz.api.cert.pem"), now.Add(-time.Hour), now.Add(3*time.Hour))
+	// long-lived cert that is already past its threshold
+	writeTestCert(test, filepath.Join(certDir, "athenz.backend.cert.pem"), now.Add(-29*24*time.Hour), now.Add(12*time.Hour))
+	// long-lived role cert that is not due yet, the other role cert is missing
+	writeTestCert(test, filepath.Join(certDir, "athenz:role.readers.cert.pem"), now.Add(-time.Hour), now.Add(30*24*time.Hour))
+
+	scheduler := newRefreshScheduler(opts)
+	services, roles, next := scheduler.due(opts, now)
+	require.Equal(test, 1, len(services))
+	assert.Equal(test, "backend", services[0].Name)
+	require.Equal(test, 1, len(roles))
+	assert.Equal(test, "athenz:role.writers", roles[0].Name)
+	assert.WithinDuration(test, now.Add(time.Hour), next, time.Second)
+
+	// a refreshed certificate that is still past its threshold is not retried right away
+	scheduler.refreshed(filepath.Join(certDir, "athenz.backend.cert.pem"), now)
+	services, _, next = scheduler.due(opts, now.Add(time.Second))
+	assert.Equal(test, 0, len(services))
+	assert.WithinDuration(test, now.Add(minRefreshInterval), next, time.Second)
+}
+
+func TestRefreshSchedulerMaxInterval(test *testing.T) {
+	certDir := test.TempDir()
+	now := time.Now()
+	opts := &options.Options{
+		Domain:           "athenz",
+		CertDir:          certDir,
+		RefreshInterval:  60,
+		CertRefreshRatio: 0.5,
+		Services: []options.Service{
+			{Name: "api", Threshold: 15},
+		},
+	}
+	writeTestCert(test, filepath.Join(certDir, "athenz.api.cert.pem"), now.Add(-time.Hour), now.Add(30*24*time.Hour))
+
+	scheduler := newRefreshScheduler(opts)
+	services, roles, next := scheduler.due(opts, now)
+	assert.Equal(test, 0, len(services))
+	assert.Equal(test, 0, len(roles))
+	assert.WithinDuration(test, now.Add(time.Hour), next, time.Second)
+}
+
+func TestRefreshSchedulerReload(test *testing.T) {
+	certDir := test.TempDir()
+	now := time.Now()
+	opts := &options.Options{
+		Domain:           "athenz",
+		CertDir:          certDir,
+		RefreshInterval:  24 * 60,
+		CertRefreshRatio: 0.5,
+		Services: []options.Service{
+			{Name: "api", Threshold: 15},
+		},
+	}
+	writeTestCert(test, filepath.Join(certDir, "athenz.api.cert.pem"), now.Add(-time.Hour), now.Add(3*time.Hour))
+
+	scheduler := newRefreshScheduler(opts)
+	_, _, next := scheduler.due(opts, now)
+	assert.WithinDuration(test, now.Add(time.Hour), next, time.Second)
+
+	// the cached refresh time is recalculated with the new ratio
+	// so the certificate is now past its refresh time
+	updated := *opts
+	updated.CertRefreshRatio = 0.2
+	scheduler.reload(&updated)
+	services, _, _ := scheduler.due(&updated, now)
+	require.Equal(test, 1, len(services))
+	assert.Equal(test, "api", services[0].Name)
+}
@@ -0,0 +1,343 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"bufio"
+	"crypto"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/futil"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/ssh/hostkey"
+	"github.com/AthenZ/athenz/libs/go/sia/ssh/userca"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// sshHostKey represents an ssh host public key file along with
+// the file where its host certificate is stored
+type sshHostKey struct {
+	pubKeyFile string
+	certFile   string
+}
+
+// sshHostCert represents a host certificate issued by ZTS for
+// one of the ssh host keys
+type sshHostCert struct {
+	certFile string
+	cert     string
+}
+
+// getSSHHostKeys returns the ssh host keys that we need to request host
+// certificates for. If the host key types are not configured, the single
+// public key file specified in the options is used, otherwise all the
+// configured key types with public keys present on the host.
+func getSSHHostKeys(opts *options.Options) []sshHostKey {
+	if len(opts.SshHostKeyTypes) == 0 {
+		if opts.SshPubKeyFile == "" {
+			return nil
+		}
+		return []sshHostKey{{pubKeyFile: opts.SshPubKeyFile, certFile: opts.SshCertFile}}
+	}
+	var hostKeys []sshHostKey
+	for _, keyType := range hostkey.PresentKeyTypes(opts.SshDir, opts.SshHostKeyTypes) {
+		hostKeys = append(hostKeys, sshHostKey{
+			pubKeyFile: hostkey.PubKeyFile(opts.SshDir, keyType),
+			certFile:   hostkey.CertFile(opts.SshDir, keyType),
+		})
+	}
+	return hostKeys
+}
+
+// generateSSHHostCSR returns the csr for the first ssh host key which is
+// included in the instance register and refresh requests
+func generateSSHHostCSR(hostKeys []sshHostKey, svc options.Service, opts *options.Options) (string, error) {
+	if len(hostKeys) == 0 {
+		return "", nil
+	}
+	return util.GenerateSSHHostCSR(hostKeys[0].pubKeyFile, opts.Domain, svc.Name, opts.PrivateIp, options.GetZTSDomains(opts))
+}
+
+// requestSSHHostCerts returns the host certificates for all the ssh host
+// keys. ZTS signs a single ssh host key with every register or refresh
+// request, so the certificate for the first key is already included in the
+// identity while the other keys are signed with dedicated ssh certificate
+// requests authenticated with the newly issued identity certificate. These
+// requests do not include an x.509 csr so the identity is not changed.
+func requestSSHHostCerts(ident *zts.InstanceIdentity, svc options.Service, key crypto.Signer, hostKeys []sshHostKey, ztsUrl string, opts *options.Options) []sshHostCert {
+	if len(hostKeys) == 0 {
+		return nil
+	}
+	var hostCerts []sshHostCert
+	if ident.SshCertificate != "" {
+		hostCerts = append(hostCerts, sshHostCert{certFile: hostKeys[0].certFile, cert: ident.SshCertificate})
+	}
+	if len(hostKeys) == 1 {
+		return hostCerts
+	}
+	client, err := util.ZtsClientFromPEM(ztsUrl, opts.ZTSServerName, []byte(util.PrivatePem(key)), []byte(ident.X509Certificate), opts.ZTSCACertFile)
+	if err != nil {
+		log.Printf("Unable to create zts client for ssh host certificates, err: %v\n", err)
+		return hostCerts
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+	for _, hostKey := range hostKeys[1:] {
+		cert, err := requestSSHHostCert(client, svc, hostKey, opts)
+		if err != nil {
+			log.Printf("Unable to request ssh host certificate for %s, err: %v\n", hostKey.pubKeyFile, err)
+			continue
+		}
+		hostCerts = append(hostCerts, sshHostCert{certFile: hostKey.certFile, cert: cert})
+	}
+	return hostCerts
+}
+
+// requestSSHHostCert requests a host certificate for the given ssh host key
+// from ZTS. ZTS validates the request against the ssh host certificate record
+// for the instance created by the register and refresh requests.
+func requestSSHHostCert(client *zts.ZTSClient, svc options.Service, hostKey sshHostKey, opts *options.Options) (string, error) {
+	pubKey, err := os.ReadFile(hostKey.pubKeyFile)
+	if err != nil {
+		return "", err
+	}
+	serviceName := fmt.Sprintf("%s.%s", opts.Domain, svc.Name)
+	origin := opts.PrivateIp
+	if origin == "" {
+		origin, _ = os.Hostname()
+	}
+	certRequest := &zts.SSHCertRequest{
+		CertRequestData: &zts.SSHCertRequestData{
+			Principals: util.SSHHostPrincipals(opts.Domain, svc.Name, options.GetZTSDomains(opts)),
+			PublicKey:  string(pubKey),
+		},
+		CertRequestMeta: &zts.SSHCertRequestMeta{
+			Requestor:     serviceName,
+			Origin:        origin,
+			CertType:      "host",
+			AthenzService: zts.EntityName(serviceName),
+			InstanceId:    zts.PathElement(opts.InstanceId),
+			TransId:       fmt.Sprintf("%x", time.Now().Unix()),
+		},
+	}
+
+	start := time.Now()
+	sshCerts, err := client.PostSSHCertRequest(certRequest)
+	status.ObserveZtsRequest("PostSSHCertRequest", time.Since(start), err)
+	if err != nil {
+		return "", err
+	}
+	if len(sshCerts.Certificates) == 0 || sshCerts.Certificates[0].Certificate == "" {
+		return "", fmt.Errorf("no ssh host certificate returned")
+	}
+	return sshCerts.Certificates[0].Certificate, nil
+}
+
+// updateSSHUserCAKeys fetches the ssh user ca public keys from the configured
+// ZTS bundle and stores them in the TrustedUserCAKeys file, making sure the
+// sshd configuration refers to the file. The keys are only updated when the
+// agent runs as root since it must be able to update the sshd configuration
+// and restart sshd.
+func updateSSHUserCAKeys(ztsUrl string, opts *options.Options) error {
+	if opts.SshUserCABundle == "" || os.Geteuid() != 0 {
+		return nil
+	}
+
+	svc := opts.Services[0]
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+
+	client, err := util.ZtsClient(ztsUrl, opts.ZTSServerName, keyFile, certFile, opts.ZTSCACertFile)
+	if err != nil {
+		return err
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+
+	start := time.Now()
+	caBundle, err := client.GetCertificateAuthorityBundle(zts.SimpleName(opts.SshUserCABundle))
+	status.ObserveZtsRequest("GetCertificateAuthorityBundle", time.Since(start), err)
+	if err != nil {
+		return err
+	}
+	updated, err := userca.Update(opts.SshTrustedUserCAKeys, caBundle.Certs)
+	if err != nil {
+		return err
+	}
+	if updated {
+		log.Printf("ssh user ca keys %s updated from zts bundle %s\n", opts.SshTrustedUserCAKeys, opts.SshUserCABundle)
+	}
+	return updateSSHTrustedUserCAKeys(opts.SshConfigFile, opts.SshTrustedUserCAKeys)
+}
+
+// updateSSHTrustedUserCAKeys makes sure the sshd configuration refers to the
+// given ssh user ca keys file. sshd only uses the first TrustedUserCAKeys
+// directive so if the configuration already includes one for a different
+// file, we don't update the configuration and only log a warning.
+func updateSSHTrustedUserCAKeys(sshConfigFile, caKeysFile string) error {
+	if sshConfigFile == "" {
+		return nil
+	}
+	value, found, err := sshConfigDirective(sshConfigFile, "TrustedUserCAKeys")
+	if err != nil {
+		log.Printf("unable to check ssh configuration directive for %s - error %v\n", sshConfigFile, err)
+		return err
+	}
+	if found {
+		if value != caKeysFile {
+			log.Printf("ssh configuration file %s already includes TrustedUserCAKeys %s, skipping %s\n", sshConfigFile, value, caKeysFile)
+		}
+		return nil
+	}
+	return updateSSHConfig(sshConfigFile, []string{fmt.Sprintf("TrustedUserCAKeys %s", caKeysFile)})
+}
+
+func restartSshdService() error {
+	return exec.Command(util.GetUtilPath("systemctl"), "restart", "sshd").Run()
+}
+
+// updateSSH writes the host certificates and makes sure the sshd
+// configuration includes a HostCertificate line for every certificate
+func updateSSH(hostCerts []sshHostCert, sshConfigFile string) error {
+
+	var configLines []string
+	for _, hostCert := range hostCerts {
+		//write the host cert file
+		err := util.UpdateFile(hostCert.certFile, []byte(hostCert.cert), 0, 0, 0644)
+		if err != nil {
+			return err
+		}
+		configLines = append(configLines, fmt.Sprintf("HostCertificate %s", hostCert.certFile))
+	}
+	return updateSSHConfig(sshConfigFile, configLines)
+}
+
+// updateSSHConfig makes sure the sshd configuration file includes all the
+// given lines. If any of the lines is missing, the file is updated and sshd
+// is restarted to notice the changes
+func updateSSHConfig(sshConfigFile string, configLines []string) error {
+	if sshConfigFile == "" {
+		return nil
+	}
+	var missingLines []string
+	for _, configLine := range configLines {
+		configPresent, err := sshConfigLinePresent(sshConfigFile, configLine)
+		if err != nil {
+			log.Printf("unable to check ssh configuration line for %s - error %v\n", sshConfigFile, err)
+			return err
+		}
+		if !configPresent {
+			missingLines = append(missingLines, configLine)
+		}
+	}
+	if len(missingLines) == 0 {
+		return nil
+	}
+	err := updateSSHConfigFile(sshConfigFile, missingLines)
+	if err != nil {
+		return err
+	}
+	return restartSshdService()
+}
+
+// updateSSHConfigFile adds the lines to the sshd configuration file. The
+// lines are appended at the end of the file unless the file includes Match
+// blocks in which case they're inserted before the first block since the
+// keywords that follow a Match line only apply to the matching connections
+func updateSSHConfigFile(sshConfigFile string, configLines []string) error {
+	info, err := os.Stat(sshConfigFile)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(sshConfigFile)
+	if err != nil {
+		return err
+	}
+	config := string(data)
+	lines := strings.Join(configLines, "\n")
+	if index := matchBlockIndex(config); index != -1 {
+		config = config[:index] + lines + "\n\n" + config[index:]
+	} else {
+		config = config + "\n" + lines + "\n"
+	}
+	return futil.WriteFile(sshConfigFile, []byte(config), info.Mode().Perm())
+}
+
+// matchBlockIndex returns the offset of the first Match line in the sshd
+// configuration or -1 if the configuration has no Match blocks
+func matchBlockIndex(config string) int {
+	offset := 0
+	for _, line := range strings.SplitAfter(config, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 0 && strings.EqualFold(fields[0], "Match") {
+			return offset
+		}
+		offset += len(line)
+	}
+	return -1
+}
+
+func sshConfigLinePresent(sshConfigFile, configLine string) (bool, error) {
+
+	file, err := os.Open(sshConfigFile)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		line := strings.Trim(scanner.Text(), " \t")
+		if strings.HasPrefix(line, configLine) {
+			log.Printf("ssh configuration file already includes expected line: %s\n", line)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sshConfigDirective returns the value of the given keyword from the global
+// section of the sshd configuration, that is before the first Match block.
+// Keywords are case-insensitive and comment lines are ignored.
+func sshConfigDirective(sshConfigFile, keyword string) (string, bool, error) {
+
+	file, err := os.Open(sshConfigFile)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if strings.EqualFold(fields[0], "Match") {
+			break
+		}
+		if strings.EqualFold(fields[0], keyword) {
+			return strings.Join(fields[1:], " "), true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
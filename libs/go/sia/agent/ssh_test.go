@@ -0,0 +1,234 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/ssh/hostkey"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRsaHostKey     = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC7 root@host"
+	testEd25519HostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHk root@host"
+)
+
+func TestGetSSHHostKeys(test *testing.T) {
+	sshDir := test.TempDir()
+	require.Nil(test, os.WriteFile(hostkey.PubKeyFile(sshDir, hostkey.Rsa), []byte(testRsaHostKey), 0644))
+	require.Nil(test, os.WriteFile(hostkey.PubKeyFile(sshDir, hostkey.Ed25519), []byte(testEd25519HostKey), 0644))
+
+	opts := &options.Options{
+		SshDir:          sshDir,
+		SshHostKeyTypes: hostkey.KeyTypes(),
+	}
+	assert.Equal(test, []sshHostKey{
+		{pubKeyFile: hostkey.PubKeyFile(sshDir, hostkey.Rsa), certFile: hostkey.CertFile(sshDir, hostkey.Rsa)},
+		{pubKeyFile: hostkey.PubKeyFile(sshDir, hostkey.Ed25519), certFile: hostkey.CertFile(sshDir, hostkey.Ed25519)},
+	}, getSSHHostKeys(opts))
+
+	opts.SshHostKeyTypes = []hostkey.KeyType{hostkey.Ecdsa}
+	assert.Empty(test, getSSHHostKeys(opts))
+
+	// without the key types the configured public key file is used
+	opts = &options.Options{
+		SshPubKeyFile: "/etc/ssh/ssh_host_rsa_key.pub",
+		SshCertFile:   "/etc/ssh/ssh_host_rsa_key-cert.pub",
+	}
+	assert.Equal(test, []sshHostKey{
+		{pubKeyFile: "/etc/ssh/ssh_host_rsa_key.pub", certFile: "/etc/ssh/ssh_host_rsa_key-cert.pub"},
+	}, getSSHHostKeys(opts))
+	assert.Empty(test, getSSHHostKeys(&options.Options{}))
+}
+
+func TestRegisterInstanceWithSSHHostKeys(test *testing.T) {
+
+	siaDir := test.TempDir()
+	sshDir := test.TempDir()
+	require.Nil(test, os.WriteFile(hostkey.PubKeyFile(sshDir, hostkey.Rsa), []byte(testRsaHostKey), 0644))
+	require.Nil(test, os.WriteFile(hostkey.PubKeyFile(sshDir, hostkey.Ed25519), []byte(testEd25519HostKey), 0644))
+
+	opts := &options.Options{
+		Domain: "athenz",
+		Services: []options.Service{
+			{
+				Name:     "hockey",
+				Uid:      util.ExecIdCommand("-u"),
+				Gid:      util.ExecIdCommand("-g"),
+				FileMode: 0440,
+			},
+		},
+		KeyDir:           siaDir,
+		CertDir:          siaDir,
+		BackUpDir:        siaDir + "/backup",
+		AthenzCACertFile: siaDir + "/ca.cert.pem",
+		ZTSDomains:       []string{"zts-aws-cloud"},
+		Region:           "us-west-2",
+		InstanceId:       "pod-1234",
+		Provider:         newTestProvider("athenz.aws.us-west-2", "pod-1234", []string{"zts-aws-cloud"}),
+		Ssh:              true,
+		SshDir:           sshDir,
+		SshHostKeyTypes:  hostkey.KeyTypes(),
+	}
+
+	err := RegisterInstance("http://127.0.0.1:5084/zts/v1", opts, false)
+	require.Nil(test, err, "unable to register instance")
+
+	// the rsa key is signed with the register request while the
+	// ed25519 key is signed with a dedicated ssh certificate request
+	data, err := os.ReadFile(hostkey.CertFile(sshDir, hostkey.Rsa))
+	require.Nil(test, err)
+	assert.Equal(test, "cert "+testRsaHostKey, string(data))
+	data, err = os.ReadFile(hostkey.CertFile(sshDir, hostkey.Ed25519))
+	require.Nil(test, err)
+	assert.Equal(test, "cert "+testEd25519HostKey, string(data))
+	assert.NoFileExists(test, hostkey.CertFile(sshDir, hostkey.Ecdsa))
+	assert.FileExists(test, siaDir+"/athenz.hockey.cert.pem")
+}
+
+func TestRequestSSHHostCerts(test *testing.T) {
+	sshDir := test.TempDir()
+	require.Nil(test, os.WriteFile(hostkey.PubKeyFile(sshDir, hostkey.Rsa), []byte(testRsaHostKey), 0644))
+	require.Nil(test, os.WriteFile(hostkey.PubKeyFile(sshDir, hostkey.Ed25519), []byte(testEd25519HostKey), 0644))
+
+	key, err := util.GenerateKey(util.RSA2048)
+	require.Nil(test, err)
+	opts := &options.Options{
+		Domain:          "athenz",
+		ZTSDomains:      []string{"zts-aws-cloud"},
+		InstanceId:      "pod-1234",
+		SshDir:          sshDir,
+		SshHostKeyTypes: hostkey.KeyTypes(),
+	}
+	svc := options.Service{Name: "hockey"}
+	ident := &zts.InstanceIdentity{
+		X509Certificate: "x509-cert",
+		SshCertificate:  "cert " + testRsaHostKey,
+	}
+	hostCerts := requestSSHHostCerts(ident, svc, key, getSSHHostKeys(opts), "http://127.0.0.1:5084/zts/v1", opts)
+	assert.Equal(test, []sshHostCert{
+		{certFile: hostkey.CertFile(sshDir, hostkey.Rsa), cert: "cert " + testRsaHostKey},
+		{certFile: hostkey.CertFile(sshDir, hostkey.Ed25519), cert: "cert " + testEd25519HostKey},
+	}, hostCerts)
+	// the additional host certificates do not change the identity
+	assert.Equal(test, "x509-cert", ident.X509Certificate)
+
+	// failed requests are skipped
+	opts.InstanceId = "pod-5678"
+	hostCerts = requestSSHHostCerts(ident, svc, key, getSSHHostKeys(opts), "http://127.0.0.1:5084/zts/v1", opts)
+	assert.Equal(test, []sshHostCert{
+		{certFile: hostkey.CertFile(sshDir, hostkey.Rsa), cert: "cert " + testRsaHostKey},
+	}, hostCerts)
+}
+
+func TestUpdateSSHUserCAKeys(test *testing.T) {
+	sshDir := test.TempDir()
+	caKeysFile := filepath.Join(sshDir, "trusted_user_ca_keys.pub")
+	sshConfigFile := filepath.Join(sshDir, "sshd_config")
+	configData := "PermitTunnel no\nTrustedUserCAKeys " + caKeysFile + "\n"
+	require.Nil(test, os.WriteFile(sshConfigFile, []byte(configData), 0644))
+
+	opts := &options.Options{
+		Domain:               "athenz",
+		Services:             []options.Service{{Name: "hockey"}},
+		SshConfigFile:        sshConfigFile,
+		SshTrustedUserCAKeys: caKeysFile,
+	}
+	// nothing to do without the bundle name
+	require.Nil(test, updateSSHUserCAKeys("http://127.0.0.1:5084/zts/v1", opts))
+	assert.NoFileExists(test, caKeysFile)
+
+	opts.SshUserCABundle = "ssh-user"
+	require.Nil(test, updateSSHUserCAKeys("http://127.0.0.1:5084/zts/v1", opts))
+	data, err := os.ReadFile(caKeysFile)
+	require.Nil(test, err)
+	assert.Equal(test, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAICEFU0UuJDcj6n0g565Yl3ZdR+p33fRniJRyG3i6fVy+ athenz-ssh-user-ca\n", string(data))
+
+	// the configuration already refers to the keys file
+	data, err = os.ReadFile(sshConfigFile)
+	require.Nil(test, err)
+	assert.Equal(test, configData, string(data))
+
+	opts.SshUserCABundle = "unknown"
+	assert.NotNil(test, updateSSHUserCAKeys("http://127.0.0.1:5084/zts/v1", opts))
+}
+
+func TestUpdateSSHTrustedUserCAKeys(test *testing.T) {
+	sshConfigFile := filepath.Join(test.TempDir(), "sshd_config")
+
+	// the keyword is case-insensitive so a directive for a different
+	// file is not replaced and no second directive is added
+	configData := "PermitTunnel no\n# TrustedUserCAKeys /etc/ssh/commented.pub\ntrustedusercakeys /etc/ssh/other_ca_keys.pub\n"
+	require.Nil(test, os.WriteFile(sshConfigFile, []byte(configData), 0644))
+	require.Nil(test, updateSSHTrustedUserCAKeys(sshConfigFile, "/etc/ssh/trusted_user_ca_keys.pub"))
+	data, err := os.ReadFile(sshConfigFile)
+	require.Nil(test, err)
+	assert.Equal(test, configData, string(data))
+
+	// directives within match blocks are not global
+	configData = "PermitTunnel no\nMatch User test\n  TrustedUserCAKeys /etc/ssh/other_ca_keys.pub\n"
+	require.Nil(test, os.WriteFile(sshConfigFile, []byte(configData), 0644))
+	value, found, err := sshConfigDirective(sshConfigFile, "TrustedUserCAKeys")
+	require.Nil(test, err)
+	assert.False(test, found)
+	assert.Empty(test, value)
+
+	assert.Nil(test, updateSSHTrustedUserCAKeys("", "/etc/ssh/trusted_user_ca_keys.pub"))
+	assert.NotNil(test, updateSSHTrustedUserCAKeys(sshConfigFile+".missing", "/etc/ssh/trusted_user_ca_keys.pub"))
+}
+
+func TestSSHConfigDirective(test *testing.T) {
+	sshConfigFile := filepath.Join(test.TempDir(), "sshd_config")
+	configData := "PermitTunnel no\n  TrustedUserCAKeys   /etc/ssh/trusted_user_ca_keys.pub\nTrustedUserCAKeys /etc/ssh/ignored.pub\n"
+	require.Nil(test, os.WriteFile(sshConfigFile, []byte(configData), 0644))
+
+	// sshd only uses the first directive
+	value, found, err := sshConfigDirective(sshConfigFile, "trustedusercakeys")
+	require.Nil(test, err)
+	assert.True(test, found)
+	assert.Equal(test, "/etc/ssh/trusted_user_ca_keys.pub", value)
+
+	_, found, err = sshConfigDirective(sshConfigFile, "HostCertificate")
+	require.Nil(test, err)
+	assert.False(test, found)
+}
+
+func TestUpdateSSHConfig(test *testing.T) {
+	sshConfigFile := filepath.Join(test.TempDir(), "sshd_config")
+	configData := "PermitTunnel no\nHostCertificate /etc/ssh/ssh_host_rsa_key-cert.pub\n  HostCertificate /etc/ssh/ssh_host_ed25519_key-cert.pub\n"
+	require.Nil(test, os.WriteFile(sshConfigFile, []byte(configData), 0644))
+
+	// all the lines are present so the file is not updated
+	err := updateSSHConfig(sshConfigFile, []string{
+		"HostCertificate /etc/ssh/ssh_host_rsa_key-cert.pub",
+		"HostCertificate /etc/ssh/ssh_host_ed25519_key-cert.pub",
+	})
+	require.Nil(test, err)
+	data, err := os.ReadFile(sshConfigFile)
+	require.Nil(test, err)
+	assert.Equal(test, configData, string(data))
+
+	assert.Nil(test, updateSSHConfig("", []string{"HostCertificate /etc/ssh/ssh_host_rsa_key-cert.pub"}))
+	assert.NotNil(test, updateSSHConfig(sshConfigFile+".missing", []string{"HostCertificate /etc/ssh/ssh_host_rsa_key-cert.pub"}))
+}
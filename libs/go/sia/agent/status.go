@@ -0,0 +1,83 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/athenzutils"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/pki/cert"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// registerStatus adds all the configured service certificates, role certificates,
+// access tokens and id tokens to the status tracker along with their current expiry
+func registerStatus(opts *options.Options) {
+	for _, svc := range opts.Services {
+		certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+		status.Register(status.ServiceCert, svc.Name, certFile, certExpiry(certFile))
+	}
+	for _, role := range opts.Roles {
+		certFile := util.GetRoleCertFileName(opts.CertDir, role.Filename, role.Name)
+		status.Register(status.RoleCert, role.Name, certFile, certExpiry(certFile))
+	}
+	for _, token := range opts.AccessTokens {
+		tokenFile := filepath.Join(opts.TokenDir, token.Domain, token.FileName)
+		status.Register(status.AccessToken, token.Domain+"/"+token.FileName, tokenFile, time.Time{})
+	}
+	for _, token := range opts.IdTokens {
+		status.Register(status.IdToken, token.Name, token.FileName, idTokenExpiry(token.FileName))
+	}
+}
+
+// recordCertStatus records the result of a certificate refresh in the status tracker
+func recordCertStatus(kind status.Kind, name, certFile string, err error) {
+	if err != nil {
+		status.Failure(kind, name, certFile, err)
+		return
+	}
+	status.Success(kind, name, certFile, certExpiry(certFile))
+}
+
+// idTokenExpiry returns the expiry of the given id token or
+// zero time if the token cannot be read
+func idTokenExpiry(tokenFile string) time.Time {
+	idToken, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return time.Time{}
+	}
+	expiry, err := athenzutils.FetchIdTokenExpiryTime(strings.TrimSpace(string(idToken)))
+	if err != nil {
+		return time.Time{}
+	}
+	return *expiry
+}
+
+// certExpiry returns the expiry of the given certificate or
+// zero time if the certificate cannot be read
+func certExpiry(certFile string) time.Time {
+	x509Cert, err := cert.FromFile(certFile)
+	if err != nil {
+		return time.Time{}
+	}
+	return x509Cert.NotAfter
+}
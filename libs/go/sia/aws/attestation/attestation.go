@@ -17,14 +17,16 @@
 package attestation
 
 import (
+	"crypto"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
 	"github.com/AthenZ/athenz/libs/go/sia/aws/stssession"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
 	"github.com/aws/aws-sdk-go/service/sts"
 )
 
@@ -37,24 +39,38 @@ type AttestationData struct {
 	Signature string `json:"signature,omitempty"` //for EC2 instance document pkcs7 signature
 }
 
-// New creates a new AttestationData with values fed to it and from the result of STS Assume Role.
-// This requires an identity document along with its signature. The aws account and region will
-// be extracted from the identity document.
-func New(opts *options.Options, service string) (*AttestationData, error) {
+// Provider implements the host provider interface for the aws platforms
+// (EC2, ECS, EKS and Fargate). The attestation data includes the temporary
+// credentials for the service role obtained from STS and, for EC2 instances,
+// the instance identity document along with its signature.
+type Provider struct {
+	provider.Base
+	UseRegionalSTS bool   //use regional sts endpoint
+	Region         string //aws region name
+	Account        string //aws account id of the service role
+	Document       string //EC2 instance identity document
+	Signature      string //EC2 instance identity document pkcs7 signature
+}
 
-	role := fmt.Sprintf("%s.%s", opts.Domain, service)
-	tok, err := getSTSToken(opts.UseRegionalSTS, opts.Region, opts.Account, role)
+// AttestationData assumes the role named after the given principal using
+// STS and returns the json encoded attestation data for the ZTS api
+func (p Provider) AttestationData(principal string, key crypto.PrivateKey, sigInfo *signature.SignatureInfo) (string, error) {
+	tok, err := getSTSToken(p.UseRegionalSTS, p.Region, p.Account, principal)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return &AttestationData{
-		Role:      role,
-		Document:  opts.EC2Document,
-		Signature: opts.EC2Signature,
+	data, err := json.Marshal(&AttestationData{
+		Role:      principal,
+		Document:  p.Document,
+		Signature: p.Signature,
 		Access:    *tok.Credentials.AccessKeyId,
 		Secret:    *tok.Credentials.SecretAccessKey,
 		Token:     *tok.Credentials.SessionToken,
-	}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
 }
 
 func getSTSToken(useRegionalSTS bool, region, account, role string) (*sts.AssumeRoleOutput, error) {
@@ -106,16 +122,3 @@ func GetECSTaskId() string {
 	}
 	return taskId
 }
-
-// GetAttestationData fetches attestation data for all the services mentioned in the config file
-func GetAttestationData(opts *options.Options) ([]*AttestationData, error) {
-	data := []*AttestationData{}
-	for _, svc := range opts.Services {
-		a, err := New(opts, svc.Name)
-		if err != nil {
-			return nil, err
-		}
-		data = append(data, a)
-	}
-	return data, nil
-}
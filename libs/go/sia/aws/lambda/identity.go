@@ -0,0 +1,410 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package lambda
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/athenzutils"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// refreshRatio is the fraction of the lifetime of a certificate or token
+// after which a new one is requested on the next call
+const refreshRatio = 0.75
+
+// Config holds the settings for the identity of a lambda function
+type Config struct {
+	ZTSUrl        string            //ZTS url e.g. https://zts.athenz.io:4443/zts/v1
+	ZTSServerName string            //ZTS server name for tls connections (optional)
+	ZTSCACertFile string            //CA certificates to verify ZTS (optional)
+	CACertFile    string            //CA certificates to verify peers with the returned tls configs (optional)
+	Provider      provider.Provider //provider attesting the function, typically from NewProvider
+	Domain        string            //domain of the service identity
+	Service       string            //service name of the service identity
+	KeyType       string            //private key type, defaults to ecdsa-p256 to keep cold starts fast
+	ExpiryTime    int               //requested service and role certificate expiry in minutes (optional)
+}
+
+// TokenSource returns a valid access token on every call
+type TokenSource interface {
+	Token() (string, error)
+}
+
+type accessToken struct {
+	token   string
+	refresh time.Time
+	expiry  time.Time
+}
+
+// Identity keeps the service identity of a lambda function along with the
+// role certificates and access tokens fetched with it. The identity should
+// be kept in a package level variable so the state is reused across warm
+// invocations of the function. Certificates and tokens are only requested
+// from ZTS when they are not available or close to their expiry, and the
+// current ones are used until they expire if a refresh fails.
+type Identity struct {
+	config    Config
+	key       crypto.Signer
+	ztsCAs    *x509.CertPool
+	caCerts   *x509.CertPool
+	mutex     sync.Mutex
+	svcCert   *tls.Certificate
+	roleCerts map[string]*tls.Certificate
+	tokens    map[string]*accessToken
+}
+
+// NewIdentity returns the identity for the given config. The private key
+// is generated once and used for the service and role certificates.
+func NewIdentity(config Config) (*Identity, error) {
+	if config.Provider == nil {
+		return nil, fmt.Errorf("missing provider")
+	}
+	if config.Domain == "" || config.Service == "" {
+		return nil, fmt.Errorf("missing domain or service")
+	}
+	keyType := util.ECDSAP256
+	if config.KeyType != "" {
+		var err error
+		keyType, err = util.ParseKeyType(config.KeyType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	key, err := util.GenerateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	ztsCAs, err := certPool(config.ZTSCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	caCerts, err := certPool(config.CACertFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		config:    config,
+		key:       key,
+		ztsCAs:    ztsCAs,
+		caCerts:   caCerts,
+		roleCerts: make(map[string]*tls.Certificate),
+		tokens:    make(map[string]*accessToken),
+	}, nil
+}
+
+// Certificate returns the service identity certificate
+func (i *Identity) Certificate() (tls.Certificate, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	cert, err := i.serviceCertificate(time.Now())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return *cert, nil
+}
+
+// RoleCertificate returns the role certificate for the given role name
+// e.g. sports:role.readers
+func (i *Identity) RoleCertificate(roleName string) (tls.Certificate, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	now := time.Now()
+	svcCert, err := i.serviceCertificate(now)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert := i.roleCerts[roleName]
+	if refreshDue(cert, now) {
+		newCert, err := i.fetchRoleCertificate(roleName, svcCert)
+		if err != nil {
+			if cert == nil || !now.Before(cert.Leaf.NotAfter) {
+				return tls.Certificate{}, err
+			}
+			log.Printf("Unable to refresh role certificate for %s, using current one, err: %v\n", roleName, err)
+		} else {
+			cert = newCert
+			i.roleCerts[roleName] = cert
+		}
+	}
+	return *cert, nil
+}
+
+// AccessToken returns an access token for the given roles in the domain.
+// Without any roles, the token includes all roles of the service in the
+// domain. The expiry time is in seconds, 0 for the ZTS default.
+func (i *Identity) AccessToken(domain string, roles []string, expiryTime int) (string, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	now := time.Now()
+	svcCert, err := i.serviceCertificate(now)
+	if err != nil {
+		return "", err
+	}
+	cacheKey := fmt.Sprintf("%s:%s:%d", domain, strings.Join(roles, ","), expiryTime)
+	token := i.tokens[cacheKey]
+	if token == nil || !now.Before(token.refresh) {
+		newToken, err := i.fetchAccessToken(domain, roles, expiryTime, svcCert, now)
+		if err != nil {
+			if token == nil || !now.Before(token.expiry) {
+				return "", err
+			}
+			log.Printf("Unable to refresh access token for %s, using current one, err: %v\n", cacheKey, err)
+		} else {
+			token = newToken
+			i.tokens[cacheKey] = token
+		}
+	}
+	return token.token, nil
+}
+
+// TLSConfig returns a tls config presenting the service identity certificate
+// for both client and server connections. The certificate is refreshed when
+// needed during the handshakes so the config can be kept across invocations.
+func (i *Identity) TLSConfig() (*tls.Config, error) {
+	if _, err := i.Certificate(); err != nil {
+		return nil, err
+	}
+	return i.tlsConfig(i.Certificate), nil
+}
+
+// RoleTLSConfig returns a tls config presenting the role certificate for
+// the given role name
+func (i *Identity) RoleTLSConfig(roleName string) (*tls.Config, error) {
+	if _, err := i.RoleCertificate(roleName); err != nil {
+		return nil, err
+	}
+	return i.tlsConfig(func() (tls.Certificate, error) {
+		return i.RoleCertificate(roleName)
+	}), nil
+}
+
+// TokenSource returns a token source for the access tokens of the given
+// roles in the domain
+func (i *Identity) TokenSource(domain string, roles []string, expiryTime int) TokenSource {
+	return &tokenSource{
+		identity:   i,
+		domain:     domain,
+		roles:      roles,
+		expiryTime: expiryTime,
+	}
+}
+
+type tokenSource struct {
+	identity   *Identity
+	domain     string
+	roles      []string
+	expiryTime int
+}
+
+func (s *tokenSource) Token() (string, error) {
+	return s.identity.AccessToken(s.domain, s.roles, s.expiryTime)
+}
+
+func (i *Identity) tlsConfig(getCert func() (tls.Certificate, error)) *tls.Config {
+	getCertificate := func() (*tls.Certificate, error) {
+		cert, err := getCert()
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    i.caCerts,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return getCertificate()
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return getCertificate()
+		},
+	}
+}
+
+// serviceCertificate returns the current service certificate, registering
+// the function again with ZTS if the certificate is due for a refresh
+func (i *Identity) serviceCertificate(now time.Time) (*tls.Certificate, error) {
+	if !refreshDue(i.svcCert, now) {
+		return i.svcCert, nil
+	}
+	cert, err := i.register()
+	if err != nil {
+		if i.svcCert == nil || !now.Before(i.svcCert.Leaf.NotAfter) {
+			return nil, err
+		}
+		log.Printf("Unable to refresh service certificate, using current one, err: %v\n", err)
+		return i.svcCert, nil
+	}
+	i.svcCert = cert
+	return cert, nil
+}
+
+func (i *Identity) register() (*tls.Certificate, error) {
+	principal := fmt.Sprintf("%s.%s", i.config.Domain, i.config.Service)
+	details := util.CertReqDetails{
+		CommonName: principal,
+		Country:    "US",
+		OrgUnit:    i.config.Provider.GetName(),
+		HostList:   i.config.Provider.GetSanDns(principal, false, false, nil),
+		URIs:       i.config.Provider.GetSanUri(principal, ip.Opts{}),
+	}
+	csr, err := util.GenerateX509CSR(i.key, details)
+	if err != nil {
+		return nil, err
+	}
+	attestData, err := i.config.Provider.AttestationData(principal, i.key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get attestation data: %v", err)
+	}
+	client, err := util.ZtsClient(i.config.ZTSUrl, i.config.ZTSServerName, "", "", i.config.ZTSCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	info := &zts.InstanceRegisterInformation{
+		Provider:        zts.ServiceName(i.config.Provider.GetName()),
+		Domain:          zts.DomainName(i.config.Domain),
+		Service:         zts.SimpleName(i.config.Service),
+		Csr:             csr,
+		AttestationData: attestData,
+	}
+	if i.config.ExpiryTime > 0 {
+		expiryTime := int32(i.config.ExpiryTime)
+		info.ExpiryTime = &expiryTime
+	}
+	identity, _, err := client.PostInstanceRegisterInformation(info)
+	if err != nil {
+		log.Printf("Unable to do PostInstanceRegisterInformation, err: %v\n", err)
+		return nil, err
+	}
+	return i.keyPair(identity.X509Certificate)
+}
+
+func (i *Identity) fetchRoleCertificate(roleName string, svcCert *tls.Certificate) (*tls.Certificate, error) {
+	domain, role, err := util.SplitRoleName(roleName)
+	if err != nil {
+		return nil, err
+	}
+	details := util.CertReqDetails{
+		CommonName: roleName,
+		Country:    "US",
+		OrgUnit:    i.config.Provider.GetName(),
+	}
+	// spiffe uri must always be the first one
+	details.URIs = util.AppendUri(nil, fmt.Sprintf("spiffe://%s/ra/%s", domain, role))
+	details.URIs = util.AppendUri(details.URIs, fmt.Sprintf("athenz://principal/%s.%s", i.config.Domain, i.config.Service))
+	csr, err := util.GenerateX509CSR(i.key, details)
+	if err != nil {
+		return nil, err
+	}
+	client := i.ztsClient(svcCert)
+	roleRequest := &zts.RoleCertificateRequest{
+		Csr: csr,
+	}
+	if i.config.ExpiryTime > 0 {
+		roleRequest.ExpiryTime = int64(i.config.ExpiryTime)
+	}
+	roleCert, err := client.PostRoleCertificateRequestExt(roleRequest)
+	if err != nil {
+		log.Printf("PostRoleCertificateRequest failed for %s, err: %v\n", roleName, err)
+		return nil, err
+	}
+	return i.keyPair(roleCert.X509Certificate)
+}
+
+func (i *Identity) fetchAccessToken(domain string, roles []string, expiryTime int, svcCert *tls.Certificate, now time.Time) (*accessToken, error) {
+	client := i.ztsClient(svcCert)
+	request := athenzutils.GenerateAccessTokenRequestString(domain, "", strings.Join(roles, ","), "", "", expiryTime)
+	res, err := client.PostAccessTokenRequest(zts.AccessTokenRequest(request))
+	if err != nil {
+		log.Printf("PostAccessTokenRequest failed for %s, err: %v\n", domain, err)
+		return nil, err
+	}
+	if res.Expires_in == nil || *res.Expires_in <= 0 {
+		return nil, fmt.Errorf("access token response for %s does not include its expiry", domain)
+	}
+	lifetime := time.Duration(*res.Expires_in) * time.Second
+	return &accessToken{
+		token:   res.Access_token,
+		refresh: now.Add(time.Duration(float64(lifetime) * refreshRatio)),
+		expiry:  now.Add(lifetime),
+	}, nil
+}
+
+// ztsClient returns a ZTS client authenticated with the given certificate
+func (i *Identity) ztsClient(cert *tls.Certificate) *zts.ZTSClient {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*cert},
+			RootCAs:      i.ztsCAs,
+			ServerName:   i.config.ZTSServerName,
+		},
+	}
+	client := zts.NewClient(i.config.ZTSUrl, transport)
+	return &client
+}
+
+func (i *Identity) keyPair(certPem string) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair([]byte(certPem), util.GetPEMBlock(i.key))
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// refreshDue returns true if the certificate is not available or has
+// reached the refresh point of its lifetime
+func refreshDue(cert *tls.Certificate, now time.Time) bool {
+	if cert == nil {
+		return true
+	}
+	lifetime := cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore)
+	refresh := cert.Leaf.NotBefore.Add(time.Duration(float64(lifetime) * refreshRatio))
+	return !now.Before(refresh)
+}
+
+func certPool(caCertFile string) (*x509.CertPool, error) {
+	if caCertFile == "" {
+		return nil, nil
+	}
+	caCerts, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCerts) {
+		return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+	}
+	return pool, nil
+}
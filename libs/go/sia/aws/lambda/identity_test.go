@@ -0,0 +1,289 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package lambda
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testProvider struct {
+	provider.Base
+}
+
+func (p testProvider) AttestationData(principal string, key crypto.PrivateKey, sigInfo *signature.SignatureInfo) (string, error) {
+	return fmt.Sprintf(`{"role":"%s"}`, principal), nil
+}
+
+// ztsServer is a fake ZTS server issuing certificates with the configured
+// validity and counting the requests for each api
+type ztsServer struct {
+	*httptest.Server
+	caKey     *ecdsa.PrivateKey
+	caCert    *x509.Certificate
+	validity  time.Duration
+	elapsed   time.Duration
+	failures  int32
+	registers int32
+	roleCerts int32
+	tokens    int32
+}
+
+func newZtsServer(t *testing.T, validity, elapsed time.Duration) *ztsServer {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	require.Nil(t, err)
+	caCert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	s := &ztsServer{caKey: caKey, caCert: caCert, validity: validity, elapsed: elapsed}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/zts/v1/instance", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.registers, 1)
+		var info zts.InstanceRegisterInformation
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&info))
+		assert.Equal(t, `{"role":"sports.api"}`, info.AttestationData)
+		if s.fail(w) {
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&zts.InstanceIdentity{Provider: info.Provider, Name: "sports.api", InstanceId: "lambda-123456789012-api", X509Certificate: s.sign(t, info.Csr)})
+	})
+	mux.HandleFunc("/zts/v1/rolecert", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.roleCerts, 1)
+		var req zts.RoleCertificateRequest
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		if s.fail(w) {
+			return
+		}
+		json.NewEncoder(w).Encode(&zts.RoleCertificate{X509Certificate: s.sign(t, req.Csr)})
+	})
+	mux.HandleFunc("/zts/v1/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&s.tokens, 1)
+		assert.Nil(t, r.ParseForm())
+		if s.fail(w) {
+			return
+		}
+		expiresIn := int32(3600)
+		json.NewEncoder(w).Encode(&zts.AccessTokenResponse{
+			Access_token: fmt.Sprintf("token-%d-%s", count, r.PostForm.Get("scope")),
+			Token_type:   "Bearer",
+			Expires_in:   &expiresIn,
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *ztsServer) fail(w http.ResponseWriter) bool {
+	if atomic.LoadInt32(&s.failures) == 0 {
+		return false
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(`{"code":500,"message":"unavailable"}`))
+	return true
+}
+
+func (s *ztsServer) sign(t *testing.T, csrPem string) string {
+	block, _ := pem.Decode([]byte(csrPem))
+	if !assert.NotNil(t, block) {
+		return ""
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	notBefore := time.Now().Add(-s.elapsed)
+	template := &x509.Certificate{
+		Subject:      csr.Subject,
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(s.validity),
+		DNSNames:     csr.DNSNames,
+		URIs:         csr.URIs,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func newTestIdentity(t *testing.T, server *ztsServer) *Identity {
+	identity, err := NewIdentity(Config{
+		ZTSUrl:   server.URL + "/zts/v1",
+		Provider: testProvider{Base: provider.Base{Name: "athenz.aws-lambda.us-west-2", InstanceId: "lambda-123456789012-api", Domains: []string{"aws.athenz.cloud"}}},
+		Domain:   "sports",
+		Service:  "api",
+	})
+	require.Nil(t, err)
+	return identity
+}
+
+func TestNewIdentity(t *testing.T) {
+	_, err := NewIdentity(Config{Domain: "sports", Service: "api"})
+	assert.NotNil(t, err)
+	_, err = NewIdentity(Config{Provider: testProvider{}, Domain: "sports"})
+	assert.NotNil(t, err)
+	_, err = NewIdentity(Config{Provider: testProvider{}, Domain: "sports", Service: "api", KeyType: "dsa"})
+	assert.NotNil(t, err)
+	_, err = NewIdentity(Config{Provider: testProvider{}, Domain: "sports", Service: "api", CACertFile: "/non-existent/ca.pem"})
+	assert.NotNil(t, err)
+
+	identity, err := NewIdentity(Config{Provider: testProvider{}, Domain: "sports", Service: "api"})
+	require.Nil(t, err)
+	_, ok := identity.key.(*ecdsa.PrivateKey)
+	assert.True(t, ok)
+}
+
+func TestIdentityCached(t *testing.T) {
+	server := newZtsServer(t, 24*time.Hour, time.Hour)
+	defer server.Close()
+	identity := newTestIdentity(t, server)
+
+	cert, err := identity.Certificate()
+	require.Nil(t, err)
+	assert.Equal(t, "sports.api", cert.Leaf.Subject.CommonName)
+	assert.Equal(t, []string{"api.sports.aws.athenz.cloud"}, cert.Leaf.DNSNames)
+	assert.Equal(t, "athenz://instanceid/athenz.aws-lambda.us-west-2/lambda-123456789012-api", cert.Leaf.URIs[1].String())
+	_, err = identity.Certificate()
+	require.Nil(t, err)
+	assert.Equal(t, int32(1), server.registers)
+
+	roleCert, err := identity.RoleCertificate("weather:role.readers")
+	require.Nil(t, err)
+	assert.Equal(t, "weather:role.readers", roleCert.Leaf.Subject.CommonName)
+	assert.Equal(t, "spiffe://weather/ra/readers", roleCert.Leaf.URIs[0].String())
+	_, err = identity.RoleCertificate("weather:role.readers")
+	require.Nil(t, err)
+	assert.Equal(t, int32(1), server.roleCerts)
+	_, err = identity.RoleCertificate("weather")
+	assert.NotNil(t, err)
+
+	token, err := identity.AccessToken("weather", []string{"readers"}, 0)
+	require.Nil(t, err)
+	assert.Equal(t, "token-1-weather:role.readers", token)
+	source := identity.TokenSource("weather", []string{"readers"}, 0)
+	token, err = source.Token()
+	require.Nil(t, err)
+	assert.Equal(t, "token-1-weather:role.readers", token)
+	token, err = identity.AccessToken("weather", nil, 0)
+	require.Nil(t, err)
+	assert.Equal(t, "token-2-weather:domain", token)
+	assert.Equal(t, int32(2), server.tokens)
+	assert.Equal(t, int32(1), server.registers)
+}
+
+func TestIdentityRefreshNearExpiry(t *testing.T) {
+	// certificates are issued with 10 minutes of their 1 hour lifetime left
+	server := newZtsServer(t, time.Hour, 50*time.Minute)
+	defer server.Close()
+	identity := newTestIdentity(t, server)
+
+	_, err := identity.Certificate()
+	require.Nil(t, err)
+	_, err = identity.Certificate()
+	require.Nil(t, err)
+	assert.Equal(t, int32(2), server.registers)
+
+	// the current certificates are used until they expire if ZTS is not available
+	_, err = identity.RoleCertificate("weather:role.readers")
+	require.Nil(t, err)
+	atomic.StoreInt32(&server.failures, 1)
+	cert, err := identity.Certificate()
+	require.Nil(t, err)
+	assert.Equal(t, "sports.api", cert.Leaf.Subject.CommonName)
+	_, err = identity.RoleCertificate("weather:role.readers")
+	require.Nil(t, err)
+	_, err = identity.AccessToken("weather", nil, 0)
+	assert.NotNil(t, err)
+}
+
+func TestIdentityRegisterFailure(t *testing.T) {
+	server := newZtsServer(t, time.Hour, 0)
+	defer server.Close()
+	atomic.StoreInt32(&server.failures, 1)
+	identity := newTestIdentity(t, server)
+
+	_, err := identity.Certificate()
+	assert.NotNil(t, err)
+	_, err = identity.TLSConfig()
+	assert.NotNil(t, err)
+	_, err = identity.RoleTLSConfig("weather:role.readers")
+	assert.NotNil(t, err)
+	_, err = identity.TokenSource("weather", nil, 0).Token()
+	assert.NotNil(t, err)
+}
+
+func TestIdentityTLSConfig(t *testing.T) {
+	server := newZtsServer(t, 24*time.Hour, 0)
+	defer server.Close()
+	identity := newTestIdentity(t, server)
+
+	config, err := identity.TLSConfig()
+	require.Nil(t, err)
+	cert, err := config.GetClientCertificate(nil)
+	require.Nil(t, err)
+	assert.Equal(t, "sports.api", cert.Leaf.Subject.CommonName)
+	cert, err = config.GetCertificate(nil)
+	require.Nil(t, err)
+	assert.Equal(t, "sports.api", cert.Leaf.Subject.CommonName)
+
+	config, err = identity.RoleTLSConfig("weather:role.readers")
+	require.Nil(t, err)
+	cert, err = config.GetClientCertificate(nil)
+	require.Nil(t, err)
+	assert.Equal(t, "weather:role.readers", cert.Leaf.Subject.CommonName)
+	assert.Equal(t, int32(1), server.registers)
+}
+
+func TestNewProvider(t *testing.T) {
+	p := NewProvider("athenz.aws-lambda.us-west-2", "123456789012", "api", []string{"aws.athenz.cloud"})
+	assert.Equal(t, "athenz.aws-lambda.us-west-2", p.GetName())
+	uris := p.GetSanUri("sports.api", ip.Opts{})
+	assert.Equal(t, "athenz://instanceid/athenz.aws-lambda.us-west-2/lambda-123456789012-api", uris[1].String())
+}
@@ -50,6 +50,9 @@ func getLambdaAttestationData(domain, service, account string) (*attestation.Att
 	return data, nil
 }
 
+// GetAWSLambdaServiceCertificate generates a new key and registers the function
+// with ZTS on every call. Use NewIdentity to keep the service identity along
+// with the role certificates and access tokens across warm invocations.
 func GetAWSLambdaServiceCertificate(ztsUrl, provider, domain, service, account string, ztsDomains []string, instanceIdSanDNS bool) (tls.Certificate, error) {
 	key, err := util.GenerateKeyPair(2048)
 	if err != nil {
@@ -0,0 +1,64 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package lambda
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// Provider implements the host provider interface for AWS Lambda functions.
+// The attestation data includes the temporary credentials obtained by
+// assuming the IAM role named after the service identity in the account.
+type Provider struct {
+	provider.Base
+	Account string //aws account id of the lambda function
+}
+
+// NewProvider returns the provider for the lambda function running as the
+// given service in the account. The instance id follows the existing
+// lambda-<account>-<service> format.
+func NewProvider(name, account, service string, ztsDomains []string) Provider {
+	return Provider{
+		Base: provider.Base{
+			Name:       name,
+			InstanceId: fmt.Sprintf("lambda-%s-%s", account, service),
+			Domains:    ztsDomains,
+		},
+		Account: account,
+	}
+}
+
+// AttestationData assumes the role for the given principal and returns the
+// json encoded attestation data for the ZTS api
+func (p Provider) AttestationData(principal string, key crypto.PrivateKey, sigInfo *signature.SignatureInfo) (string, error) {
+	domain, service := util.SplitDomain(principal)
+	data, err := getLambdaAttestationData(domain, service, p.Account)
+	if err != nil {
+		return "", err
+	}
+	attestData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(attestData), nil
+}
@@ -14,60 +14,85 @@
 // limitations under the License.
 //
 
+// Package sds is kept for backward compatibility only. The secret discovery
+// service is not specific to aws and has been moved to the sds package.
+//
+// Deprecated: use github.com/AthenZ/athenz/libs/go/sia/sds instead.
 package sds
 
 import (
-	"errors"
-	"fmt"
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
-	envoySecret "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
-	"google.golang.org/grpc"
-	"log"
-	"os"
+	"context"
+	"net"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/sds"
+	"google.golang.org/grpc/credentials"
 )
 
+// ServerHandler is the secret discovery service handler.
+//
+// Deprecated: use sds.ServerHandler instead.
+type ServerHandler = sds.ServerHandler
+
+// ClientInfo contains the details of the connected client.
+//
+// Deprecated: use sds.ClientInfo instead.
+type ClientInfo = sds.ClientInfo
+
+// Subscriber represents a client subscribed to secret updates.
+//
+// Deprecated: use sds.Subscriber instead.
+type Subscriber = sds.Subscriber
+
+// Listener is the unix domain socket listener.
+//
+// Deprecated: use sds.Listener instead.
+type Listener = sds.Listener
+
+// UdsConn is a unix domain socket connection.
+//
+// Deprecated: use sds.UdsConn instead.
+type UdsConn = sds.UdsConn
+
+// Deprecated: use sds.NewCredentials instead.
+func NewCredentials() credentials.TransportCredentials {
+	return sds.NewCredentials()
+}
+
+// Deprecated: use sds.AthenzGrpcServerName instead.
+func AthenzGrpcServerName() string {
+	return sds.AthenzGrpcServerName()
+}
+
+// Deprecated: use sds.ClientInfoFromContext instead.
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	return sds.ClientInfoFromContext(ctx)
+}
+
+// Deprecated: use sds.NewServerHandler instead.
+func NewServerHandler(opts *options.Options) *ServerHandler {
+	return sds.NewServerHandler(opts)
+}
+
+// Deprecated: use sds.ClientAuthType instead.
+func ClientAuthType() string {
+	return sds.ClientAuthType()
+}
+
+// StartGrpcServer starts the secret discovery service without
+// support for configuration updates.
+//
+// Deprecated: use sds.StartGrpcServer instead.
 func StartGrpcServer(opts *options.Options, certUpdates chan bool) error {
+	return sds.StartGrpcServer(opts, certUpdates, nil)
+}
 
-	listener, err := StartUdsListener(opts.SDSUdsPath)
-	if err != nil {
-		return fmt.Errorf("unable to start uds listener for %s, error: %v", opts.SDSUdsPath, err)
-	}
-	defer listener.Close()
-
-	grpcServer := grpc.NewServer(
-		grpc.Creds(NewCredentials()),
-	)
-
-	serverHandler := NewServerHandler(opts)
-	go notifyCertificateUpdates(serverHandler, certUpdates)
-
-	envoySecret.RegisterSecretDiscoveryServiceServer(grpcServer, serverHandler)
-
-	errChan := make(chan error)
-	go func() {
-		errChan <- grpcServer.Serve(listener)
-	}()
-
-	select {
-	case err = <-errChan:
-		log.Println("Stopping GRPC SDS server...")
-		grpcServer.Stop()
-		if _, err := os.Stat(opts.SDSUdsPath); err == nil {
-			os.Remove(opts.SDSUdsPath)
-		}
-		if errors.Is(err, grpc.ErrServerStopped) {
-			err = nil
-		}
-	}
-
-	return err
+// Deprecated: use sds.NewSubscriber instead.
+func NewSubscriber() *Subscriber {
+	return sds.NewSubscriber()
 }
 
-func notifyCertificateUpdates(serverHandler *ServerHandler, updates <-chan bool) {
-	for {
-		select {
-		case <-updates:
-			serverHandler.NotifySubscribers()
-		}
-	}
+// Deprecated: use sds.StartUdsListener instead.
+func StartUdsListener(udsPath string) (net.Listener, error) {
+	return sds.StartUdsListener(udsPath)
 }
@@ -0,0 +1,287 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package awscreds manages the AWS temporary credentials that the agent
+// fetches from ZTS with its service identity. The credentials are written
+// into named profiles of a managed AWS credentials file and can optionally
+// be served on a local container credentials endpoint so the AWS SDKs pick
+// them up through AWS_CONTAINER_CREDENTIALS_FULL_URI without any files.
+package awscreds
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+const (
+	// credentials are refreshed once half of their lifetime has passed
+	refreshRatio = 0.5
+	// refresh interval when there are no profiles configured
+	idleInterval = time.Hour
+)
+
+type profileState struct {
+	config  options.AWSCredentials
+	creds   *zts.AWSTemporaryCredentials
+	refresh time.Time
+}
+
+// Manager keeps the current credentials for all configured profiles
+type Manager struct {
+	mutex     sync.RWMutex
+	opts      *options.Options
+	ztsUrl    string
+	profiles  map[string]*profileState
+	modified  bool
+	authToken string
+}
+
+func NewManager(opts *options.Options, ztsUrl string) (*Manager, error) {
+	authToken, err := newAuthToken()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		ztsUrl:    ztsUrl,
+		profiles:  make(map[string]*profileState),
+		authToken: authToken,
+	}
+	m.UpdateOptions(opts)
+	return m, nil
+}
+
+func (m *Manager) GetOptions() *options.Options {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.opts
+}
+
+// UpdateOptions applies the profiles from the given options. The removed
+// profiles are dropped from the credentials file while the profiles whose
+// settings have changed are fetched again on the next refresh
+func (m *Manager) UpdateOptions(opts *options.Options) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	configured := make(map[string]bool)
+	for _, c := range opts.AWSCredentials {
+		configured[c.Profile] = true
+		state, ok := m.profiles[c.Profile]
+		if !ok || state.config != c {
+			if ok {
+				log.Printf("aws credentials profile %s updated\n", c.Profile)
+			}
+			m.profiles[c.Profile] = &profileState{config: c}
+			status.Register(status.AWSCredentials, c.Profile, opts.AWSCredentialsFile, time.Time{})
+		}
+	}
+	for profile := range m.profiles {
+		if !configured[profile] {
+			log.Printf("aws credentials profile %s removed\n", profile)
+			delete(m.profiles, profile)
+			status.Unregister(status.AWSCredentials, profile)
+			m.modified = true
+		}
+	}
+	m.opts = opts
+}
+
+// Refresh fetches the credentials for all profiles that are due for a
+// refresh and updates the credentials file if any of them has changed.
+// It returns the duration until the next profile needs to be refreshed
+func (m *Manager) Refresh() time.Duration {
+
+	opts := m.GetOptions()
+	now := time.Now()
+	for _, c := range opts.AWSCredentials {
+		if !m.isDue(c.Profile, now) {
+			continue
+		}
+		creds, err := fetchCredentials(m.ztsUrl, opts, c)
+		if err != nil {
+			log.Printf("unable to fetch aws credentials for profile %s, err: %v\n", c.Profile, err)
+			status.Failure(status.AWSCredentials, c.Profile, opts.AWSCredentialsFile, err)
+			m.setRefresh(c.Profile, nil, now.Add(retryInterval(opts)))
+			continue
+		}
+		expiry := creds.Expiration.Time
+		refresh := now.Add(time.Duration(float64(expiry.Sub(now)) * refreshRatio))
+		log.Printf("aws credentials for profile %s refreshed, expiry: %v\n", c.Profile, expiry)
+		status.Success(status.AWSCredentials, c.Profile, opts.AWSCredentialsFile, expiry)
+		m.setRefresh(c.Profile, creds, refresh)
+	}
+
+	if m.takeModified() {
+		if err := m.writeCredentialsFile(opts); err != nil {
+			log.Printf("unable to update aws credentials file %s, err: %v\n", opts.AWSCredentialsFile, err)
+		}
+	}
+	return m.nextRefresh(now)
+}
+
+func (m *Manager) isDue(profile string, now time.Time) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	state, ok := m.profiles[profile]
+	return ok && !now.Before(state.refresh)
+}
+
+// setRefresh records the next refresh time of the profile and the new
+// credentials if the fetch was successful. After a failure the current
+// credentials are kept until they expire
+func (m *Manager) setRefresh(profile string, creds *zts.AWSTemporaryCredentials, refresh time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	state, ok := m.profiles[profile]
+	if !ok {
+		return
+	}
+	state.refresh = refresh
+	if creds != nil {
+		state.creds = creds
+		m.modified = true
+	}
+}
+
+func (m *Manager) takeModified() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	modified := m.modified
+	m.modified = false
+	return modified
+}
+
+func (m *Manager) nextRefresh(now time.Time) time.Duration {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	next := now.Add(idleInterval)
+	for _, state := range m.profiles {
+		if state.refresh.Before(next) {
+			next = state.refresh
+		}
+	}
+	if next.Before(now) {
+		return 0
+	}
+	return next.Sub(now)
+}
+
+// getCredentials returns the current credentials for the given
+// profile or nil if the credentials are not available or expired
+func (m *Manager) getCredentials(profile string) *zts.AWSTemporaryCredentials {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	state, ok := m.profiles[profile]
+	if !ok || state.creds == nil || !time.Now().Before(state.creds.Expiration.Time) {
+		return nil
+	}
+	return state.creds
+}
+
+// writeCredentialsFile generates the credentials file with all the
+// profiles that have valid credentials. The file is owned by the user
+// of the primary service
+func (m *Manager) writeCredentialsFile(opts *options.Options) error {
+
+	var buf bytes.Buffer
+	buf.WriteString("# generated by siad - any changes will be overwritten\n")
+	for _, c := range opts.AWSCredentials {
+		creds := m.getCredentials(c.Profile)
+		if creds == nil {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n[%s]\n", c.Profile)
+		fmt.Fprintf(&buf, "aws_access_key_id = %s\n", creds.AccessKeyId)
+		fmt.Fprintf(&buf, "aws_secret_access_key = %s\n", creds.SecretAccessKey)
+		fmt.Fprintf(&buf, "aws_session_token = %s\n", creds.SessionToken)
+	}
+
+	err := os.MkdirAll(filepath.Dir(opts.AWSCredentialsFile), 0755)
+	if err != nil {
+		return err
+	}
+	svc := opts.Services[0]
+	return util.UpdateFile(opts.AWSCredentialsFile, buf.Bytes(), svc.Uid, svc.Gid, 0400)
+}
+
+func fetchCredentials(ztsUrl string, opts *options.Options, c options.AWSCredentials) (*zts.AWSTemporaryCredentials, error) {
+
+	var svc *options.Service
+	for i := range opts.Services {
+		if opts.Services[i].Name == c.Service {
+			svc = &opts.Services[i]
+			break
+		}
+	}
+	if svc == nil {
+		return nil, fmt.Errorf("aws credentials profile %s has unknown service: %s", c.Profile, c.Service)
+	}
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+
+	client, err := util.ZtsClient(ztsUrl, opts.ZTSServerName, keyFile, certFile, opts.ZTSCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+
+	var duration *int32
+	if c.Duration != 0 {
+		seconds := int32(c.Duration)
+		duration = &seconds
+	}
+	start := time.Now()
+	creds, err := client.GetAWSTemporaryCredentials(zts.DomainName(c.Domain), zts.AWSArnRoleName(c.Role), duration, c.ExternalId)
+	status.ObserveZtsRequest("GetAWSTemporaryCredentials", time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	if creds.Expiration.Time.IsZero() {
+		return nil, fmt.Errorf("zts response does not include the credentials expiration")
+	}
+	return creds, nil
+}
+
+// retryInterval returns the interval after which a failed
+// fetch is retried based on the configured backoff settings
+func retryInterval(opts *options.Options) time.Duration {
+	interval := opts.BackoffInitialInterval
+	if interval <= 0 {
+		interval = options.DEFAULT_BACKOFF_INITIAL_INTERVAL
+	}
+	return time.Duration(interval) * time.Second
+}
+
+func newAuthToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
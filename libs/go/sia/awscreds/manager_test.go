@@ -0,0 +1,162 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package awscreds
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/ardielle/ardielle-go/rdl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeZts returns credentials for the roles in the sports domain
+// and keeps track of the number of requests it has received
+type fakeZts struct {
+	server   *httptest.Server
+	requests int32
+	lifetime time.Duration
+}
+
+func newFakeZts(test *testing.T) *fakeZts {
+	f := &fakeZts{lifetime: time.Hour}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&f.requests, 1)
+		if !strings.HasPrefix(r.URL.Path, "/zts/v1/domain/sports/role/") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"code":403,"message":"not authorized"}`))
+			return
+		}
+		role := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/zts/v1/domain/sports/role/"), "/creds")
+		json.NewEncoder(w).Encode(zts.AWSTemporaryCredentials{
+			AccessKeyId:     "AKIA" + role,
+			SecretAccessKey: "secret-" + r.URL.Query().Get("externalId"),
+			SessionToken:    "token-" + string(rune('0'+count)),
+			Expiration:      rdl.Timestamp{Time: time.Now().Add(f.lifetime).UTC()},
+		})
+	}))
+	test.Cleanup(f.server.Close)
+	return f
+}
+
+func testOptions(test *testing.T) *options.Options {
+	return &options.Options{
+		Domain:             "sports",
+		KeyDir:             "/tmp/keys",
+		CertDir:            "/tmp/certs",
+		AWSCredentialsFile: filepath.Join(test.TempDir(), "aws", "credentials"),
+		Services:           []options.Service{{Name: "api", Uid: os.Getuid(), Gid: os.Getgid()}},
+		AWSCredentials: []options.AWSCredentials{
+			{Profile: "default", Domain: "sports", Role: "reader", Service: "api"},
+			{Profile: "writer", Domain: "sports", Role: "writer", Duration: 3600, ExternalId: "sia", Service: "api"},
+		},
+	}
+}
+
+func TestRefresh(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	opts := testOptions(test)
+	manager, err := NewManager(opts, ztsServer.server.URL+"/zts/v1")
+	require.Nil(test, err)
+
+	wait := manager.Refresh()
+	assert.Equal(test, int32(2), atomic.LoadInt32(&ztsServer.requests))
+	assert.InDelta(test, (30 * time.Minute).Seconds(), wait.Seconds(), 5)
+
+	data, err := os.ReadFile(opts.AWSCredentialsFile)
+	require.Nil(test, err)
+	assert.Equal(test, `# generated by siad - any changes will be overwritten
+
+[default]
+aws_access_key_id = AKIAreader
+aws_secret_access_key = secret-
+aws_session_token = token-1
+
+[writer]
+aws_access_key_id = AKIAwriter
+aws_secret_access_key = secret-sia
+aws_session_token = token-2
+`, string(data))
+	info, err := os.Stat(opts.AWSCredentialsFile)
+	require.Nil(test, err)
+	assert.Equal(test, os.FileMode(0400), info.Mode().Perm())
+
+	// nothing is due so no new requests are sent
+	manager.Refresh()
+	assert.Equal(test, int32(2), atomic.LoadInt32(&ztsServer.requests))
+
+	// removed profiles are dropped from the file and updated
+	// profiles are fetched again
+	updated := *opts
+	updated.AWSCredentials = []options.AWSCredentials{
+		{Profile: "default", Domain: "sports", Role: "admin", Service: "api"},
+	}
+	manager.UpdateOptions(&updated)
+	manager.Refresh()
+	assert.Equal(test, int32(3), atomic.LoadInt32(&ztsServer.requests))
+	data, err = os.ReadFile(opts.AWSCredentialsFile)
+	require.Nil(test, err)
+	assert.Contains(test, string(data), "aws_access_key_id = AKIAadmin")
+	assert.NotContains(test, string(data), "[writer]")
+}
+
+func TestRefreshFailure(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	opts := testOptions(test)
+	opts.AWSCredentials = []options.AWSCredentials{
+		{Profile: "default", Domain: "weather", Role: "reader", Service: "api"},
+	}
+	opts.BackoffInitialInterval = 15
+	manager, err := NewManager(opts, ztsServer.server.URL+"/zts/v1")
+	require.Nil(test, err)
+
+	wait := manager.Refresh()
+	assert.InDelta(test, 15, wait.Seconds(), 1)
+	assert.Nil(test, manager.getCredentials("default"))
+	_, err = os.Stat(opts.AWSCredentialsFile)
+	assert.True(test, os.IsNotExist(err))
+}
+
+func TestFetchCredentialsUnknownService(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	opts := testOptions(test)
+	c := options.AWSCredentials{Profile: "default", Domain: "sports", Role: "reader", Service: "backend"}
+	_, err := fetchCredentials(ztsServer.server.URL+"/zts/v1", opts, c)
+	require.NotNil(test, err)
+	assert.Contains(test, err.Error(), "unknown service: backend")
+	assert.Equal(test, int32(0), atomic.LoadInt32(&ztsServer.requests))
+}
+
+func TestRefreshNoProfiles(test *testing.T) {
+	opts := testOptions(test)
+	opts.AWSCredentials = nil
+	manager, err := NewManager(opts, "http://127.0.0.1:1/zts/v1")
+	require.Nil(test, err)
+	assert.Equal(test, idleInterval, manager.Refresh())
+	_, err = os.Stat(opts.AWSCredentialsFile)
+	assert.True(test, os.IsNotExist(err))
+}
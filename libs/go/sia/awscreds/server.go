@@ -0,0 +1,120 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package awscreds
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// containerCredentials is the response format expected by the AWS SDKs
+// from the container credentials endpoint
+type containerCredentials struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// GetAuthTokenFile returns the file with the authorization token that the
+// clients must include in their requests to the credentials endpoint. The
+// AWS SDKs read it from AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE
+func GetAuthTokenFile(credentialsFile string) string {
+	return filepath.Join(filepath.Dir(credentialsFile), "auth_token")
+}
+
+// Handler returns the http handler serving the credentials of each
+// profile on the /v1/credentials/<profile> endpoint
+func (m *Manager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/credentials/", m.serveCredentials)
+	return mux
+}
+
+// Serve writes the authorization token file and serves the container
+// credentials endpoint on the given port of the loopback interface
+// until the server fails
+func (m *Manager) Serve(port int) error {
+
+	opts := m.GetOptions()
+	tokenFile := GetAuthTokenFile(opts.AWSCredentialsFile)
+	err := os.MkdirAll(filepath.Dir(tokenFile), 0755)
+	if err != nil {
+		return err
+	}
+	// the token file is replaced rather than rewritten in place since
+	// the existing read-only file might be owned by the service user
+	svc := opts.Services[0]
+	err = util.UpdateFile(tokenFile, []byte(m.authToken), svc.Uid, svc.Gid, 0400)
+	if err != nil {
+		return fmt.Errorf("unable to write aws credentials token file %s: %v", tokenFile, err)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("aws credentials endpoint is ready on address: %s\n", listener.Addr().String())
+
+	server := &http.Server{
+		Handler:           m.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.Serve(listener)
+}
+
+func (m *Manager) serveCredentials(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(m.authToken)) != 1 {
+		log.Printf("aws credentials endpoint: denied request from %s for %s\n", r.RemoteAddr, r.URL.Path)
+		http.Error(w, "invalid authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	profile := strings.TrimPrefix(r.URL.Path, "/v1/credentials/")
+	creds := m.getCredentials(profile)
+	if creds == nil {
+		http.Error(w, fmt.Sprintf("credentials for profile %s are not available", profile), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(containerCredentials{
+		AccessKeyId:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.Expiration.Time.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("unable to encode aws credentials response: %v\n", err)
+	}
+}
@@ -0,0 +1,98 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package awscreds
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeCredentials(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	manager, err := NewManager(testOptions(test), ztsServer.server.URL+"/zts/v1")
+	require.Nil(test, err)
+	manager.Refresh()
+
+	server := httptest.NewServer(manager.Handler())
+	defer server.Close()
+
+	get := func(path, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.Nil(test, err)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(test, err)
+		return resp
+	}
+
+	resp := get("/v1/credentials/writer", manager.authToken)
+	require.Equal(test, http.StatusOK, resp.StatusCode)
+	var creds containerCredentials
+	require.Nil(test, json.NewDecoder(resp.Body).Decode(&creds))
+	resp.Body.Close()
+	assert.Equal(test, "AKIAwriter", creds.AccessKeyId)
+	assert.Equal(test, "secret-sia", creds.SecretAccessKey)
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	require.Nil(test, err)
+	assert.InDelta(test, time.Now().Add(time.Hour).Unix(), expiration.Unix(), 5)
+
+	resp = get("/v1/credentials/writer", "")
+	resp.Body.Close()
+	assert.Equal(test, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get("/v1/credentials/writer", "invalid-token")
+	resp.Body.Close()
+	assert.Equal(test, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get("/v1/credentials/unknown", manager.authToken)
+	resp.Body.Close()
+	assert.Equal(test, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGetAuthTokenFile(test *testing.T) {
+	assert.Equal(test, "/var/lib/sia/aws/auth_token", GetAuthTokenFile("/var/lib/sia/aws/credentials"))
+}
+
+func TestServeReplacesTokenFile(test *testing.T) {
+	opts := testOptions(test)
+	manager, err := NewManager(opts, "http://127.0.0.1:1/zts/v1")
+	require.Nil(test, err)
+
+	// the read-only token file from a previous run is replaced
+	tokenFile := GetAuthTokenFile(opts.AWSCredentialsFile)
+	require.Nil(test, os.MkdirAll(filepath.Dir(tokenFile), 0755))
+	require.Nil(test, os.WriteFile(tokenFile, []byte("previous-token"), 0400))
+
+	go manager.Serve(0)
+	assert.Eventually(test, func() bool {
+		data, err := os.ReadFile(tokenFile)
+		return err == nil && string(data) == manager.authToken
+	}, 5*time.Second, 10*time.Millisecond)
+	info, err := os.Stat(tokenFile)
+	require.Nil(test, err)
+	assert.Equal(test, os.FileMode(0400), info.Mode().Perm())
+}
@@ -6,11 +6,10 @@ package siafile
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"github.com/AthenZ/athenz/libs/go/athenz-common/log"
+	"github.com/AthenZ/athenz/libs/go/sia/futil"
 	"github.com/AthenZ/athenz/libs/go/sia/verify"
 	"os"
-	"time"
 )
 
 func Update(fileName string, contents []byte, uid, gid int, perm os.FileMode, vfn verify.VerifyFn) error {
@@ -27,7 +26,7 @@ func Update(fileName string, contents []byte, uid, gid int, perm os.FileMode, vf
 	if err != nil {
 		if os.IsNotExist(err) {
 			log.Printf("Updating file %s...\n", fileName)
-			err = os.WriteFile(fileName, contents, perm)
+			err = replaceFile(fileName, contents, uid, gid, perm, nil)
 			if err != nil {
 				log.Printf("Unable to write new file %s, err: %v\n", fileName, err)
 				return err
@@ -51,7 +50,7 @@ func Update(fileName string, contents []byte, uid, gid int, perm os.FileMode, vf
 				}
 			}
 		} else {
-			err = overrideFile(fileName, err, contents, perm, vfn)
+			err = replaceFile(fileName, contents, uid, gid, perm, vfn)
 			if err != nil {
 				return err
 			}
@@ -70,48 +69,30 @@ func Update(fileName string, contents []byte, uid, gid int, perm os.FileMode, vf
 	return nil
 }
 
-// overrideFile replaces existing file with new content (after verifying the content is valid)
-func overrideFile(fileName string, err error, contents []byte, perm os.FileMode, vfn verify.VerifyFn) error {
-	timeNano := time.Now().UnixNano()
-	// write the new contents to a temporary file
-	newFileName := fmt.Sprintf("%s.tmp%d", fileName, timeNano)
-	log.Printf("Writing contents to temporary file %s...\n", newFileName)
-	err = os.WriteFile(newFileName, contents, perm)
-	if err != nil {
-		log.Printf("Unable to write new file %s, err: %v\n", newFileName, err)
-		return err
-	}
-
-	// Sanity check the new file against the existing one
+// replaceFile replaces the file with new content (after verifying the content is valid).
+// The content is synced to a temporary file with the requested ownership which is then
+// renamed over the file, so the file has either its old or its new content after a crash.
+func replaceFile(fileName string, contents []byte, uid, gid int, perm os.FileMode, vfn verify.VerifyFn) error {
+	var verifyFn futil.VerifyFn
 	if vfn != nil {
-		err = vfn(fileName, newFileName)
-		if err != nil {
-			log.Printf("invalid content: %q, error: %v\n", newFileName, err)
+		// Sanity check the new file against the existing one
+		verifyFn = func(staged map[string]string) error {
+			err := vfn(fileName, staged[fileName])
+			if err != nil {
+				log.Printf("invalid content: %q, error: %v\n", staged[fileName], err)
+			}
 			return err
 		}
 	}
-
-	// move the contents of the old file to a backup file
-	bakFileName := fmt.Sprintf("%s.bak%d", fileName, timeNano)
-	log.Printf("Renaming original file %s to backup file %s...\n", fileName, bakFileName)
-	err = os.Rename(fileName, bakFileName)
-	if err != nil {
-		log.Printf("Unable to rename file %s to %s, err: %v\n", fileName, bakFileName, err)
-		return err
+	fileUid, fileGid := -1, -1
+	if uid != 0 || gid != 0 {
+		fileUid, fileGid = uid, gid
 	}
-	// move the new contents to the original location
-	log.Printf("Renaming temporary file %s to requested file %s...\n", newFileName, fileName)
-	err = os.Rename(newFileName, fileName)
+	err := futil.WriteFiles([]futil.File{{Name: fileName, Data: contents, Perm: perm, Uid: fileUid, Gid: fileGid}}, "", verifyFn)
 	if err != nil {
-		log.Printf("Unable to rename file %s to %s, err: %v\n", newFileName, fileName, err)
-		// before returning try to restore the original file
-		os.Rename(bakFileName, fileName)
-		return err
+		log.Printf("Unable to replace file %s, err: %v\n", fileName, err)
 	}
-	// remove the temporary backup file
-	log.Printf("Removing backup file %s...\n", bakFileName)
-	os.Remove(bakFileName)
-	return nil
+	return err
 }
 
 func Copy(sourceFile, destFile string, perm os.FileMode) error {
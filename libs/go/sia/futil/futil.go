@@ -41,9 +41,16 @@ func MakeDirs(dirs []string, perm os.FileMode) error {
 	return nil
 }
 
-// WriteFile is analogous to os.WriteFile, with an explicit call to Sync
-// right after a successful Write operation
+// WriteFile is analogous to os.WriteFile, except the contents are written
+// and synced to a temporary file that is then renamed over the given file,
+// so the file has either its old or its new contents after a crash
 func WriteFile(name string, data []byte, perm os.FileMode) error {
+	return WriteFiles([]File{{Name: name, Data: data, Perm: perm, Uid: -1, Gid: -1}}, "", nil)
+}
+
+// syncWrite writes the file with an explicit call to Sync right after
+// a successful Write operation
+func syncWrite(name string, data []byte, perm os.FileMode) error {
 	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
@@ -0,0 +1,307 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package futil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// File is a file written by WriteFiles
+type File struct {
+	Name string      //destination file
+	Data []byte      //file contents
+	Perm os.FileMode //file permissions
+	Uid  int         //file owner, -1 to keep the owner of the process
+	Gid  int         //file group, -1 to keep the group of the process
+}
+
+// VerifyFn validates the staged contents before they replace the destination
+// files. The staged map returns the temporary file for every destination file.
+type VerifyFn func(staged map[string]string) error
+
+// journalPrefix is the prefix of the journal files in the backup directory
+// that record the files of a transaction until it is committed
+const journalPrefix = ".txn"
+
+type journalEntry struct {
+	Name   string      `json:"name"`
+	Temp   string      `json:"temp"`
+	Backup string      `json:"backup,omitempty"`
+	Perm   os.FileMode `json:"perm"`
+	Uid    int         `json:"uid"`
+	Gid    int         `json:"gid"`
+}
+
+// WriteFiles replaces the given files as a single unit. The contents are
+// written and synced to temporary files in the destination directories and
+// validated with the verify function before the existing files are copied
+// to the backup directory and the temporary files are renamed over them.
+// If any of the renames fails, the files that were already replaced are
+// restored from their backups. While the files are replaced, a journal in
+// the backup directory records the transaction so RecoverFiles can restore
+// the files if the process does not complete it. Without a backup directory
+// the backups are written next to the destination files. The backups are
+// only kept until the transaction is complete. A single file is replaced
+// with one atomic rename, so it does not require a backup.
+func WriteFiles(files []File, backupDir string, verify VerifyFn) error {
+	timeNano := time.Now().UnixNano()
+	entries := make([]journalEntry, 0, len(files))
+	staged := make(map[string]string, len(files))
+	for _, f := range files {
+		temp := fmt.Sprintf("%s.tmp%d", f.Name, timeNano)
+		if err := writeSynced(temp, f.Data, f.Perm, f.Uid, f.Gid); err != nil {
+			os.Remove(temp)
+			removeTemps(entries)
+			return fmt.Errorf("unable to write temporary file %s, error: %v", temp, err)
+		}
+		entries = append(entries, journalEntry{Name: f.Name, Temp: temp, Perm: f.Perm, Uid: f.Uid, Gid: f.Gid})
+		staged[f.Name] = temp
+	}
+	if verify != nil {
+		if err := verify(staged); err != nil {
+			removeTemps(entries)
+			return err
+		}
+	}
+
+	journal := ""
+	if len(entries) > 1 {
+		for i := range entries {
+			if !Exists(entries[i].Name) {
+				continue
+			}
+			backup := fmt.Sprintf("%s.bak%d", entries[i].Name, timeNano)
+			if backupDir != "" {
+				backup = filepath.Join(backupDir, backupName(entries[i].Name, timeNano))
+			}
+			if err := backupFile(entries[i].Name, backup); err != nil {
+				removeTemps(entries)
+				return fmt.Errorf("unable to back up file %s, error: %v", entries[i].Name, err)
+			}
+			entries[i].Backup = backup
+		}
+		if backupDir != "" {
+			journal = filepath.Join(backupDir, fmt.Sprintf("%s%d", journalPrefix, timeNano))
+			if err := writeJournal(journal, entries); err != nil {
+				os.Remove(journal)
+				removeTemps(entries)
+				return fmt.Errorf("unable to write journal %s, error: %v", journal, err)
+			}
+		}
+	}
+
+	for i, e := range entries {
+		err := os.Rename(e.Temp, e.Name)
+		if err == nil {
+			err = syncDir(filepath.Dir(e.Name))
+		}
+		if err != nil {
+			log.Printf("unable to rename file %s to %s, error: %v\n", e.Temp, e.Name, err)
+			if len(entries) > 1 && restoreFiles(entries[:i+1]) == nil {
+				removeBackups(entries)
+			}
+			removeTemps(entries)
+			removeJournal(journal)
+			return fmt.Errorf("unable to replace file %s, error: %v", e.Name, err)
+		}
+	}
+	removeJournal(journal)
+	removeBackups(entries)
+	return nil
+}
+
+// backupName returns the name of the backup file in the backup directory.
+// The name includes a hash of the full path so files with the same name in
+// different directories, and the transaction time so concurrent transactions
+// sharing the backup directory, never use the same backup file.
+func backupName(name string, timeNano int64) string {
+	hash := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("%s.%s.bak%d", filepath.Base(name), hex.EncodeToString(hash[:8]), timeNano)
+}
+
+// RecoverFiles restores the files of the transactions recorded in the backup
+// directory that were interrupted before they were complete. It must be
+// called before any new transactions are started with the backup directory.
+func RecoverFiles(backupDir string) error {
+	if backupDir == "" {
+		return nil
+	}
+	journals, err := filepath.Glob(filepath.Join(backupDir, journalPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, journal := range journals {
+		data, err := os.ReadFile(journal)
+		if err != nil {
+			return err
+		}
+		var entries []journalEntry
+		// the journal is only complete once all the backups are
+		// taken and none of the files are replaced before that
+		if err = json.Unmarshal(data, &entries); err != nil {
+			log.Printf("discarding incomplete journal %s, error: %v\n", journal, err)
+		} else {
+			log.Printf("restoring files from interrupted transaction %s...\n", journal)
+			if err = restoreFiles(entries); err != nil {
+				return err
+			}
+			removeTemps(entries)
+			removeBackups(entries)
+		}
+		removeJournal(journal)
+	}
+	// remove the backups of the transactions that were interrupted
+	// before their journal was written
+	backups, err := filepath.Glob(filepath.Join(backupDir, "*.bak[0-9]*"))
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups {
+		os.Remove(backup)
+	}
+	return nil
+}
+
+// restoreFiles restores the files from their backups and removes the files
+// that did not exist before the transaction
+func restoreFiles(entries []journalEntry) error {
+	var lastErr error
+	for _, e := range entries {
+		var err error
+		if e.Backup == "" {
+			if err = os.Remove(e.Name); os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			var data []byte
+			if data, err = os.ReadFile(e.Backup); err == nil {
+				temp := fmt.Sprintf("%s.tmp%d", e.Name, time.Now().UnixNano())
+				if err = writeSynced(temp, data, e.Perm, e.Uid, e.Gid); err == nil {
+					err = os.Rename(temp, e.Name)
+				}
+				if err != nil {
+					os.Remove(temp)
+				}
+			}
+		}
+		if err == nil {
+			err = syncDir(filepath.Dir(e.Name))
+		}
+		if err != nil {
+			log.Printf("unable to restore file %s, error: %v\n", e.Name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func removeTemps(entries []journalEntry) {
+	for _, e := range entries {
+		os.Remove(e.Temp)
+	}
+}
+
+func removeBackups(entries []journalEntry) {
+	for _, e := range entries {
+		if e.Backup != "" {
+			os.Remove(e.Backup)
+		}
+	}
+}
+
+func removeJournal(journal string) {
+	if journal == "" {
+		return
+	}
+	if err := os.Remove(journal); err != nil && !os.IsNotExist(err) {
+		log.Printf("unable to remove journal %s, error: %v\n", journal, err)
+		return
+	}
+	syncDir(filepath.Dir(journal))
+}
+
+func writeJournal(journal string, entries []journalEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err = writeSynced(journal, data, 0600, -1, -1); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(journal))
+}
+
+// backupFile copies the file to the backup file with the same permissions
+func backupFile(name, backup string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	temp := fmt.Sprintf("%s.tmp%d", backup, time.Now().UnixNano())
+	if err = writeSynced(temp, data, info.Mode().Perm(), -1, -1); err == nil {
+		err = os.Rename(temp, backup)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return syncDir(filepath.Dir(backup))
+}
+
+// writeSynced writes the file and syncs its contents to disk before
+// setting the requested permissions and ownership
+func writeSynced(name string, data []byte, perm os.FileMode, uid, gid int) error {
+	if err := syncWrite(name, data, perm); err != nil {
+		return err
+	}
+	if err := os.Chmod(name, perm); err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		return os.Chown(name, uid, gid)
+	}
+	return nil
+}
+
+// syncDir syncs the directory so the renamed and removed entries are
+// persisted. Directories cannot be synced on windows.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err1 := d.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package futil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertContents(t *testing.T, name, contents string) {
+	data, err := os.ReadFile(name)
+	require.Nil(t, err)
+	assert.Equal(t, contents, string(data))
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	temps, err := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	require.Nil(t, err)
+	assert.Empty(t, temps)
+	journals, err := filepath.Glob(filepath.Join(dir, journalPrefix+"*"))
+	require.Nil(t, err)
+	assert.Empty(t, journals)
+	backups, err := filepath.Glob(filepath.Join(dir, "*.bak*"))
+	require.Nil(t, err)
+	assert.Empty(t, backups)
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "backup")
+	require.Nil(t, os.Mkdir(backupDir, 0700))
+	keyFile := filepath.Join(dir, "sports.api.key.pem")
+	certFile := filepath.Join(dir, "sports.api.cert.pem")
+
+	files := []File{
+		{Name: keyFile, Data: []byte("key-1"), Perm: 0400, Uid: -1, Gid: -1},
+		{Name: certFile, Data: []byte("cert-1"), Perm: 0444, Uid: -1, Gid: -1},
+	}
+	require.Nil(t, WriteFiles(files, backupDir, nil))
+	assertContents(t, keyFile, "key-1")
+	assertContents(t, certFile, "cert-1")
+	info, err := os.Stat(keyFile)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0400), info.Mode().Perm())
+
+	files[0].Data = []byte("key-2")
+	files[1].Data = []byte("cert-2")
+	err = WriteFiles(files, backupDir, func(staged map[string]string) error {
+		assertContents(t, staged[keyFile], "key-2")
+		assertContents(t, staged[certFile], "cert-2")
+		return nil
+	})
+	require.Nil(t, err)
+	assertContents(t, keyFile, "key-2")
+	assertContents(t, certFile, "cert-2")
+	// the backups are only kept until the transaction is complete
+	assertNoTempFiles(t, dir)
+	assertNoTempFiles(t, backupDir)
+}
+
+func TestWriteFilesSameBaseName(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "backup")
+	require.Nil(t, os.Mkdir(backupDir, 0700))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "app1"), 0700))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "app2"), 0700))
+	app1File := filepath.Join(dir, "app1", "keystore.p12")
+	app2File := filepath.Join(dir, "app2", "keystore.p12")
+	newFile := filepath.Join(dir, "cert.pem")
+	require.Nil(t, os.WriteFile(app1File, []byte("app1-1"), 0400))
+	require.Nil(t, os.WriteFile(app2File, []byte("app2-1"), 0400))
+
+	files := []File{
+		{Name: app1File, Data: []byte("app1-2"), Perm: 0400, Uid: -1, Gid: -1},
+		{Name: app2File, Data: []byte("app2-2"), Perm: 0400, Uid: -1, Gid: -1},
+		{Name: newFile, Data: []byte("cert-2"), Perm: 0444, Uid: -1, Gid: -1},
+	}
+	// both files are replaced before the last rename fails so each
+	// must be restored from its own backup
+	err := WriteFiles(files, backupDir, func(staged map[string]string) error {
+		return os.Remove(staged[newFile])
+	})
+	assert.NotNil(t, err)
+	assertContents(t, app1File, "app1-1")
+	assertContents(t, app2File, "app2-1")
+	assertNoTempFiles(t, backupDir)
+
+	assert.NotEqual(t, backupName(app1File, 1), backupName(app2File, 1))
+	assert.NotEqual(t, backupName(app1File, 1), backupName(app1File, 2))
+}
+
+func TestWriteFilesVerifyFailure(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	require.Nil(t, os.WriteFile(keyFile, []byte("key-1"), 0400))
+	require.Nil(t, os.WriteFile(certFile, []byte("cert-1"), 0444))
+
+	files := []File{
+		{Name: keyFile, Data: []byte("key-2"), Perm: 0400, Uid: -1, Gid: -1},
+		{Name: certFile, Data: []byte("cert-2"), Perm: 0444, Uid: -1, Gid: -1},
+	}
+	err := WriteFiles(files, "", func(staged map[string]string) error {
+		return os.ErrInvalid
+	})
+	assert.Equal(t, os.ErrInvalid, err)
+	assertContents(t, keyFile, "key-1")
+	assertContents(t, certFile, "cert-1")
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteFilesRollback(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "backup")
+	require.Nil(t, os.Mkdir(backupDir, 0700))
+	keyFile := filepath.Join(dir, "key.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	require.Nil(t, os.WriteFile(keyFile, []byte("key-1"), 0400))
+
+	files := []File{
+		{Name: keyFile, Data: []byte("key-2"), Perm: 0400, Uid: -1, Gid: -1},
+		{Name: certFile, Data: []byte("cert-2"), Perm: 0444, Uid: -1, Gid: -1},
+	}
+	// removing the staged certificate makes its rename fail after
+	// the key file has already been replaced
+	err := WriteFiles(files, backupDir, func(staged map[string]string) error {
+		return os.Remove(staged[certFile])
+	})
+	assert.NotNil(t, err)
+	assertContents(t, keyFile, "key-1")
+	assert.NoFileExists(t, certFile)
+	assertNoTempFiles(t, dir)
+	assertNoTempFiles(t, backupDir)
+}
+
+func TestWriteFilesWithoutBackupDir(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	require.Nil(t, os.WriteFile(keyFile, []byte("key-1"), 0400))
+	require.Nil(t, os.WriteFile(certFile, []byte("cert-1"), 0444))
+
+	files := []File{
+		{Name: keyFile, Data: []byte("key-2"), Perm: 0400, Uid: -1, Gid: -1},
+		{Name: certFile, Data: []byte("cert-2"), Perm: 0444, Uid: -1, Gid: -1},
+	}
+	require.Nil(t, WriteFiles(files, "", nil))
+	assertContents(t, keyFile, "key-2")
+	assertContents(t, certFile, "cert-2")
+	backups, err := filepath.Glob(filepath.Join(dir, "*.bak*"))
+	require.Nil(t, err)
+	assert.Empty(t, backups)
+	assertNoTempFiles(t, dir)
+
+	assert.NotNil(t, WriteFiles([]File{{Name: filepath.Join(dir, "missing", "key.pem"), Data: []byte("key"), Perm: 0400, Uid: -1, Gid: -1}}, "", nil))
+}
+
+func TestRecoverFiles(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "backup")
+	require.Nil(t, os.Mkdir(backupDir, 0700))
+	keyFile := filepath.Join(dir, "key.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+
+	// the key was replaced and the new certificate was created
+	// but the journal was not removed before the process exited
+	require.Nil(t, os.WriteFile(keyFile, []byte("key-2"), 0400))
+	require.Nil(t, os.WriteFile(certFile, []byte("cert-2"), 0444))
+	require.Nil(t, os.WriteFile(certFile+".tmp1", []byte("cert-2"), 0444))
+	require.Nil(t, os.WriteFile(filepath.Join(backupDir, "key.pem"), []byte("key-1"), 0400))
+	entries := []journalEntry{
+		{Name: keyFile, Temp: keyFile + ".tmp1", Backup: filepath.Join(backupDir, "key.pem"), Perm: 0400, Uid: -1, Gid: -1},
+		{Name: certFile, Temp: certFile + ".tmp1", Perm: 0444, Uid: -1, Gid: -1},
+	}
+	require.Nil(t, writeJournal(filepath.Join(backupDir, journalPrefix+"1"), entries))
+	// incomplete journals are discarded along with their backups
+	require.Nil(t, os.WriteFile(filepath.Join(backupDir, journalPrefix+"2"), []byte(`[{"name":`), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(backupDir, backupName(certFile, 2)), []byte("cert-0"), 0400))
+
+	require.Nil(t, RecoverFiles(backupDir))
+	assertContents(t, keyFile, "key-1")
+	assert.NoFileExists(t, certFile)
+	assertNoTempFiles(t, dir)
+	assertNoTempFiles(t, backupDir)
+
+	require.Nil(t, RecoverFiles(backupDir))
+	require.Nil(t, RecoverFiles(""))
+}
@@ -0,0 +1,55 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package attestation
+
+import (
+	"crypto"
+	"encoding/json"
+
+	"github.com/AthenZ/athenz/libs/go/sia/gcp/meta"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+)
+
+type AttestationData struct {
+	IdentityToken string `json:"identityToken,omitempty"` //the instance identity token signed by google
+}
+
+// Provider implements the host provider interface for the gcp platforms
+// (GCE and GKE). The attestation data includes the identity token for the
+// default service account obtained from the metadata server.
+type Provider struct {
+	provider.Base
+	MetaEndPoint string //metadata server endpoint
+	Audience     string //audience for the identity token, typically the ZTS url
+}
+
+// AttestationData fetches the identity token for the configured audience
+// and returns the json encoded attestation data for the ZTS api
+func (p Provider) AttestationData(principal string, key crypto.PrivateKey, sigInfo *signature.SignatureInfo) (string, error) {
+	token, err := meta.GetIdentityToken(p.MetaEndPoint, p.Audience)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&AttestationData{
+		IdentityToken: token,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,56 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package attestation
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttestationData(test *testing.T) {
+	metaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, "jwt-"+r.URL.Query().Get("audience"))
+	}))
+	defer metaServer.Close()
+
+	p := Provider{
+		Base:         provider.Base{Name: "athenz.gcp.us-west1", InstanceId: "1234"},
+		MetaEndPoint: metaServer.URL,
+		Audience:     "https://zts.athenz.io:4443/zts/v1",
+	}
+	data, err := p.AttestationData("sports.api", nil, nil)
+	require.Nil(test, err)
+
+	var attestData AttestationData
+	require.Nil(test, json.Unmarshal([]byte(data), &attestData))
+	assert.Equal(test, "jwt-https://zts.athenz.io:4443/zts/v1", attestData.IdentityToken)
+
+	p.MetaEndPoint = "http://127.0.0.1:1"
+	_, err = p.AttestationData("sports.api", nil, nil)
+	assert.NotNil(test, err)
+}
@@ -0,0 +1,159 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package meta
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DomainAttribute is the instance or project metadata attribute
+// that specifies the Athenz domain for the service identity
+const DomainAttribute = "athenz-domain"
+
+// GetData makes a http call to the local metadata server and returns the metadata value as bytes
+func GetData(base, path string) ([]byte, error) {
+	c := &http.Client{}
+	c.Timeout = 5 * time.Second
+	req, err := http.NewRequest("GET", base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == 200 {
+		return body, nil
+	}
+	return nil, fmt.Errorf("cannot get metadata, path: %q, status code is %d", path, res.StatusCode)
+}
+
+func getValue(base, path string) (string, error) {
+	data, err := GetData(base, path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("empty metadata value, path: %q", path)
+	}
+	return value, nil
+}
+
+// GetProject returns the project id of the instance
+func GetProject(base string) (string, error) {
+	return getValue(base, "/computeMetadata/v1/project/project-id")
+}
+
+// GetInstanceId returns the unique numeric id of the instance
+func GetInstanceId(base string) (string, error) {
+	return getValue(base, "/computeMetadata/v1/instance/id")
+}
+
+// GetInstanceName returns the name of the instance
+func GetInstanceName(base string) (string, error) {
+	return getValue(base, "/computeMetadata/v1/instance/name")
+}
+
+// GetZone returns the zone name of the instance e.g. us-west1-a
+func GetZone(base string) (string, error) {
+	zone, err := getValue(base, "/computeMetadata/v1/instance/zone")
+	if err != nil {
+		return "", err
+	}
+	// the value is returned as projects/<project-number>/zones/<zone>
+	return zone[strings.LastIndex(zone, "/")+1:], nil
+}
+
+// GetRegion returns the region name of the instance based on its zone
+func GetRegion(base string) string {
+	zone, err := GetZone(base)
+	if err != nil {
+		log.Printf("Unable to determine zone from metadata: %v\n", err)
+		log.Println("No region information available. Defaulting to us-west1")
+		return "us-west1"
+	}
+	return zoneToRegion(zone)
+}
+
+func zoneToRegion(zone string) string {
+	idx := strings.LastIndex(zone, "-")
+	if idx <= 0 {
+		return zone
+	}
+	return zone[:idx]
+}
+
+// GetPrivateIp returns the ip address of the first network interface
+func GetPrivateIp(base string) (string, error) {
+	return getValue(base, "/computeMetadata/v1/instance/network-interfaces/0/ip")
+}
+
+// GetServiceAccount returns the email of the default service account
+func GetServiceAccount(base string) (string, error) {
+	return getValue(base, "/computeMetadata/v1/instance/service-accounts/default/email")
+}
+
+// GetIdentityToken returns the signed instance identity token for the
+// default service account with the given audience. The full format
+// includes the project and instance details in the token claims.
+func GetIdentityToken(base, audience string) (string, error) {
+	path := fmt.Sprintf("/computeMetadata/v1/instance/service-accounts/default/identity?audience=%s&format=full", url.QueryEscape(audience))
+	return getValue(base, path)
+}
+
+// GetAttribute returns the value of the given custom metadata attribute.
+// The instance attributes are checked first followed by the project ones.
+func GetAttribute(base, name string) (string, error) {
+	value, err := getValue(base, "/computeMetadata/v1/instance/attributes/"+name)
+	if err == nil {
+		return value, nil
+	}
+	return getValue(base, "/computeMetadata/v1/project/attributes/"+name)
+}
+
+// GetDomainService returns the Athenz domain and service for the instance.
+// The domain is specified by the athenz-domain metadata attribute and the
+// service is the account id of the default service account, so the service
+// account sports-api@project.iam.gserviceaccount.com maps to service sports-api.
+func GetDomainService(base string) (string, string, error) {
+	domain, err := GetAttribute(base, DomainAttribute)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to determine domain from %s attribute: %v", DomainAttribute, err)
+	}
+	email, err := GetServiceAccount(base)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to determine service account: %v", err)
+	}
+	idx := strings.Index(email, "@")
+	if idx <= 0 {
+		return "", "", fmt.Errorf("invalid service account email: %s", email)
+	}
+	return domain, email[:idx], nil
+}
@@ -0,0 +1,174 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package meta
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/dimfeld/httptreemux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testServer struct {
+	listener net.Listener
+	addr     string
+}
+
+func (t *testServer) start(h http.Handler) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Panicln("Unable to serve on randomly assigned port")
+	}
+	s := &http.Server{Handler: h}
+	t.listener = listener
+	t.addr = listener.Addr().String()
+
+	go func() {
+		s.Serve(listener)
+	}()
+}
+
+func (t *testServer) stop() {
+	t.listener.Close()
+}
+
+func (t *testServer) httpUrl() string {
+	return fmt.Sprintf("http://%s", t.addr)
+}
+
+func startMetaServer(values map[string]string) *testServer {
+	router := httptreemux.New()
+	for path, value := range values {
+		value := value
+		router.GET(path, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			io.WriteString(w, value)
+		})
+	}
+	metaServer := &testServer{}
+	metaServer.start(router)
+	return metaServer
+}
+
+func TestGetData(test *testing.T) {
+	metaServer := startMetaServer(map[string]string{
+		"/computeMetadata/v1/project/project-id": "athenz-project\n",
+		"/computeMetadata/v1/instance/id":        "1234567890123456789",
+		"/computeMetadata/v1/instance/name":      "athenz-vm",
+		"/computeMetadata/v1/instance/zone":      "projects/1234/zones/us-west1-b",
+	})
+	defer metaServer.stop()
+
+	project, err := GetProject(metaServer.httpUrl())
+	require.Nil(test, err)
+	assert.Equal(test, "athenz-project", project)
+
+	instanceId, err := GetInstanceId(metaServer.httpUrl())
+	require.Nil(test, err)
+	assert.Equal(test, "1234567890123456789", instanceId)
+
+	name, err := GetInstanceName(metaServer.httpUrl())
+	require.Nil(test, err)
+	assert.Equal(test, "athenz-vm", name)
+
+	zone, err := GetZone(metaServer.httpUrl())
+	require.Nil(test, err)
+	assert.Equal(test, "us-west1-b", zone)
+	assert.Equal(test, "us-west1", GetRegion(metaServer.httpUrl()))
+
+	_, err = GetData(metaServer.httpUrl(), "/computeMetadata/v1/instance/unknown")
+	assert.NotNil(test, err)
+}
+
+func TestGetRegionDefault(test *testing.T) {
+	metaServer := startMetaServer(map[string]string{})
+	defer metaServer.stop()
+
+	assert.Equal(test, "us-west1", GetRegion(metaServer.httpUrl()))
+	assert.Equal(test, "europe-west4", zoneToRegion("europe-west4-a"))
+	assert.Equal(test, "local", zoneToRegion("local"))
+}
+
+func TestGetIdentityToken(test *testing.T) {
+	router := httptreemux.New()
+	router.GET("/computeMetadata/v1/instance/service-accounts/default/identity", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if r.URL.Query().Get("format") != "full" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, "token-for-"+r.URL.Query().Get("audience"))
+	})
+	metaServer := &testServer{}
+	metaServer.start(router)
+	defer metaServer.stop()
+
+	token, err := GetIdentityToken(metaServer.httpUrl(), "https://zts.athenz.io:4443/zts/v1")
+	require.Nil(test, err)
+	assert.Equal(test, "token-for-https://zts.athenz.io:4443/zts/v1", token)
+}
+
+func TestGetDomainService(test *testing.T) {
+	metaServer := startMetaServer(map[string]string{
+		"/computeMetadata/v1/project/attributes/athenz-domain":        "sports",
+		"/computeMetadata/v1/instance/service-accounts/default/email": "api@athenz-project.iam.gserviceaccount.com",
+	})
+	defer metaServer.stop()
+
+	domain, service, err := GetDomainService(metaServer.httpUrl())
+	require.Nil(test, err)
+	assert.Equal(test, "sports", domain)
+	assert.Equal(test, "api", service)
+
+	// instance attribute takes precedence over the project one
+	instanceServer := startMetaServer(map[string]string{
+		"/computeMetadata/v1/instance/attributes/athenz-domain":       "sports.prod",
+		"/computeMetadata/v1/project/attributes/athenz-domain":        "sports",
+		"/computeMetadata/v1/instance/service-accounts/default/email": "api@athenz-project.iam.gserviceaccount.com",
+	})
+	defer instanceServer.stop()
+
+	domain, service, err = GetDomainService(instanceServer.httpUrl())
+	require.Nil(test, err)
+	assert.Equal(test, "sports.prod", domain)
+	assert.Equal(test, "api", service)
+}
+
+func TestGetDomainServiceFailures(test *testing.T) {
+	noDomainServer := startMetaServer(map[string]string{
+		"/computeMetadata/v1/instance/service-accounts/default/email": "api@athenz-project.iam.gserviceaccount.com",
+	})
+	defer noDomainServer.stop()
+	_, _, err := GetDomainService(noDomainServer.httpUrl())
+	assert.NotNil(test, err)
+
+	invalidEmailServer := startMetaServer(map[string]string{
+		"/computeMetadata/v1/project/attributes/athenz-domain":        "sports",
+		"/computeMetadata/v1/instance/service-accounts/default/email": "default",
+	})
+	defer invalidEmailServer.stop()
+	_, _, err = GetDomainService(invalidEmailServer.httpUrl())
+	assert.NotNil(test, err)
+}
@@ -0,0 +1,231 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_TIMEOUT = 30 // 30 secs
+
+// Hook represents an action executed after a certificate or token
+// has been updated so that the applications using it can reload it.
+// Exactly one of the command, pid file or url attributes must be set.
+type Hook struct {
+	Command []string `json:"command,omitempty"`  //command and its arguments to execute
+	PidFile string   `json:"pid_file,omitempty"` //file containing the pid of the process to signal
+	Signal  string   `json:"signal,omitempty"`   //signal to send to the process, default SIGHUP
+	Url     string   `json:"url,omitempty"`      //local http url to post the update notification to
+	Timeout int      `json:"timeout,omitempty"`  //timeout in seconds for the command or http request, default 30 secs
+}
+
+// Event is the payload posted to the hook url
+type Event struct {
+	Name string `json:"name"` //name of the service, role or token that was updated
+	File string `json:"file"` //path of the updated file
+}
+
+// Validate verifies that the hook is correctly configured
+func (h Hook) Validate() error {
+	count := 0
+	if len(h.Command) != 0 {
+		count++
+	}
+	if h.PidFile != "" {
+		count++
+	}
+	if h.Url != "" {
+		count++
+	}
+	if count != 1 {
+		return fmt.Errorf("hook must specify exactly one of command, pid_file or url")
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("invalid hook timeout: %d", h.Timeout)
+	}
+	if h.Signal != "" && h.PidFile == "" {
+		return fmt.Errorf("hook signal %s specified without pid_file", h.Signal)
+	}
+	if h.PidFile != "" {
+		if _, err := parseSignal(h.Signal); err != nil {
+			return err
+		}
+	}
+	if h.Url != "" {
+		return validateLocalUrl(h.Url)
+	}
+	return nil
+}
+
+// ValidateAll verifies that all the given hooks are correctly configured
+func ValidateAll(hooks []Hook) error {
+	for _, h := range hooks {
+		if err := h.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h Hook) String() string {
+	switch {
+	case len(h.Command) != 0:
+		return fmt.Sprintf("command %q", strings.Join(h.Command, " "))
+	case h.PidFile != "":
+		return fmt.Sprintf("signal %s to pid in %s", h.signalName(), h.PidFile)
+	default:
+		return fmt.Sprintf("post to %s", h.Url)
+	}
+}
+
+// RunOnChange executes the given hooks if the contents of the file are
+// different from the given previous contents of the file
+func RunOnChange(name, file string, prevContents []byte, hooks []Hook) {
+	if len(hooks) == 0 {
+		return
+	}
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		log.Printf("unable to read %s to check for changes, skipping hooks for %s: %v\n", file, name, err)
+		return
+	}
+	if bytes.Equal(contents, prevContents) {
+		log.Printf("%s has not changed, skipping hooks for %s\n", file, name)
+		return
+	}
+	Run(name, file, hooks)
+}
+
+// Run executes the given hooks and logs the result of each hook
+func Run(name, file string, hooks []Hook) {
+	for _, h := range hooks {
+		err := h.run(name, file)
+		if err != nil {
+			log.Printf("hook %s for %s failed: %v\n", h, name, err)
+		} else {
+			log.Printf("hook %s for %s completed successfully\n", h, name)
+		}
+	}
+}
+
+func (h Hook) run(name, file string) error {
+	timeout := time.Duration(h.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = DEFAULT_TIMEOUT * time.Second
+	}
+	switch {
+	case len(h.Command) != 0:
+		return h.runCommand(name, file, timeout)
+	case h.PidFile != "":
+		return h.sendSignal()
+	default:
+		return h.post(name, file, timeout)
+	}
+}
+
+func (h Hook) runCommand(name, file string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(), "ATHENZ_SIA_HOOK_NAME="+name, "ATHENZ_SIA_HOOK_FILE="+file)
+	output, err := cmd.CombinedOutput()
+	if len(output) != 0 {
+		log.Printf("hook %s output: %s\n", h, strings.TrimSpace(string(output)))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+func (h Hook) sendSignal() error {
+	sig, err := parseSignal(h.Signal)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(h.PidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid pid in %s", h.PidFile)
+	}
+	return kill(pid, sig)
+}
+
+func (h Hook) post(name, file string, timeout time.Duration) error {
+	body, err := json.Marshal(Event{Name: name, File: file})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(h.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (h Hook) signalName() string {
+	return signalName(h.Signal)
+}
+
+func signalName(name string) string {
+	if name == "" {
+		return "SIGHUP"
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return name
+}
+
+func validateLocalUrl(hookUrl string) error {
+	u, err := url.Parse(hookUrl)
+	if err != nil {
+		return fmt.Errorf("invalid hook url %s: %v", hookUrl, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("hook url %s must use http or https", hookUrl)
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("hook url %s must refer to a local address", hookUrl)
+}
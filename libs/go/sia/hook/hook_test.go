@@ -0,0 +1,140 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(test *testing.T) {
+	tests := []struct {
+		name  string
+		hook  Hook
+		valid bool
+	}{
+		{"command", Hook{Command: []string{"/bin/true"}}, true},
+		{"pidfile", Hook{PidFile: "/run/nginx.pid"}, true},
+		{"pidfile-signal", Hook{PidFile: "/run/nginx.pid", Signal: "usr1"}, true},
+		{"url", Hook{Url: "http://127.0.0.1:8080/reload"}, true},
+		{"url-localhost", Hook{Url: "https://localhost/reload"}, true},
+		{"url-ipv6", Hook{Url: "http://[::1]:8080/reload"}, true},
+		{"empty", Hook{}, false},
+		{"multiple", Hook{Command: []string{"/bin/true"}, Url: "http://127.0.0.1/reload"}, false},
+		{"unknown-signal", Hook{PidFile: "/run/nginx.pid", Signal: "SIGKILL"}, false},
+		{"signal-without-pidfile", Hook{Command: []string{"/bin/true"}, Signal: "SIGHUP"}, false},
+		{"remote-url", Hook{Url: "http://example.com/reload"}, false},
+		{"invalid-scheme", Hook{Url: "ftp://127.0.0.1/reload"}, false},
+		{"negative-timeout", Hook{Command: []string{"/bin/true"}, Timeout: -1}, false},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			err := tt.hook.Validate()
+			assert.Equal(t, tt.valid, err == nil, "unexpected result: %v", err)
+		})
+	}
+}
+
+func TestRunCommand(test *testing.T) {
+	dir := test.TempDir()
+	outFile := filepath.Join(dir, "out")
+	h := Hook{Command: []string{"/bin/sh", "-c", fmt.Sprintf("echo $ATHENZ_SIA_HOOK_NAME $ATHENZ_SIA_HOOK_FILE > %s", outFile)}}
+	require.Nil(test, h.run("api", "/var/lib/sia/certs/api.cert.pem"))
+	data, err := os.ReadFile(outFile)
+	require.Nil(test, err)
+	assert.Equal(test, "api /var/lib/sia/certs/api.cert.pem\n", string(data))
+
+	h = Hook{Command: []string{"/bin/sh", "-c", "exit 1"}}
+	assert.NotNil(test, h.run("api", ""))
+}
+
+func TestRunCommandTimeout(test *testing.T) {
+	h := Hook{Command: []string{"/bin/sleep", "10"}, Timeout: 1}
+	err := h.run("api", "")
+	require.NotNil(test, err)
+	assert.Contains(test, err.Error(), "timed out")
+}
+
+func TestSendSignal(test *testing.T) {
+	cmd := exec.Command("/bin/sleep", "30")
+	require.Nil(test, cmd.Start())
+	pidFile := filepath.Join(test.TempDir(), "sleep.pid")
+	require.Nil(test, os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0644))
+
+	h := Hook{PidFile: pidFile, Signal: "TERM"}
+	require.Nil(test, h.run("api", ""))
+	err := cmd.Wait()
+	assert.NotNil(test, err)
+
+	require.Nil(test, os.WriteFile(pidFile, []byte("invalid"), 0644))
+	assert.NotNil(test, h.run("api", ""))
+	h = Hook{PidFile: filepath.Join(test.TempDir(), "missing.pid")}
+	assert.NotNil(test, h.run("api", ""))
+}
+
+func TestPost(test *testing.T) {
+	var event Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/reload" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&event)
+	}))
+	defer server.Close()
+
+	h := Hook{Url: server.URL + "/reload"}
+	require.Nil(test, h.run("athenz:role.readers", "/var/lib/sia/certs/readers.cert.pem"))
+	assert.Equal(test, Event{Name: "athenz:role.readers", File: "/var/lib/sia/certs/readers.cert.pem"}, event)
+
+	h = Hook{Url: server.URL + "/unknown"}
+	assert.NotNil(test, h.run("api", ""))
+}
+
+func TestRunOnChange(test *testing.T) {
+	dir := test.TempDir()
+	certFile := filepath.Join(dir, "api.cert.pem")
+	outFile := filepath.Join(dir, "out")
+	require.Nil(test, os.WriteFile(certFile, []byte("cert-1"), 0644))
+	hooks := []Hook{{Command: []string{"/bin/sh", "-c", fmt.Sprintf("echo run >> %s", outFile)}}}
+
+	// contents not changed - hook must not be executed
+	RunOnChange("api", certFile, []byte("cert-1"), hooks)
+	_, err := os.Stat(outFile)
+	assert.True(test, os.IsNotExist(err))
+
+	// contents changed - hook must be executed
+	RunOnChange("api", certFile, []byte("cert-0"), hooks)
+	data, err := os.ReadFile(outFile)
+	require.Nil(test, err)
+	assert.Equal(test, "run\n", string(data))
+
+	// new file - hook must be executed
+	RunOnChange("api", certFile, nil, hooks)
+	data, err = os.ReadFile(outFile)
+	require.Nil(test, err)
+	assert.Equal(test, "run\nrun\n", string(data))
+}
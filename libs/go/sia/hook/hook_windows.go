@@ -0,0 +1,32 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package hook
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// parseSignal rejects all the signals since windows does not support
+// notifying processes with signals so pid_file hooks cannot be used
+func parseSignal(name string) (syscall.Signal, error) {
+	return 0, fmt.Errorf("hook signal %s is not supported on windows", signalName(name))
+}
+
+func kill(pid int, sig syscall.Signal) error {
+	return fmt.Errorf("unable to send signal %s to pid %d on windows", sig, pid)
+}
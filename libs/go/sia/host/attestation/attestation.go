@@ -0,0 +1,244 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/host/hostdoc"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// attestation modes supported for on-prem hosts
+const (
+	ModeBootstrapKey  = "key"   //proof signed by the pre-provisioned bootstrap key
+	ModeRegisterToken = "token" //instance register token obtained from ZTS
+)
+
+type AttestationData struct {
+	Document  string                   `json:"document,omitempty"`  //the host document
+	Signature *signature.SignatureInfo `json:"signature,omitempty"` //the host document signature, if available
+	Principal string                   `json:"principal,omitempty"` //the service identity requesting the certificate
+	Timestamp int64                    `json:"timestamp,omitempty"` //unix time when the attestation data was generated
+	KeyId     string                   `json:"keyId,omitempty"`     //id of the bootstrap key
+	Proof     string                   `json:"proof,omitempty"`     //base64 encoded signature of the proof payload by the bootstrap key
+	Token     string                   `json:"token,omitempty"`     //the instance register token issued by ZTS
+}
+
+// Provider implements the host provider interface for on-prem hosts. The
+// service identities and the ips of the host are listed in the host document
+// which is included in the attestation data along with either a proof signed
+// by the bootstrap key or an instance register token obtained from ZTS with
+// the bootstrap key and certificate.
+type Provider struct {
+	provider.Base
+	Doc               *hostdoc.Doc             //the host document
+	SigInfo           *signature.SignatureInfo //the host document signature, if available
+	Mode              string                   //attestation mode - key or token
+	BootstrapKeyFile  string                   //pre-provisioned bootstrap private key
+	BootstrapCertFile string                   //pre-provisioned bootstrap certificate, required for the token mode
+	BootstrapKeyId    string                   //id of the bootstrap key registered with the provider
+	ZTSUrl            string                   //ZTS url to request the instance register token from
+	ZTSServerName     string                   //ZTS server name for tls connections
+	ZTSCACertFile     string                   //ZTS CA certificate file
+	ExcludeIps        ip.Opts                  //host ips to exclude from the certificate
+}
+
+// AttestationData returns the json encoded attestation data for the given
+// principal. The key is the private key of the service identity and the
+// proof binds its public key to the host document.
+func (p Provider) AttestationData(principal string, key crypto.PrivateKey, sigInfo *signature.SignatureInfo) (string, error) {
+	if p.Doc == nil {
+		return "", fmt.Errorf("missing host document")
+	}
+	if sigInfo == nil {
+		sigInfo = p.SigInfo
+	}
+	data := &AttestationData{
+		Document:  string(p.Doc.Bytes),
+		Signature: sigInfo,
+		Principal: principal,
+		Timestamp: time.Now().Unix(),
+	}
+	switch p.Mode {
+	case ModeRegisterToken:
+		token, err := p.registerToken(principal)
+		if err != nil {
+			return "", err
+		}
+		data.Token = token
+	case ModeBootstrapKey, "":
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return "", fmt.Errorf("unsupported private key for principal %s", principal)
+		}
+		proof, err := p.proof(data, signer.Public())
+		if err != nil {
+			return "", err
+		}
+		data.KeyId = p.BootstrapKeyId
+		data.Proof = proof
+	default:
+		return "", fmt.Errorf("unsupported attestation mode: %s", p.Mode)
+	}
+	attestData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(attestData), nil
+}
+
+// proof signs the proof payload for the given public key with the bootstrap key
+func (p Provider) proof(data *AttestationData, publicKey crypto.PublicKey) (string, error) {
+	bootstrapKey, err := util.PrivateKeyFromFile(p.BootstrapKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read bootstrap key: %v", err)
+	}
+	digest, err := ProofDigest(data, publicKey)
+	if err != nil {
+		return "", err
+	}
+	// ed25519 keys sign the digest as the message
+	var signerOpts crypto.SignerOpts = crypto.SHA256
+	if _, ok := bootstrapKey.Public().(ed25519.PublicKey); ok {
+		signerOpts = crypto.Hash(0)
+	}
+	sig, err := bootstrapKey.Sign(rand.Reader, digest, signerOpts)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign attestation proof: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// registerToken requests an instance register token for the given principal
+// from ZTS authenticating with the bootstrap key and certificate
+func (p Provider) registerToken(principal string) (string, error) {
+	client, err := util.ZtsClient(p.ZTSUrl, p.ZTSServerName, p.BootstrapKeyFile, p.BootstrapCertFile, p.ZTSCACertFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to initialize ZTS client for %s, err: %v", p.ZTSUrl, err)
+	}
+	domain, service := util.SplitDomain(principal)
+	token, err := client.GetInstanceRegisterToken(zts.ServiceName(p.Name), zts.DomainName(domain), zts.SimpleName(service), zts.PathElement(p.InstanceId))
+	if err != nil {
+		return "", fmt.Errorf("unable to get instance register token for %s, err: %v", principal, err)
+	}
+	return string(token.AttestationData), nil
+}
+
+// ProofDigest returns the sha256 digest of the payload signed by the bootstrap
+// key. The payload binds the principal and the host document to the public key
+// of the requested certificate so the proof cannot be replayed with another key.
+func ProofDigest(data *AttestationData, publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal public key: %v", err)
+	}
+	docHash := sha256.Sum256([]byte(data.Document))
+	keyHash := sha256.Sum256(der)
+	payload := fmt.Sprintf("%s\n%d\n%s\n%s", data.Principal, data.Timestamp, hex.EncodeToString(docHash[:]), hex.EncodeToString(keyHash[:]))
+	digest := sha256.Sum256([]byte(payload))
+	return digest[:], nil
+}
+
+// VerifyProof verifies the proof included in the attestation data was signed
+// by the given bootstrap key for the public key of the requested certificate
+func VerifyProof(data *AttestationData, publicKey, bootstrapKey crypto.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(data.Proof)
+	if err != nil {
+		return fmt.Errorf("unable to decode attestation proof: %v", err)
+	}
+	digest, err := ProofDigest(data, publicKey)
+	if err != nil {
+		return err
+	}
+	valid := false
+	switch pub := bootstrapKey.(type) {
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(pub, digest, sig)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(pub, digest, sig)
+	default:
+		return fmt.Errorf("unsupported bootstrap key type: %T", bootstrapKey)
+	}
+	if !valid {
+		return fmt.Errorf("invalid attestation proof for principal %s", data.Principal)
+	}
+	return nil
+}
+
+// GetSanIp returns the ips of the host that are also listed in the host
+// document. When the document ips or the host ips are not given, the ones
+// from the host document and the network interfaces are used.
+func (p Provider) GetSanIp(docIp map[string]bool, ips []net.IP, opts ip.Opts) []net.IP {
+	if docIp == nil && p.Doc != nil {
+		docIp = p.Doc.Ip
+	}
+	if ips == nil {
+		var err error
+		ips, err = ip.GetIps()
+		if err != nil {
+			log.Printf("Unable to obtain host ips: %v\n", err)
+			return nil
+		}
+	}
+	if !opts.ExcludeAll && len(opts.ExcludeNets) == 0 {
+		opts = p.ExcludeIps
+	}
+	if opts.ExcludeAll {
+		return nil
+	}
+	sanIps := []net.IP{}
+	for _, i := range ips {
+		if !docIp[strings.ToLower(i.String())] {
+			continue
+		}
+		if excluded(i, opts.ExcludeNets) {
+			continue
+		}
+		sanIps = append(sanIps, i)
+	}
+	return sanIps
+}
+
+func excluded(i net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(i) {
+			return true
+		}
+	}
+	return false
+}
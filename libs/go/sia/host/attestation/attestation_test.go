@@ -0,0 +1,148 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package attestation
+
+import (
+	"crypto"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/hostdoc"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hostDocument = `{"domain":"sports","service":"api,backend","uuid":"7716086","ip":["10.196.66.191","2a00:1288:110:c30::1027"],"provider":"athenz.onprem"}`
+
+func newProvider(t *testing.T, keyType util.KeyType) (Provider, crypto.Signer) {
+	doc, _, err := hostdoc.NewPlainDoc([]byte(hostDocument))
+	require.Nil(t, err)
+
+	bootstrapKey, err := util.GenerateKey(keyType)
+	require.Nil(t, err)
+	keyFile := filepath.Join(t.TempDir(), "bootstrap.key.pem")
+	require.Nil(t, os.WriteFile(keyFile, []byte(util.PrivatePem(bootstrapKey)), 0400))
+
+	return Provider{
+		Base:             provider.Base{Name: "athenz.onprem", InstanceId: doc.Uuid},
+		Doc:              doc,
+		SigInfo:          &signature.SignatureInfo{Signature: "sig", Keyid: "cluster1"},
+		Mode:             ModeBootstrapKey,
+		BootstrapKeyFile: keyFile,
+		BootstrapKeyId:   "bootstrap-0",
+	}, bootstrapKey
+}
+
+func TestAttestationDataBootstrapKey(test *testing.T) {
+	for _, keyType := range []util.KeyType{util.RSA2048, util.ECDSAP256, util.Ed25519} {
+		p, bootstrapKey := newProvider(test, keyType)
+		key, err := util.GenerateKey(util.ECDSAP256)
+		require.Nil(test, err)
+
+		data, err := p.AttestationData("sports.api", key, nil)
+		require.Nil(test, err)
+
+		var attestData AttestationData
+		require.Nil(test, json.Unmarshal([]byte(data), &attestData))
+		assert.Equal(test, hostDocument, attestData.Document)
+		assert.Equal(test, "cluster1", attestData.Signature.Keyid)
+		assert.Equal(test, "sports.api", attestData.Principal)
+		assert.Equal(test, "bootstrap-0", attestData.KeyId)
+		assert.Nil(test, VerifyProof(&attestData, key.Public(), bootstrapKey.Public()))
+
+		// the proof is bound to the service key and the principal
+		otherKey, err := util.GenerateKey(util.ECDSAP256)
+		require.Nil(test, err)
+		assert.NotNil(test, VerifyProof(&attestData, otherKey.Public(), bootstrapKey.Public()))
+		attestData.Principal = "sports.backend"
+		assert.NotNil(test, VerifyProof(&attestData, key.Public(), bootstrapKey.Public()))
+	}
+}
+
+func TestAttestationDataErrors(test *testing.T) {
+	p, _ := newProvider(test, util.ECDSAP256)
+	_, err := p.AttestationData("sports.api", nil, nil)
+	assert.NotNil(test, err)
+
+	key, err := util.GenerateKey(util.ECDSAP256)
+	require.Nil(test, err)
+	p.BootstrapKeyFile = filepath.Join(test.TempDir(), "missing.key.pem")
+	_, err = p.AttestationData("sports.api", key, nil)
+	assert.NotNil(test, err)
+
+	p.Mode = "unknown"
+	_, err = p.AttestationData("sports.api", key, nil)
+	assert.NotNil(test, err)
+
+	p.Doc = nil
+	_, err = p.AttestationData("sports.api", key, nil)
+	assert.NotNil(test, err)
+}
+
+func TestAttestationDataRegisterToken(test *testing.T) {
+	ztsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/zts/v1/instance/athenz.onprem/sports/api/7716086/token" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"code":404,"message":"unknown instance"}`)
+			return
+		}
+		io.WriteString(w, `{"provider":"athenz.onprem","domain":"sports","service":"api","attestationData":"register-token"}`)
+	}))
+	defer ztsServer.Close()
+
+	p, _ := newProvider(test, util.ECDSAP256)
+	p.Mode = ModeRegisterToken
+	p.ZTSUrl = ztsServer.URL + "/zts/v1"
+
+	data, err := p.AttestationData("sports.api", nil, nil)
+	require.Nil(test, err)
+	var attestData AttestationData
+	require.Nil(test, json.Unmarshal([]byte(data), &attestData))
+	assert.Equal(test, "register-token", attestData.Token)
+	assert.Equal(test, "", attestData.Proof)
+
+	_, err = p.AttestationData("sports.backend", nil, nil)
+	assert.NotNil(test, err)
+}
+
+func TestGetSanIp(test *testing.T) {
+	p, _ := newProvider(test, util.ECDSAP256)
+	ips := []net.IP{net.ParseIP("10.196.66.191"), net.ParseIP("10.196.66.192"), net.ParseIP("2a00:1288:110:c30::1027")}
+
+	sanIps := p.GetSanIp(nil, ips, ip.Opts{})
+	require.Equal(test, 2, len(sanIps))
+	assert.Equal(test, "10.196.66.191", sanIps[0].String())
+	assert.Equal(test, "2a00:1288:110:c30::1027", sanIps[1].String())
+
+	_, excludeNet, _ := net.ParseCIDR("10.196.66.0/24")
+	p.ExcludeIps = ip.Opts{ExcludeNets: []*net.IPNet{excludeNet}}
+	sanIps = p.GetSanIp(nil, ips, ip.Opts{})
+	require.Equal(test, 1, len(sanIps))
+	assert.Equal(test, "2a00:1288:110:c30::1027", sanIps[0].String())
+
+	assert.Equal(test, 0, len(p.GetSanIp(nil, ips, ip.Opts{ExcludeAll: true})))
+}
@@ -0,0 +1,131 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package provider
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// Base implements the provider methods that are common to all platforms
+// supported by the sia agent. The certificate details follow the Athenz
+// conventions based on the provider name, the instance id and the dns
+// domains configured for the provider in ZTS. Platform providers embed
+// Base and implement the AttestationData method.
+type Base struct {
+	Name       string   //provider service name e.g. athenz.aws.us-west-2
+	InstanceId string   //instance, task or pod id assigned by the platform
+	Domains    []string //dns domains configured for the provider in ZTS
+	Hostname   string   //hostname of the instance, defaults to the os hostname
+}
+
+// PrepareKey reads the private key from the given file
+func (b Base) PrepareKey(keyFile string) (crypto.PrivateKey, error) {
+	return util.PrivateKeyFromFile(keyFile)
+}
+
+func (b Base) GetName() string {
+	return b.Name
+}
+
+func (b Base) GetHostname() string {
+	if b.Hostname != "" {
+		return b.Hostname
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Unable to extract instance hostname: %v\n", err)
+		return ""
+	}
+	return hostname
+}
+
+// GetCsrDn returns the provider name as the organizational unit
+func (b Base) GetCsrDn() pkix.Name {
+	return pkix.Name{
+		OrganizationalUnit: []string{b.Name},
+	}
+}
+
+// GetSanDns returns the <service>.<hyphenated-domain>.<dns-domain> entry for
+// every configured dns domain along with the wildcard and hostname entries
+// if requested and the given cnames
+func (b Base) GetSanDns(principal string, wildcard, includeHost bool, cnames []string) []string {
+	domain, service := util.SplitDomain(principal)
+	hyphenDomain := strings.Replace(domain, ".", "-", -1)
+	hosts := []string{}
+	for _, dnsDomain := range b.Domains {
+		hosts = append(hosts, fmt.Sprintf("%s.%s.%s", service, hyphenDomain, dnsDomain))
+		if wildcard {
+			hosts = append(hosts, fmt.Sprintf("*.%s.%s.%s", service, hyphenDomain, dnsDomain))
+		}
+	}
+	if includeHost {
+		if hostname := b.GetHostname(); hostname != "" {
+			hosts = append(hosts, hostname)
+		}
+	}
+	return append(hosts, cnames...)
+}
+
+// GetSanUri returns the spiffe uri for the principal followed by the
+// athenz://instanceid/<provider>/<instance-id> uri
+func (b Base) GetSanUri(principal string, opts ip.Opts) []*url.URL {
+	domain, service := util.SplitDomain(principal)
+	uris := []*url.URL{}
+	// spiffe uri must always be the first one
+	uris = util.AppendUri(uris, fmt.Sprintf("spiffe://%s/sa/%s", domain, service))
+	return util.AppendUri(uris, fmt.Sprintf("athenz://instanceid/%s/%s", b.Name, b.InstanceId))
+}
+
+// GetEmail returns the principal as the local part of an email address
+// in the first configured dns domain
+func (b Base) GetEmail(principal string) []string {
+	if len(b.Domains) == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s@%s", principal, b.Domains[0])}
+}
+
+// GetRoleDnsNames returns no san dns entries for role certificates
+func (b Base) GetRoleDnsNames(cert *x509.Certificate, principal string) []string {
+	return nil
+}
+
+// GetSanIp returns no san ip entries since the instance ips are not
+// verified by the cloud providers
+func (b Base) GetSanIp(docIp map[string]bool, ips []net.IP, opts ip.Opts) []net.IP {
+	return nil
+}
+
+// GetSuffix returns the first configured dns domain
+func (b Base) GetSuffix() string {
+	if len(b.Domains) == 0 {
+		return ""
+	}
+	return b.Domains[0]
+}
@@ -0,0 +1,85 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package provider
+
+import (
+	"os"
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseGetSanDns(t *testing.T) {
+	a := assert.New(t)
+
+	b := Base{Name: "athenz.aws.us-west-2", Domains: []string{"aws.athenz.cloud", "athenz.io"}, Hostname: "host1.athenz.io"}
+	a.Equal([]string{"api.sports-prod.aws.athenz.cloud", "api.sports-prod.athenz.io"}, b.GetSanDns("sports.prod.api", false, false, nil))
+	a.Equal([]string{
+		"api.sports.aws.athenz.cloud",
+		"*.api.sports.aws.athenz.cloud",
+		"api.sports.athenz.io",
+		"*.api.sports.athenz.io",
+		"host1.athenz.io",
+		"api.example.com",
+	}, b.GetSanDns("sports.api", true, true, []string{"api.example.com"}))
+}
+
+func TestBaseGetSanUri(t *testing.T) {
+	a := assert.New(t)
+
+	b := Base{Name: "athenz.aws.us-west-2", InstanceId: "i-0123"}
+	uris := b.GetSanUri("sports.api", ip.Opts{})
+	require.Equal(t, 2, len(uris))
+	a.Equal("spiffe://sports/sa/api", uris[0].String())
+	a.Equal("athenz://instanceid/athenz.aws.us-west-2/i-0123", uris[1].String())
+}
+
+func TestBaseDetails(t *testing.T) {
+	a := assert.New(t)
+
+	b := Base{Name: "athenz.aws.us-west-2", Domains: []string{"aws.athenz.cloud"}, Hostname: "host1.athenz.io"}
+	a.Equal("athenz.aws.us-west-2", b.GetName())
+	a.Equal("host1.athenz.io", b.GetHostname())
+	a.Equal([]string{"athenz.aws.us-west-2"}, b.GetCsrDn().OrganizationalUnit)
+	a.Equal([]string{"sports.api@aws.athenz.cloud"}, b.GetEmail("sports.api"))
+	a.Equal("aws.athenz.cloud", b.GetSuffix())
+	a.Nil(b.GetRoleDnsNames(nil, "sports.api"))
+	a.Nil(b.GetSanIp(nil, nil, ip.Opts{}))
+
+	empty := Base{}
+	a.Nil(empty.GetEmail("sports.api"))
+	a.Equal("", empty.GetSuffix())
+	a.NotEqual("", empty.GetHostname())
+}
+
+func TestBasePrepareKey(t *testing.T) {
+	b := Base{}
+	_, err := b.PrepareKey("invalid-file")
+	assert.NotNil(t, err)
+
+	key, err := util.GenerateKeyPair(2048)
+	require.Nil(t, err)
+	keyFile := t.TempDir() + "/key.pem"
+	require.Nil(t, os.WriteFile(keyFile, []byte(util.PrivatePem(key)), 0400))
+	signer, err := b.PrepareKey(keyFile)
+	require.Nil(t, err)
+	assert.NotNil(t, signer)
+}
@@ -29,13 +29,14 @@ import (
 
 // Provider is the interface which wraps various Providers known to ZTS
 // It has methods for providing attestationdata depending on provider type
-// and generating sub-parts of DN to be including in the CSR and San DNS and URI entries
+// and generating sub-parts of DN to be including in the CSR and San DNS and URI entries.
+// The principal arguments are the full service names in the <domain>.<service> format.
 type Provider interface {
 	// PrepareKey creates/setup up a private key for use with the provider
-	PrepareKey(string) (crypto.PrivateKey, error)
+	PrepareKey(keyFile string) (crypto.PrivateKey, error)
 
 	// AttestationData returns the attestation data that can be used in the ZTS api
-	AttestationData(string, crypto.PrivateKey, *signature.SignatureInfo) (string, error)
+	AttestationData(principal string, key crypto.PrivateKey, sigInfo *signature.SignatureInfo) (string, error)
 
 	// GetName returns the name of the current provider
 	GetName() string
@@ -47,19 +48,19 @@ type Provider interface {
 	GetCsrDn() pkix.Name
 
 	// GetSanDns returns an array of provider specific SAN DNS entries
-	GetSanDns(string, bool, bool, []string) []string
+	GetSanDns(principal string, wildcard, includeHost bool, cnames []string) []string
 
 	// GetSanUri returns an array of provider specific SAN URI entries
-	GetSanUri(string, ip.Opts) []*url.URL
+	GetSanUri(principal string, opts ip.Opts) []*url.URL
 
 	// GetEmail retuns an array of one email which can be used to identify the principal
-	GetEmail(string) []string
+	GetEmail(principal string) []string
 
 	// GetRoleDnsNames returns an array of SanDNS entries that can be used for Role Cert
-	GetRoleDnsNames(*x509.Certificate, string) []string
+	GetRoleDnsNames(cert *x509.Certificate, principal string) []string
 
 	// GetSanIp returns an array of IPs that can be included in San IPs from the list of IPs found on the box
-	GetSanIp(map[string]bool, []net.IP, ip.Opts) []net.IP
+	GetSanIp(docIp map[string]bool, ips []net.IP, opts ip.Opts) []net.IP
 
 	// GetSuffix returns the suffix for the current provider
 	GetSuffix() string
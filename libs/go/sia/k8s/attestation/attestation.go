@@ -0,0 +1,55 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package attestation
+
+import (
+	"crypto"
+	"encoding/json"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+	"github.com/AthenZ/athenz/libs/go/sia/k8s/podinfo"
+)
+
+type AttestationData struct {
+	Token string `json:"token,omitempty"` //the projected service account token
+}
+
+// Provider implements the host provider interface for kubernetes pods.
+// The attestation data includes the audience bound service account
+// token projected into the pod by the kubelet.
+type Provider struct {
+	provider.Base
+	TokenFile string //projected service account token file
+}
+
+// AttestationData reads the current service account token and returns
+// the json encoded attestation data for the ZTS api. The token is read
+// every time since the kubelet rotates it before it expires.
+func (p Provider) AttestationData(principal string, key crypto.PrivateKey, sigInfo *signature.SignatureInfo) (string, error) {
+	token, _, err := podinfo.ReadToken(p.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&AttestationData{
+		Token: token,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
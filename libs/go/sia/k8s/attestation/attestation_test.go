@@ -0,0 +1,58 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package attestation
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttestationData(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwt.StandardClaims{
+		Subject: "system:serviceaccount:sports:api",
+	}).SignedString(key)
+	require.Nil(t, err)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.Nil(t, os.WriteFile(tokenFile, []byte(token), 0600))
+
+	p := Provider{
+		Base:      provider.Base{Name: "athenz.k8s.cluster1", InstanceId: "pod-uid-1234"},
+		TokenFile: tokenFile,
+	}
+	data, err := p.AttestationData("sports.api", nil, nil)
+	require.Nil(t, err)
+
+	var attestData AttestationData
+	require.Nil(t, json.Unmarshal([]byte(data), &attestData))
+	assert.Equal(t, token, attestData.Token)
+
+	p.TokenFile = filepath.Join(t.TempDir(), "missing")
+	_, err = p.AttestationData("sports.api", nil, nil)
+	assert.NotNil(t, err)
+}
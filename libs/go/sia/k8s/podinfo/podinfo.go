@@ -0,0 +1,129 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package podinfo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Claims are the claims included in the projected service account tokens
+// issued by the kubernetes api server
+type Claims struct {
+	jwt.StandardClaims
+	Kubernetes KubernetesClaims `json:"kubernetes.io,omitempty"`
+}
+
+type KubernetesClaims struct {
+	Namespace      string `json:"namespace,omitempty"`
+	Pod            Ref    `json:"pod,omitempty"`
+	ServiceAccount Ref    `json:"serviceaccount,omitempty"`
+}
+
+type Ref struct {
+	Name string `json:"name,omitempty"`
+	Uid  string `json:"uid,omitempty"`
+}
+
+// Namespace returns the namespace of the pod
+func (c *Claims) Namespace() string {
+	return c.Kubernetes.Namespace
+}
+
+// ServiceAccount returns the name of the service account of the pod
+func (c *Claims) ServiceAccount() string {
+	return c.Kubernetes.ServiceAccount.Name
+}
+
+// PodUid returns the uid of the pod the token is bound to, if any
+func (c *Claims) PodUid() string {
+	return c.Kubernetes.Pod.Uid
+}
+
+// ReadToken reads the projected service account token from the given
+// file and returns the token along with its claims. The signature is
+// not verified since the token is validated by ZTS but we reject the
+// token if it has expired or does not identify a service account.
+func ReadToken(tokenFile string) (string, *Claims, error) {
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", nil, err
+	}
+	token := strings.TrimSpace(string(data))
+	claims, err := ParseToken(token)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid service account token %s: %v", tokenFile, err)
+	}
+	return token, claims, nil
+}
+
+// ParseToken parses the claims from the given service account token
+func ParseToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := claims.Valid(); err != nil {
+		return nil, err
+	}
+	// legacy tokens do not include the kubernetes.io claims so we need
+	// to extract the details from system:serviceaccount:<namespace>:<name>
+	if claims.Kubernetes.Namespace == "" || claims.Kubernetes.ServiceAccount.Name == "" {
+		comps := strings.Split(claims.Subject, ":")
+		if len(comps) != 4 || comps[0] != "system" || comps[1] != "serviceaccount" {
+			return nil, fmt.Errorf("token subject %q is not a service account", claims.Subject)
+		}
+		claims.Kubernetes.Namespace = comps[2]
+		claims.Kubernetes.ServiceAccount.Name = comps[3]
+	}
+	return claims, nil
+}
+
+// ReadAnnotations reads the pod annotations from the given file that
+// is projected by the downward api. Each line of the file has the
+// format key="value" with the value quoted as a go string.
+func ReadAnnotations(annotationsFile string) (map[string]string, error) {
+	data, err := os.ReadFile(annotationsFile)
+	if err != nil {
+		return nil, err
+	}
+	annotations := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid annotation line: %s", line)
+		}
+		value, err := strconv.Unquote(line[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation value: %s", line)
+		}
+		annotations[line[:idx]] = value
+	}
+	return annotations, scanner.Err()
+}
@@ -0,0 +1,136 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package podinfo
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signToken(t *testing.T, claims jwt.Claims) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	require.Nil(t, err)
+	return token
+}
+
+func TestParseToken(t *testing.T) {
+	a := assert.New(t)
+
+	token := signToken(t, &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  "https://zts.athenz.io",
+			Subject:   "system:serviceaccount:sports:api",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+		Kubernetes: KubernetesClaims{
+			Namespace:      "sports",
+			Pod:            Ref{Name: "api-7d9f", Uid: "pod-uid-1234"},
+			ServiceAccount: Ref{Name: "api", Uid: "sa-uid-1234"},
+		},
+	})
+	claims, err := ParseToken(token)
+	require.Nil(t, err)
+	a.Equal("sports", claims.Namespace())
+	a.Equal("api", claims.ServiceAccount())
+	a.Equal("pod-uid-1234", claims.PodUid())
+	a.Equal("https://zts.athenz.io", claims.Audience)
+}
+
+func TestParseTokenLegacy(t *testing.T) {
+	a := assert.New(t)
+
+	// legacy secret based tokens only include the subject
+	token := signToken(t, &jwt.StandardClaims{
+		Subject: "system:serviceaccount:sports:api",
+	})
+	claims, err := ParseToken(token)
+	require.Nil(t, err)
+	a.Equal("sports", claims.Namespace())
+	a.Equal("api", claims.ServiceAccount())
+	a.Equal("", claims.PodUid())
+}
+
+func TestParseTokenFailures(t *testing.T) {
+	_, err := ParseToken("invalid-token")
+	assert.NotNil(t, err)
+
+	expired := signToken(t, &jwt.StandardClaims{
+		Subject:   "system:serviceaccount:sports:api",
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	})
+	_, err = ParseToken(expired)
+	assert.NotNil(t, err)
+
+	user := signToken(t, &jwt.StandardClaims{
+		Subject: "system:node:node1",
+	})
+	_, err = ParseToken(user)
+	assert.NotNil(t, err)
+}
+
+func TestReadToken(t *testing.T) {
+	token := signToken(t, &jwt.StandardClaims{
+		Subject: "system:serviceaccount:sports:api",
+	})
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.Nil(t, os.WriteFile(tokenFile, []byte(token+"\n"), 0600))
+
+	readToken, claims, err := ReadToken(tokenFile)
+	require.Nil(t, err)
+	assert.Equal(t, token, readToken)
+	assert.Equal(t, "api", claims.ServiceAccount())
+
+	_, _, err = ReadToken(filepath.Join(t.TempDir(), "missing"))
+	assert.NotNil(t, err)
+}
+
+func TestReadAnnotations(t *testing.T) {
+	a := assert.New(t)
+
+	dir := t.TempDir()
+	annotationsFile := filepath.Join(dir, "annotations")
+	content := `athenz.io/domain="sports"
+athenz.io/service="api"
+kubernetes.io/config.seen="2023-01-10T10:00:00.000000000Z"
+description="multi\nline"
+`
+	require.Nil(t, os.WriteFile(annotationsFile, []byte(content), 0644))
+
+	annotations, err := ReadAnnotations(annotationsFile)
+	require.Nil(t, err)
+	a.Equal("sports", annotations["athenz.io/domain"])
+	a.Equal("api", annotations["athenz.io/service"])
+	a.Equal("multi\nline", annotations["description"])
+
+	invalidFile := filepath.Join(dir, "invalid")
+	require.Nil(t, os.WriteFile(invalidFile, []byte("athenz.io/domain=sports\n"), 0644))
+	_, err = ReadAnnotations(invalidFile)
+	a.NotNil(err)
+
+	_, err = ReadAnnotations(filepath.Join(dir, "missing"))
+	a.NotNil(err)
+}
@@ -0,0 +1,1370 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package options
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	ac "github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/aws/doc"
+	"github.com/AthenZ/athenz/libs/go/sia/aws/meta"
+	"github.com/AthenZ/athenz/libs/go/sia/aws/stssession"
+	gcpmeta "github.com/AthenZ/athenz/libs/go/sia/gcp/meta"
+	"github.com/AthenZ/athenz/libs/go/sia/hook"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/output"
+	"github.com/AthenZ/athenz/libs/go/sia/ssh/hostkey"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// package options contains types for parsing sia_config file and options to carry those config values
+
+// ConfigService represents a service to be specified by user, and specify User/Group attributes for the service
+type ConfigService struct {
+	Filename         string          `json:"filename,omitempty"`
+	User             string          `json:"user,omitempty"`
+	Group            string          `json:"group,omitempty"`
+	ExpiryTime       int             `json:"expiry_time,omitempty"`
+	SDSUdsUid        int             `json:"sds_uds_uid,omitempty"`
+	SDSNodeId        string          `json:"sds_node_id,omitempty"`
+	SDSNodeCluster   string          `json:"sds_node_cluster,omitempty"`
+	SDSUdsGids       []int           `json:"sds_uds_gids,omitempty"`       //uds connections must be from one of the given group ids
+	SDSUdsExe        []string        `json:"sds_uds_exe,omitempty"`        //uds connections must be from one of the given executable paths (glob patterns)
+	SDSUdsCgroups    []string        `json:"sds_uds_cgroups,omitempty"`    //uds connections must be from a process in one of the given cgroups (glob patterns)
+	SDSTcpPrincipals []string        `json:"sds_tcp_principals,omitempty"` //tcp connections must be authenticated with a certificate for one of the given principals
+	Threshold        float64         `json:"cert_threshold_to_check,omitempty"`
+	Hooks            []hook.Hook     `json:"hooks,omitempty"`   //hooks to execute after the service certificate is updated
+	Outputs          []output.Output `json:"outputs,omitempty"` //additional formats generated from the service key and certificate
+}
+
+// ConfigRole represents a role to be specified by user, and specify attributes for the role
+type ConfigRole struct {
+	Filename   string          `json:"filename,omitempty"`    //filename for the generated role certificate file
+	ExpiryTime int             `json:"expiry_time,omitempty"` //requested expiry time for the role certificate
+	Service    string          `json:"service,omitempty"`     //principal with role access
+	User       string          `json:"user,omitempty"`        //user owner on the role identity key
+	Group      string          `json:"group,omitempty"`       //group owner on the role identity key
+	Threshold  float64         `json:"cert_threshold_to_check,omitempty"`
+	Hooks      []hook.Hook     `json:"hooks,omitempty"`   //hooks to execute after the role certificate is updated
+	Outputs    []output.Output `json:"outputs,omitempty"` //additional formats generated from the role key and certificate
+}
+
+// ConfigCABundle represents a CA trust bundle served by the SDS server. The bundle
+// is either generated from the given list of files or fetched from ZTS
+type ConfigCABundle struct {
+	Files     []string `json:"files,omitempty"`      //list of CA certificate files included in the bundle
+	ZTSBundle string   `json:"zts_bundle,omitempty"` //name of the CA bundle to fetch from ZTS e.g. athenz, x509
+}
+
+// ConfigAWSCredentials represents the AWS temporary credentials to be fetched from ZTS
+type ConfigAWSCredentials struct {
+	Domain     string `json:"domain,omitempty"`      //athenz domain of the aws role - defaults to the service domain
+	Role       string `json:"role,omitempty"`        //aws role name to assume
+	Duration   int    `json:"duration,omitempty"`    //credentials duration in seconds
+	ExternalId string `json:"external_id,omitempty"` //external id required by the aws role trust policy
+	Service    string `json:"service,omitempty"`     //service identity used to fetch the credentials - defaults to the primary service
+}
+
+// ConfigAccount represents each of the accounts that can be specified in the config file
+type ConfigAccount struct {
+	Name         string                `json:"name,omitempty"`                       //name of the service identity
+	User         string                `json:"user,omitempty"`                       //the username to chown the cert/key dirs to. If absent, then root.
+	Group        string                `json:"group,omitempty"`                      //the group name to chown the cert/key dirs to. If absent, then athenz.
+	Domain       string                `json:"domain,omitempty"`                     //name of the domain for the identity
+	Account      string                `json:"account,omitempty"`                    //name of the account
+	Service      string                `json:"service,omitempty"`                    //name of the service for the identity
+	Zts          string                `json:"zts,omitempty"`                        //the ZTS to contact
+	Filename     string                `json:"filename,omitempty"`                   //filename to put the service certificate
+	Roles        map[string]ConfigRole `json:"roles,omitempty"`                      //map of roles to retrieve certificates for
+	Version      string                `json:"version,omitempty"`                    //sia version number
+	Threshold    float64               `json:"cert_threshold_to_check,omitempty"`    // Threshold to verify for all certs
+	SshThreshold float64               `json:"sshcert_threshold_to_check,omitempty"` // Threshold to verify for ssh certs
+}
+
+// Config represents entire sia_config file
+type Config struct {
+	Version                 string                          `json:"version,omitempty"`                    //name of the provider
+	Service                 string                          `json:"service,omitempty"`                    //name of the service for the identity
+	Services                map[string]ConfigService        `json:"services,omitempty"`                   //names of the multiple services for the identity
+	Ssh                     *bool                           `json:"ssh,omitempty"`                        //ssh certificate support
+	SshHostKeyType          hostkey.KeyType                 `json:"ssh_host_key_type,omitempty"`          //ssh host key type - rsa, ecdsa, etc
+	SshHostKeyTypes         []hostkey.KeyType               `json:"ssh_host_key_types,omitempty"`         //ssh host key types to request certificates for - all present keys by default
+	SshUserCABundle         string                          `json:"ssh_user_ca_bundle,omitempty"`         //zts bundle name with the ssh user ca public keys
+	SshTrustedUserCAKeys    string                          `json:"ssh_trusted_user_ca_keys,omitempty"`   //sshd TrustedUserCAKeys file to store the ssh user ca public keys
+	SanDnsWildcard          bool                            `json:"sandns_wildcard,omitempty"`            //san dns wildcard support
+	SanDnsHostname          bool                            `json:"sandns_hostname,omitempty"`            //san dns hostname support
+	UseRegionalSTS          bool                            `json:"regionalsts,omitempty"`                //whether to use a regional STS endpoint (default is false)
+	Accounts                []ConfigAccount                 `json:"accounts,omitempty"`                   //array of configured accounts
+	GenerateRoleKey         bool                            `json:"generate_role_key,omitempty"`          //private key to be generated for role certificate
+	RotateKey               bool                            `json:"rotate_key,omitempty"`                 //rotate private key support
+	User                    string                          `json:"user,omitempty"`                       //the user name to chown the cert/key dirs to. If absent, then root
+	Group                   string                          `json:"group,omitempty"`                      //the group name to chown the cert/key dirs to. If absent, then athenz
+	SDSUdsPath              string                          `json:"sds_uds_path,omitempty"`               //uds path if the agent should support uds connections
+	SDSUdsUid               int                             `json:"sds_uds_uid,omitempty"`                //uds connections must be from the given user uid
+	SDSTcpAddress           string                          `json:"sds_tcp_address,omitempty"`            //host:port if the agent should support tcp connections authenticated with mtls
+	ExpiryTime              int                             `json:"expiry_time,omitempty"`                //service and role certificate expiry in minutes
+	RefreshInterval         int                             `json:"refresh_interval,omitempty"`           //specifies refresh interval in minutes
+	ZTSRegion               string                          `json:"zts_region,omitempty"`                 //specifies zts region for the requests
+	DropPrivileges          bool                            `json:"drop_privileges,omitempty"`            //drop privileges to configured user instead of running as root
+	AccessTokens            map[string]ac.Role              `json:"access_tokens,omitempty"`              // map of role name to token attributes
+	IdTokens                map[string]ac.IdTokenConfig     `json:"id_tokens,omitempty"`                  // map of id token name to token attributes
+	KeyType                 string                          `json:"key_type,omitempty"`                   //private key type - rsa-2048, rsa-4096, ecdsa-p256, ecdsa-p384, ed25519
+	BackoffInitialInterval  int                             `json:"backoff_initial_interval,omitempty"`   //initial retry interval in seconds after a failed refresh
+	BackoffMaxInterval      int                             `json:"backoff_max_interval,omitempty"`       //maximum retry interval in seconds after a failed refresh
+	CertRefreshRatio        float64                         `json:"cert_refresh_ratio,omitempty"`         //fraction of the certificate lifetime after which it is refreshed
+	CertRefreshJitter       float64                         `json:"cert_refresh_jitter,omitempty"`        //random fraction of the certificate lifetime to refresh earlier
+	StatusUdsPath           string                          `json:"status_uds_path,omitempty"`            //uds path for the health, status and metrics endpoints
+	StatusPort              int                             `json:"status_port,omitempty"`                //localhost port for the health, status and metrics endpoints
+	TokenServerUdsPath      string                          `json:"token_server_uds_path,omitempty"`      //uds path for the local access, role and id token endpoints
+	CABundles               map[string]ConfigCABundle       `json:"ca_bundles,omitempty"`                 //named CA trust bundles served by the SDS server
+	CABundleRefreshInterval int                             `json:"ca_bundle_refresh_interval,omitempty"` //refresh interval in minutes for CA bundles fetched from ZTS
+	AWSCredentials          map[string]ConfigAWSCredentials `json:"aws_credentials,omitempty"`            //map of profile name to aws temporary credentials
+	AWSCredentialsFile      string                          `json:"aws_credentials_file,omitempty"`       //aws credentials file managed by the agent
+	AWSCredentialsPort      int                             `json:"aws_credentials_port,omitempty"`       //localhost port for the container credentials endpoint
+}
+
+type AccessProfileConfig struct {
+	Profile string `json:"profile,omitempty"` // map of role name to token attributes
+}
+
+// Role contains role details. Attributes are set based on the config values
+type Role struct {
+	Name       string
+	Service    string
+	ExpiryTime int
+	Filename   string
+	User       string
+	Uid        int
+	Gid        int
+	FileMode   int
+	Threshold  float64
+	Hooks      []hook.Hook
+	Outputs    []output.Output
+}
+
+// Service represents service details. Attributes are filled in based on the config values
+type Service struct {
+	Name             string
+	Filename         string
+	User             string
+	Group            string
+	Uid              int
+	Gid              int
+	FileMode         int
+	ExpiryTime       int
+	SDSUdsUid        int
+	SDSNodeId        string
+	SDSNodeCluster   string
+	SDSUdsGids       []int
+	SDSUdsExe        []string
+	SDSUdsCgroups    []string
+	SDSTcpPrincipals []string
+	Threshold        float64
+	Hooks            []hook.Hook
+	Outputs          []output.Output
+}
+
+// CABundle contains the CA trust bundle details. Attributes are set based on the config values
+type CABundle struct {
+	Name      string
+	Files     []string
+	ZTSBundle string
+}
+
+// AWSCredentials contains the AWS temporary credentials details. Attributes are set based on the config values
+type AWSCredentials struct {
+	Profile    string
+	Domain     string
+	Role       string
+	Duration   int
+	ExternalId string
+	Service    string
+}
+
+// Options represents settings that are derived from config file and application defaults
+type Options struct {
+	Provider                provider.Provider //platform provider for attestation data and certificate details
+	Name                    string            //name of the service identity
+	User                    string            //the user name to chown the cert/key dirs to. If absent, then root
+	Group                   string            //the group name to chown the cert/key dirs to. If absent, then athenz
+	Domain                  string            //name of the domain for the identity
+	Account                 string            //name of the account
+	Service                 string            //name of the service for the identity
+	Zts                     string            //the ZTS to contact
+	Filename                string            //filename to put the service certificate
+	InstanceId              string            //instance id if ec2, task id if running within eks/ecs
+	Roles                   []Role            //map of roles to retrieve certificates for
+	Region                  string            //region name
+	SanDnsWildcard          bool              //san dns wildcard support
+	SanDnsHostname          bool              //san dns hostname support
+	Version                 string            //sia version number
+	ZTSDomains              []string          //list of domain prefixes for sanDNS entries
+	Services                []Service         //array of configured services
+	Ssh                     bool              //ssh certificate support
+	UseRegionalSTS          bool              //use regional sts endpoint
+	KeyDir                  string            //private key directory path
+	CertDir                 string            //x.509 certificate directory path
+	AthenzCACertFile        string            //filename to store Athenz CA certs
+	ZTSCACertFile           string            //filename for CA certs when communicating with ZTS
+	ZTSServerName           string            //ZTS server name, if necessary for tls
+	ZTSAWSDomains           []string          //Deprecated: use ZTSDomains, only used if ZTSDomains is not set
+	GenerateRoleKey         bool              //option to generate a separate key for role certificates
+	RotateKey               bool              //rotate the private key when refreshing certificates
+	BackUpDir               string            //backup directory for key/cert rotation
+	CertCountryName         string            //generated x.509 certificate country name
+	CertOrgName             string            //generated x.509 certificate organization name
+	SshPubKeyFile           string            //ssh host public key file path
+	SshCertFile             string            //ssh host certificate file path
+	SshConfigFile           string            //sshd config file path
+	SshDir                  string            //ssh host keys directory path
+	SshHostKeyTypes         []hostkey.KeyType //ssh host key types to request host certificates for
+	SshUserCABundle         string            //zts bundle name with the ssh user ca public keys
+	SshTrustedUserCAKeys    string            //sshd TrustedUserCAKeys file path
+	PrivateIp               string            //instance private ip
+	EC2StartTime            *time.Time        //EC2 instance start time
+	InstanceIdSanDNS        bool              //include instance id in a san dns entry (backward compatible option)
+	RolePrincipalEmail      bool              //include role principal in a san email field (backward compatible option)
+	SDSUdsPath              string            //UDS path if the agent should support uds connections
+	SDSUdsUid               int               //UDS connections must be from the given user uid
+	SDSTcpAddress           string            //TCP host:port if the agent should support mtls connections
+	RefreshInterval         int               //maximum refresh interval for certificates - default 24 hours
+	ZTSRegion               string            //ZTS region in case the client needs this information
+	DropPrivileges          bool              //Drop privileges to configured user instead of running as root
+	TokenDir                string            //Access tokens directory
+	AccessTokens            []ac.AccessToken  //Access tokens object
+	IdTokens                []ac.IdToken      //ID tokens object
+	Profile                 string            //Access profile name
+	Threshold               float64
+	SshThreshold            float64
+	KeyType                 util.KeyType     //private key type for service and role certificates
+	BackoffInitialInterval  int              //initial retry interval in seconds after a failed refresh
+	BackoffMaxInterval      int              //maximum retry interval in seconds after a failed refresh
+	CertRefreshRatio        float64          //fraction of the certificate lifetime after which it is refreshed
+	CertRefreshJitter       float64          //random fraction of the certificate lifetime to refresh earlier
+	StatusUdsPath           string           //UDS path for the health, status and metrics endpoints
+	StatusPort              int              //localhost port for the health, status and metrics endpoints
+	TokenServerUdsPath      string           //UDS path for the local access, role and id token endpoints
+	CABundles               []CABundle       //named CA trust bundles served by the SDS server
+	CABundleRefreshInterval int              //refresh interval in minutes for CA bundles fetched from ZTS
+	AWSCredentials          []AWSCredentials //AWS temporary credentials profiles
+	AWSCredentialsFile      string           //AWS credentials file managed by the agent
+	AWSCredentialsPort      int              //localhost port for the container credentials endpoint
+}
+
+const (
+	DEFAULT_TOKEN_EXPIRY = 28800       // 8 hrs
+	DEFAULT_THRESHOLD    = float64(15) // 15 days
+
+	DEFAULT_BACKOFF_INITIAL_INTERVAL = 30   // 30 secs
+	DEFAULT_BACKOFF_MAX_INTERVAL     = 1800 // 30 mins
+
+	DEFAULT_CERT_REFRESH_RATIO  = float64(0.5)
+	DEFAULT_CERT_REFRESH_JITTER = float64(0.1)
+
+	DEFAULT_CA_BUNDLE_REFRESH_INTERVAL = 60 // 1 hour
+
+	DEFAULT_SSH_DIR         = "/etc/ssh"
+	DEFAULT_SSH_CONFIG_FILE = "/etc/ssh/sshd_config"
+)
+
+func GetAccountId(metaEndPoint string, useRegionalSTS bool, region string) (string, error) {
+	// first try to get the account from our creds and if
+	// fails we'll fall back to identity document
+	configAccount, _, err := InitCredsConfig("", "@", useRegionalSTS, region)
+	if err == nil {
+		return configAccount.Account, nil
+	}
+	document, err := meta.GetData(metaEndPoint, "/latest/dynamic/instance-identity/document")
+	if err != nil {
+		return "", err
+	}
+	return doc.GetDocumentEntry(document, "accountId")
+}
+
+func InitCredsConfig(roleSuffix, accessProfileSeparator string, useRegionalSTS bool, region string) (*ConfigAccount, *AccessProfileConfig, error) {
+	account, domain, service, profile, err := stssession.GetMetaDetailsFromCreds(roleSuffix, accessProfileSeparator, useRegionalSTS, region)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse role arn: %v", err)
+	}
+	return &ConfigAccount{
+			Domain:       domain,
+			Service:      service,
+			Account:      account,
+			Name:         fmt.Sprintf("%s.%s", domain, service),
+			Threshold:    DEFAULT_THRESHOLD,
+			SshThreshold: DEFAULT_THRESHOLD,
+		}, &AccessProfileConfig{
+			Profile: profile,
+		}, nil
+}
+
+func InitProfileConfig(metaEndPoint, roleSuffix, accessProfileSeparator string) (*ConfigAccount, *AccessProfileConfig, error) {
+	info, err := meta.GetData(metaEndPoint, "/latest/meta-data/iam/info")
+	if err != nil {
+		return nil, nil, err
+	}
+	arn, err := doc.GetDocumentEntry(info, "InstanceProfileArn")
+	if err != nil {
+		return nil, nil, err
+	}
+	account, domain, service, profile, err := util.ParseRoleArn(arn, "instance-profile/", roleSuffix, accessProfileSeparator)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &ConfigAccount{
+			Domain:       domain,
+			Service:      service,
+			Account:      account,
+			Name:         fmt.Sprintf("%s.%s", domain, service),
+			Threshold:    DEFAULT_THRESHOLD,
+			SshThreshold: DEFAULT_THRESHOLD,
+		}, &AccessProfileConfig{
+			Profile: profile,
+		}, nil
+}
+
+// InitGCPMetaConfig determines the service identity from the gcp metadata
+// server - the domain is specified by the athenz-domain attribute and the
+// service is the account id of the default service account
+func InitGCPMetaConfig(metaEndPoint, project string) (*ConfigAccount, error) {
+	domain, service, err := gcpmeta.GetDomainService(metaEndPoint)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigAccount{
+		Domain:       domain,
+		Service:      service,
+		Account:      project,
+		Name:         fmt.Sprintf("%s.%s", domain, service),
+		Threshold:    DEFAULT_THRESHOLD,
+		SshThreshold: DEFAULT_THRESHOLD,
+	}, nil
+}
+
+func InitFileConfig(fileName, metaEndPoint string, useRegionalSTS bool, region, account string) (*Config, *ConfigAccount, error) {
+	confBytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(confBytes) == 0 {
+		return nil, nil, errors.New("empty config bytes")
+	}
+	var config Config
+	err = json.Unmarshal(confBytes, &config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.Service == "" {
+		return &config, nil, fmt.Errorf("missing required Service field from the config file")
+	}
+	// if we have more than one account block defined (not recommended)
+	// then we need to determine our account id
+	if len(config.Accounts) > 1 && account == "" {
+		account, _ = GetAccountId(metaEndPoint, useRegionalSTS || config.UseRegionalSTS, region)
+	}
+	for _, configAccount := range config.Accounts {
+		if configAccount.Account == account || len(config.Accounts) == 1 {
+			if configAccount.Domain == "" || configAccount.Account == "" {
+				return &config, nil, fmt.Errorf("missing required Domain/Account from the config file")
+			}
+			configAccount.Service = config.Service
+			configAccount.Name = fmt.Sprintf("%s.%s", configAccount.Domain, configAccount.Service)
+			configAccount.Threshold = nonZeroValue(configAccount.Threshold, DEFAULT_THRESHOLD)
+			configAccount.SshThreshold = nonZeroValue(configAccount.SshThreshold, DEFAULT_THRESHOLD)
+			return &config, &configAccount, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("missing account %s details from config file", account)
+}
+
+func InitAccessProfileFileConfig(fileName string) (*AccessProfileConfig, error) {
+	confBytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(confBytes) == 0 {
+		return nil, errors.New("empty config bytes")
+	}
+	var config AccessProfileConfig
+	err = json.Unmarshal(confBytes, &config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Profile == "" {
+		return nil, fmt.Errorf("missing required Profile field from the config file")
+	}
+
+	return &AccessProfileConfig{
+		Profile: config.Profile,
+	}, nil
+}
+
+func InitEnvConfig(config *Config) (*Config, *ConfigAccount, error) {
+	// it is possible that the config object was already created the
+	// config file in which case we're not going to override any
+	// of the settings.
+	if config == nil {
+		config = &Config{}
+	}
+	if !config.SanDnsWildcard {
+		config.SanDnsWildcard = util.ParseEnvBooleanFlag("ATHENZ_SIA_SANDNS_WILDCARD")
+	}
+	if !config.SanDnsHostname {
+		config.SanDnsHostname = util.ParseEnvBooleanFlag("ATHENZ_SIA_SANDNS_HOSTNAME")
+	}
+	if !config.UseRegionalSTS {
+		config.UseRegionalSTS = util.ParseEnvBooleanFlag("ATHENZ_SIA_REGIONAL_STS")
+	}
+	if !config.GenerateRoleKey {
+		config.GenerateRoleKey = util.ParseEnvBooleanFlag("ATHENZ_SIA_GENERATE_ROLE_KEY")
+	}
+	if !config.RotateKey {
+		config.RotateKey = util.ParseEnvBooleanFlag("ATHENZ_SIA_ROTATE_KEY")
+	}
+	if config.User == "" {
+		config.User = os.Getenv("ATHENZ_SIA_USER")
+	}
+	if config.Group == "" {
+		config.Group = os.Getenv("ATHENZ_SIA_GROUP")
+	}
+	if config.SDSUdsPath == "" {
+		config.SDSUdsPath = os.Getenv("ATHENZ_SIA_SDS_UDS_PATH")
+	}
+	if config.SDSUdsUid == 0 {
+		uid := util.ParseEnvIntFlag("ATHENZ_SIA_SDS_UDS_UID", 0)
+		if uid > 0 {
+			config.SDSUdsUid = uid
+		}
+	}
+	if config.SDSTcpAddress == "" {
+		config.SDSTcpAddress = os.Getenv("ATHENZ_SIA_SDS_TCP_ADDRESS")
+	}
+	if config.ExpiryTime == 0 {
+		expiryTime := util.ParseEnvIntFlag("ATHENZ_SIA_EXPIRY_TIME", 0)
+		if expiryTime > 0 {
+			config.ExpiryTime = expiryTime
+		}
+	}
+	if config.RefreshInterval == 0 {
+		refreshInterval := util.ParseEnvIntFlag("ATHENZ_SIA_REFRESH_INTERVAL", 0)
+		if refreshInterval > 0 {
+			config.RefreshInterval = refreshInterval
+		}
+	}
+	if config.ZTSRegion == "" {
+		config.ZTSRegion = os.Getenv("ATHENZ_SIA_ZTS_REGION")
+	}
+	if !config.DropPrivileges {
+		config.DropPrivileges = util.ParseEnvBooleanFlag("ATHENZ_SIA_DROP_PRIVILEGES")
+	}
+	if config.KeyType == "" {
+		config.KeyType = os.Getenv("ATHENZ_SIA_KEY_TYPE")
+	}
+	if config.BackoffInitialInterval == 0 {
+		backoffInitialInterval := util.ParseEnvIntFlag("ATHENZ_SIA_BACKOFF_INITIAL_INTERVAL", 0)
+		if backoffInitialInterval > 0 {
+			config.BackoffInitialInterval = backoffInitialInterval
+		}
+	}
+	if config.BackoffMaxInterval == 0 {
+		backoffMaxInterval := util.ParseEnvIntFlag("ATHENZ_SIA_BACKOFF_MAX_INTERVAL", 0)
+		if backoffMaxInterval > 0 {
+			config.BackoffMaxInterval = backoffMaxInterval
+		}
+	}
+	if config.CertRefreshRatio == 0 {
+		config.CertRefreshRatio = util.ParseEnvFloatFlag("ATHENZ_SIA_CERT_REFRESH_RATIO", 0)
+	}
+	if config.CertRefreshJitter == 0 {
+		config.CertRefreshJitter = util.ParseEnvFloatFlag("ATHENZ_SIA_CERT_REFRESH_JITTER", 0)
+	}
+	if config.StatusUdsPath == "" {
+		config.StatusUdsPath = os.Getenv("ATHENZ_SIA_STATUS_UDS_PATH")
+	}
+	if config.StatusPort == 0 {
+		statusPort := util.ParseEnvIntFlag("ATHENZ_SIA_STATUS_PORT", 0)
+		if statusPort > 0 {
+			config.StatusPort = statusPort
+		}
+	}
+	if config.TokenServerUdsPath == "" {
+		config.TokenServerUdsPath = os.Getenv("ATHENZ_SIA_TOKEN_SERVER_UDS_PATH")
+	}
+	if config.CABundles == nil {
+		caBundlesEnv := os.Getenv("ATHENZ_SIA_CA_BUNDLES")
+		if caBundlesEnv != "" {
+			err := json.Unmarshal([]byte(caBundlesEnv), &config.CABundles)
+			if err != nil {
+				return config, nil, fmt.Errorf("unable to parse ca bundles '%s': %v", caBundlesEnv, err)
+			}
+		}
+	}
+	if config.CABundleRefreshInterval == 0 {
+		caBundleRefreshInterval := util.ParseEnvIntFlag("ATHENZ_SIA_CA_BUNDLE_REFRESH_INTERVAL", 0)
+		if caBundleRefreshInterval > 0 {
+			config.CABundleRefreshInterval = caBundleRefreshInterval
+		}
+	}
+	if config.AWSCredentials == nil {
+		awsCredentialsEnv := os.Getenv("ATHENZ_SIA_AWS_CREDENTIALS")
+		if awsCredentialsEnv != "" {
+			err := json.Unmarshal([]byte(awsCredentialsEnv), &config.AWSCredentials)
+			if err != nil {
+				return config, nil, fmt.Errorf("unable to parse aws credentials '%s': %v", awsCredentialsEnv, err)
+			}
+		}
+	}
+	if config.IdTokens == nil {
+		idTokensEnv := os.Getenv("ATHENZ_SIA_ID_TOKENS")
+		if idTokensEnv != "" {
+			err := json.Unmarshal([]byte(idTokensEnv), &config.IdTokens)
+			if err != nil {
+				return config, nil, fmt.Errorf("unable to parse id tokens '%s': %v", idTokensEnv, err)
+			}
+		}
+	}
+	if config.AWSCredentialsFile == "" {
+		config.AWSCredentialsFile = os.Getenv("ATHENZ_SIA_AWS_CREDENTIALS_FILE")
+	}
+	if config.AWSCredentialsPort == 0 {
+		awsCredentialsPort := util.ParseEnvIntFlag("ATHENZ_SIA_AWS_CREDENTIALS_PORT", 0)
+		if awsCredentialsPort > 0 {
+			config.AWSCredentialsPort = awsCredentialsPort
+		}
+	}
+
+	roleArn := os.Getenv("ATHENZ_SIA_IAM_ROLE_ARN")
+	if roleArn == "" {
+		return config, nil, fmt.Errorf("athenz role arn env variable not configured")
+	}
+	account, domain, service, _, err := util.ParseRoleArn(roleArn, "role/", "", "")
+	if err != nil {
+		return config, nil, fmt.Errorf("unable to parse athenz role arn: %v", err)
+	}
+	if account == "" || domain == "" || service == "" {
+		return config, nil, fmt.Errorf("invalid role arn - missing components: %s", roleArn)
+	}
+
+	var configRoles map[string]ConfigRole
+	rolesEnv := os.Getenv("ATHENZ_SIA_ACCOUNT_ROLES")
+	if rolesEnv != "" {
+		err = json.Unmarshal([]byte(rolesEnv), &configRoles)
+		if err != nil {
+			return config, nil, fmt.Errorf("unable to parse athenz account roles '%s': %v", rolesEnv, err)
+		}
+	}
+
+	threshold := util.ParseEnvFloatFlag("ATHENZ_SIA_ACCOUNT_THRESHOLD", DEFAULT_THRESHOLD)
+	sshThreshold := util.ParseEnvFloatFlag("ATHENZ_SIA_ACCOUNT_SSH_THRESHOLD", DEFAULT_THRESHOLD)
+
+	return config, &ConfigAccount{
+		Account:      account,
+		Domain:       domain,
+		Service:      service,
+		Roles:        configRoles,
+		Name:         fmt.Sprintf("%s.%s", domain, service),
+		Threshold:    threshold,
+		SshThreshold: sshThreshold,
+	}, nil
+}
+
+func InitAccessProfileEnvConfig() (*AccessProfileConfig, error) {
+
+	accessProfile := os.Getenv("ATHENZ_SIA_ACCESS_PROFILE")
+	if accessProfile == "" {
+		return nil, fmt.Errorf("athenz accessProfile variable not configured")
+	}
+
+	return &AccessProfileConfig{
+		Profile: accessProfile,
+	}, nil
+}
+
+// setOptions takes in sia_config objects and returns a pointer to Options after parsing and initializing the defaults
+// It uses profile arn for defaults when sia_config is empty or non-parsable. It populates "services" array
+func setOptions(config *Config, account *ConfigAccount, profileConfig *AccessProfileConfig, siaDir, version string) (*Options, error) {
+
+	//update regional sts and wildcard settings based on config settings
+	useRegionalSTS := false
+	sanDnsWildcard := false
+	sanDnsHostname := false
+	sdsUdsPath := ""
+	sdsUdsUid := 0
+	sdsTcpAddress := ""
+	generateRoleKey := false
+	rotateKey := false
+	expiryTime := 0
+	refreshInterval := 24 * 60
+	ztsRegion := ""
+	dropPrivileges := false
+	profile := ""
+	keyType := util.DEFAULT_KEY_TYPE
+	backoffInitialInterval := DEFAULT_BACKOFF_INITIAL_INTERVAL
+	backoffMaxInterval := DEFAULT_BACKOFF_MAX_INTERVAL
+	certRefreshRatio := DEFAULT_CERT_REFRESH_RATIO
+	certRefreshJitter := DEFAULT_CERT_REFRESH_JITTER
+	statusUdsPath := ""
+	statusPort := 0
+	tokenServerUdsPath := ""
+	awsCredentialsFile := fmt.Sprintf("%s/aws/credentials", siaDir)
+	awsCredentialsPort := 0
+	caBundleRefreshInterval := DEFAULT_CA_BUNDLE_REFRESH_INTERVAL
+	ssh := false
+	sshHostKeyTypes := hostkey.KeyTypes()
+	sshUserCABundle := ""
+	sshTrustedUserCAKeys := fmt.Sprintf("%s/trusted_user_ca_keys.pub", DEFAULT_SSH_DIR)
+
+	if config != nil {
+		useRegionalSTS = config.UseRegionalSTS
+		sanDnsWildcard = config.SanDnsWildcard
+		sanDnsHostname = config.SanDnsHostname
+		sdsUdsPath = config.SDSUdsPath
+		sdsUdsUid = config.SDSUdsUid
+		sdsTcpAddress = config.SDSTcpAddress
+		if sdsTcpAddress != "" {
+			if _, _, err := net.SplitHostPort(sdsTcpAddress); err != nil {
+				return nil, fmt.Errorf("invalid sds tcp address: %s, error: %v", sdsTcpAddress, err)
+			}
+		}
+		expiryTime = config.ExpiryTime
+		ztsRegion = config.ZTSRegion
+		dropPrivileges = config.DropPrivileges
+		if config.RefreshInterval > 0 {
+			refreshInterval = config.RefreshInterval
+		}
+		if config.BackoffInitialInterval > 0 {
+			backoffInitialInterval = config.BackoffInitialInterval
+		}
+		if config.BackoffMaxInterval > 0 {
+			backoffMaxInterval = config.BackoffMaxInterval
+		}
+		if backoffMaxInterval < backoffInitialInterval {
+			return nil, fmt.Errorf("backoff max interval %d is less than initial interval %d", backoffMaxInterval, backoffInitialInterval)
+		}
+		if config.CertRefreshRatio != 0 {
+			certRefreshRatio = config.CertRefreshRatio
+		}
+		if config.CertRefreshJitter != 0 {
+			certRefreshJitter = config.CertRefreshJitter
+		}
+		if certRefreshRatio <= 0 || certRefreshRatio >= 1 {
+			return nil, fmt.Errorf("cert refresh ratio %v must be between 0 and 1", certRefreshRatio)
+		}
+		if certRefreshJitter < 0 || certRefreshJitter >= certRefreshRatio {
+			return nil, fmt.Errorf("cert refresh jitter %v must be between 0 and refresh ratio %v", certRefreshJitter, certRefreshRatio)
+		}
+		statusUdsPath = config.StatusUdsPath
+		statusPort = config.StatusPort
+		tokenServerUdsPath = config.TokenServerUdsPath
+		if config.AWSCredentialsFile != "" {
+			awsCredentialsFile = config.AWSCredentialsFile
+		}
+		awsCredentialsPort = config.AWSCredentialsPort
+		if awsCredentialsPort < 0 || awsCredentialsPort > 65535 {
+			return nil, fmt.Errorf("invalid aws credentials port: %d", awsCredentialsPort)
+		}
+		if statusPort < 0 || statusPort > 65535 {
+			return nil, fmt.Errorf("invalid status port: %d", statusPort)
+		}
+		if config.CABundleRefreshInterval > 0 {
+			caBundleRefreshInterval = config.CABundleRefreshInterval
+		}
+		//ssh host certificates are requested for all the configured
+		//key types that are present on the host. the single key type
+		//setting is still honored if the list is not specified
+		if config.Ssh != nil {
+			ssh = *config.Ssh
+		}
+		if len(config.SshHostKeyTypes) != 0 {
+			sshHostKeyTypes = config.SshHostKeyTypes
+		} else if config.SshHostKeyType != 0 {
+			sshHostKeyTypes = []hostkey.KeyType{config.SshHostKeyType}
+		}
+		sshUserCABundle = config.SshUserCABundle
+		if config.SshTrustedUserCAKeys != "" {
+			sshTrustedUserCAKeys = config.SshTrustedUserCAKeys
+		}
+		var err error
+		keyType, err = util.ParseKeyType(config.KeyType)
+		if err != nil {
+			return nil, err
+		}
+
+		//update account user/group settings if override provided at the config level
+		if account.User == "" && config.User != "" {
+			account.User = config.User
+		}
+		if account.Group == "" && config.Group != "" {
+			account.Group = config.Group
+		}
+
+		//update generate role and rotate key options if config is provided
+		generateRoleKey = config.GenerateRoleKey
+		rotateKey = config.RotateKey
+		if len(account.Roles) != 0 && generateRoleKey == false && rotateKey == true {
+			log.Println("Cannot set rotate_key to true, with generate_role_key as false, when there are one or more roles defined in config")
+			generateRoleKey = false
+			rotateKey = false
+		}
+	}
+
+	var services []Service
+	if config == nil || len(config.Services) == 0 {
+		//There is no sia_config, or multiple services are not configured.
+		//Populate services with the account information we gathered
+		s := Service{
+			Name:      account.Service,
+			Filename:  account.Filename,
+			User:      account.User,
+			Threshold: account.Threshold,
+		}
+		s.Uid, s.Gid, s.FileMode = util.SvcAttrs(account.User, account.Group)
+		s.SDSUdsUid = sdsUdsUid
+		s.ExpiryTime = expiryTime
+		services = append(services, s)
+	} else {
+		// sia_config and services are found
+		if _, ok := config.Services[config.Service]; !ok {
+			return nil, fmt.Errorf("services: %+v mentioned, service: %q needs to be part of services", config.Services, config.Service)
+		}
+		// Populate config.Service into first
+		first := Service{
+			Name: config.Service,
+		}
+
+		// Populate the remaining into tail
+		var tail []Service
+		for name, s := range config.Services {
+			if err := hook.ValidateAll(s.Hooks); err != nil {
+				return nil, fmt.Errorf("invalid hook for service %s: %v", name, err)
+			}
+			if err := output.ValidateAll(s.Outputs); err != nil {
+				return nil, fmt.Errorf("invalid output for service %s: %v", name, err)
+			}
+			if err := validatePatterns(s.SDSUdsExe); err != nil {
+				return nil, fmt.Errorf("invalid sds_uds_exe for service %s: %v", name, err)
+			}
+			if err := validatePatterns(s.SDSUdsCgroups); err != nil {
+				return nil, fmt.Errorf("invalid sds_uds_cgroups for service %s: %v", name, err)
+			}
+			svcExpiryTime := expiryTime
+			if s.ExpiryTime > 0 {
+				svcExpiryTime = s.ExpiryTime
+			}
+			svcSDSUdsUid := sdsUdsUid
+			if s.SDSUdsUid != 0 {
+				svcSDSUdsUid = s.SDSUdsUid
+			}
+			if name == config.Service {
+				first.Filename = s.Filename
+				first.User = s.User
+				first.Group = s.Group
+				// If User/Group are not specified, apply the User/Group settings from Config Account
+				// This is for backwards compatibility - For other multiple services, the User/Group need to be explicitly mentioned in config
+				if first.User == "" {
+					first.User = account.User
+				}
+				if first.Group == "" {
+					first.Group = account.Group
+				}
+				first.Uid, first.Gid, first.FileMode = util.SvcAttrs(first.User, first.Group)
+				first.ExpiryTime = svcExpiryTime
+				first.SDSNodeId = s.SDSNodeId
+				first.SDSNodeCluster = s.SDSNodeCluster
+				first.SDSUdsUid = svcSDSUdsUid
+				first.SDSUdsGids = s.SDSUdsGids
+				first.SDSUdsExe = s.SDSUdsExe
+				first.SDSUdsCgroups = s.SDSUdsCgroups
+				first.SDSTcpPrincipals = s.SDSTcpPrincipals
+				first.Threshold = nonZeroValue(s.Threshold, account.Threshold)
+				first.Hooks = s.Hooks
+				outputs, err := output.Resolve(s.Outputs, first.Uid, first.Gid, first.FileMode)
+				if err != nil {
+					return nil, fmt.Errorf("invalid output for service %s: %v", name, err)
+				}
+				first.Outputs = outputs
+			} else {
+				ts := Service{
+					Name:      name,
+					Filename:  s.Filename,
+					User:      s.User,
+					Group:     s.Group,
+					Threshold: nonZeroValue(s.Threshold, account.Threshold),
+					Hooks:     s.Hooks,
+				}
+				ts.Uid, ts.Gid, ts.FileMode = util.SvcAttrs(s.User, s.Group)
+				outputs, err := output.Resolve(s.Outputs, ts.Uid, ts.Gid, ts.FileMode)
+				if err != nil {
+					return nil, fmt.Errorf("invalid output for service %s: %v", name, err)
+				}
+				ts.Outputs = outputs
+				ts.ExpiryTime = svcExpiryTime
+				ts.SDSNodeId = s.SDSNodeId
+				ts.SDSNodeCluster = s.SDSNodeCluster
+				ts.SDSUdsUid = svcSDSUdsUid
+				ts.SDSUdsGids = s.SDSUdsGids
+				ts.SDSUdsExe = s.SDSUdsExe
+				ts.SDSUdsCgroups = s.SDSUdsCgroups
+				ts.SDSTcpPrincipals = s.SDSTcpPrincipals
+				tail = append(tail, ts)
+			}
+			if s.Filename != "" && s.Filename[0] == '/' {
+				log.Println("when custom filepaths are specified, rotate_key and generate_role_key are not supported")
+				generateRoleKey = false
+				rotateKey = false
+			}
+		}
+		services = append(services, first)
+		services = append(services, tail...)
+	}
+
+	// Process all access_tokens
+	accessTokens, err := processAccessTokens(config, services)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenDir := fmt.Sprintf("%s/tokens", siaDir)
+	idTokens, err := processIdTokens(config, services, tokenDir)
+	if err != nil {
+		return nil, err
+	}
+
+	caBundles, err := processCABundles(config)
+	if err != nil {
+		return nil, err
+	}
+
+	awsCredentials, err := processAWSCredentials(config, services, account.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []Role
+	for name, r := range account.Roles {
+		if r.Filename != "" && r.Filename[0] == '/' {
+			log.Println("when custom filepaths are specified, rotate_key and generate_role_key are not supported")
+			generateRoleKey = false
+			rotateKey = false
+		}
+		if err := hook.ValidateAll(r.Hooks); err != nil {
+			return nil, fmt.Errorf("invalid hook for role %s: %v", name, err)
+		}
+		if err := output.ValidateAll(r.Outputs); err != nil {
+			return nil, fmt.Errorf("invalid output for role %s: %v", name, err)
+		}
+		roleService := getRoleServiceOwner(r.Service, services)
+		role := Role{
+			Name:       name,
+			Service:    roleService.Name,
+			Filename:   r.Filename,
+			ExpiryTime: r.ExpiryTime,
+			FileMode:   roleService.FileMode,
+			Threshold:  nonZeroValue(r.Threshold, account.Threshold),
+			Hooks:      r.Hooks,
+		}
+		role.Uid = roleService.Uid
+		role.Gid = roleService.Gid
+		// override the uid/gid values if specified at role level
+		if r.User != "" || r.Group != "" {
+			rUid, rGid, fileMode := util.SvcAttrs(r.User, r.Group)
+			if r.User != "" {
+				role.Uid = rUid
+			}
+			if r.Group != "" {
+				role.Gid = rGid
+				role.FileMode = fileMode
+			}
+		}
+		role.Outputs, err = output.Resolve(r.Outputs, role.Uid, role.Gid, role.FileMode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output for role %s: %v", name, err)
+		}
+		roles = append(roles, role)
+	}
+
+	if profileConfig != nil {
+		profile = profileConfig.Profile
+	}
+
+	return &Options{
+		Name:                    account.Name,
+		User:                    account.User,
+		Group:                   account.Group,
+		Domain:                  account.Domain,
+		Account:                 account.Account,
+		Zts:                     account.Zts,
+		Filename:                account.Filename,
+		Version:                 fmt.Sprintf("SIA-AWS %s", version),
+		UseRegionalSTS:          useRegionalSTS,
+		SanDnsWildcard:          sanDnsWildcard,
+		SanDnsHostname:          sanDnsHostname,
+		Services:                services,
+		Roles:                   roles,
+		Ssh:                     ssh,
+		SshDir:                  DEFAULT_SSH_DIR,
+		SshConfigFile:           DEFAULT_SSH_CONFIG_FILE,
+		SshHostKeyTypes:         sshHostKeyTypes,
+		SshUserCABundle:         sshUserCABundle,
+		SshTrustedUserCAKeys:    sshTrustedUserCAKeys,
+		TokenDir:                tokenDir,
+		CertDir:                 fmt.Sprintf("%s/certs", siaDir),
+		KeyDir:                  fmt.Sprintf("%s/keys", siaDir),
+		AthenzCACertFile:        fmt.Sprintf("%s/certs/ca.cert.pem", siaDir),
+		GenerateRoleKey:         generateRoleKey,
+		RotateKey:               rotateKey,
+		BackUpDir:               fmt.Sprintf("%s/backup", siaDir),
+		SDSUdsPath:              sdsUdsPath,
+		SDSTcpAddress:           sdsTcpAddress,
+		RefreshInterval:         refreshInterval,
+		ZTSRegion:               ztsRegion,
+		DropPrivileges:          dropPrivileges,
+		AccessTokens:            accessTokens,
+		IdTokens:                idTokens,
+		Profile:                 profile,
+		Threshold:               account.Threshold,
+		SshThreshold:            account.SshThreshold,
+		KeyType:                 keyType,
+		BackoffInitialInterval:  backoffInitialInterval,
+		BackoffMaxInterval:      backoffMaxInterval,
+		CertRefreshRatio:        certRefreshRatio,
+		CertRefreshJitter:       certRefreshJitter,
+		StatusUdsPath:           statusUdsPath,
+		StatusPort:              statusPort,
+		TokenServerUdsPath:      tokenServerUdsPath,
+		CABundles:               caBundles,
+		CABundleRefreshInterval: caBundleRefreshInterval,
+		AWSCredentials:          awsCredentials,
+		AWSCredentialsFile:      awsCredentialsFile,
+		AWSCredentialsPort:      awsCredentialsPort,
+	}, nil
+}
+
+func getRoleServiceOwner(serviceName string, services []Service) Service {
+	if serviceName == "" {
+		return services[0]
+	}
+	for _, s := range services {
+		if s.Name == serviceName {
+			return s
+		}
+	}
+	log.Printf("unknown service %s specified for role, defaulting to primary service\n", serviceName)
+	return services[0]
+}
+
+func processAccessTokens(config *Config, processedSvcs []Service) ([]ac.AccessToken, error) {
+	if config == nil || config.AccessTokens == nil {
+		return nil, nil
+	}
+
+	var accessTokens []ac.AccessToken
+
+	for k, t := range config.AccessTokens {
+		parts := strings.Split(k, "/")
+		if len(parts) <= 1 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid access-token role, domain: %v, role: %v", k, t.Roles)
+		}
+		domain := parts[0]
+		fileName := parts[1]
+		roles := t.Roles
+		if len(roles) == 0 {
+			roles = []string{fileName}
+		}
+		expiry := DEFAULT_TOKEN_EXPIRY
+		if t.Expiry != 0 {
+			expiry = t.Expiry
+		}
+		service := t.Service
+
+		// if service is not presented, choose the main service
+		if service == "" {
+			service = config.Service
+		}
+
+		processedSvc, err := getSvc(service, processedSvcs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := hook.ValidateAll(t.Hooks); err != nil {
+			return nil, fmt.Errorf("invalid hook for access-token %s: %v", k, err)
+		}
+
+		authzDetails, err := processAuthorizationDetails(t.AuthorizationDetails)
+		if err != nil {
+			return nil, fmt.Errorf("invalid authorization details for access-token %s: %v", k, err)
+		}
+		if t.ExpiryThreshold < 0 {
+			return nil, fmt.Errorf("invalid expiry threshold for access-token %s: %d", k, t.ExpiryThreshold)
+		}
+		var storeOptions *ac.StoreTokenOptions
+		if t.Store != "" {
+			store, err := ac.ParseStoreTokenOptions(t.Store)
+			if err != nil {
+				return nil, fmt.Errorf("invalid access-token %s: %v", k, err)
+			}
+			storeOptions = &store
+		}
+
+		accessTokens = append(accessTokens, ac.AccessToken{
+			FileName:                 fileName,
+			Service:                  service,
+			Domain:                   domain,
+			Roles:                    roles,
+			Expiry:                   expiry,
+			User:                     processedSvc.User,
+			Uid:                      processedSvc.Uid,
+			Gid:                      processedSvc.Gid,
+			AuthorizationDetails:     authzDetails,
+			ProxyPrincipalSpiffeUris: t.ProxyPrincipalSpiffeUris,
+			IdTokenService:           t.IdTokenService,
+			ProxyForPrincipal:        t.ProxyForPrincipal,
+			ExpiryThreshold:          t.ExpiryThreshold,
+			StoreOptions:             storeOptions,
+			Hooks:                    t.Hooks,
+		})
+	}
+	return accessTokens, nil
+}
+
+// processAuthorizationDetails returns the compact form of the authorization
+// details which can be specified either as a json array or as a json string
+func processAuthorizationDetails(details json.RawMessage) (string, error) {
+	if len(details) == 0 {
+		return "", nil
+	}
+	var value string
+	if err := json.Unmarshal(details, &value); err == nil {
+		details = json.RawMessage(value)
+	}
+	var items []map[string]interface{}
+	if err := json.Unmarshal(details, &items); err != nil {
+		return "", fmt.Errorf("authorization details must be a json array: %v", err)
+	}
+	var buffer bytes.Buffer
+	if err := json.Compact(&buffer, details); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// processIdTokens validates the configured id tokens and sets the default
+// scope, file and owner values. Id tokens without an explicit file are stored
+// in the id_tokens subdirectory of the token directory while relative file
+// names are resolved against the token directory
+func processIdTokens(config *Config, services []Service, tokenDir string) ([]ac.IdToken, error) {
+	if config == nil || config.IdTokens == nil {
+		return nil, nil
+	}
+
+	var idTokens []ac.IdToken
+	for name, t := range config.IdTokens {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid id token name: %q", name)
+		}
+		if t.ClientId == "" || t.RedirectUri == "" {
+			return nil, fmt.Errorf("id token %s must include client_id and redirect_uri", name)
+		}
+		service := t.Service
+		if service == "" {
+			service = services[0].Name
+		}
+		processedSvc, err := getSvc(service, services)
+		if err != nil {
+			return nil, fmt.Errorf("id token %s has unknown service: %s", name, service)
+		}
+		if err := hook.ValidateAll(t.Hooks); err != nil {
+			return nil, fmt.Errorf("invalid hook for id token %s: %v", name, err)
+		}
+		scope := t.Scope
+		if scope == "" {
+			scope = "openid"
+		}
+		fileName := t.File
+		if fileName == "" {
+			fileName = filepath.Join(tokenDir, "id_tokens", name)
+		} else if !filepath.IsAbs(fileName) {
+			fileName = filepath.Join(tokenDir, fileName)
+		}
+		idToken := ac.IdToken{
+			Name:        name,
+			FileName:    fileName,
+			Service:     service,
+			ClientId:    t.ClientId,
+			RedirectUri: t.RedirectUri,
+			Scope:       scope,
+			KeyType:     t.KeyType,
+			FullArn:     t.FullArn,
+			User:        processedSvc.User,
+			Uid:         processedSvc.Uid,
+			Gid:         processedSvc.Gid,
+			Hooks:       t.Hooks,
+		}
+		// override the uid/gid values if specified at token level
+		if t.User != "" || t.Group != "" {
+			uid, gid, _ := util.SvcAttrs(t.User, t.Group)
+			if t.User != "" {
+				idToken.User = t.User
+				idToken.Uid = uid
+			}
+			if t.Group != "" {
+				idToken.Gid = gid
+			}
+		}
+		idTokens = append(idTokens, idToken)
+	}
+	sort.Slice(idTokens, func(i, j int) bool {
+		return idTokens[i].Name < idTokens[j].Name
+	})
+	return idTokens, nil
+}
+
+// validatePatterns verifies that the given values are valid path.Match patterns
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return fmt.Errorf("empty pattern")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+func processCABundles(config *Config) ([]CABundle, error) {
+	if config == nil || config.CABundles == nil {
+		return nil, nil
+	}
+
+	var caBundles []CABundle
+	for name, b := range config.CABundles {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid ca bundle name: %q", name)
+		}
+		if (len(b.Files) == 0) == (b.ZTSBundle == "") {
+			return nil, fmt.Errorf("ca bundle %s must include exactly one of files or zts_bundle", name)
+		}
+		caBundles = append(caBundles, CABundle{
+			Name:      name,
+			Files:     b.Files,
+			ZTSBundle: b.ZTSBundle,
+		})
+	}
+	sort.Slice(caBundles, func(i, j int) bool {
+		return caBundles[i].Name < caBundles[j].Name
+	})
+	return caBundles, nil
+}
+
+// processAWSCredentials validates the configured aws credentials profiles and
+// sets the default domain and service values. The profiles are sorted by name
+// so the managed credentials file is always generated in the same order
+func processAWSCredentials(config *Config, services []Service, domain string) ([]AWSCredentials, error) {
+	if config == nil || config.AWSCredentials == nil {
+		return nil, nil
+	}
+
+	var awsCredentials []AWSCredentials
+	for profile, c := range config.AWSCredentials {
+		if profile == "" || strings.ContainsAny(profile, "[]\n") {
+			return nil, fmt.Errorf("invalid aws credentials profile name: %q", profile)
+		}
+		if c.Role == "" {
+			return nil, fmt.Errorf("aws credentials profile %s must include the aws role", profile)
+		}
+		if c.Duration != 0 && (c.Duration < 900 || c.Duration > 43200) {
+			return nil, fmt.Errorf("aws credentials profile %s duration %d must be between 900 and 43200 seconds", profile, c.Duration)
+		}
+		credsDomain := c.Domain
+		if credsDomain == "" {
+			credsDomain = domain
+		}
+		service := c.Service
+		if service == "" {
+			service = services[0].Name
+		} else if _, err := getSvc(service, services); err != nil {
+			return nil, fmt.Errorf("aws credentials profile %s has unknown service: %s", profile, service)
+		}
+		awsCredentials = append(awsCredentials, AWSCredentials{
+			Profile:    profile,
+			Domain:     credsDomain,
+			Role:       c.Role,
+			Duration:   c.Duration,
+			ExternalId: c.ExternalId,
+			Service:    service,
+		})
+	}
+	sort.Slice(awsCredentials, func(i, j int) bool {
+		return awsCredentials[i].Profile < awsCredentials[j].Profile
+	})
+	return awsCredentials, nil
+}
+
+// GetCABundleFiles returns the list of files that make up the given CA bundle.
+// The CA bundles fetched from ZTS are stored in the certificate directory. If the
+// default bundle is not configured, the Athenz CA certificate file is used
+func GetCABundleFiles(opts *Options, name string) ([]string, error) {
+	for _, b := range opts.CABundles {
+		if b.Name == name {
+			if b.ZTSBundle != "" {
+				return []string{GetZTSCABundleFile(opts, b)}, nil
+			}
+			return b.Files, nil
+		}
+	}
+	if name == "default" {
+		return []string{opts.AthenzCACertFile}, nil
+	}
+	return nil, fmt.Errorf("unknown ca bundle: %s", name)
+}
+
+// GetZTSCABundleFile returns the file where the CA bundle fetched from ZTS is stored
+func GetZTSCABundleFile(opts *Options, bundle CABundle) string {
+	return fmt.Sprintf("%s/%s.ca.bundle.pem", opts.CertDir, bundle.Name)
+}
+
+func getSvc(name string, services []Service) (Service, error) {
+	for _, s := range services {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Service{}, fmt.Errorf("%q not found in processed services", name)
+}
+
+// GetZTSDomains returns the domain prefixes for the sanDNS entries
+// falling back to the deprecated ZTSAWSDomains if ZTSDomains is not set
+func GetZTSDomains(opts *Options) []string {
+	if len(opts.ZTSDomains) == 0 {
+		return opts.ZTSAWSDomains
+	}
+	return opts.ZTSDomains
+}
+
+// GetSvcNames returns comma separated list of service names
+func GetSvcNames(svcs []Service) string {
+	var b bytes.Buffer
+	for _, svc := range svcs {
+		b.WriteString(fmt.Sprintf("%s,", svc.Name))
+	}
+	return strings.TrimSuffix(b.String(), ",")
+}
+
+// GetRoleKeyPrefix returns the prefix of the private key file used for
+// the given role certificate. Unless the role keys are generated, the role
+// certificate uses the same private key as its service certificate.
+func GetRoleKeyPrefix(opts *Options, role Role) string {
+	if !opts.GenerateRoleKey {
+		return fmt.Sprintf("%s.%s", opts.Domain, role.Service)
+	}
+	if role.Filename != "" {
+		return strings.TrimSuffix(role.Filename, ".cert.pem")
+	}
+	return role.Name
+}
+
+// GetRunsAsUidGid returns the uid/gid that the tool should
+// continue to run as based on the configured setup. For example,
+// if all services have been configured to have the same uid/gid
+// for keys and certs, then the tool can drop its access from root
+// to the specified user. If they're multiple users defined then
+// the return values would be -1/-1
+func GetRunsAsUidGid(opts *Options) (int, int) {
+	// first we want to check if the caller has specifically indicated
+	// that they want to keep the privileges and not drop to another user
+	if !opts.DropPrivileges {
+		log.Println("Configured to keep run as privileges")
+		return -1, -1
+	}
+	// if the os does not support uid/gid values, or the unix domain
+	// socket path is configured then we're not going to make any
+	// changes
+	if syscall.Getuid() == -1 || syscall.Getgid() == -1 || opts.SDSUdsPath != "" {
+		log.Println("OS does not support setuid/setgid or UDS path is configured - keeping run as privileges")
+		return -1, -1
+	}
+	uid := -1
+	gid := -1
+	for i, svc := range opts.Services {
+		if i == 0 {
+			uid = svc.Uid
+			gid = svc.Gid
+		} else {
+			// if we have a mismatch with our current
+			// set then we cannot change our run-as user
+			if svc.Uid != uid || svc.Gid != gid {
+				return -1, -1
+			}
+		}
+	}
+	// if we have a mismatch with any of our roles then
+	// we cannot change our run-as user either
+	for _, role := range opts.Roles {
+		if role.Uid != uid || role.Gid != gid {
+			return -1, -1
+		}
+	}
+	// if our uid is equivalent to our running process uid
+	// there is no need to change it
+	if uid == syscall.Getuid() {
+		uid = -1
+	}
+	// if our gid is equivalent to our running process gid
+	// there is no need to change it
+	if gid == syscall.Getgid() {
+		gid = -1
+	}
+	log.Printf("RunAs configuration - uid: %d, gid: %d\n", uid, gid)
+	return uid, gid
+}
+
+func NewOptions(config *Config, configAccount *ConfigAccount, profileConfig *AccessProfileConfig, siaDir, siaVersion string, useRegionalSTS bool, region string) (*Options, error) {
+
+	opts, err := setOptions(config, configAccount, profileConfig, siaDir, siaVersion)
+	if err != nil {
+		log.Printf("Unable to formulate options, error: %v\n", err)
+		return nil, err
+	}
+
+	opts.Region = region
+	if useRegionalSTS {
+		opts.UseRegionalSTS = true
+	}
+	return opts, nil
+}
+
+func nonZeroValue(t, base float64) float64 {
+	if t != 0 {
+		return t
+	}
+	return base
+}
@@ -0,0 +1,244 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package output
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/AthenZ/athenz/libs/go/sia/futil"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+const (
+	FormatPemBundle  = "pem_bundle" //private key, certificate and ca certificates in a single pem file
+	FormatPKCS12     = "pkcs12"     //pkcs#12 file with the private key, certificate and ca certificates
+	FormatTrustStore = "truststore" //java keystore with the ca certificates as trusted entries
+)
+
+// DefaultTrustStorePassword is the password used for the truststore
+// integrity check when no password file is configured
+const DefaultTrustStorePassword = "changeit"
+
+// Output represents an additional file generated from the service or role
+// key and certificate every time the certificate is updated
+type Output struct {
+	Format       string      `json:"format"`                  //pem_bundle, pkcs12 or truststore
+	Filename     string      `json:"filename"`                //output file, relative to the certs directory unless absolute
+	PasswordFile string      `json:"password_file,omitempty"` //file containing the pkcs12 or truststore password
+	User         string      `json:"user,omitempty"`          //user owner on the file, defaults to the service or role owner
+	Group        string      `json:"group,omitempty"`         //group owner on the file, defaults to the service or role group
+	Mode         string      `json:"mode,omitempty"`          //file mode in octal e.g. 0440
+	Uid          int         `json:"-"`                       //resolved user owner
+	Gid          int         `json:"-"`                       //resolved group owner
+	FileMode     os.FileMode `json:"-"`                       //resolved file mode
+}
+
+// Validate verifies that the output is correctly configured
+func (o Output) Validate() error {
+	switch o.Format {
+	case FormatPemBundle, FormatTrustStore:
+	case FormatPKCS12:
+		if o.PasswordFile == "" {
+			return fmt.Errorf("pkcs12 output %s requires a password_file", o.Filename)
+		}
+	default:
+		return fmt.Errorf("unknown output format: %q", o.Format)
+	}
+	if o.Filename == "" {
+		return fmt.Errorf("%s output requires a filename", o.Format)
+	}
+	if o.Mode != "" {
+		if _, err := parseMode(o.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateAll verifies that all the given outputs are correctly configured
+func ValidateAll(outputs []Output) error {
+	for _, o := range outputs {
+		if err := o.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve returns the outputs with their ownership and mode resolved. The
+// outputs are owned by the given uid and gid unless their user or group is
+// specified. The truststore is readable by everyone by default, while the
+// outputs that include the private key default to the given file mode. An
+// error is returned if the user or group of any output does not exist.
+func Resolve(outputs []Output, uid, gid, fileMode int) ([]Output, error) {
+	var resolved []Output
+	for _, o := range outputs {
+		o.Uid, o.Gid = uid, gid
+		o.FileMode = os.FileMode(fileMode)
+		if o.Format == FormatTrustStore {
+			o.FileMode = 0444
+		}
+		if o.User != "" || o.Group != "" {
+			oUid, oGid, err := util.UserGroupIds(o.User, o.Group)
+			if err != nil {
+				return nil, fmt.Errorf("invalid owner for %s output %s: %v", o.Format, o.Filename, err)
+			}
+			if o.User != "" {
+				o.Uid = oUid
+			}
+			if o.Group != "" {
+				o.Gid = oGid
+			}
+		}
+		if o.Mode != "" {
+			if mode, err := parseMode(o.Mode); err == nil {
+				o.FileMode = mode
+			}
+		}
+		resolved = append(resolved, o)
+	}
+	return resolved, nil
+}
+
+// FileName returns the path of the output file
+func (o Output) FileName(certDir string) string {
+	if filepath.IsAbs(o.Filename) {
+		return o.Filename
+	}
+	return filepath.Join(certDir, o.Filename)
+}
+
+// Write generates the outputs from the private key, certificate and ca
+// certificates in pem format and replaces all the output files as a single
+// unit, restoring the previous files from the backup directory on failure
+func Write(name string, outputs []Output, key, cert, caCerts []byte, certDir, backupDir string) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+	var files []futil.File
+	for _, o := range outputs {
+		data, err := o.generate(key, cert, caCerts)
+		if err != nil {
+			return fmt.Errorf("unable to generate %s output for %s, error: %v", o.Format, name, err)
+		}
+		fileName := o.FileName(certDir)
+		log.Printf("writing %s output for %s to %s\n", o.Format, name, fileName)
+		files = append(files, util.OwnedFile(fileName, data, o.Uid, o.Gid, o.FileMode))
+	}
+	if backupDir != "" {
+		if err := util.EnsureBackUpDir(backupDir); err != nil {
+			return err
+		}
+	}
+	return futil.WriteFiles(files, backupDir, nil)
+}
+
+func (o Output) generate(key, cert, caCerts []byte) ([]byte, error) {
+	switch o.Format {
+	case FormatPemBundle:
+		var buf bytes.Buffer
+		buf.Write(key)
+		buf.Write(cert)
+		buf.Write(caCerts)
+		return buf.Bytes(), nil
+	case FormatPKCS12:
+		password, err := readPassword(o.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := util.PrivateKeyFromPEMBytes(key)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := parseCertificates(cert)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("no certificate found")
+		}
+		cas, err := parseCertificates(caCerts)
+		if err != nil {
+			return nil, err
+		}
+		return EncodePKCS12(privateKey, certs[0], append(certs[1:], cas...), certs[0].Subject.CommonName, password)
+	case FormatTrustStore:
+		password := DefaultTrustStorePassword
+		if o.PasswordFile != "" {
+			var err error
+			if password, err = readPassword(o.PasswordFile); err != nil {
+				return nil, err
+			}
+		}
+		cas, err := parseCertificates(caCerts)
+		if err != nil {
+			return nil, err
+		}
+		if len(cas) == 0 {
+			return nil, fmt.Errorf("no ca certificates found")
+		}
+		return EncodeTrustStore(cas, password)
+	}
+	return nil, fmt.Errorf("unknown output format: %q", o.Format)
+}
+
+func readPassword(passwordFile string) (string, error) {
+	data, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password file %s, error: %v", passwordFile, err)
+	}
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", passwordFile)
+	}
+	return password, nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func parseMode(mode string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, fmt.Errorf("invalid output file mode: %q", mode)
+	}
+	return os.FileMode(value), nil
+}
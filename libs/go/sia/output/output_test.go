@@ -0,0 +1,309 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package output
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pkcs12"
+)
+
+type testCredentials struct {
+	key     *ecdsa.PrivateKey
+	cert    *x509.Certificate
+	ca      *x509.Certificate
+	keyPem  []byte
+	certPem []byte
+	caPem   []byte
+}
+
+func newTestCredentials(t *testing.T) testCredentials {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Athenz CA"},
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.Nil(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "sports.api"},
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err = x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	return testCredentials{
+		key:     key,
+		cert:    cert,
+		ca:      ca,
+		keyPem:  []byte(util.PrivatePem(key)),
+		certPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		caPem:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}),
+	}
+}
+
+// decodeTrustStore verifies the keystore digest and returns the certificates
+func decodeTrustStore(t *testing.T, data []byte, password string) []*x509.Certificate {
+	require.True(t, len(data) > 20)
+	contents, digest := data[:len(data)-20], data[len(data)-20:]
+	require.Equal(t, trustStoreDigest(contents, password), digest)
+
+	r := bytes.NewReader(contents)
+	var magic, version, count uint32
+	require.Nil(t, binary.Read(r, binary.BigEndian, &magic))
+	require.Nil(t, binary.Read(r, binary.BigEndian, &version))
+	require.Nil(t, binary.Read(r, binary.BigEndian, &count))
+	assert.Equal(t, uint32(jksMagic), magic)
+	assert.Equal(t, uint32(jksVersion), version)
+	readUTF := func() string {
+		var size uint16
+		require.Nil(t, binary.Read(r, binary.BigEndian, &size))
+		value := make([]byte, size)
+		_, err := r.Read(value)
+		require.Nil(t, err)
+		return string(value)
+	}
+	var certs []*x509.Certificate
+	for i := uint32(0); i < count; i++ {
+		var tag, size uint32
+		var timestamp uint64
+		require.Nil(t, binary.Read(r, binary.BigEndian, &tag))
+		assert.Equal(t, uint32(jksTrustedCertTag), tag)
+		assert.NotEmpty(t, readUTF())
+		require.Nil(t, binary.Read(r, binary.BigEndian, &timestamp))
+		assert.Equal(t, "X.509", readUTF())
+		require.Nil(t, binary.Read(r, binary.BigEndian, &size))
+		der := make([]byte, size)
+		_, err := r.Read(der)
+		require.Nil(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.Nil(t, err)
+		certs = append(certs, cert)
+	}
+	assert.Equal(t, 0, r.Len())
+	return certs
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, ValidateAll([]Output{
+		{Format: FormatPemBundle, Filename: "sports.api.bundle.pem"},
+		{Format: FormatPKCS12, Filename: "/var/lib/sia/certs/sports.api.p12", PasswordFile: "/etc/sia/p12.pass", Mode: "0440"},
+		{Format: FormatTrustStore, Filename: "athenz.truststore.jks"},
+	}))
+	assert.NotNil(t, Output{Format: "jceks", Filename: "sports.api.jceks"}.Validate())
+	assert.NotNil(t, Output{Format: FormatPemBundle}.Validate())
+	assert.NotNil(t, Output{Format: FormatPKCS12, Filename: "sports.api.p12"}.Validate())
+	assert.NotNil(t, Output{Format: FormatPemBundle, Filename: "sports.api.bundle.pem", Mode: "0999"}.Validate())
+	assert.NotNil(t, Output{Format: FormatPemBundle, Filename: "sports.api.bundle.pem", Mode: "01777"}.Validate())
+}
+
+func TestResolve(t *testing.T) {
+	outputs, err := Resolve([]Output{
+		{Format: FormatPemBundle, Filename: "sports.api.bundle.pem"},
+		{Format: FormatTrustStore, Filename: "athenz.truststore.jks"},
+		{Format: FormatTrustStore, Filename: "athenz.truststore.jks", Mode: "0440"},
+		{Format: FormatPemBundle, Filename: "sports.api.root.pem", User: "root", Group: "root"},
+	}, 1001, 1002, 0440)
+	require.Nil(t, err)
+	require.Equal(t, 4, len(outputs))
+	assert.Equal(t, 1001, outputs[0].Uid)
+	assert.Equal(t, 1002, outputs[0].Gid)
+	assert.Equal(t, os.FileMode(0440), outputs[0].FileMode)
+	assert.Equal(t, os.FileMode(0444), outputs[1].FileMode)
+	assert.Equal(t, os.FileMode(0440), outputs[2].FileMode)
+	assert.Equal(t, 0, outputs[3].Uid)
+	assert.Equal(t, 0, outputs[3].Gid)
+
+	// unknown owners are rejected instead of falling back to the defaults
+	_, err = Resolve([]Output{{Format: FormatPemBundle, Filename: "sports.api.bundle.pem", User: "unknown-sia-user"}}, 1001, 1002, 0440)
+	assert.NotNil(t, err)
+	_, err = Resolve([]Output{{Format: FormatPemBundle, Filename: "sports.api.bundle.pem", Group: "unknown-sia-group"}}, 1001, 1002, 0440)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "/var/lib/sia/certs/sports.api.bundle.pem", outputs[0].FileName("/var/lib/sia/certs"))
+	assert.Equal(t, "/opt/app/sports.api.p12", Output{Filename: "/opt/app/sports.api.p12"}.FileName("/var/lib/sia/certs"))
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	creds := newTestCredentials(t)
+	data, err := EncodePKCS12(creds.key, creds.cert, []*x509.Certificate{creds.ca}, "sports.api", "secret")
+	require.Nil(t, err)
+
+	blocks, err := pkcs12.ToPEM(data, "secret")
+	require.Nil(t, err)
+	require.Equal(t, 3, len(blocks))
+	assert.Equal(t, creds.cert.Raw, blocks[0].Bytes)
+	assert.Equal(t, "sports.api", blocks[0].Headers["friendlyName"])
+	assert.Equal(t, creds.ca.Raw, blocks[1].Bytes)
+	key, err := x509.ParseECPrivateKey(blocks[2].Bytes)
+	require.Nil(t, err)
+	assert.True(t, creds.key.Equal(key))
+	assert.Equal(t, blocks[0].Headers["localKeyId"], blocks[2].Headers["localKeyId"])
+
+	_, err = pkcs12.ToPEM(data, "invalid")
+	assert.NotNil(t, err)
+}
+
+func TestEncodeTrustStore(t *testing.T) {
+	creds := newTestCredentials(t)
+	data, err := EncodeTrustStore([]*x509.Certificate{creds.ca, creds.cert}, "changeit")
+	require.Nil(t, err)
+	certs := decodeTrustStore(t, data, "changeit")
+	require.Equal(t, 2, len(certs))
+	assert.Equal(t, creds.ca.Raw, certs[0].Raw)
+	assert.Equal(t, creds.cert.Raw, certs[1].Raw)
+	assert.NotEqual(t, trustStoreDigest(data[:len(data)-20], "invalid"), data[len(data)-20:])
+}
+
+// TestEncodePKCS12OpenSSL verifies that openssl can read the pkcs12 file
+func TestEncodePKCS12OpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not available")
+	}
+	creds := newTestCredentials(t)
+	data, err := EncodePKCS12(creds.key, creds.cert, []*x509.Certificate{creds.ca}, "sports.api", "secret")
+	require.Nil(t, err)
+	p12File := filepath.Join(t.TempDir(), "sports.api.p12")
+	require.Nil(t, os.WriteFile(p12File, data, 0400))
+
+	out, err := exec.Command(openssl, "pkcs12", "-info", "-in", p12File, "-passin", "pass:secret", "-nodes").CombinedOutput()
+	require.Nil(t, err, string(out))
+	assert.Contains(t, string(out), "friendlyName: sports.api")
+	var certs [][]byte
+	var keys [][]byte
+	for rest := out; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, block.Bytes)
+		} else {
+			keys = append(keys, block.Bytes)
+		}
+	}
+	assert.Equal(t, [][]byte{creds.cert.Raw, creds.ca.Raw}, certs)
+	require.Equal(t, 1, len(keys))
+	key, err := x509.ParsePKCS8PrivateKey(keys[0])
+	require.Nil(t, err)
+	assert.True(t, creds.key.Equal(key))
+
+	// the mac verification fails with the wrong password
+	out, err = exec.Command(openssl, "pkcs12", "-info", "-in", p12File, "-passin", "pass:invalid", "-nodes").CombinedOutput()
+	assert.NotNil(t, err, string(out))
+}
+
+// TestEncodeTrustStoreKeytool verifies that the java keytool can read the truststore
+func TestEncodeTrustStoreKeytool(t *testing.T) {
+	keytool, err := exec.LookPath("keytool")
+	if err != nil {
+		t.Skip("keytool is not available")
+	}
+	creds := newTestCredentials(t)
+	data, err := EncodeTrustStore([]*x509.Certificate{creds.ca, creds.cert}, "changeit")
+	require.Nil(t, err)
+	jksFile := filepath.Join(t.TempDir(), "athenz.truststore.jks")
+	require.Nil(t, os.WriteFile(jksFile, data, 0444))
+
+	out, err := exec.Command(keytool, "-list", "-keystore", jksFile, "-storetype", "JKS", "-storepass", "changeit").CombinedOutput()
+	require.Nil(t, err, string(out))
+	assert.Contains(t, string(out), "athenz-ca-0")
+	assert.Contains(t, string(out), "athenz-ca-1")
+	assert.Contains(t, string(out), "trustedCertEntry")
+
+	// the integrity check fails with the wrong password
+	out, err = exec.Command(keytool, "-list", "-keystore", jksFile, "-storetype", "JKS", "-storepass", "invalid").CombinedOutput()
+	assert.NotNil(t, err, string(out))
+}
+
+func TestWrite(t *testing.T) {
+	creds := newTestCredentials(t)
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "p12.pass")
+	require.Nil(t, os.WriteFile(passwordFile, []byte("secret\n"), 0400))
+	uid, gid := util.ExecIdCommand("-u"), util.ExecIdCommand("-g")
+
+	outputs, err := Resolve([]Output{
+		{Format: FormatPemBundle, Filename: "sports.api.bundle.pem"},
+		{Format: FormatPKCS12, Filename: filepath.Join(dir, "sports.api.p12"), PasswordFile: passwordFile, Mode: "0400"},
+		{Format: FormatTrustStore, Filename: "athenz.truststore.jks"},
+	}, uid, gid, 0440)
+	require.Nil(t, err)
+	require.Nil(t, Write("sports.api", outputs, creds.keyPem, creds.certPem, creds.caPem, dir, filepath.Join(dir, "backup")))
+
+	data, err := os.ReadFile(filepath.Join(dir, "sports.api.bundle.pem"))
+	require.Nil(t, err)
+	assert.Equal(t, string(creds.keyPem)+string(creds.certPem)+string(creds.caPem), string(data))
+	info, err := os.Stat(filepath.Join(dir, "sports.api.bundle.pem"))
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0440), info.Mode().Perm())
+
+	data, err = os.ReadFile(filepath.Join(dir, "sports.api.p12"))
+	require.Nil(t, err)
+	blocks, err := pkcs12.ToPEM(data, "secret")
+	require.Nil(t, err)
+	assert.Equal(t, 3, len(blocks))
+	info, err = os.Stat(filepath.Join(dir, "sports.api.p12"))
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0400), info.Mode().Perm())
+
+	data, err = os.ReadFile(filepath.Join(dir, "athenz.truststore.jks"))
+	require.Nil(t, err)
+	certs := decodeTrustStore(t, data, DefaultTrustStorePassword)
+	require.Equal(t, 1, len(certs))
+	assert.Equal(t, creds.ca.Raw, certs[0].Raw)
+	info, err = os.Stat(filepath.Join(dir, "athenz.truststore.jks"))
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0444), info.Mode().Perm())
+
+	// outputs are not written if any of them cannot be generated
+	require.Nil(t, os.Remove(passwordFile))
+	assert.NotNil(t, Write("sports.api", outputs, creds.keyPem, creds.certPem, creds.caPem, dir, ""))
+	assert.NotNil(t, Write("sports.api", outputs[2:], creds.keyPem, creds.certPem, nil, dir, ""))
+}
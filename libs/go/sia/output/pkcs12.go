@@ -0,0 +1,338 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package output
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+	"unicode/utf16"
+)
+
+// pkcs12Iterations is the iteration count used for the key derivation of
+// both the private key encryption and the integrity mac (openssl default)
+const pkcs12Iterations = 2048
+
+var (
+	oidDataContentType            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509Certificate            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyId                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue //explicitly tagged [0] content
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue     //explicitly tagged [0] value
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue //set of attribute values
+}
+
+type certBag struct {
+	Id   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// EncodePKCS12 returns the PKCS#12 file with the private key and the
+// certificate along with the ca certificates. The private key is encrypted
+// with pbeWithSHAAnd3-KeyTripleDES-CBC and the contents are protected with
+// a SHA-1 HMAC, which are supported by all java versions and openssl. The
+// friendly name is used as the alias of the key entry in the java keystore.
+func EncodePKCS12(key crypto.PrivateKey, cert *x509.Certificate, caCerts []*x509.Certificate, friendlyName, password string) ([]byte, error) {
+	bmpPassword := bmpString(password)
+	localKeyId := sha1.Sum(cert.Raw)
+	attributes, err := bagAttributes(friendlyName, localKeyId[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificates are stored as plain data while the private key
+	// is encrypted in its own shrouded key bag
+	bags := make([]safeBag, 0, len(caCerts)+1)
+	bag, err := newCertBag(cert, attributes)
+	if err != nil {
+		return nil, err
+	}
+	bags = append(bags, bag)
+	for _, caCert := range caCerts {
+		if bag, err = newCertBag(caCert, nil); err != nil {
+			return nil, err
+		}
+		bags = append(bags, bag)
+	}
+	certContent, err := dataContentInfo(bags)
+	if err != nil {
+		return nil, err
+	}
+	bag, err = newShroudedKeyBag(key, bmpPassword, attributes)
+	if err != nil {
+		return nil, err
+	}
+	keyContent, err := dataContentInfo([]safeBag{bag})
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]contentInfo{certContent, keyContent})
+	if err != nil {
+		return nil, err
+	}
+	macSalt, err := randomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	macKey := pbkdf(sha1.New, 20, 64, macSalt, bmpPassword, pkcs12Iterations, 3, 20)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authSafe)
+
+	content, err := explicitOctetString(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPdu{
+		Version: 3,
+		AuthSafe: contentInfo{
+			ContentType: oidDataContentType,
+			Content:     content,
+		},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+func bagAttributes(friendlyName string, localKeyId []byte) ([]pkcs12Attribute, error) {
+	keyId, err := asn1.Marshal(localKeyId)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{
+		Id:    oidLocalKeyId,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: keyId},
+	}}
+	if friendlyName != "" {
+		bmpName := bmpString(friendlyName)
+		name, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagBMPString, Bytes: bmpName[:len(bmpName)-2]})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{
+			Id:    oidFriendlyName,
+			Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: name},
+		})
+	}
+	return attributes, nil
+}
+
+func newCertBag(cert *x509.Certificate, attributes []pkcs12Attribute) (safeBag, error) {
+	data, err := asn1.Marshal(certBag{Id: oidX509Certificate, Data: cert.Raw})
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{Id: oidCertBag, Value: explicitValue(data), Attributes: attributes}, nil
+}
+
+func newShroudedKeyBag(key crypto.PrivateKey, bmpPassword []byte, attributes []pkcs12Attribute) (safeBag, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return safeBag{}, err
+	}
+	salt, err := randomBytes(8)
+	if err != nil {
+		return safeBag{}, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	encKey := pbkdf(sha1.New, 20, 64, salt, bmpPassword, pkcs12Iterations, 1, 24)
+	iv := pbkdf(sha1.New, 20, 64, salt, bmpPassword, pkcs12Iterations, 2, 8)
+	block, err := des.NewTripleDESCipher(encKey)
+	if err != nil {
+		return safeBag{}, err
+	}
+	padding := block.BlockSize() - len(pkcs8)%block.BlockSize()
+	encrypted := make([]byte, len(pkcs8)+padding)
+	copy(encrypted, pkcs8)
+	for i := len(pkcs8); i < len(encrypted); i++ {
+		encrypted[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	data, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBEWithSHAAnd3KeyTripleDES,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{Id: oidPKCS8ShroudedKeyBag, Value: explicitValue(data), Attributes: attributes}, nil
+}
+
+// dataContentInfo returns the content info with the given safe bags as data
+func dataContentInfo(bags []safeBag) (contentInfo, error) {
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	content, err := explicitOctetString(safeContents)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidDataContentType, Content: content}, nil
+}
+
+func explicitOctetString(data []byte) (asn1.RawValue, error) {
+	octets, err := asn1.Marshal(data)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	return explicitValue(octets), nil
+}
+
+func explicitValue(data []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: data}
+}
+
+func randomBytes(size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return nil, errors.New("unable to generate random salt")
+	}
+	return data, nil
+}
+
+// bmpString returns the password encoded as a null terminated big-endian
+// UTF-16 string as required by the PKCS#12 key derivation function
+func bmpString(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	data := make([]byte, 0, len(encoded)*2+2)
+	for _, c := range encoded {
+		data = append(data, byte(c>>8), byte(c))
+	}
+	return append(data, 0, 0)
+}
+
+// pbkdf implements the PKCS#12 key derivation function defined in RFC 7292
+// appendix B.2 for the given hash function with output size u and block
+// size v. The id selects the key material: 1 for the encryption key, 2 for
+// the initialization vector and 3 for the mac key.
+func pbkdf(h func() hash.Hash, u, v int, salt, password []byte, iterations int, id byte, size int) []byte {
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	fill := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		filled := make([]byte, v*((len(data)+v-1)/v))
+		for i := range filled {
+			filled[i] = data[i%len(data)]
+		}
+		return filled
+	}
+	input := append(fill(salt), fill(password)...)
+
+	var result []byte
+	for len(result) < size {
+		digest := h()
+		digest.Write(d)
+		digest.Write(input)
+		a := digest.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			digest.Reset()
+			digest.Write(a)
+			a = digest.Sum(a[:0])
+		}
+		result = append(result, a...)
+		if len(result) >= size {
+			break
+		}
+		// every v byte block of the input is set to (block + b + 1) mod 2^(v*8)
+		// where b is the digest repeated to fill v bytes
+		b := make([]byte, v)
+		for i := range b {
+			b[i] = a[i%u]
+		}
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return result[:size]
+}
@@ -0,0 +1,88 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package output
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic          = 0xfeedfeed
+	jksVersion        = 2
+	jksTrustedCertTag = 2
+	// jksIntegrityText is mixed into the keystore digest by the java implementation
+	jksIntegrityText = "Mighty Aphrodite"
+)
+
+// EncodeTrustStore returns the java keystore (JKS) with the given ca
+// certificates as trusted certificate entries. The aliases of the entries
+// are athenz-ca-<n> and the keystore integrity is protected with the
+// SHA-1 digest keyed with the password as computed by the java keytool.
+func EncodeTrustStore(caCerts []*x509.Certificate, password string) ([]byte, error) {
+	var buf bytes.Buffer
+	writeUint32(&buf, jksMagic)
+	writeUint32(&buf, jksVersion)
+	writeUint32(&buf, uint32(len(caCerts)))
+	timestamp := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	for i, cert := range caCerts {
+		writeUint32(&buf, jksTrustedCertTag)
+		if err := writeUTF(&buf, fmt.Sprintf("athenz-ca-%d", i)); err != nil {
+			return nil, err
+		}
+		binary.Write(&buf, binary.BigEndian, timestamp)
+		if err := writeUTF(&buf, "X.509"); err != nil {
+			return nil, err
+		}
+		writeUint32(&buf, uint32(len(cert.Raw)))
+		buf.Write(cert.Raw)
+	}
+	buf.Write(trustStoreDigest(buf.Bytes(), password))
+	return buf.Bytes(), nil
+}
+
+// trustStoreDigest returns the SHA-1 digest of the password characters in
+// big-endian order, the integrity text and the keystore contents
+func trustStoreDigest(data []byte, password string) []byte {
+	digest := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		digest.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	digest.Write([]byte(jksIntegrityText))
+	digest.Write(data)
+	return digest.Sum(nil)
+}
+
+func writeUint32(buf *bytes.Buffer, value uint32) {
+	binary.Write(buf, binary.BigEndian, value)
+}
+
+// writeUTF writes the string in the java modified UTF-8 format which matches
+// the standard encoding for the ascii aliases and certificate type
+func writeUTF(buf *bytes.Buffer, value string) error {
+	if len(value) > 0xffff {
+		return fmt.Errorf("string too long: %d", len(value))
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.WriteString(value)
+	return nil
+}
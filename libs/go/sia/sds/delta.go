@@ -0,0 +1,206 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	envoyCore "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyDiscovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	envoySecret "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// DeltaSecrets implements the incremental variant of the secret discovery
+// service. The client subscribes and unsubscribes individual resources and
+// after each certificate update only the secrets that have changed since
+// they were last sent are pushed to the client. Secrets that are no longer
+// available (e.g. the role was removed from the configuration) are reported
+// as removed resources.
+func (handler *ServerHandler) DeltaSecrets(stream envoySecret.SecretDiscoveryService_DeltaSecretsServer) error {
+
+	sub := handler.subscribeToCertUpdates()
+	defer handler.removeSubscriber(sub)
+
+	clientInfo := ClientInfoFromContext(stream.Context())
+	log.Printf("DeltaSecrets: %s: client info: %v\n", sub.GetId(), clientInfo)
+
+	reqChan := make(chan *envoyDiscovery.DeltaDiscoveryRequest, 1)
+	errChan := make(chan error, 1)
+
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				log.Printf("DeltaSecrets: %s: receiving error: %v\n", sub.GetId(), err)
+				if status.Code(err) == codes.Canceled || errors.Is(err, io.EOF) {
+					log.Printf("DeltaSecrets: %s: resetting error...\n", sub.GetId())
+					err = nil
+				}
+				errChan <- err
+				return
+			}
+			reqChan <- req
+		}
+	}()
+
+	var typeUrl string
+	var node *envoyCore.Node
+	for {
+		var resourceNames []string
+		select {
+		case newReq := <-reqChan:
+
+			log.Printf("DeltaSecrets: %s: processing request: nonce: %s, subscribe: %v, unsubscribe: %v\n",
+				sub.GetId(), newReq.GetResponseNonce(), newReq.GetResourceNamesSubscribe(), newReq.GetResourceNamesUnsubscribe())
+
+			// if envoy reported any errors then we're just going to log them,
+			// but we won't stop processing any requests or close connections
+			if newReq.ErrorDetail != nil {
+				log.Printf("DeltaSecrets: %s: envoy reported error: %s\n", sub.GetId(), newReq.ErrorDetail.Message)
+			}
+
+			// only acks and nacks carry the nonce of our last response, so
+			// validate it when present and if mismatch, ignore the request.
+			// requests that change the subscriptions without acking a
+			// response have an empty nonce and are always processed
+			if newReq.ResponseNonce != "" && !sub.ValidateResponseNonce(newReq.ResponseNonce) {
+				continue
+			}
+
+			// the node and type url are only required in the first request
+			if newReq.GetNode() != nil {
+				node = newReq.GetNode()
+			}
+			if newReq.GetTypeUrl() != "" {
+				typeUrl = newReq.GetTypeUrl()
+			}
+
+			// when the client reconnects it reports the versions of the
+			// resources it already has, so we don't need to send them again
+			// unless they have changed
+			for name, version := range newReq.GetInitialResourceVersions() {
+				sub.Subscribe([]string{name})
+				sub.SetResourceVersion(name, version)
+			}
+			sub.Subscribe(newReq.GetResourceNamesSubscribe())
+			sub.Unsubscribe(newReq.GetResourceNamesUnsubscribe())
+
+			// we only need to respond if there are new subscriptions,
+			// otherwise this is just an ack/nack of our last response
+			resourceNames = newReq.GetResourceNamesSubscribe()
+			for name := range newReq.GetInitialResourceVersions() {
+				resourceNames = append(resourceNames, name)
+			}
+			if len(resourceNames) == 0 {
+				continue
+			}
+
+		case <-sub.GetCertUpdates():
+			sub.IncrementVersion()
+			// in case we receive an update before an actual request is processed
+			// we should ignore the update
+			if typeUrl == "" {
+				continue
+			}
+			resourceNames = sub.GetResourceNames()
+
+		case err := <-errChan:
+			return err
+		}
+
+		resp, err := handler.getDeltaResponse(sub, clientInfo, node, typeUrl, resourceNames)
+		if err != nil {
+			log.Printf("DeltaSecrets: %s: unable to generate delta response: %v\n", sub.GetId(), err)
+			return err
+		}
+
+		// if none of the secrets have changed there is nothing to push
+		if len(resp.Resources) == 0 && len(resp.RemovedResources) == 0 {
+			log.Printf("DeltaSecrets: %s: no secrets changed\n", sub.GetId())
+			continue
+		}
+
+		log.Printf("DeltaSecrets: %s: pushing %d updated and %d removed secrets to envoy...\n", sub.GetId(), len(resp.Resources), len(resp.RemovedResources))
+		if err := stream.Send(resp); err != nil {
+			log.Printf("DeltaSecrets: %s: secret send error: %v\n", sub.GetId(), err)
+			return err
+		}
+
+		// update the last nonce successfully sent to client
+		sub.SetResponseNonce(resp.GetNonce())
+	}
+}
+
+// getDeltaResponse generates the incremental response for the given
+// subscribed resources. Only the secrets whose version is different
+// from the version last sent to the subscriber are included while the
+// secrets that were sent before but are no longer available are
+// reported as removed.
+func (handler *ServerHandler) getDeltaResponse(sub *Subscriber, info ClientInfo, node *envoyCore.Node, typeUrl string, resourceNames []string) (*envoyDiscovery.DeltaDiscoveryResponse, error) {
+
+	resp := &envoyDiscovery.DeltaDiscoveryResponse{
+		TypeUrl:           typeUrl,
+		SystemVersionInfo: sub.GetVersionInfo(),
+	}
+
+	// provide a nonce for streaming requests
+	var err error
+	if resp.Nonce, err = util.Nonce(); err != nil {
+		return nil, err
+	}
+
+	for _, spiffeUri := range resourceNames {
+		secret, err := handler.getResourceSecret(spiffeUri, info, node, sub.GetId())
+		if err != nil {
+			return nil, err
+		}
+		lastVersion := sub.GetResourceVersion(spiffeUri)
+		if secret == nil {
+			if lastVersion != "" {
+				resp.RemovedResources = append(resp.RemovedResources, spiffeUri)
+				sub.SetResourceVersion(spiffeUri, "")
+			}
+			continue
+		}
+		version := secretVersion(secret)
+		if version == lastVersion {
+			continue
+		}
+		resp.Resources = append(resp.Resources, &envoyDiscovery.Resource{
+			Name:     spiffeUri,
+			Version:  version,
+			Resource: secret,
+		})
+		sub.SetResourceVersion(spiffeUri, version)
+	}
+	return resp, nil
+}
+
+// secretVersion returns the version of the secret based on its contents
+// so the same secret always has the same version even across restarts
+func secretVersion(secret *anypb.Any) string {
+	sum := sha256.Sum256(secret.GetValue())
+	return hex.EncodeToString(sum[:8])
+}
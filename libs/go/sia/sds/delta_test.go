@@ -0,0 +1,247 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	envoyDiscovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+)
+
+const secretTypeUrl = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+
+type fakeDeltaStream struct {
+	grpc.ServerStream
+	requests  chan *envoyDiscovery.DeltaDiscoveryRequest
+	responses chan *envoyDiscovery.DeltaDiscoveryResponse
+}
+
+func newFakeDeltaStream() *fakeDeltaStream {
+	return &fakeDeltaStream{
+		requests:  make(chan *envoyDiscovery.DeltaDiscoveryRequest, 1),
+		responses: make(chan *envoyDiscovery.DeltaDiscoveryResponse, 1),
+	}
+}
+
+func (stream *fakeDeltaStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *fakeDeltaStream) Recv() (*envoyDiscovery.DeltaDiscoveryRequest, error) {
+	req, ok := <-stream.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (stream *fakeDeltaStream) Send(resp *envoyDiscovery.DeltaDiscoveryResponse) error {
+	stream.responses <- resp
+	return nil
+}
+
+func (stream *fakeDeltaStream) expectResponse(test *testing.T) *envoyDiscovery.DeltaDiscoveryResponse {
+	select {
+	case resp := <-stream.responses:
+		return resp
+	case <-time.After(5 * time.Second):
+		test.Fatalf("no delta response received")
+	}
+	return nil
+}
+
+func (stream *fakeDeltaStream) expectNoResponse(test *testing.T) {
+	select {
+	case resp := <-stream.responses:
+		test.Fatalf("unexpected delta response received: %v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func deltaTestOptions() *options.Options {
+	return &options.Options{
+		Domain:           "athenz",
+		KeyDir:           "data",
+		CertDir:          "data",
+		AthenzCACertFile: "data/ca.cert.pem",
+		Services:         []options.Service{{Name: "api"}},
+	}
+}
+
+func TestDeltaSecrets(test *testing.T) {
+	handler := NewServerHandler(deltaTestOptions())
+	stream := newFakeDeltaStream()
+	result := make(chan error, 1)
+	go func() {
+		result <- handler.DeltaSecrets(stream)
+	}()
+
+	stream.requests <- &envoyDiscovery.DeltaDiscoveryRequest{
+		TypeUrl:                secretTypeUrl,
+		ResourceNamesSubscribe: []string{"spiffe://athenz/sa/api", "spiffe://athenz/ca/default"},
+	}
+	resp := stream.expectResponse(test)
+	if len(resp.Resources) != 2 {
+		test.Fatalf("expected 2 resources, received %d", len(resp.Resources))
+	}
+	if resp.TypeUrl != secretTypeUrl || resp.Nonce == "" {
+		test.Errorf("invalid delta response: type url %s, nonce %s", resp.TypeUrl, resp.Nonce)
+	}
+
+	// ack of the response does not generate a new response
+	stream.requests <- &envoyDiscovery.DeltaDiscoveryRequest{ResponseNonce: resp.Nonce}
+	stream.expectNoResponse(test)
+
+	// certificate update without any changes does not push anything
+	handler.NotifySubscribers()
+	stream.expectNoResponse(test)
+
+	// once the service is removed from the configuration its
+	// certificate is reported as removed while the CA bundle
+	// has not changed so it is not included
+	opts := deltaTestOptions()
+	opts.Services = []options.Service{{Name: "backend"}}
+	handler.UpdateOptions(opts)
+	resp = stream.expectResponse(test)
+	if len(resp.Resources) != 0 {
+		test.Errorf("expected no updated resources, received %d", len(resp.Resources))
+	}
+	if len(resp.RemovedResources) != 1 || resp.RemovedResources[0] != "spiffe://athenz/sa/api" {
+		test.Errorf("unexpected removed resources: %v", resp.RemovedResources)
+	}
+	if resp.SystemVersionInfo != "2" {
+		test.Errorf("unexpected system version info: %s", resp.SystemVersionInfo)
+	}
+
+	close(stream.requests)
+	if err := <-result; err != nil {
+		test.Errorf("delta stream returned an error: %v", err)
+	}
+}
+
+func TestDeltaSecretsUnsubscribe(test *testing.T) {
+	handler := NewServerHandler(deltaTestOptions())
+	stream := newFakeDeltaStream()
+	result := make(chan error, 1)
+	go func() {
+		result <- handler.DeltaSecrets(stream)
+	}()
+
+	stream.requests <- &envoyDiscovery.DeltaDiscoveryRequest{
+		TypeUrl:                secretTypeUrl,
+		ResourceNamesSubscribe: []string{"spiffe://athenz/sa/api"},
+	}
+	resp := stream.expectResponse(test)
+	stream.requests <- &envoyDiscovery.DeltaDiscoveryRequest{
+		ResponseNonce:            resp.Nonce,
+		ResourceNamesUnsubscribe: []string{"spiffe://athenz/sa/api"},
+	}
+	stream.expectNoResponse(test)
+
+	// the removed service is no longer tracked so there is nothing to report
+	opts := deltaTestOptions()
+	opts.Services = nil
+	handler.UpdateOptions(opts)
+	stream.expectNoResponse(test)
+
+	close(stream.requests)
+	if err := <-result; err != nil {
+		test.Errorf("delta stream returned an error: %v", err)
+	}
+}
+
+func TestDeltaSecretsSubscribeWithoutNonce(test *testing.T) {
+	handler := NewServerHandler(deltaTestOptions())
+	stream := newFakeDeltaStream()
+	result := make(chan error, 1)
+	go func() {
+		result <- handler.DeltaSecrets(stream)
+	}()
+
+	stream.requests <- &envoyDiscovery.DeltaDiscoveryRequest{
+		TypeUrl:                secretTypeUrl,
+		ResourceNamesSubscribe: []string{"spiffe://athenz/sa/api"},
+	}
+	resp := stream.expectResponse(test)
+	if len(resp.Resources) != 1 {
+		test.Fatalf("expected 1 resource, received %d", len(resp.Resources))
+	}
+
+	// envoy changes its subscriptions without acking so the
+	// request has no nonce but must still be processed
+	stream.requests <- &envoyDiscovery.DeltaDiscoveryRequest{
+		ResourceNamesSubscribe: []string{"spiffe://athenz/ca/default"},
+	}
+	resp = stream.expectResponse(test)
+	if len(resp.Resources) != 1 || resp.Resources[0].Name != "spiffe://athenz/ca/default" {
+		test.Errorf("unexpected resources: %v", resp.Resources)
+	}
+
+	// a stale nonce is still rejected
+	stream.requests <- &envoyDiscovery.DeltaDiscoveryRequest{
+		ResponseNonce:          "stale-nonce",
+		ResourceNamesSubscribe: []string{"spiffe://athenz/sa/api"},
+	}
+	stream.expectNoResponse(test)
+
+	close(stream.requests)
+	if err := <-result; err != nil {
+		test.Errorf("delta stream returned an error: %v", err)
+	}
+}
+
+func TestGetDeltaResponseInitialVersions(test *testing.T) {
+	handler := NewServerHandler(deltaTestOptions())
+	sub := NewSubscriber()
+	defer sub.Close()
+
+	resourceNames := []string{"spiffe://athenz/sa/api", "spiffe://athenz/sa/unknown"}
+	sub.Subscribe(resourceNames)
+	resp, err := handler.getDeltaResponse(sub, ClientInfo{}, nil, secretTypeUrl, resourceNames)
+	if err != nil {
+		test.Fatalf("unable to generate delta response: %v", err)
+	}
+	if len(resp.Resources) != 1 || len(resp.RemovedResources) != 0 {
+		test.Fatalf("unexpected delta response: %v", resp)
+	}
+	version := resp.Resources[0].Version
+
+	// a new subscriber that already has the same version of the secret
+	// (e.g. after a reconnect) does not receive it again
+	sub2 := NewSubscriber()
+	defer sub2.Close()
+	sub2.Subscribe(resourceNames[:1])
+	sub2.SetResourceVersion(resourceNames[0], version)
+	resp, err = handler.getDeltaResponse(sub2, ClientInfo{}, nil, secretTypeUrl, resourceNames[:1])
+	if err != nil {
+		test.Fatalf("unable to generate delta response: %v", err)
+	}
+	if len(resp.Resources) != 0 {
+		test.Errorf("unchanged secret was included in the response")
+	}
+
+	// an invalid CA bundle fails the response same as for the full state requests
+	_, err = handler.getDeltaResponse(sub, ClientInfo{}, nil, secretTypeUrl, []string{"spiffe://athenz/ca/unknown"})
+	if err == nil {
+		test.Errorf("delta response generated for unknown CA bundle")
+	}
+}
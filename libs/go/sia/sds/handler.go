@@ -0,0 +1,626 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	siastatus "github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	envoyCore "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyTls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	envoyDiscovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	envoySecret "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type ServerHandler struct {
+	Mutex       sync.RWMutex
+	Options     *options.Options
+	Subscribers map[string]*Subscriber
+}
+
+func NewServerHandler(opts *options.Options) *ServerHandler {
+	return &ServerHandler{
+		Options:     opts,
+		Subscribers: make(map[string]*Subscriber),
+		Mutex:       sync.RWMutex{},
+	}
+}
+
+func (handler *ServerHandler) StreamSecrets(stream envoySecret.SecretDiscoveryService_StreamSecretsServer) error {
+
+	sub := handler.subscribeToCertUpdates()
+	defer handler.removeSubscriber(sub)
+
+	clientInfo := ClientInfoFromContext(stream.Context())
+	log.Printf("StreamSecrets: %s: client info: %v\n", sub.GetId(), clientInfo)
+
+	reqChan := make(chan *envoyDiscovery.DiscoveryRequest, 1)
+	errChan := make(chan error, 1)
+
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				log.Printf("StreamSecrets: %s: receiving error: %v\n", sub.GetId(), err)
+				if status.Code(err) == codes.Canceled || errors.Is(err, io.EOF) {
+					log.Printf("StreamSecrets: %s: resetting error...\n", sub.GetId())
+					err = nil
+				}
+				errChan <- err
+				return
+			}
+			reqChan <- req
+		}
+	}()
+
+	var curReq *envoyDiscovery.DiscoveryRequest
+	for {
+		select {
+		case newReq := <-reqChan:
+
+			if curReq == nil {
+				log.Printf("StreamSecrets: %s: processing new request\n", sub.GetId())
+			} else {
+				log.Printf("StreamSecrets: %s: processing request: version: %s, nonce: %s\n", sub.GetId(), newReq.GetVersionInfo(), newReq.GetResponseNonce())
+			}
+
+			// if envoy reported any errors then we're just going to log them,
+			// but we won't stop processing any requests or close connections
+			if newReq.ErrorDetail != nil {
+				log.Printf("StreamSecrets: %s: envoy reported error: %s\n", sub.GetId(), newReq.ErrorDetail.Message)
+			}
+
+			// validate the request nonce and if mismatch, ignore the request
+			if !sub.ValidateResponseNonce(newReq.ResponseNonce) {
+				continue
+			}
+
+			// validate version information
+			if !sub.ValidateVersionInfo(newReq.VersionInfo) {
+				continue
+			}
+
+			// after the first request we should only process the requests
+			// if the list of requested resource names has changed
+			if curReq != nil && !resourceNamesChanged(curReq.ResourceNames, newReq.ResourceNames) {
+				continue
+			}
+
+			// set and process the new request
+			curReq = newReq
+
+		case <-sub.GetCertUpdates():
+			sub.IncrementVersion()
+			// in case we receive an update before an actual request is processed
+			// we should ignore the update
+			if curReq == nil {
+				continue
+			}
+			log.Printf("StreamSecrets: %s: pushing updated certificates to envoy...\n", sub.GetId())
+
+		case err := <-errChan:
+			return err
+		}
+
+		resp, err := handler.getStreamResponse(sub, clientInfo, curReq)
+		if err != nil {
+			log.Printf("StreamSecrets: %s: unable to generate stream response: %v\n", sub.GetId(), err)
+			return err
+		}
+
+		if err := stream.Send(resp); err != nil {
+			log.Printf("StreamSecrets: %s: secret send error: %v\n", sub.GetId(), err)
+			return err
+		}
+
+		// update the last nonce successfully sent to client
+		sub.SetResponseNonce(resp.GetNonce())
+	}
+}
+
+func (handler *ServerHandler) FetchSecrets(ctx context.Context, req *envoyDiscovery.DiscoveryRequest) (*envoyDiscovery.DiscoveryResponse, error) {
+
+	clientInfo := ClientInfoFromContext(ctx)
+	log.Printf("FetchSecrets: client info: %v\n", clientInfo)
+
+	resp, err := handler.getFetchResponse(clientInfo, req)
+	if err != nil {
+		log.Printf("FetchSecrets: unable to generate response: %v\n", err)
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func resourceNamesChanged(currentResources []string, newResources []string) bool {
+	// if the length of arrays are different, then we know there is a change
+	if len(currentResources) != len(newResources) {
+		return true
+	}
+	// typically, we'll only get a small number of resources,
+	// but we'll use a map/set to see if there is a change
+	var resourceMap = make(map[string]bool)
+	for _, resource := range currentResources {
+		resourceMap[resource] = true
+	}
+	for _, resource := range newResources {
+		if !resourceMap[resource] {
+			return true
+		}
+	}
+	return false
+}
+
+func (handler *ServerHandler) getFetchResponse(info ClientInfo, req *envoyDiscovery.DiscoveryRequest) (*envoyDiscovery.DiscoveryResponse, error) {
+
+	resp := &envoyDiscovery.DiscoveryResponse{
+		TypeUrl: req.TypeUrl,
+	}
+
+	err := handler.getResponse(req, info, "", resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (handler *ServerHandler) getStreamResponse(sub *Subscriber, info ClientInfo, req *envoyDiscovery.DiscoveryRequest) (*envoyDiscovery.DiscoveryResponse, error) {
+
+	resp := &envoyDiscovery.DiscoveryResponse{
+		TypeUrl:     req.TypeUrl,
+		VersionInfo: sub.GetVersionInfo(),
+	}
+
+	// provide a nonce for streaming requests
+	var err error
+	if resp.Nonce, err = util.Nonce(); err != nil {
+		return nil, err
+	}
+
+	err = handler.getResponse(req, info, sub.GetId(), resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (handler *ServerHandler) getResponse(req *envoyDiscovery.DiscoveryRequest, info ClientInfo, subId string, resp *envoyDiscovery.DiscoveryResponse) error {
+
+	for _, resource := range req.ResourceNames {
+		log.Printf("Response: %s: requesting secret: %s\n", subId, resource)
+	}
+
+	// parse the requested resource name
+	for _, spiffeUri := range req.ResourceNames {
+		secret, err := handler.getResourceSecret(spiffeUri, info, req.GetNode(), subId)
+		if err != nil {
+			return err
+		}
+		if secret != nil {
+			resp.Resources = append(resp.Resources, secret)
+		}
+	}
+	return nil
+}
+
+// getResourceSecret returns the secret for the given spiffe uri. If the
+// request cannot be authenticated or the secret is not available, the
+// failure is logged and nil is returned without an error. An error is only
+// returned if the requested CA bundle cannot be generated.
+func (handler *ServerHandler) getResourceSecret(spiffeUri string, info ClientInfo, node *envoyCore.Node, subId string) (*anypb.Any, error) {
+
+	// let's check if this is a CA Bundle certificate spiffe uri
+	namespace, name := util.ParseCASpiffeUri(spiffeUri)
+	if namespace != "" && name != "" {
+		return handler.getTLSCABundleSecret(spiffeUri, namespace, name)
+	}
+	// next check if this is a role certificate spiffe uri
+	domain, roleName := util.ParseRoleSpiffeUri(spiffeUri)
+	if domain != "" && roleName != "" {
+		role, err := handler.authenticateRoleRequest(info, node, domain, roleName)
+		if err != nil {
+			auditDeniedRequest(subId, info, spiffeUri, err)
+			return nil, nil
+		}
+		tlsCertificate, err := handler.getRoleTLSCertificateSecret(spiffeUri, role)
+		if err != nil {
+			log.Printf("Response: %s: unable to build envoyTls role certificate: %v\n", subId, err)
+			return nil, nil
+		}
+		return tlsCertificate, nil
+	}
+	// access tokens are returned as generic secrets
+	domain, tokenName := util.ParseAccessTokenSpiffeUri(spiffeUri)
+	if domain != "" && tokenName != "" {
+		token, err := handler.authenticateAccessTokenRequest(info, node, domain, tokenName)
+		if err != nil {
+			auditDeniedRequest(subId, info, spiffeUri, err)
+			return nil, nil
+		}
+		accessToken, err := handler.getAccessTokenSecret(spiffeUri, token)
+		if err != nil {
+			log.Printf("Response: %s: unable to build access token secret: %v\n", subId, err)
+			return nil, nil
+		}
+		return accessToken, nil
+	}
+	domain, service := util.ParseServiceSpiffeUri(spiffeUri)
+	if domain == "" || service == "" {
+		log.Printf("Response: %s: unable to parse spiffe uri: %s\n", subId, spiffeUri)
+		return nil, nil
+	}
+	// authenticate the request
+	svc, err := handler.authenticateRequest(info, node, domain, service)
+	if err != nil {
+		auditDeniedRequest(subId, info, spiffeUri, err)
+		return nil, nil
+	}
+	tlsCertificate, err := handler.getTLSCertificateSecret(spiffeUri, svc)
+	if err != nil {
+		log.Printf("Response: %s: unable to build envoyTls certificate: %v\n", subId, err)
+		return nil, nil
+	}
+	return tlsCertificate, nil
+}
+
+func (handler *ServerHandler) subscribeToCertUpdates() *Subscriber {
+
+	handler.Mutex.Lock()
+	subscriber := NewSubscriber()
+	handler.Subscribers[subscriber.GetId()] = subscriber
+	siastatus.SetSdsSubscribers(len(handler.Subscribers))
+	handler.Mutex.Unlock()
+
+	log.Printf("Subscription: %s: registering new subscriber\n", subscriber.GetId())
+	return subscriber
+}
+
+func (handler *ServerHandler) removeSubscriber(subscriber *Subscriber) {
+
+	log.Printf("Subscription: %s: removing subscriber\n", subscriber.GetId())
+
+	handler.Mutex.Lock()
+	delete(handler.Subscribers, subscriber.GetId())
+	siastatus.SetSdsSubscribers(len(handler.Subscribers))
+	defer handler.Mutex.Unlock()
+
+	subscriber.Close()
+}
+
+func (handler *ServerHandler) NotifySubscribers() {
+
+	handler.Mutex.RLock()
+	for _, subscriber := range handler.Subscribers {
+		subscriber.Notify()
+	}
+	handler.Mutex.RUnlock()
+}
+
+// GetOptions returns the options currently used by the handler
+func (handler *ServerHandler) GetOptions() *options.Options {
+	handler.Mutex.RLock()
+	defer handler.Mutex.RUnlock()
+	return handler.Options
+}
+
+// UpdateOptions replaces the options used by the handler after the
+// configuration has been reloaded and notifies all subscribers so that
+// their requests are authorized again against the new service list
+// without closing any of the existing streams
+func (handler *ServerHandler) UpdateOptions(opts *options.Options) {
+	handler.Mutex.Lock()
+	handler.Options = opts
+	handler.Mutex.Unlock()
+
+	log.Println("Subscription: options updated, notifying subscribers")
+	handler.NotifySubscribers()
+}
+
+func (handler *ServerHandler) authenticateRequest(info ClientInfo, node *envoyCore.Node, domain, service string) (*options.Service, error) {
+
+	opts := handler.GetOptions()
+	if domain != opts.Domain {
+		return nil, fmt.Errorf("invalid domain name: %s, expected: %s", domain, opts.Domain)
+	}
+	for _, svc := range opts.Services {
+		if svc.Name == service {
+			var err error
+			if info.Principal != "" {
+				err = authenticateTcpClient(info, &svc)
+			} else {
+				err = authenticateUdsClient(info, &svc)
+			}
+			if err != nil {
+				return nil, err
+			}
+			nodeId := ""
+			if node != nil {
+				nodeId = node.GetId()
+			}
+			if svc.SDSNodeId != "" && svc.SDSNodeId != nodeId {
+				return nil, fmt.Errorf("invalid node id: %s, expected: %s", nodeId, svc.SDSNodeId)
+			}
+			nodeCluster := ""
+			if node != nil {
+				nodeCluster = node.GetCluster()
+			}
+			if svc.SDSNodeCluster != "" && svc.SDSNodeCluster != nodeCluster {
+				return nil, fmt.Errorf("invalid node cluster: %s, expected: %s", nodeCluster, svc.SDSNodeCluster)
+			}
+			return &svc, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown service: %s", service)
+}
+
+// authenticateUdsClient verifies that the user, group and process details
+// of the Unix-Domain-Socket client match the rules configured for the service
+func authenticateUdsClient(info ClientInfo, svc *options.Service) error {
+	if svc.SDSUdsUid != info.UserID {
+		return fmt.Errorf("invalid uid: %d, expected: %d", info.UserID, svc.SDSUdsUid)
+	}
+	if len(svc.SDSUdsGids) != 0 && !containsGid(svc.SDSUdsGids, info.GroupID) {
+		return fmt.Errorf("invalid gid: %d, expected one of: %v", info.GroupID, svc.SDSUdsGids)
+	}
+	if len(svc.SDSUdsExe) != 0 && !matchesAny(svc.SDSUdsExe, []string{info.Exe}) {
+		return fmt.Errorf("invalid executable: %q, expected one of: %v", info.Exe, svc.SDSUdsExe)
+	}
+	if len(svc.SDSUdsCgroups) != 0 && !matchesAny(svc.SDSUdsCgroups, info.Cgroups) {
+		return fmt.Errorf("invalid cgroups: %v, expected one of: %v", info.Cgroups, svc.SDSUdsCgroups)
+	}
+	return nil
+}
+
+// authenticateTcpClient verifies that the principal from the mTLS client
+// certificate is authorized to fetch the secrets of the service. Services
+// without any configured principals are not available over TCP
+func authenticateTcpClient(info ClientInfo, svc *options.Service) error {
+	for _, principal := range svc.SDSTcpPrincipals {
+		if principal == info.Principal {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid principal: %s, expected one of: %v", info.Principal, svc.SDSTcpPrincipals)
+}
+
+// authenticateRoleRequest looks up the requested role certificate and
+// verifies that the client is authorized to access the certificate of
+// the service that the role certificate is issued for
+func (handler *ServerHandler) authenticateRoleRequest(info ClientInfo, node *envoyCore.Node, domain, roleName string) (*options.Role, error) {
+
+	opts := handler.GetOptions()
+	name := fmt.Sprintf("%s:role.%s", domain, roleName)
+	for _, role := range opts.Roles {
+		if role.Name == name {
+			if _, err := handler.authenticateRequest(info, node, opts.Domain, role.Service); err != nil {
+				return nil, err
+			}
+			return &role, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown role: %s", name)
+}
+
+// authenticateAccessTokenRequest looks up the requested access token and
+// verifies that the client is authorized to access the certificate of
+// the service that the access token is issued for
+func (handler *ServerHandler) authenticateAccessTokenRequest(info ClientInfo, node *envoyCore.Node, domain, tokenName string) (*config.AccessToken, error) {
+
+	opts := handler.GetOptions()
+	for _, token := range opts.AccessTokens {
+		if token.Domain == domain && token.FileName == tokenName {
+			if _, err := handler.authenticateRequest(info, node, opts.Domain, token.Service); err != nil {
+				return nil, err
+			}
+			return &token, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown access token: %s/%s", domain, tokenName)
+}
+
+// auditDeniedRequest logs the details of the client whose request
+// for the given secret was rejected
+func auditDeniedRequest(subId string, info ClientInfo, spiffeUri string, err error) {
+	if info.Principal != "" {
+		log.Printf("Audit: %s: denied request for secret: %s, principal: %s, reason: %v\n",
+			subId, spiffeUri, info.Principal, err)
+		return
+	}
+	log.Printf("Audit: %s: denied request for secret: %s, uid: %d, gid: %d, pid: %d, exe: %q, cgroups: %v, reason: %v\n",
+		subId, spiffeUri, info.UserID, info.GroupID, info.PID, info.Exe, info.Cgroups, err)
+}
+
+func containsGid(gids []int, gid int) bool {
+	for _, g := range gids {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+func (handler *ServerHandler) getTLSCertificateSecret(spiffeUri string, svc *options.Service) (*anypb.Any, error) {
+
+	opts := handler.GetOptions()
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+	return newTLSCertificateSecret(spiffeUri, keyFile, certFile)
+}
+
+func (handler *ServerHandler) getRoleTLSCertificateSecret(spiffeUri string, role *options.Role) (*anypb.Any, error) {
+
+	opts := handler.GetOptions()
+	keyFile := fmt.Sprintf("%s/%s.key.pem", opts.KeyDir, options.GetRoleKeyPrefix(opts, *role))
+	certFile := util.GetRoleCertFileName(opts.CertDir, role.Filename, role.Name)
+	return newTLSCertificateSecret(spiffeUri, keyFile, certFile)
+}
+
+func (handler *ServerHandler) getAccessTokenSecret(spiffeUri string, token *config.AccessToken) (*anypb.Any, error) {
+
+	tokenFile := filepath.Join(handler.GetOptions().TokenDir, token.Domain, token.FileName)
+	accessToken, err := readAccessToken(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return anypb.New(&envoyTls.Secret{
+		Name: spiffeUri,
+		Type: &envoyTls.Secret_GenericSecret{
+			GenericSecret: &envoyTls.GenericSecret{
+				Secret: &envoyCore.DataSource{
+					Specifier: &envoyCore.DataSource_InlineString{
+						InlineString: accessToken,
+					},
+				},
+			},
+		},
+	})
+}
+
+// readAccessToken returns the access token from the given token file. The
+// file includes either the access token only or the full zts response
+// based on the configured store options
+func readAccessToken(tokenFile string) (string, error) {
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+	var accessToken string
+	if err := json.Unmarshal(data, &accessToken); err != nil {
+		var tokenResponse struct {
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.Unmarshal(data, &tokenResponse); err != nil {
+			return "", fmt.Errorf("unable to parse access token file %s: %v", tokenFile, err)
+		}
+		accessToken = tokenResponse.AccessToken
+	}
+	if accessToken == "" {
+		return "", fmt.Errorf("access token file %s does not include an access token", tokenFile)
+	}
+	return accessToken, nil
+}
+
+func newTLSCertificateSecret(spiffeUri, keyFile, certFile string) (*anypb.Any, error) {
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return anypb.New(&envoyTls.Secret{
+		Name: spiffeUri,
+		Type: &envoyTls.Secret_TlsCertificate{
+			TlsCertificate: &envoyTls.TlsCertificate{
+				CertificateChain: &envoyCore.DataSource{
+					Specifier: &envoyCore.DataSource_InlineBytes{
+						InlineBytes: certPEM,
+					},
+				},
+				PrivateKey: &envoyCore.DataSource{
+					Specifier: &envoyCore.DataSource_InlineBytes{
+						InlineBytes: keyPEM,
+					},
+				},
+			},
+		},
+	})
+}
+
+func (handler *ServerHandler) getTLSCABundleSecret(spiffeUri, caNamespace, caName string) (*anypb.Any, error) {
+
+	//we support a single namespace athenz with the configured bundle names
+	if caNamespace != "athenz" {
+		return nil, fmt.Errorf("unknown TLS CA Bundle: %s\n", spiffeUri)
+	}
+	caFiles, err := options.GetCABundleFiles(handler.GetOptions(), caName)
+	if err != nil {
+		return nil, fmt.Errorf("unknown TLS CA Bundle: %s\n", spiffeUri)
+	}
+	caCertsPEM, err := readCABundle(caFiles)
+	if err != nil {
+		return nil, err
+	}
+	configTrustDomains := []*envoyTls.SPIFFECertValidatorConfig_TrustDomain{
+		{
+			Name: caName,
+			TrustBundle: &envoyCore.DataSource{
+				Specifier: &envoyCore.DataSource_InlineBytes{
+					InlineBytes: caCertsPEM,
+				},
+			},
+		},
+	}
+
+	typedConfig, err := anypb.New(&envoyTls.SPIFFECertValidatorConfig{
+		TrustDomains: configTrustDomains,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return anypb.New(&envoyTls.Secret{
+		Name: spiffeUri,
+		Type: &envoyTls.Secret_ValidationContext{
+			ValidationContext: &envoyTls.CertificateValidationContext{
+				CustomValidatorConfig: &envoyCore.TypedExtensionConfig{
+					Name:        "envoy.tls.cert_validator.spiffe",
+					TypedConfig: typedConfig,
+				},
+			},
+		},
+	})
+}
+
+// readCABundle returns the combined contents of the given CA certificate files.
+// Including multiple files allows both the old and new CA certificates to be
+// trusted while the CA is being rotated
+func readCABundle(caFiles []string) ([]byte, error) {
+	var caCertsPEM []byte
+	for _, caFile := range caFiles {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		caCertsPEM = append(caCertsPEM, data...)
+		if len(data) != 0 && data[len(data)-1] != '\n' {
+			caCertsPEM = append(caCertsPEM, '\n')
+		}
+	}
+	if len(caCertsPEM) == 0 {
+		return nil, fmt.Errorf("empty CA bundle: %v", caFiles)
+	}
+	return caCertsPEM, nil
+}
@@ -0,0 +1,530 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"github.com/AthenZ/athenz/libs/go/sia/access/config"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	envoyCore "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyTls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	envoyDiscovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResourceNamesChanged(test *testing.T) {
+	curList := []string{"abc", "bcd", "cde"}
+	newList := []string{"abc", "bcd", "cde"}
+	if resourceNamesChanged(curList, newList) {
+		test.Errorf("identical resource lists marked as changed")
+	}
+
+	curList = []string{"abc", "bcd", "cde"}
+	newList = []string{"abc", "bcd"}
+	if !resourceNamesChanged(curList, newList) {
+		test.Errorf("different size resource lists marked as not changed")
+	}
+
+	curList = []string{"abc", "bcd", "cde"}
+	newList = []string{"abc", "bcd", "def"}
+	if !resourceNamesChanged(curList, newList) {
+		test.Errorf("different value / same size resource lists marked as not changed")
+	}
+}
+
+func TestGetResponseInvalidUri(test *testing.T) {
+	handler := NewServerHandler(&options.Options{})
+	req := envoyDiscovery.DiscoveryRequest{
+		ResourceNames: []string{"spiffe://athenz/invalid"},
+	}
+	resp := envoyDiscovery.DiscoveryResponse{}
+	err := handler.getResponse(&req, ClientInfo{}, "", &resp)
+	if err != nil {
+		test.Errorf("unexpected error returned: %v", err)
+	}
+	if len(resp.Resources) != 0 {
+		test.Errorf("unexpected response objects created")
+	}
+}
+
+func TestSubscriptionChanges(test *testing.T) {
+	handler := NewServerHandler(&options.Options{})
+	if len(handler.Subscribers) != 0 {
+		test.Errorf("new handler has some subscribers")
+	}
+	sub := handler.subscribeToCertUpdates()
+	if handler.Subscribers[sub.GetId()] == nil {
+		test.Errorf("new subscriber is not in the list")
+	}
+	id := sub.GetId()
+	handler.removeSubscriber(sub)
+	if handler.Subscribers[id] != nil {
+		test.Errorf("removed subscriber is still in the list")
+	}
+}
+
+func TestSubscriptionNotifications(test *testing.T) {
+	handler := NewServerHandler(&options.Options{})
+	sub := handler.subscribeToCertUpdates()
+	handler.NotifySubscribers()
+	updates := <-sub.GetCertUpdates()
+	if !updates {
+		test.Errorf("subscriber update flag is not set correctly")
+	}
+	handler.removeSubscriber(sub)
+}
+
+func TestUpdateOptions(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "api", SDSUdsUid: 123}, {Name: "backend", SDSUdsUid: 123}},
+	})
+	sub := handler.subscribeToCertUpdates()
+	defer handler.removeSubscriber(sub)
+	_, err := handler.authenticateRequest(ClientInfo{UserID: 123}, nil, "athenz", "backend")
+	if err != nil {
+		test.Errorf("valid requested was not correctly authenticated: %v", err)
+	}
+	handler.UpdateOptions(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "api", SDSUdsUid: 123}},
+	})
+	updates := <-sub.GetCertUpdates()
+	if !updates {
+		test.Errorf("subscriber was not notified after options update")
+	}
+	_, err = handler.authenticateRequest(ClientInfo{UserID: 123}, nil, "athenz", "backend")
+	if err == nil || !strings.Contains(err.Error(), "unknown service: backend") {
+		test.Errorf("removed service was authenticated: %v", err)
+	}
+}
+
+func TestAuthenticateRequestMismatchDomain(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain: "athenz",
+	})
+	_, err := handler.authenticateRequest(ClientInfo{}, nil, "sports", "api")
+	if err == nil {
+		test.Errorf("invalid domain was correctly authenticated")
+	}
+	if !strings.Contains(err.Error(), "invalid domain name") {
+		test.Errorf("error does not include expected invalid domain message: %s", err.Error())
+	}
+}
+
+func TestAuthenticateRequestUnknownService(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "backend"}},
+	})
+	_, err := handler.authenticateRequest(ClientInfo{}, nil, "athenz", "api")
+	if err == nil {
+		test.Errorf("unknown service was correctly authenticated")
+	}
+	if !strings.Contains(err.Error(), "unknown service") {
+		test.Errorf("error does not include expected unknown service message: %s", err.Error())
+	}
+}
+
+func TestAuthenticateRequestMismatchUid(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "api", SDSUdsUid: 124}},
+	})
+	_, err := handler.authenticateRequest(ClientInfo{UserID: 123}, nil, "athenz", "api")
+	if err == nil {
+		test.Errorf("mismatched uid was correctly authenticated")
+	}
+	if !strings.Contains(err.Error(), "invalid uid") {
+		test.Errorf("error does not include expected invalid uid message: %s", err.Error())
+	}
+}
+
+func TestAuthenticateRequestMismatchNodeId(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "api", SDSUdsUid: 123, SDSNodeId: "id1"}},
+	})
+	// first try with nil node
+	_, err := handler.authenticateRequest(ClientInfo{UserID: 123}, nil, "athenz", "api")
+	if err == nil {
+		test.Errorf("mismatched node id was correctly authenticated")
+	}
+	if !strings.Contains(err.Error(), "invalid node id: ,") {
+		test.Errorf("error does not include expected invalid node id message: %s", err.Error())
+	}
+	// now with a node with mismatched nodeid
+	_, err = handler.authenticateRequest(ClientInfo{UserID: 123}, &envoyCore.Node{Id: "id2"}, "athenz", "api")
+	if err == nil {
+		test.Errorf("mismatched node id was correctly authenticated")
+	}
+	if !strings.Contains(err.Error(), "invalid node id: id2,") {
+		test.Errorf("error does not include expected invalid node id message: %s", err.Error())
+	}
+}
+
+func TestAuthenticateRequestMismatchNodeCluster(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "api", SDSUdsUid: 123, SDSNodeCluster: "cluster1"}},
+	})
+	// first try with nil node
+	_, err := handler.authenticateRequest(ClientInfo{UserID: 123}, nil, "athenz", "api")
+	if err == nil {
+		test.Errorf("mismatched node cluster was correctly authenticated")
+	}
+	if !strings.Contains(err.Error(), "invalid node cluster: ,") {
+		test.Errorf("error does not include expected invalid node cluster message: %s", err.Error())
+	}
+	// now with a node with mismatched node cluster
+	_, err = handler.authenticateRequest(ClientInfo{UserID: 123}, &envoyCore.Node{Id: "id1", Cluster: "cluster2"}, "athenz", "api")
+	if err == nil {
+		test.Errorf("mismatched node cluster was correctly authenticated")
+	}
+	if !strings.Contains(err.Error(), "invalid node cluster: cluster2,") {
+		test.Errorf("error does not include expected invalid node cluster message: %s", err.Error())
+	}
+}
+
+func TestAuthenticateRequestProcessRules(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain: "athenz",
+		Services: []options.Service{{
+			Name:          "api",
+			SDSUdsUid:     123,
+			SDSUdsGids:    []int{100, 200},
+			SDSUdsExe:     []string{"/usr/local/bin/envoy", "/opt/envoy/bin/envoy-*"},
+			SDSUdsCgroups: []string{"/system.slice/envoy.service", "/kubepods/*/pod1234/*"},
+		}},
+	})
+	valid := ClientInfo{
+		UserID:  123,
+		GroupID: 200,
+		PID:     4567,
+		Exe:     "/opt/envoy/bin/envoy-1.24",
+		Cgroups: []string{"/kubepods/burstable/pod1234/abcd"},
+	}
+	tests := []struct {
+		name   string
+		update func(info *ClientInfo)
+		errMsg string
+	}{
+		{"valid", func(info *ClientInfo) {}, ""},
+		{"invalid-gid", func(info *ClientInfo) { info.GroupID = 300 }, "invalid gid: 300"},
+		{"unknown-gid", func(info *ClientInfo) { info.GroupID = -1 }, "invalid gid: -1"},
+		{"invalid-exe", func(info *ClientInfo) { info.Exe = "/usr/bin/curl" }, "invalid executable"},
+		{"unknown-exe", func(info *ClientInfo) { info.Exe = "" }, "invalid executable"},
+		{"invalid-cgroup", func(info *ClientInfo) { info.Cgroups = []string{"/kubepods/burstable/pod5678/abcd"} }, "invalid cgroups"},
+		{"unknown-cgroup", func(info *ClientInfo) { info.Cgroups = nil }, "invalid cgroups"},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			info := valid
+			tt.update(&info)
+			_, err := handler.authenticateRequest(info, nil, "athenz", "api")
+			if tt.errMsg == "" && err != nil {
+				t.Errorf("valid requested was not correctly authenticated: %v", err)
+			}
+			if tt.errMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.errMsg)) {
+				t.Errorf("request was not rejected with expected error %q: %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRequestMatchNilNode(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "api", SDSUdsUid: 123}},
+	})
+	_, err := handler.authenticateRequest(ClientInfo{UserID: 123}, nil, "athenz", "api")
+	if err != nil {
+		test.Errorf("valid requested was not correctly authenticated: %v", err)
+	}
+}
+
+func TestAuthenticateRequestMatchWithNode(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "api", SDSUdsUid: 123, SDSNodeId: "id1", SDSNodeCluster: "cluster1"}},
+	})
+	_, err := handler.authenticateRequest(ClientInfo{UserID: 123}, &envoyCore.Node{Id: "id1", Cluster: "cluster1"}, "athenz", "api")
+	if err != nil {
+		test.Errorf("valid requested was not correctly authenticated: %v", err)
+	}
+}
+
+func TestGetTLSCertificateSecretUnknownPrivateKey(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		KeyDir:  "data",
+		CertDir: "data",
+		Domain:  "athenz",
+	})
+	svc := options.Service{
+		Name: "unknown",
+	}
+	_, err := handler.getTLSCertificateSecret("spiffe://athenz/sa/api", &svc)
+	if err == nil {
+		test.Errorf("was able to generate certificate for unknown key file")
+	}
+}
+
+func TestGetTLSCertificateSecretUnknownCertificate(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		KeyDir:  "data",
+		CertDir: "data",
+		Domain:  "athenz",
+	})
+	svc := options.Service{
+		Name:     "api",
+		Filename: "unknown",
+	}
+	_, err := handler.getTLSCertificateSecret("spiffe://athenz/sa/api", &svc)
+	if err == nil {
+		test.Errorf("was able to generate certificate for unknown cert file")
+	}
+}
+
+func TestGetTLSCertificateSecret(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		KeyDir:  "data",
+		CertDir: "data",
+		Domain:  "athenz",
+	})
+	svc := options.Service{
+		Name: "api",
+	}
+	_, err := handler.getTLSCertificateSecret("spiffe://athenz/sa/api", &svc)
+	if err != nil {
+		test.Errorf("unable to generate certificate secret: %v", err)
+	}
+}
+
+func TestGetTLSCABundleSecretInvalidNamespace(test *testing.T) {
+	handler := NewServerHandler(&options.Options{})
+	_, err := handler.getTLSCABundleSecret("spiffe://athenz/ca/default", "coretech", "default")
+	if err == nil {
+		test.Errorf("certificate generated for invalid namespace")
+	}
+}
+
+func TestGetTLSCABundleSecretInvalidName(test *testing.T) {
+	handler := NewServerHandler(&options.Options{})
+	_, err := handler.getTLSCABundleSecret("spiffe://athenz/ca/default", "athenz", "primary")
+	if err == nil {
+		test.Errorf("certificate generated for invalid name")
+	}
+}
+
+func TestGetTLSCABundleSecretInvalidFile(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		AthenzCACertFile: "unknown-file",
+	})
+	_, err := handler.getTLSCABundleSecret("spiffe://athenz/ca/default", "athenz", "default")
+	if err == nil {
+		test.Errorf("certificate generated for invalid filename")
+	}
+}
+
+func TestGetTLSCABundleSecret(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		AthenzCACertFile: "data/ca.cert.pem",
+	})
+	_, err := handler.getTLSCABundleSecret("spiffe://athenz/ca/default", "athenz", "default")
+	if err != nil {
+		test.Errorf("unable to generate valid bundle: %v", err)
+	}
+}
+
+func TestGetTLSCABundleSecretNamedBundles(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		AthenzCACertFile: "data/unknown-file",
+		CertDir:          "data",
+		CABundles: []options.CABundle{
+			{Name: "default", Files: []string{"data/ca.cert.pem", "data/ca.cert.pem"}},
+			{Name: "partner", Files: []string{"data/unknown-file"}},
+			{Name: "roles", ZTSBundle: "athenz"},
+		},
+	})
+	resource, err := handler.getTLSCABundleSecret("spiffe://athenz/ca/default", "athenz", "default")
+	if err != nil {
+		test.Fatalf("unable to generate valid bundle: %v", err)
+	}
+	var secret envoyTls.Secret
+	if err := resource.UnmarshalTo(&secret); err != nil {
+		test.Fatalf("unable to unmarshal bundle secret: %v", err)
+	}
+	if secret.GetName() != "spiffe://athenz/ca/default" {
+		test.Errorf("invalid secret name: %s", secret.GetName())
+	}
+	_, err = handler.getTLSCABundleSecret("spiffe://athenz/ca/partner", "athenz", "partner")
+	if err == nil {
+		test.Errorf("bundle generated for invalid filename")
+	}
+	// zts bundle has not been fetched yet
+	_, err = handler.getTLSCABundleSecret("spiffe://athenz/ca/roles", "athenz", "roles")
+	if err == nil {
+		test.Errorf("bundle generated for zts bundle that was not fetched")
+	}
+}
+
+func TestReadCABundle(test *testing.T) {
+	caFile := filepath.Join(test.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----"), 0400); err != nil {
+		test.Fatalf("unable to write ca file: %v", err)
+	}
+	caCertsPEM, err := readCABundle([]string{caFile, caFile})
+	if err != nil {
+		test.Fatalf("unable to read ca bundle: %v", err)
+	}
+	if strings.Count(string(caCertsPEM), "-----END CERTIFICATE-----\n") != 2 {
+		test.Errorf("ca files were not combined correctly: %s", caCertsPEM)
+	}
+	emptyFile := filepath.Join(test.TempDir(), "empty.pem")
+	if err := os.WriteFile(emptyFile, nil, 0400); err != nil {
+		test.Fatalf("unable to write ca file: %v", err)
+	}
+	if _, err := readCABundle([]string{emptyFile}); err == nil {
+		test.Errorf("empty ca bundle was accepted")
+	}
+}
+
+func TestAuthenticateRoleRequest(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:   "athenz",
+		Services: []options.Service{{Name: "api", SDSUdsUid: 123}, {Name: "backend", SDSUdsUid: 456}},
+		Roles: []options.Role{
+			{Name: "sports:role.readers", Service: "api"},
+			{Name: "sports:role.writers", Service: "backend"},
+		},
+	})
+	role, err := handler.authenticateRoleRequest(ClientInfo{UserID: 123}, nil, "sports", "readers")
+	if err != nil {
+		test.Errorf("valid role request was not correctly authenticated: %v", err)
+	} else if role.Name != "sports:role.readers" {
+		test.Errorf("invalid role returned: %s", role.Name)
+	}
+	_, err = handler.authenticateRoleRequest(ClientInfo{UserID: 123}, nil, "sports", "writers")
+	if err == nil || !strings.Contains(err.Error(), "invalid uid: 123, expected: 456") {
+		test.Errorf("role request for another service was authenticated: %v", err)
+	}
+	_, err = handler.authenticateRoleRequest(ClientInfo{UserID: 123}, nil, "sports", "admins")
+	if err == nil || !strings.Contains(err.Error(), "unknown role: sports:role.admins") {
+		test.Errorf("unknown role request was authenticated: %v", err)
+	}
+}
+
+func TestGetRoleTLSCertificateSecret(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		KeyDir:  "data",
+		CertDir: "data",
+		Domain:  "athenz",
+	})
+	role := options.Role{
+		Name:     "sports:role.readers",
+		Service:  "api",
+		Filename: "athenz.api.cert.pem",
+	}
+	_, err := handler.getRoleTLSCertificateSecret("spiffe://sports/ra/readers", &role)
+	if err != nil {
+		test.Errorf("unable to generate role certificate secret: %v", err)
+	}
+	role.Filename = ""
+	_, err = handler.getRoleTLSCertificateSecret("spiffe://sports/ra/readers", &role)
+	if err == nil {
+		test.Errorf("was able to generate certificate for unknown role cert file")
+	}
+}
+
+func TestAuthenticateAccessTokenRequest(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain:       "athenz",
+		Services:     []options.Service{{Name: "api", SDSUdsUid: 123}},
+		AccessTokens: []config.AccessToken{{FileName: "readers", Domain: "sports", Service: "api"}},
+	})
+	token, err := handler.authenticateAccessTokenRequest(ClientInfo{UserID: 123}, nil, "sports", "readers")
+	if err != nil {
+		test.Errorf("valid access token request was not correctly authenticated: %v", err)
+	} else if token.FileName != "readers" {
+		test.Errorf("invalid access token returned: %s", token.FileName)
+	}
+	_, err = handler.authenticateAccessTokenRequest(ClientInfo{UserID: 456}, nil, "sports", "readers")
+	if err == nil {
+		test.Errorf("access token request with invalid uid was authenticated")
+	}
+	_, err = handler.authenticateAccessTokenRequest(ClientInfo{UserID: 123}, nil, "sports", "writers")
+	if err == nil || !strings.Contains(err.Error(), "unknown access token: sports/writers") {
+		test.Errorf("unknown access token request was authenticated: %v", err)
+	}
+}
+
+func TestGetAccessTokenSecret(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		TokenDir: "data",
+	})
+	token := config.AccessToken{FileName: "readers", Domain: "athenz"}
+	resource, err := handler.getAccessTokenSecret("spiffe://athenz/at/readers", &token)
+	if err != nil {
+		test.Fatalf("unable to generate access token secret: %v", err)
+	}
+	var secret envoyTls.Secret
+	if err := resource.UnmarshalTo(&secret); err != nil {
+		test.Fatalf("unable to unmarshal access token secret: %v", err)
+	}
+	if secret.GetName() != "spiffe://athenz/at/readers" {
+		test.Errorf("invalid secret name: %s", secret.GetName())
+	}
+	if !strings.HasPrefix(secret.GetGenericSecret().GetSecret().GetInlineString(), "eyJ") {
+		test.Errorf("invalid access token in secret: %s", secret.GetGenericSecret().GetSecret().GetInlineString())
+	}
+	token.FileName = "unknown"
+	_, err = handler.getAccessTokenSecret("spiffe://athenz/at/unknown", &token)
+	if err == nil {
+		test.Errorf("was able to generate secret for unknown token file")
+	}
+}
+
+func TestReadAccessToken(test *testing.T) {
+	tokenDir := test.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		token   string
+	}{
+		{"token-only", `"eyJhbGciOiJFUzI1NiJ9.e30.c2ln"`, "eyJhbGciOiJFUzI1NiJ9.e30.c2ln"},
+		{"zts-response", `{"access_token":"eyJhbGciOiJFUzI1NiJ9.e30.c2ln","token_type":"Bearer","expires_in":3600}`, "eyJhbGciOiJFUzI1NiJ9.e30.c2ln"},
+		{"no-token", `{"token_type":"Bearer"}`, ""},
+		{"invalid", `invalid-json`, ""},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			tokenFile := filepath.Join(tokenDir, tt.name)
+			if err := os.WriteFile(tokenFile, []byte(tt.content), 0400); err != nil {
+				t.Fatalf("unable to write token file: %v", err)
+			}
+			token, err := readAccessToken(tokenFile)
+			if tt.token == "" && err == nil {
+				t.Errorf("invalid token file was accepted")
+			}
+			if token != tt.token {
+				t.Errorf("invalid token returned - expected: %s, received %s", tt.token, token)
+			}
+		})
+	}
+}
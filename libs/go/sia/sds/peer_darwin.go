@@ -16,15 +16,11 @@
 
 package sds
 
-type ClientInfo struct {
-	UserID int
-	PID    int
-}
-
-func (ClientInfo) AuthType() string {
-	return ClientAuthType()
-}
+import (
+	"net"
+)
 
-func ClientAuthType() string {
-	return "athenz-uds"
+// getPeerGroupId is not supported on this platform
+func getPeerGroupId(_ net.Conn) (int, bool) {
+	return -1, false
 }
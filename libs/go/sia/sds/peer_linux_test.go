@@ -0,0 +1,53 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetPeerGroupId(test *testing.T) {
+	udsPath := filepath.Join(test.TempDir(), "sds.sock")
+	listener, err := net.Listen("unix", udsPath)
+	if err != nil {
+		test.Fatalf("unable to create uds listener: %v", err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("unix", udsPath)
+	if err != nil {
+		test.Fatalf("unable to connect to uds listener: %v", err)
+	}
+	defer client.Close()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		test.Fatalf("unable to accept uds connection: %v", err)
+	}
+	defer conn.Close()
+
+	gid, ok := getPeerGroupId(conn)
+	if !ok || gid != os.Getgid() {
+		test.Errorf("invalid peer gid returned - expected: %d, received %d", os.Getgid(), gid)
+	}
+	if _, ok := getPeerGroupId(&UdsConn{Conn: conn}); ok {
+		test.Errorf("peer gid returned for non-unix connection")
+	}
+}
@@ -0,0 +1,84 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// procDir is the root of the proc filesystem used to
+// look up the details of the connected processes
+var procDir = "/proc"
+
+// getProcessExe returns the resolved path of the executable
+// of the given process or an empty string if it's not available
+func getProcessExe(pid int) string {
+	if pid <= 0 {
+		return ""
+	}
+	exe, err := os.Readlink(fmt.Sprintf("%s/%d/exe", procDir, pid))
+	if err != nil {
+		return ""
+	}
+	return exe
+}
+
+// getProcessCgroups returns the list of cgroup paths
+// that the given process is a member of
+func getProcessCgroups(pid int) []string {
+	if pid <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(fmt.Sprintf("%s/%d/cgroup", procDir, pid))
+	if err != nil {
+		return nil
+	}
+	return parseCgroups(string(data))
+}
+
+// parseCgroups extracts the unique cgroup paths from the contents of
+// the /proc/<pid>/cgroup file. Each line has the format
+// hierarchy-ID:controller-list:cgroup-path
+func parseCgroups(data string) []string {
+	var cgroups []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(fields) != 3 || fields[2] == "" || seen[fields[2]] {
+			continue
+		}
+		seen[fields[2]] = true
+		cgroups = append(cgroups, fields[2])
+	}
+	return cgroups
+}
+
+// matchesAny returns true if any of the given values matches
+// any of the patterns. The patterns use the path.Match syntax
+func matchesAny(patterns []string, values []string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if matched, _ := path.Match(pattern, value); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
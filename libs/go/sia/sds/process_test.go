@@ -0,0 +1,91 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCgroups(test *testing.T) {
+	data := `12:memory:/kubepods/burstable/pod1234/abcd
+11:cpu,cpuacct:/kubepods/burstable/pod1234/abcd
+1:name=systemd:/system.slice/envoy.service
+0::/system.slice/envoy.service
+invalid-line
+`
+	cgroups := parseCgroups(data)
+	expected := []string{"/kubepods/burstable/pod1234/abcd", "/system.slice/envoy.service"}
+	if !reflect.DeepEqual(cgroups, expected) {
+		test.Errorf("invalid cgroups returned - expected: %v, received %v", expected, cgroups)
+	}
+}
+
+func TestMatchesAny(test *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		values   []string
+		match    bool
+	}{
+		{"exact", []string{"/usr/local/bin/envoy"}, []string{"/usr/local/bin/envoy"}, true},
+		{"glob", []string{"/usr/local/bin/envoy-*"}, []string{"/usr/local/bin/envoy-1.24"}, true},
+		{"glob-no-separator", []string{"/usr/*"}, []string{"/usr/local/bin/envoy"}, false},
+		{"cgroup", []string{"/kubepods/*/pod1234/*"}, []string{"/system.slice/sshd.service", "/kubepods/burstable/pod1234/abcd"}, true},
+		{"no-values", []string{"/system.slice/envoy.service"}, nil, false},
+		{"empty-value", []string{"/usr/local/bin/envoy"}, []string{""}, false},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			if matchesAny(tt.patterns, tt.values) != tt.match {
+				t.Errorf("unexpected match result for patterns %v and values %v", tt.patterns, tt.values)
+			}
+		})
+	}
+}
+
+func TestGetProcessDetails(test *testing.T) {
+	savedProcDir := procDir
+	defer func() { procDir = savedProcDir }()
+	procDir = test.TempDir()
+
+	pidDir := filepath.Join(procDir, "1234")
+	if err := os.MkdirAll(pidDir, 0755); err != nil {
+		test.Fatalf("unable to create pid directory: %v", err)
+	}
+	if err := os.Symlink("/usr/local/bin/envoy", filepath.Join(pidDir, "exe")); err != nil {
+		test.Fatalf("unable to create exe link: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pidDir, "cgroup"), []byte("0::/system.slice/envoy.service\n"), 0444); err != nil {
+		test.Fatalf("unable to create cgroup file: %v", err)
+	}
+
+	if exe := getProcessExe(1234); exe != "/usr/local/bin/envoy" {
+		test.Errorf("invalid exe returned: %s", exe)
+	}
+	if cgroups := getProcessCgroups(1234); len(cgroups) != 1 || cgroups[0] != "/system.slice/envoy.service" {
+		test.Errorf("invalid cgroups returned: %v", cgroups)
+	}
+	if exe := getProcessExe(5678); exe != "" {
+		test.Errorf("exe returned for unknown process: %s", exe)
+	}
+	if cgroups := getProcessCgroups(0); cgroups != nil {
+		test.Errorf("cgroups returned for invalid pid: %v", cgroups)
+	}
+}
@@ -0,0 +1,106 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"errors"
+	"fmt"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	envoySecret "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"google.golang.org/grpc"
+	"log"
+	"net"
+	"os"
+)
+
+// StartGrpcServer starts the SDS server on the configured listeners. The
+// Unix-Domain-Socket listener authorizes clients based on their user and
+// process details while the TCP listener requires mTLS authentication
+// with a certificate issued by the Athenz CA
+func StartGrpcServer(opts *options.Options, certUpdates chan bool, optsUpdates chan *options.Options) error {
+
+	serverHandler := NewServerHandler(opts)
+
+	var listeners []net.Listener
+	var grpcServers []*grpc.Server
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	if opts.SDSUdsPath != "" {
+		listener, err := StartUdsListener(opts.SDSUdsPath)
+		if err != nil {
+			return fmt.Errorf("unable to start uds listener for %s, error: %v", opts.SDSUdsPath, err)
+		}
+		listeners = append(listeners, listener)
+		grpcServers = append(grpcServers, grpc.NewServer(
+			grpc.Creds(NewCredentials()),
+		))
+	}
+	if opts.SDSTcpAddress != "" {
+		listener, err := StartTcpListener(opts.SDSTcpAddress)
+		if err != nil {
+			return fmt.Errorf("unable to start tcp listener for %s, error: %v", opts.SDSTcpAddress, err)
+		}
+		listeners = append(listeners, listener)
+		grpcServers = append(grpcServers, grpc.NewServer(
+			grpc.Creds(NewTLSCredentials(serverHandler)),
+		))
+	}
+	if len(listeners) == 0 {
+		return errors.New("no sds listeners configured")
+	}
+
+	go notifyCertificateUpdates(serverHandler, certUpdates, optsUpdates)
+
+	errChan := make(chan error, len(grpcServers))
+	for idx, grpcServer := range grpcServers {
+		envoySecret.RegisterSecretDiscoveryServiceServer(grpcServer, serverHandler)
+		go func(grpcServer *grpc.Server, listener net.Listener) {
+			errChan <- grpcServer.Serve(listener)
+		}(grpcServer, listeners[idx])
+	}
+
+	err := <-errChan
+	log.Println("Stopping GRPC SDS server...")
+	for _, grpcServer := range grpcServers {
+		grpcServer.Stop()
+	}
+	if opts.SDSUdsPath != "" {
+		if _, err := os.Stat(opts.SDSUdsPath); err == nil {
+			os.Remove(opts.SDSUdsPath)
+		}
+	}
+	if errors.Is(err, grpc.ErrServerStopped) {
+		err = nil
+	}
+
+	return err
+}
+
+func notifyCertificateUpdates(serverHandler *ServerHandler, updates <-chan bool, optsUpdates <-chan *options.Options) {
+	for {
+		select {
+		case <-updates:
+			serverHandler.NotifySubscribers()
+		case opts := <-optsUpdates:
+			serverHandler.UpdateOptions(opts)
+		}
+	}
+}
@@ -19,6 +19,7 @@ package sds
 import (
 	"github.com/google/uuid"
 	"log"
+	"sort"
 	"strconv"
 )
 
@@ -27,12 +28,14 @@ type Subscriber struct {
 	certUpdChan   chan bool
 	responseNonce string
 	versionNumber int
+	resources     map[string]string
 }
 
 func NewSubscriber() *Subscriber {
 	return &Subscriber{
 		id:          uuid.New().String(),
 		certUpdChan: make(chan bool, 1),
+		resources:   make(map[string]string),
 	}
 }
 
@@ -78,3 +81,45 @@ func (subscriber *Subscriber) GetCertUpdates() chan bool {
 func (subscriber *Subscriber) Notify() {
 	subscriber.certUpdChan <- true
 }
+
+// Subscribe adds the given resource names to the list of resources
+// tracked for incremental (delta) requests. Resources that are already
+// tracked keep their last sent version.
+func (subscriber *Subscriber) Subscribe(resourceNames []string) {
+	for _, name := range resourceNames {
+		if _, ok := subscriber.resources[name]; !ok {
+			subscriber.resources[name] = ""
+		}
+	}
+}
+
+// Unsubscribe removes the given resource names from the list of
+// resources tracked for incremental (delta) requests
+func (subscriber *Subscriber) Unsubscribe(resourceNames []string) {
+	for _, name := range resourceNames {
+		delete(subscriber.resources, name)
+	}
+}
+
+// GetResourceNames returns the sorted list of subscribed resource names
+func (subscriber *Subscriber) GetResourceNames() []string {
+	names := make([]string, 0, len(subscriber.resources))
+	for name := range subscriber.resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetResourceVersion returns the version of the given resource last sent
+// to the subscriber or an empty string if the resource was not sent
+func (subscriber *Subscriber) GetResourceVersion(name string) string {
+	return subscriber.resources[name]
+}
+
+// SetResourceVersion records the version of the given subscribed resource
+func (subscriber *Subscriber) SetResourceVersion(name, version string) {
+	if _, ok := subscriber.resources[name]; ok {
+		subscriber.resources[name] = version
+	}
+}
@@ -66,3 +66,31 @@ func TestSubscriberVersionInfo(test *testing.T) {
 	}
 	sub.Close()
 }
+
+func TestSubscriberResources(test *testing.T) {
+	sub := NewSubscriber()
+	sub.Subscribe([]string{"spiffe://athenz/sa/api", "spiffe://athenz/ca/default"})
+	names := sub.GetResourceNames()
+	if len(names) != 2 || names[0] != "spiffe://athenz/ca/default" || names[1] != "spiffe://athenz/sa/api" {
+		test.Errorf("subscriber does not have expected resources: %v", names)
+	}
+	sub.SetResourceVersion("spiffe://athenz/sa/api", "v1")
+	sub.SetResourceVersion("spiffe://athenz/sa/backend", "v1")
+	if sub.GetResourceVersion("spiffe://athenz/sa/api") != "v1" {
+		test.Errorf("subscriber does not have expected resource version")
+	}
+	if sub.GetResourceVersion("spiffe://athenz/sa/backend") != "" {
+		test.Errorf("subscriber has version for a resource that was not subscribed")
+	}
+	//subscribing again must not reset the version
+	sub.Subscribe([]string{"spiffe://athenz/sa/api"})
+	if sub.GetResourceVersion("spiffe://athenz/sa/api") != "v1" {
+		test.Errorf("subscriber resource version was reset")
+	}
+	sub.Unsubscribe([]string{"spiffe://athenz/sa/api"})
+	names = sub.GetResourceNames()
+	if len(names) != 1 || names[0] != "spiffe://athenz/ca/default" {
+		test.Errorf("subscriber does not have expected resources after unsubscribe: %v", names)
+	}
+	sub.Close()
+}
@@ -0,0 +1,154 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/AthenZ/athenz/libs/go/athenzutils"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"google.golang.org/grpc/credentials"
+	"log"
+	"net"
+	"os"
+)
+
+// StartTcpListener Start a TCP listener for clients that cannot share a
+// Unix-Domain-Socket with the agent (e.g. envoy running in a separate network
+// namespace). The connections are authenticated with mTLS by the credentials
+// returned from NewTLSCredentials
+func StartTcpListener(address string) (net.Listener, error) {
+
+	tcpListener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen to TCP listener on address %q: %v", address, err)
+	}
+
+	log.Printf("TCP listener is ready on address: %s\n", tcpListener.Addr().String())
+	return tcpListener, nil
+}
+
+type tlsCredentials struct {
+	handler *ServerHandler
+}
+
+// NewTLSCredentials returns the grpc credentials for the TCP listener. The
+// server presents the certificate of the primary service and requires the
+// client to present a certificate issued by the Athenz CA. The principal
+// from the client certificate is passed to grpc as part of the ClientInfo
+// object and is used to authorize access to the requested secrets
+func NewTLSCredentials(handler *ServerHandler) credentials.TransportCredentials {
+	return &tlsCredentials{handler: handler}
+}
+
+func (creds *tlsCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn.Close()
+	return conn, ClientInfo{}, errors.New("client handshake not expected")
+}
+
+func (creds *tlsCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+
+	config, err := creds.serverConfig()
+	if err != nil {
+		conn.Close()
+		return conn, ClientInfo{}, fmt.Errorf("unable to generate tls config: %v", err)
+	}
+
+	tlsConn := tls.Server(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		tlsConn.Close()
+		return conn, ClientInfo{}, fmt.Errorf("tls handshake failed with %s: %v", conn.RemoteAddr(), err)
+	}
+
+	clientInfo, err := getTLSClientDetails(tlsConn.ConnectionState())
+	if err != nil {
+		tlsConn.Close()
+		return conn, ClientInfo{}, err
+	}
+	return tlsConn, clientInfo, nil
+}
+
+// serverConfig generates the tls config for the new connection. The key,
+// certificate and CA files are read for every connection so any refreshed
+// certificates are picked up without restarting the listener
+func (creds *tlsCredentials) serverConfig() (*tls.Config, error) {
+
+	opts := creds.handler.GetOptions()
+	if len(opts.Services) == 0 {
+		return nil, errors.New("no services configured")
+	}
+	svc := opts.Services[0]
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caCerts, err := os.ReadFile(opts.AthenzCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caCerts) {
+		return nil, fmt.Errorf("unable to load ca certificates from %s", opts.AthenzCACertFile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    certPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	}, nil
+}
+
+// Get the principal from the verified client certificate
+func getTLSClientDetails(state tls.ConnectionState) (ClientInfo, error) {
+
+	if len(state.PeerCertificates) == 0 {
+		return ClientInfo{}, errors.New("client did not provide a certificate")
+	}
+	principal, err := athenzutils.ExtractServicePrincipal(*state.PeerCertificates[0])
+	if err != nil {
+		return ClientInfo{}, fmt.Errorf("unable to extract principal from client certificate: %v", err)
+	}
+	return ClientInfo{
+		UserID:    -1,
+		GroupID:   -1,
+		Principal: principal,
+	}, nil
+}
+
+func (creds *tlsCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: TLSClientAuthType(),
+		ServerName:       AthenzGrpcServerName(),
+	}
+}
+
+func (creds *tlsCredentials) Clone() credentials.TransportCredentials {
+	clone := *creds
+	return &clone
+}
+
+func (creds *tlsCredentials) OverrideServerName(_ string) error {
+	return nil
+}
@@ -0,0 +1,228 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(test *testing.T, commonName string, issuer *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		test.Fatalf("unable to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{commonName},
+	}
+	parent := template
+	var signer crypto.Signer = key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent = issuer.cert
+		signer = issuer.key
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		test.Fatalf("unable to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		test.Fatalf("unable to parse certificate: %v", err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func (tc *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.cert.Raw})
+}
+
+func (tc *testCert) keyPEM(test *testing.T) []byte {
+	keyBytes, err := x509.MarshalECPrivateKey(tc.key)
+	if err != nil {
+		test.Fatalf("unable to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+}
+
+func (tc *testCert) tlsCertificate(test *testing.T) tls.Certificate {
+	certificate, err := tls.X509KeyPair(tc.certPEM(), tc.keyPEM(test))
+	if err != nil {
+		test.Fatalf("unable to load key pair: %v", err)
+	}
+	return certificate
+}
+
+// tlsTestOptions writes the ca and the server certificate for the
+// athenz.api service into a temporary directory
+func tlsTestOptions(test *testing.T, ca *testCert) *options.Options {
+	dir := test.TempDir()
+	server := newTestCert(test, "athenz.api", ca)
+	files := map[string][]byte{
+		"athenz.api.cert.pem": server.certPEM(),
+		"athenz.api.key.pem":  server.keyPEM(test),
+		"ca.cert.pem":         ca.certPEM(),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0400); err != nil {
+			test.Fatalf("unable to write %s: %v", name, err)
+		}
+	}
+	return &options.Options{
+		Domain:           "athenz",
+		KeyDir:           dir,
+		CertDir:          dir,
+		AthenzCACertFile: filepath.Join(dir, "ca.cert.pem"),
+		Services:         []options.Service{{Name: "api", SDSTcpPrincipals: []string{"athenz.envoy"}}},
+	}
+}
+
+// tlsHandshake runs the server handshake against a client presenting the given certificate
+func tlsHandshake(test *testing.T, opts *options.Options, ca *testCert, client *testCert) (ClientInfo, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		test.Fatalf("unable to start listener: %v", err)
+	}
+	defer listener.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{
+		RootCAs:    roots,
+		ServerName: "athenz.api",
+		NextProtos: []string{"h2"},
+	}
+	if client != nil {
+		config.Certificates = []tls.Certificate{client.tlsCertificate(test)}
+	}
+	go func() {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+		if err == nil {
+			// wait for the server to complete the handshake
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+	}()
+
+	serverConn, err := listener.Accept()
+	if err != nil {
+		test.Fatalf("unable to accept connection: %v", err)
+	}
+	conn, authInfo, err := NewTLSCredentials(NewServerHandler(opts)).ServerHandshake(serverConn)
+	if err != nil {
+		return ClientInfo{}, err
+	}
+	defer conn.Close()
+	return authInfo.(ClientInfo), nil
+}
+
+func TestTLSServerHandshake(test *testing.T) {
+	ca := newTestCert(test, "Athenz Test CA", nil)
+	opts := tlsTestOptions(test, ca)
+
+	info, err := tlsHandshake(test, opts, ca, newTestCert(test, "athenz.envoy", ca))
+	if err != nil {
+		test.Fatalf("handshake with valid client certificate failed: %v", err)
+	}
+	if info.Principal != "athenz.envoy" || info.UserID != -1 || info.GroupID != -1 {
+		test.Errorf("unexpected client info: %v", info)
+	}
+	if info.AuthType() != "athenz-tls" {
+		test.Errorf("unexpected auth-type: '%s', expected 'athenz-tls'", info.AuthType())
+	}
+
+	// certificate from a different ca is rejected
+	_, err = tlsHandshake(test, opts, ca, newTestCert(test, "athenz.envoy", newTestCert(test, "Unknown CA", nil)))
+	if err == nil {
+		test.Errorf("handshake with untrusted client certificate was accepted")
+	}
+
+	// client must present a certificate
+	_, err = tlsHandshake(test, opts, ca, nil)
+	if err == nil {
+		test.Errorf("handshake without client certificate was accepted")
+	}
+
+	// missing server certificate fails the handshake
+	opts.Services[0].Name = "unknown"
+	_, err = tlsHandshake(test, opts, ca, newTestCert(test, "athenz.envoy", ca))
+	if err == nil || !strings.Contains(err.Error(), "unable to generate tls config") {
+		test.Errorf("handshake without server certificate was not rejected: %v", err)
+	}
+}
+
+func TestAuthenticateRequestTcpPrincipal(test *testing.T) {
+	handler := NewServerHandler(&options.Options{
+		Domain: "athenz",
+		Services: []options.Service{
+			{Name: "api", SDSTcpPrincipals: []string{"athenz.envoy", "sports.proxy"}},
+			{Name: "backend"},
+		},
+	})
+	tests := []struct {
+		name      string
+		principal string
+		service   string
+		errMsg    string
+	}{
+		{"valid", "athenz.envoy", "api", ""},
+		{"valid-other-domain", "sports.proxy", "api", ""},
+		{"invalid-principal", "athenz.backend", "api", "invalid principal: athenz.backend"},
+		{"no-tcp-principals", "athenz.envoy", "backend", "invalid principal: athenz.envoy"},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			info := ClientInfo{UserID: -1, GroupID: -1, Principal: tt.principal}
+			_, err := handler.authenticateRequest(info, nil, "athenz", tt.service)
+			if tt.errMsg == "" && err != nil {
+				t.Errorf("valid requested was not correctly authenticated: %v", err)
+			}
+			if tt.errMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.errMsg)) {
+				t.Errorf("request was not rejected with expected error %q: %v", tt.errMsg, err)
+			}
+		})
+	}
+}
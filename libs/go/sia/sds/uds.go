@@ -93,7 +93,7 @@ func (listener *Listener) Addr() net.Addr {
 // Get the Unix-Domain-Socket's user and process ids
 func getUdsUserDetails(connection net.Conn) ClientInfo {
 
-	var clientInfo ClientInfo
+	clientInfo := ClientInfo{UserID: -1, GroupID: -1}
 
 	// Get uid from UDS connection.
 	credentials, err := peercred.Get(connection)
@@ -111,9 +111,18 @@ func getUdsUserDetails(connection net.Conn) ClientInfo {
 			clientInfo.UserID = uid
 		}
 	}
+	clientInfo.GroupID, ok = getPeerGroupId(connection)
+	if !ok {
+		log.Println("unable to obtain connection group id")
+	}
 	clientInfo.PID, ok = credentials.PID()
 	if !ok {
 		log.Println("unable to obtain connection pid")
+		return clientInfo
 	}
+	// the process details are captured when the connection is accepted
+	// and are used to authorize all requests received on the connection
+	clientInfo.Exe = getProcessExe(clientInfo.PID)
+	clientInfo.Cgroups = getProcessCgroups(clientInfo.PID)
 	return clientInfo
 }
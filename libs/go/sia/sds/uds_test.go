@@ -0,0 +1,40 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sds
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+)
+
+func TestGetUdsUserDetailsPeerCredFailure(test *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// without the peer credentials the client must not match
+	// any configured uid, including root
+	info := getUdsUserDetails(server)
+	if info.UserID != -1 || info.GroupID != -1 {
+		test.Errorf("invalid client ids returned - uid: %d, gid: %d", info.UserID, info.GroupID)
+	}
+	if err := authenticateUdsClient(info, &options.Service{Name: "api", SDSUdsUid: 0}); err == nil {
+		test.Errorf("client without peer credentials was authenticated")
+	}
+}
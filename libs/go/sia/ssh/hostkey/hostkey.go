@@ -48,6 +48,11 @@ var toId = map[string]KeyType{
 	"ed25519": Ed25519,
 }
 
+// String returns the name of the key type used in the host key file names
+func (s KeyType) String() string {
+	return toString[s]
+}
+
 // MarshalJSON marshals the enum as a quoted json string
 func (s KeyType) MarshalJSON() ([]byte, error) {
 	buffer := bytes.NewBufferString(`"`)
@@ -96,3 +101,20 @@ func PubKeyFile(sshDir string, keyType KeyType) string {
 func CertFile(sshDir string, keyType KeyType) string {
 	return filepath.Join(sshDir, fmt.Sprintf("ssh_host_%s_key-cert.pub", toString[keyType]))
 }
+
+// KeyTypes returns all the supported host key types
+func KeyTypes() []KeyType {
+	return []KeyType{Rsa, Ecdsa, Ed25519}
+}
+
+// PresentKeyTypes returns the host key types from the given list whose
+// public key files are present in the ssh directory
+func PresentKeyTypes(sshDir string, keyTypes []KeyType) []KeyType {
+	var present []KeyType
+	for _, keyType := range keyTypes {
+		if _, err := os.Stat(PubKeyFile(sshDir, keyType)); err == nil {
+			present = append(present, keyType)
+		}
+	}
+	return present
+}
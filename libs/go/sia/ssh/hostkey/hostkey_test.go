@@ -129,3 +129,14 @@ func TestCertKeyFile(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf("/tmp/ssh_host_%s_key-cert.pub", s), CertFile("/tmp", kt))
 	}
 }
+
+func TestPresentKeyTypes(t *testing.T) {
+	tmpSshDir := t.TempDir()
+	assert.Empty(t, PresentKeyTypes(tmpSshDir, KeyTypes()))
+
+	require.Nil(t, os.WriteFile(PubKeyFile(tmpSshDir, Ecdsa), []byte("ecdsa key"), 0644))
+	require.Nil(t, os.WriteFile(PubKeyFile(tmpSshDir, Ed25519), []byte("ed25519 key"), 0644))
+	assert.Equal(t, []KeyType{Ecdsa, Ed25519}, PresentKeyTypes(tmpSshDir, KeyTypes()))
+	assert.Equal(t, []KeyType{Ed25519}, PresentKeyTypes(tmpSshDir, []KeyType{Rsa, Ed25519}))
+	assert.Equal(t, "ecdsa", Ecdsa.String())
+}
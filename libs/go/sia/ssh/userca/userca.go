@@ -0,0 +1,75 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package userca
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"golang.org/x/crypto/ssh"
+)
+
+// Parse returns the ssh user ca public keys from the bundle in the format
+// expected by the sshd TrustedUserCAKeys file. Empty lines and comments are
+// dropped and an error is returned if any of the keys cannot be parsed or
+// the bundle does not include any keys.
+func Parse(bundle string) ([]byte, error) {
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(strings.NewReader(bundle))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+			return nil, fmt.Errorf("unable to parse ssh user ca public key: %q, error: %v", line, err)
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return nil, fmt.Errorf("no ssh user ca public keys found")
+	}
+	return buf.Bytes(), nil
+}
+
+// Update writes the ssh user ca public keys from the bundle to the
+// TrustedUserCAKeys file if they have changed. It returns true if the
+// file was updated.
+func Update(caKeysFile, bundle string) (bool, error) {
+	caKeys, err := Parse(bundle)
+	if err != nil {
+		return false, err
+	}
+	prevCAKeys, _ := os.ReadFile(caKeysFile)
+	if bytes.Equal(prevCAKeys, caKeys) {
+		return false, nil
+	}
+	// Setting 644 as the file is read by sshd similar to the host public keys
+	err = util.UpdateFile(caKeysFile, caKeys, 0, 0, 0644)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
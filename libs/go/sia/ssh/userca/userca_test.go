@@ -0,0 +1,74 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package userca
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newCAKey(t *testing.T) string {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.Nil(t, err)
+	return string(ssh.MarshalAuthorizedKey(sshPub))
+}
+
+func TestParse(t *testing.T) {
+	caKey1, caKey2 := newCAKey(t), newCAKey(t)
+
+	caKeys, err := Parse("# athenz ssh user ca\n" + caKey1 + "\n  " + caKey2)
+	require.Nil(t, err)
+	assert.Equal(t, caKey1+caKey2, string(caKeys))
+
+	_, err = Parse("# athenz ssh user ca\n\n")
+	assert.NotNil(t, err)
+	_, err = Parse(caKey1 + "ssh-ed25519 invalid-key\n")
+	assert.NotNil(t, err)
+}
+
+func TestUpdate(t *testing.T) {
+	caKey := newCAKey(t)
+	caKeysFile := filepath.Join(t.TempDir(), "trusted_user_ca_keys.pub")
+
+	updated, err := Update(caKeysFile, caKey)
+	require.Nil(t, err)
+	assert.True(t, updated)
+	data, err := os.ReadFile(caKeysFile)
+	require.Nil(t, err)
+	assert.Equal(t, caKey, string(data))
+
+	updated, err = Update(caKeysFile, "# athenz ssh user ca\n"+caKey)
+	require.Nil(t, err)
+	assert.False(t, updated)
+
+	// invalid bundles do not replace the existing keys
+	updated, err = Update(caKeysFile, "invalid")
+	assert.NotNil(t, err)
+	assert.False(t, updated)
+	data, err = os.ReadFile(caKeysFile)
+	require.Nil(t, err)
+	assert.Equal(t, caKey, string(data))
+}
@@ -0,0 +1,173 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Handler returns the http handler serving the /healthz, /status
+// and /metrics endpoints based on the state of the tracker
+func (t *Tracker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", t.serveHealth)
+	mux.HandleFunc("/status", t.serveStatus)
+	mux.HandleFunc("/metrics", t.serveMetrics)
+	return mux
+}
+
+func (t *Tracker) serveHealth(w http.ResponseWriter, _ *http.Request) {
+	if t.Status().Healthy {
+		io.WriteString(w, "ok\n")
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(w, "expired credentials\n")
+}
+
+func (t *Tracker) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	status := t.Status()
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Printf("unable to encode status response: %v\n", err)
+	}
+}
+
+func (t *Tracker) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	t.WriteMetrics(w)
+}
+
+// WriteMetrics writes the agent metrics in the Prometheus text format
+func (t *Tracker) WriteMetrics(w io.Writer) {
+	status := t.Status()
+	now := time.Now()
+
+	fmt.Fprintln(w, "# HELP sia_refresh_attempts_total Number of refresh attempts.")
+	fmt.Fprintln(w, "# TYPE sia_refresh_attempts_total counter")
+	for _, e := range status.Entries {
+		fmt.Fprintf(w, "sia_refresh_attempts_total{%s} %d\n", entryLabels(e), e.Attempts)
+	}
+	fmt.Fprintln(w, "# HELP sia_refresh_failures_total Number of failed refresh attempts.")
+	fmt.Fprintln(w, "# TYPE sia_refresh_failures_total counter")
+	for _, e := range status.Entries {
+		fmt.Fprintf(w, "sia_refresh_failures_total{%s} %d\n", entryLabels(e), e.Failures)
+	}
+	fmt.Fprintln(w, "# HELP sia_refresh_consecutive_failures Number of refresh failures since the last success.")
+	fmt.Fprintln(w, "# TYPE sia_refresh_consecutive_failures gauge")
+	for _, e := range status.Entries {
+		fmt.Fprintf(w, "sia_refresh_consecutive_failures{%s} %d\n", entryLabels(e), e.ConsecutiveFailures)
+	}
+	fmt.Fprintln(w, "# HELP sia_last_success_timestamp_seconds Time of the last successful refresh.")
+	fmt.Fprintln(w, "# TYPE sia_last_success_timestamp_seconds gauge")
+	for _, e := range status.Entries {
+		if e.LastSuccess != nil {
+			fmt.Fprintf(w, "sia_last_success_timestamp_seconds{%s} %d\n", entryLabels(e), e.LastSuccess.Unix())
+		}
+	}
+	fmt.Fprintln(w, "# HELP sia_expiry_seconds Seconds until the credential expires.")
+	fmt.Fprintln(w, "# TYPE sia_expiry_seconds gauge")
+	for _, e := range status.Entries {
+		if e.Expiry != nil {
+			fmt.Fprintf(w, "sia_expiry_seconds{%s} %.0f\n", entryLabels(e), e.Expiry.Sub(now).Seconds())
+		}
+	}
+
+	t.mutex.RLock()
+	operations := make([]string, 0, len(t.ztsLatency))
+	for op := range t.ztsLatency {
+		operations = append(operations, op)
+	}
+	sort.Strings(operations)
+	fmt.Fprintln(w, "# HELP sia_zts_request_duration_seconds Latency of the requests sent to ZTS.")
+	fmt.Fprintln(w, "# TYPE sia_zts_request_duration_seconds summary")
+	for _, op := range operations {
+		l := t.ztsLatency[op]
+		fmt.Fprintf(w, "sia_zts_request_duration_seconds_sum{operation=%q} %f\n", op, l.sum.Seconds())
+		fmt.Fprintf(w, "sia_zts_request_duration_seconds_count{operation=%q} %d\n", op, l.count)
+	}
+	fmt.Fprintln(w, "# HELP sia_zts_request_failures_total Number of failed requests sent to ZTS.")
+	fmt.Fprintln(w, "# TYPE sia_zts_request_failures_total counter")
+	for _, op := range operations {
+		fmt.Fprintf(w, "sia_zts_request_failures_total{operation=%q} %d\n", op, t.ztsFailures[op])
+	}
+	t.mutex.RUnlock()
+
+	fmt.Fprintln(w, "# HELP sia_sds_subscribers Number of active SDS subscribers.")
+	fmt.Fprintln(w, "# TYPE sia_sds_subscribers gauge")
+	fmt.Fprintf(w, "sia_sds_subscribers %d\n", status.SdsSubscribers)
+}
+
+func entryLabels(e Entry) string {
+	return fmt.Sprintf("type=%q,name=%q", e.Kind, e.Name)
+}
+
+// Listen returns the listener for the status server. If the uds path is
+// specified, the server listens on the unix domain socket, otherwise it
+// listens on the given port on the loopback interface only.
+func Listen(udsPath string, port int) (net.Listener, error) {
+	if udsPath != "" {
+		err := os.MkdirAll(filepath.Dir(udsPath), 0755)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create directory for status socket %s: %v", udsPath, err)
+		}
+		os.Remove(udsPath)
+		listener, err := net.Listen("unix", udsPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to listen on status socket %s: %v", udsPath, err)
+		}
+		err = os.Chmod(udsPath, 0666)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("unable to set permissions on status socket %s: %v", udsPath, err)
+		}
+		return listener, nil
+	}
+	return net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+}
+
+// StartServer serves the status endpoints of the default tracker until
+// the server fails. It returns immediately if no listener is configured.
+func StartServer(udsPath string, port int) error {
+	if udsPath == "" && port == 0 {
+		return nil
+	}
+	listener, err := Listen(udsPath, port)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("status server listening on %s\n", listener.Addr())
+	server := &http.Server{
+		Handler:           Default.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.Serve(listener)
+}
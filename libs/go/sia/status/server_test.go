@@ -0,0 +1,111 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package status
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(test *testing.T) {
+	tracker := NewTracker()
+	tracker.Success(ServiceCert, "api", "api.cert.pem", time.Now().Add(time.Hour))
+	tracker.Failure(RoleCert, "athenz:role.readers", "readers.cert.pem", errors.New("forbidden"))
+	tracker.ObserveZtsRequest("PostRoleCertificateRequest", 250*time.Millisecond, errors.New("forbidden"))
+	tracker.SetSdsSubscribers(2)
+
+	server := httptest.NewServer(tracker.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/healthz")
+	require.Nil(test, err)
+	assert.Equal(test, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/status")
+	require.Nil(test, err)
+	var status Status
+	require.Nil(test, json.NewDecoder(resp.Body).Decode(&status))
+	resp.Body.Close()
+	assert.True(test, status.Healthy)
+	assert.Equal(test, 2, status.SdsSubscribers)
+	require.Equal(test, 2, len(status.Entries))
+	assert.Equal(test, "forbidden", status.Entries[0].LastError)
+
+	resp, err = http.Get(server.URL + "/metrics")
+	require.Nil(test, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	metrics := string(body)
+	assert.Contains(test, metrics, `sia_refresh_attempts_total{type="service_cert",name="api"} 1`)
+	assert.Contains(test, metrics, `sia_refresh_failures_total{type="role_cert",name="athenz:role.readers"} 1`)
+	assert.Contains(test, metrics, `sia_expiry_seconds{type="service_cert",name="api"}`)
+	assert.Contains(test, metrics, `sia_zts_request_duration_seconds_count{operation="PostRoleCertificateRequest"} 1`)
+	assert.Contains(test, metrics, `sia_zts_request_failures_total{operation="PostRoleCertificateRequest"} 1`)
+	assert.Contains(test, metrics, "sia_sds_subscribers 2")
+
+	tracker.Register(ServiceCert, "api", "", time.Now().Add(-time.Minute))
+	resp, err = http.Get(server.URL + "/healthz")
+	require.Nil(test, err)
+	assert.Equal(test, http.StatusServiceUnavailable, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestWriteMetricsEmpty(test *testing.T) {
+	var buf bytes.Buffer
+	NewTracker().WriteMetrics(&buf)
+	assert.Contains(test, buf.String(), "sia_sds_subscribers 0")
+}
+
+func TestListenUds(test *testing.T) {
+	udsPath := filepath.Join(test.TempDir(), "run", "status.sock")
+	listener, err := Listen(udsPath, 0)
+	require.Nil(test, err)
+	defer listener.Close()
+
+	server := &http.Server{Handler: NewTracker().Handler()}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", udsPath)
+			},
+		},
+	}
+	resp, err := client.Get("http://localhost/healthz")
+	require.Nil(test, err)
+	assert.Equal(test, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestStartServerNotConfigured(test *testing.T) {
+	assert.Nil(test, StartServer("", 0))
+}
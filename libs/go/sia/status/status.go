@@ -0,0 +1,232 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kind identifies the type of credential being tracked
+type Kind string
+
+const (
+	ServiceCert    Kind = "service_cert"
+	RoleCert       Kind = "role_cert"
+	AccessToken    Kind = "access_token"
+	IdToken        Kind = "id_token"
+	AWSCredentials Kind = "aws_credentials"
+)
+
+// Entry contains the refresh state of a single service certificate,
+// role certificate, access token, id token or aws credentials profile
+// managed by the agent
+type Entry struct {
+	Kind                Kind       `json:"type"`
+	Name                string     `json:"name"`
+	File                string     `json:"file"`
+	Expiry              *time.Time `json:"expiry,omitempty"`
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorTime       *time.Time `json:"last_error_time,omitempty"`
+	Attempts            int64      `json:"attempts"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int64      `json:"consecutive_failures"`
+}
+
+// Status is the snapshot of the agent state returned by the /status endpoint
+type Status struct {
+	Healthy        bool      `json:"healthy"`
+	StartTime      time.Time `json:"start_time"`
+	SdsSubscribers int       `json:"sds_subscribers"`
+	Entries        []Entry   `json:"entries"`
+}
+
+type latency struct {
+	count int64
+	sum   time.Duration
+}
+
+// Tracker keeps the state of all credentials managed by the agent
+// along with the latency of the requests sent to ZTS
+type Tracker struct {
+	mutex          sync.RWMutex
+	startTime      time.Time
+	entries        map[string]*Entry
+	ztsLatency     map[string]*latency
+	ztsFailures    map[string]int64
+	sdsSubscribers int
+}
+
+// Default is the tracker updated by the agent components
+var Default = NewTracker()
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		startTime:   time.Now(),
+		entries:     make(map[string]*Entry),
+		ztsLatency:  make(map[string]*latency),
+		ztsFailures: make(map[string]int64),
+	}
+}
+
+func entryKey(kind Kind, name string) string {
+	return string(kind) + "/" + name
+}
+
+// entry returns the entry for the given credential, creating it if
+// necessary. The caller must hold the write lock.
+func (t *Tracker) entry(kind Kind, name, file string) *Entry {
+	key := entryKey(kind, name)
+	e, ok := t.entries[key]
+	if !ok {
+		e = &Entry{Kind: kind, Name: name}
+		t.entries[key] = e
+	}
+	if file != "" {
+		e.File = file
+	}
+	return e
+}
+
+// Register adds the given credential to the tracker with its current
+// expiry time, if known, without counting it as a refresh attempt
+func (t *Tracker) Register(kind Kind, name, file string, expiry time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	e := t.entry(kind, name, file)
+	if !expiry.IsZero() {
+		e.Expiry = &expiry
+	}
+}
+
+// Unregister removes the given credential from the tracker
+func (t *Tracker) Unregister(kind Kind, name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.entries, entryKey(kind, name))
+}
+
+// Success records a successful refresh of the given credential
+func (t *Tracker) Success(kind Kind, name, file string, expiry time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	e := t.entry(kind, name, file)
+	e.Attempts++
+	e.ConsecutiveFailures = 0
+	e.LastAttempt = &now
+	e.LastSuccess = &now
+	if !expiry.IsZero() {
+		e.Expiry = &expiry
+	}
+}
+
+// Failure records a failed refresh of the given credential
+func (t *Tracker) Failure(kind Kind, name, file string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	e := t.entry(kind, name, file)
+	e.Attempts++
+	e.Failures++
+	e.ConsecutiveFailures++
+	e.LastAttempt = &now
+	e.LastErrorTime = &now
+	if err != nil {
+		e.LastError = err.Error()
+	}
+}
+
+// ObserveZtsRequest records the latency of a request sent to ZTS
+func (t *Tracker) ObserveZtsRequest(operation string, duration time.Duration, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	l, ok := t.ztsLatency[operation]
+	if !ok {
+		l = &latency{}
+		t.ztsLatency[operation] = l
+	}
+	l.count++
+	l.sum += duration
+	if err != nil {
+		t.ztsFailures[operation]++
+	}
+}
+
+// SetSdsSubscribers records the number of active SDS subscribers
+func (t *Tracker) SetSdsSubscribers(count int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.sdsSubscribers = count
+}
+
+// Status returns a snapshot of the current state. The agent is considered
+// healthy if none of the credentials with a known expiry have expired.
+func (t *Tracker) Status() Status {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	now := time.Now()
+	status := Status{
+		Healthy:        true,
+		StartTime:      t.startTime,
+		SdsSubscribers: t.sdsSubscribers,
+		Entries:        make([]Entry, 0, len(t.entries)),
+	}
+	for _, e := range t.entries {
+		if e.Expiry != nil && !e.Expiry.After(now) {
+			status.Healthy = false
+		}
+		status.Entries = append(status.Entries, *e)
+	}
+	sort.Slice(status.Entries, func(i, j int) bool {
+		return entryKey(status.Entries[i].Kind, status.Entries[i].Name) < entryKey(status.Entries[j].Kind, status.Entries[j].Name)
+	})
+	return status
+}
+
+// Register adds the given credential to the default tracker
+func Register(kind Kind, name, file string, expiry time.Time) {
+	Default.Register(kind, name, file, expiry)
+}
+
+// Unregister removes the given credential from the default tracker
+func Unregister(kind Kind, name string) {
+	Default.Unregister(kind, name)
+}
+
+// Success records a successful refresh in the default tracker
+func Success(kind Kind, name, file string, expiry time.Time) {
+	Default.Success(kind, name, file, expiry)
+}
+
+// Failure records a failed refresh in the default tracker
+func Failure(kind Kind, name, file string, err error) {
+	Default.Failure(kind, name, file, err)
+}
+
+// ObserveZtsRequest records the latency of a ZTS request in the default tracker
+func ObserveZtsRequest(operation string, duration time.Duration, err error) {
+	Default.ObserveZtsRequest(operation, duration, err)
+}
+
+// SetSdsSubscribers records the number of active SDS subscribers in the default tracker
+func SetSdsSubscribers(count int) {
+	Default.SetSdsSubscribers(count)
+}
@@ -0,0 +1,88 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package status
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackerSuccessFailure(test *testing.T) {
+	tracker := NewTracker()
+	expiry := time.Now().Add(time.Hour)
+
+	tracker.Register(ServiceCert, "api", "/var/lib/sia/certs/athenz.api.cert.pem", time.Time{})
+	tracker.Failure(ServiceCert, "api", "", errors.New("zts unavailable"))
+	tracker.Failure(ServiceCert, "api", "", errors.New("zts unavailable"))
+
+	status := tracker.Status()
+	require.Equal(test, 1, len(status.Entries))
+	entry := status.Entries[0]
+	assert.Equal(test, "/var/lib/sia/certs/athenz.api.cert.pem", entry.File)
+	assert.Equal(test, int64(2), entry.Attempts)
+	assert.Equal(test, int64(2), entry.Failures)
+	assert.Equal(test, int64(2), entry.ConsecutiveFailures)
+	assert.Equal(test, "zts unavailable", entry.LastError)
+	assert.Nil(test, entry.LastSuccess)
+	assert.Nil(test, entry.Expiry)
+
+	tracker.Success(ServiceCert, "api", "", expiry)
+	entry = tracker.Status().Entries[0]
+	assert.Equal(test, int64(3), entry.Attempts)
+	assert.Equal(test, int64(2), entry.Failures)
+	assert.Equal(test, int64(0), entry.ConsecutiveFailures)
+	assert.NotNil(test, entry.LastSuccess)
+	assert.Equal(test, expiry, *entry.Expiry)
+	assert.True(test, tracker.Status().Healthy)
+}
+
+func TestTrackerHealth(test *testing.T) {
+	tracker := NewTracker()
+	tracker.Register(ServiceCert, "api", "api.cert.pem", time.Now().Add(time.Hour))
+	tracker.Register(AccessToken, "athenz/reader", "reader", time.Time{})
+	assert.True(test, tracker.Status().Healthy)
+
+	tracker.Register(RoleCert, "athenz:role.readers", "readers.cert.pem", time.Now().Add(-time.Minute))
+	status := tracker.Status()
+	assert.False(test, status.Healthy)
+
+	// entries are sorted by type and name
+	require.Equal(test, 3, len(status.Entries))
+	assert.Equal(test, AccessToken, status.Entries[0].Kind)
+	assert.Equal(test, RoleCert, status.Entries[1].Kind)
+	assert.Equal(test, ServiceCert, status.Entries[2].Kind)
+}
+
+func TestTrackerSdsSubscribers(test *testing.T) {
+	tracker := NewTracker()
+	tracker.SetSdsSubscribers(3)
+	assert.Equal(test, 3, tracker.Status().SdsSubscribers)
+}
+
+func TestTrackerUnregister(test *testing.T) {
+	tracker := NewTracker()
+	tracker.Register(RoleCert, "athenz:role.readers", "readers.cert.pem", time.Time{})
+	tracker.Register(RoleCert, "athenz:role.writers", "writers.cert.pem", time.Time{})
+	tracker.Unregister(RoleCert, "athenz:role.readers")
+	status := tracker.Status()
+	require.Equal(test, 1, len(status.Entries))
+	assert.Equal(test, "athenz:role.writers", status.Entries[0].Name)
+}
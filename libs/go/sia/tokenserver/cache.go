@@ -0,0 +1,78 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tokenserver
+
+import (
+	"sync"
+	"time"
+)
+
+// cacheRefreshRatio is the fraction of the token lifetime after which
+// the cached token is no longer served and a new one is requested
+const cacheRefreshRatio = 0.75
+
+type cacheEntry struct {
+	data    []byte
+	refresh time.Time
+	expiry  time.Time
+}
+
+// tokenCache keeps the responses received from ZTS so that the same token
+// is returned to the applications until it gets close to its expiry
+type tokenCache struct {
+	mutex   sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// get returns the cached response for the given key if it has
+// not yet reached its refresh time
+func (c *tokenCache) get(key string, now time.Time) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.refresh) {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// put stores the response issued at the given time and expiring at the
+// given expiry time. Any other entries that have expired are removed
+func (c *tokenCache) put(key string, data []byte, issued, expiry time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for k, entry := range c.entries {
+		if !issued.Before(entry.expiry) {
+			delete(c.entries, k)
+		}
+	}
+	if !issued.Before(expiry) {
+		return
+	}
+	lifetime := expiry.Sub(issued)
+	c.entries[key] = cacheEntry{
+		data:    data,
+		refresh: issued.Add(time.Duration(float64(lifetime) * cacheRefreshRatio)),
+		expiry:  expiry,
+	}
+}
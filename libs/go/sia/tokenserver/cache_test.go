@@ -0,0 +1,50 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tokenserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenCache(test *testing.T) {
+	cache := newTokenCache()
+	issued := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, ok := cache.get("api:/v1/accesstoken?domain=sports", issued)
+	assert.False(test, ok)
+
+	cache.put("api:/v1/accesstoken?domain=sports", []byte("token"), issued, issued.Add(time.Hour))
+	data, ok := cache.get("api:/v1/accesstoken?domain=sports", issued.Add(30*time.Minute))
+	assert.True(test, ok)
+	assert.Equal(test, []byte("token"), data)
+
+	// once most of the token lifetime has passed a new token is requested
+	_, ok = cache.get("api:/v1/accesstoken?domain=sports", issued.Add(45*time.Minute))
+	assert.False(test, ok)
+
+	// responses without a valid expiry are never cached
+	cache.put("api:/v1/roletoken?domain=sports", []byte("token"), issued, time.Time{})
+	_, ok = cache.get("api:/v1/roletoken?domain=sports", issued)
+	assert.False(test, ok)
+
+	// expired entries are removed when new entries are added
+	cache.put("api:/v1/idtoken?client_id=sports.api", []byte("token"), issued.Add(2*time.Hour), issued.Add(3*time.Hour))
+	assert.Equal(test, 1, len(cache.entries))
+}
@@ -0,0 +1,253 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package tokenserver implements the local token-vending endpoints of the
+// agent. Applications running on the host connect over a Unix-Domain-Socket
+// and receive access, role and id tokens issued by ZTS for the service
+// identity configured with their user, without having to load the service
+// key and certificate themselves.
+package tokenserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/status"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/ardielle/ardielle-go/rdl"
+	"inet.af/peercred"
+)
+
+type peerUidKey struct{}
+
+type Server struct {
+	mutex  sync.RWMutex
+	opts   *options.Options
+	ztsUrl string
+	cache  *tokenCache
+}
+
+func NewServer(opts *options.Options, ztsUrl string) *Server {
+	return &Server{
+		opts:   opts,
+		ztsUrl: ztsUrl,
+		cache:  newTokenCache(),
+	}
+}
+
+func (s *Server) GetOptions() *options.Options {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.opts
+}
+
+// UpdateOptions replaces the options after a config reload. The
+// new service users are applied to all the following requests
+func (s *Server) UpdateOptions(opts *options.Options) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.opts = opts
+}
+
+// Handler returns the http handler serving the token endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/accesstoken", s.serveAccessToken)
+	mux.HandleFunc("/v1/roletoken", s.serveRoleToken)
+	mux.HandleFunc("/v1/idtoken", s.serveIdToken)
+	return mux
+}
+
+// Serve listens on the given Unix-Domain-Socket and serves the token
+// endpoints until the server fails
+func (s *Server) Serve(udsPath string) error {
+	listener, err := listen(udsPath)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("token server is ready on path: %s\n", udsPath)
+
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ConnContext:       peerContext,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.Serve(listener)
+}
+
+func listen(udsPath string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(udsPath), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create directory for token socket %s: %v", udsPath, err)
+	}
+	os.Remove(udsPath)
+	listener, err := net.Listen("unix", udsPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on token socket %s: %v", udsPath, err)
+	}
+	// any local process may connect since the requests
+	// are authorized based on the peer uid
+	err = os.Chmod(udsPath, 0666)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to set permissions on token socket %s: %v", udsPath, err)
+	}
+	return listener, nil
+}
+
+// peerContext stores the uid of the process on the other side of the
+// Unix-Domain-Socket connection in the context of all its requests
+func peerContext(ctx context.Context, conn net.Conn) context.Context {
+	credentials, err := peercred.Get(conn)
+	if err != nil {
+		log.Printf("unable to obtain token server connection credentials: %v\n", err)
+		return ctx
+	}
+	userId, ok := credentials.UserID()
+	if !ok {
+		log.Println("unable to obtain token server connection user id")
+		return ctx
+	}
+	uid, err := strconv.Atoi(userId)
+	if err != nil {
+		log.Printf("unable to convert token server connection user id: %s, %v\n", userId, err)
+		return ctx
+	}
+	return context.WithValue(ctx, peerUidKey{}, uid)
+}
+
+func peerUid(ctx context.Context) (int, bool) {
+	uid, ok := ctx.Value(peerUidKey{}).(int)
+	return uid, ok
+}
+
+// authenticateRequest determines the service identity to be used for the
+// request based on the uid of the calling process. Processes running as root
+// may request tokens for any of the services while all other processes can
+// only request tokens for the services configured with their user. If the
+// user has access to multiple services, the service parameter is required.
+func (s *Server) authenticateRequest(w http.ResponseWriter, r *http.Request) (*options.Service, bool) {
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	uid, ok := peerUid(r.Context())
+	if !ok {
+		http.Error(w, "unable to determine caller uid", http.StatusForbidden)
+		return nil, false
+	}
+
+	var allowed []options.Service
+	for _, svc := range s.GetOptions().Services {
+		if uid == 0 || svc.Uid == uid {
+			allowed = append(allowed, svc)
+		}
+	}
+
+	service := r.URL.Query().Get("service")
+	if service != "" {
+		for _, svc := range allowed {
+			if svc.Name == service {
+				return &svc, true
+			}
+		}
+		log.Printf("token server: denied request from uid %d for service %s\n", uid, service)
+		http.Error(w, fmt.Sprintf("uid %d is not authorized for service %s", uid, service), http.StatusForbidden)
+		return nil, false
+	}
+	switch len(allowed) {
+	case 0:
+		log.Printf("token server: denied request from uid %d, no matching service\n", uid)
+		http.Error(w, fmt.Sprintf("uid %d is not authorized for any service", uid), http.StatusForbidden)
+		return nil, false
+	case 1:
+		return &allowed[0], true
+	default:
+		http.Error(w, "service parameter is required", http.StatusBadRequest)
+		return nil, false
+	}
+}
+
+type fetchFunc func(client *zts.ZTSClient) ([]byte, time.Time, error)
+
+// serveToken returns the cached response for the request if available,
+// otherwise it calls ZTS with the identity of the given service and
+// caches the response until it's close to its expiry
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request, svc *options.Service, operation string, fetch fetchFunc) {
+
+	params := r.URL.Query()
+	params.Del("service")
+	key := fmt.Sprintf("%s:%s?%s", svc.Name, r.URL.Path, params.Encode())
+
+	data, ok := s.cache.get(key, time.Now())
+	if !ok {
+		client, err := s.ztsClient(svc)
+		if err != nil {
+			log.Printf("token server: unable to create zts client for service %s: %v\n", svc.Name, err)
+			http.Error(w, "unable to create zts client", http.StatusInternalServerError)
+			return
+		}
+		issued := time.Now()
+		var expiry time.Time
+		data, expiry, err = fetch(client)
+		status.ObserveZtsRequest(operation, time.Since(issued), err)
+		if err != nil {
+			log.Printf("token server: %s request for service %s failed: %v\n", operation, svc.Name, err)
+			http.Error(w, err.Error(), errorCode(err))
+			return
+		}
+		s.cache.put(key, data, issued, expiry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (s *Server) ztsClient(svc *options.Service) (*zts.ZTSClient, error) {
+	opts := s.GetOptions()
+	keyFile := fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, svc.Name)
+	certFile := util.GetSvcCertFileName(opts.CertDir, svc.Filename, opts.Domain, svc.Name)
+	client, err := util.ZtsClient(s.ztsUrl, opts.ZTSServerName, keyFile, certFile, opts.ZTSCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	client.AddCredentials("User-Agent", opts.Version)
+	return client, nil
+}
+
+// errorCode returns the client error codes from ZTS as is (e.g. the
+// service is not authorized for the role) while all other failures
+// are reported as bad gateway
+func errorCode(err error) int {
+	var rdlErr rdl.ResourceError
+	if errors.As(err, &rdlErr) && rdlErr.Code >= 400 && rdlErr.Code < 500 {
+		return rdlErr.Code
+	}
+	return http.StatusBadGateway
+}
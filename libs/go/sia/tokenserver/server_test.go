@@ -0,0 +1,248 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tokenserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeZts implements the token endpoints used by the server and
+// keeps track of the number of requests it has received
+type fakeZts struct {
+	server   *httptest.Server
+	requests int32
+}
+
+func newFakeZts(test *testing.T) *fakeZts {
+	f := &fakeZts{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/zts/v1/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.requests, 1)
+		r.ParseForm()
+		if r.Form.Get("scope") == "weather:domain" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"code":403,"message":"not authorized"}`)
+			return
+		}
+		expiresIn := int32(3600)
+		json.NewEncoder(w).Encode(zts.AccessTokenResponse{
+			Access_token: "access-token:" + r.Form.Get("scope"),
+			Token_type:   "Bearer",
+			Expires_in:   &expiresIn,
+		})
+	})
+	mux.HandleFunc("/zts/v1/domain/sports/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.requests, 1)
+		json.NewEncoder(w).Encode(zts.RoleToken{
+			Token:      "role-token:" + r.URL.Query().Get("role"),
+			ExpiryTime: time.Now().Add(time.Hour).Unix(),
+		})
+	})
+	mux.HandleFunc("/zts/v1/oauth2/auth", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.requests, 1)
+		w.Header().Set("Location", r.URL.Query().Get("redirect_uri")+"#id_token="+testIdToken(time.Now().Add(time.Hour))+"&state=")
+		w.WriteHeader(http.StatusFound)
+	})
+	f.server = httptest.NewServer(mux)
+	test.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeZts) url() string {
+	return f.server.URL + "/zts/v1"
+}
+
+func testIdToken(expiry time.Time) string {
+	encode := func(data string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(data))
+	}
+	return encode(`{"alg":"RS256","typ":"JWT"}`) + "." + encode(fmt.Sprintf(`{"sub":"sports.api","exp":%d}`, expiry.Unix())) + "." + encode("signature")
+}
+
+func testOptions() *options.Options {
+	return &options.Options{
+		Domain:  "sports",
+		KeyDir:  "/tmp/keys",
+		CertDir: "/tmp/certs",
+		Services: []options.Service{
+			{Name: "api", Uid: 1001},
+			{Name: "ui", Uid: 1002},
+			{Name: "backend", Uid: 1002},
+		},
+	}
+}
+
+func request(test *testing.T, handler http.Handler, uid int, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if uid >= 0 {
+		req = req.WithContext(context.WithValue(req.Context(), peerUidKey{}, uid))
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAuthenticateRequest(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	handler := NewServer(testOptions(), ztsServer.url()).Handler()
+
+	tests := []struct {
+		name   string
+		uid    int
+		target string
+		code   int
+	}{
+		{"single-service", 1001, "/v1/accesstoken?domain=sports", http.StatusOK},
+		{"explicit-service", 1002, "/v1/accesstoken?domain=sports&service=ui", http.StatusOK},
+		{"multiple-services", 1002, "/v1/accesstoken?domain=sports", http.StatusBadRequest},
+		{"unauthorized-service", 1001, "/v1/accesstoken?domain=sports&service=ui", http.StatusForbidden},
+		{"unknown-uid", 1003, "/v1/accesstoken?domain=sports", http.StatusForbidden},
+		{"root-any-service", 0, "/v1/accesstoken?domain=sports&service=backend", http.StatusOK},
+		{"no-peer-credentials", -1, "/v1/accesstoken?domain=sports", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			recorder := request(t, handler, tt.uid, tt.target)
+			assert.Equal(t, tt.code, recorder.Code, recorder.Body.String())
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/accesstoken?domain=sports", nil)
+	req = req.WithContext(context.WithValue(req.Context(), peerUidKey{}, 1001))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(test, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestServeAccessToken(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	handler := NewServer(testOptions(), ztsServer.url()).Handler()
+
+	recorder := request(test, handler, 1001, "/v1/accesstoken?domain=sports&roles=readers,writers&expires_in=3600")
+	require.Equal(test, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(test, "application/json", recorder.Header().Get("Content-Type"))
+	var res zts.AccessTokenResponse
+	require.Nil(test, json.Unmarshal(recorder.Body.Bytes(), &res))
+	assert.Equal(test, "access-token:sports:role.readers sports:role.writers", res.Access_token)
+
+	// the same request is served from the cache
+	recorder = request(test, handler, 1001, "/v1/accesstoken?domain=sports&roles=readers,writers&expires_in=3600")
+	assert.Equal(test, http.StatusOK, recorder.Code)
+	assert.Equal(test, int32(1), atomic.LoadInt32(&ztsServer.requests))
+
+	// different parameters require a new token
+	recorder = request(test, handler, 1001, "/v1/accesstoken?domain=sports")
+	require.Equal(test, http.StatusOK, recorder.Code)
+	require.Nil(test, json.Unmarshal(recorder.Body.Bytes(), &res))
+	assert.Equal(test, "access-token:sports:domain", res.Access_token)
+	assert.Equal(test, int32(2), atomic.LoadInt32(&ztsServer.requests))
+
+	// client errors from zts are returned as is
+	recorder = request(test, handler, 1001, "/v1/accesstoken?domain=weather")
+	assert.Equal(test, http.StatusForbidden, recorder.Code)
+
+	recorder = request(test, handler, 1001, "/v1/accesstoken")
+	assert.Equal(test, http.StatusBadRequest, recorder.Code)
+	recorder = request(test, handler, 1001, "/v1/accesstoken?domain=sports&expires_in=abc")
+	assert.Equal(test, http.StatusBadRequest, recorder.Code)
+}
+
+func TestServeRoleToken(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	handler := NewServer(testOptions(), ztsServer.url()).Handler()
+
+	recorder := request(test, handler, 1001, "/v1/roletoken?domain=sports&roles=readers&min_expiry=900")
+	require.Equal(test, http.StatusOK, recorder.Code, recorder.Body.String())
+	var res zts.RoleToken
+	require.Nil(test, json.Unmarshal(recorder.Body.Bytes(), &res))
+	assert.Equal(test, "role-token:readers", res.Token)
+
+	recorder = request(test, handler, 1001, "/v1/roletoken?domain=sports&roles=readers&min_expiry=900")
+	assert.Equal(test, http.StatusOK, recorder.Code)
+	assert.Equal(test, int32(1), atomic.LoadInt32(&ztsServer.requests))
+
+	recorder = request(test, handler, 1001, "/v1/roletoken?domain=sports&max_expiry=-1")
+	assert.Equal(test, http.StatusBadRequest, recorder.Code)
+}
+
+func TestServeIdToken(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	handler := NewServer(testOptions(), ztsServer.url()).Handler()
+
+	recorder := request(test, handler, 1001, "/v1/idtoken?client_id=sports.gateway&redirect_uri=https://gateway.sports.athenz.io")
+	require.Equal(test, http.StatusOK, recorder.Code, recorder.Body.String())
+	var res idTokenResponse
+	require.Nil(test, json.Unmarshal(recorder.Body.Bytes(), &res))
+	assert.Equal(test, 3, len(strings.Split(res.IdToken, ".")))
+	assert.InDelta(test, time.Now().Add(time.Hour).Unix(), res.ExpiryTime, 5)
+
+	recorder = request(test, handler, 1001, "/v1/idtoken?client_id=sports.gateway&redirect_uri=https://gateway.sports.athenz.io")
+	assert.Equal(test, http.StatusOK, recorder.Code)
+	assert.Equal(test, int32(1), atomic.LoadInt32(&ztsServer.requests))
+
+	recorder = request(test, handler, 1001, "/v1/idtoken")
+	assert.Equal(test, http.StatusBadRequest, recorder.Code)
+}
+
+func TestServeUds(test *testing.T) {
+	ztsServer := newFakeZts(test)
+	opts := testOptions()
+	opts.Services[0].Uid = os.Getuid()
+	server := NewServer(opts, ztsServer.url())
+
+	udsPath := filepath.Join(test.TempDir(), "token.sock")
+	go server.Serve(udsPath)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", udsPath)
+			},
+		},
+	}
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		resp, err = client.Get("http://localhost/v1/accesstoken?domain=sports&service=api")
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.Nil(test, err)
+	defer resp.Body.Close()
+	assert.Equal(test, http.StatusOK, resp.StatusCode)
+}
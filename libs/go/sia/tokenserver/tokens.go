@@ -0,0 +1,208 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tokenserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/athenzutils"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+type idTokenResponse struct {
+	IdToken    string `json:"id_token"`
+	ExpiryTime int64  `json:"expiry_time"`
+}
+
+// serveAccessToken handles GET /v1/accesstoken?domain=<domain>&roles=<role1,role2>&expires_in=<seconds>
+// and returns the access token response from ZTS. If no roles are specified
+// the token includes all the roles the service has access to in the domain
+func (s *Server) serveAccessToken(w http.ResponseWriter, r *http.Request) {
+
+	svc, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	domain := query.Get("domain")
+	if domain == "" {
+		http.Error(w, "domain parameter is required", http.StatusBadRequest)
+		return
+	}
+	expiresIn, err := intParam(query, "expires_in")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := accessTokenRequest(domain, listParam(query, "roles"), expiresIn)
+
+	s.serveToken(w, r, svc, "PostAccessTokenRequest", func(client *zts.ZTSClient) ([]byte, time.Time, error) {
+		issued := time.Now()
+		res, err := client.PostAccessTokenRequest(zts.AccessTokenRequest(request))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		var expiry time.Time
+		if res.Expires_in != nil {
+			expiry = issued.Add(time.Duration(*res.Expires_in) * time.Second)
+		}
+		data, err := json.Marshal(res)
+		return data, expiry, err
+	})
+}
+
+// serveRoleToken handles GET /v1/roletoken?domain=<domain>&roles=<role1,role2>&min_expiry=<seconds>&max_expiry=<seconds>
+// and returns the role token response from ZTS
+func (s *Server) serveRoleToken(w http.ResponseWriter, r *http.Request) {
+
+	svc, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	domain := query.Get("domain")
+	if domain == "" {
+		http.Error(w, "domain parameter is required", http.StatusBadRequest)
+		return
+	}
+	minExpiry, err := int32Param(query, "min_expiry")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxExpiry, err := int32Param(query, "max_expiry")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	roles := strings.Join(listParam(query, "roles"), ",")
+
+	s.serveToken(w, r, svc, "GetRoleToken", func(client *zts.ZTSClient) ([]byte, time.Time, error) {
+		res, err := client.GetRoleToken(zts.DomainName(domain), zts.EntityList(roles), minExpiry, maxExpiry, "")
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		data, err := json.Marshal(res)
+		return data, time.Unix(res.ExpiryTime, 0), err
+	})
+}
+
+// serveIdToken handles GET /v1/idtoken?client_id=<client-id>&redirect_uri=<uri>&scope=<scope>&key_type=<RSA|EC>
+// and returns the id token issued by ZTS along with its expiry time
+func (s *Server) serveIdToken(w http.ResponseWriter, r *http.Request) {
+
+	svc, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	clientId := query.Get("client_id")
+	if clientId == "" {
+		http.Error(w, "client_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	scope := query.Get("scope")
+	if scope == "" {
+		scope = "openid"
+	}
+	redirectUri := query.Get("redirect_uri")
+	keyType := query.Get("key_type")
+
+	s.serveToken(w, r, svc, "GetOIDCResponse", func(client *zts.ZTSClient) ([]byte, time.Time, error) {
+		nonce, err := util.Nonce()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		client.DisableRedirect = true
+		_, location, err := client.GetOIDCResponse("id_token", zts.ServiceName(clientId), redirectUri, scope, "", zts.EntityName(nonce), zts.SimpleName(keyType), nil)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		idToken, err := athenzutils.ExtractIdTokenFromLocation(location)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		expiry, err := athenzutils.FetchIdTokenExpiryTime(idToken)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("unable to parse id token: %v", err)
+		}
+		data, err := json.Marshal(idTokenResponse{IdToken: idToken, ExpiryTime: expiry.Unix()})
+		return data, *expiry, err
+	})
+}
+
+func accessTokenRequest(domain string, roles []string, expiresIn int) string {
+	params := url.Values{}
+	params.Add("grant_type", "client_credentials")
+	if expiresIn > 0 {
+		params.Add("expires_in", strconv.Itoa(expiresIn))
+	}
+	var scope string
+	if len(roles) == 0 {
+		scope = domain + ":domain"
+	} else {
+		for idx, role := range roles {
+			if idx != 0 {
+				scope += " "
+			}
+			scope += domain + ":role." + role
+		}
+	}
+	params.Add("scope", scope)
+	return params.Encode()
+}
+
+func listParam(query url.Values, name string) []string {
+	var values []string
+	for _, value := range strings.Split(query.Get(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func intParam(query url.Values, name string) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid %s parameter: %s", name, value)
+	}
+	return number, nil
+}
+
+func int32Param(query url.Values, name string) (*int32, error) {
+	number, err := intParam(query, name)
+	if err != nil || number == 0 {
+		return nil, err
+	}
+	if number > int(^uint32(0)>>1) {
+		return nil, fmt.Errorf("invalid %s parameter: %d", name, number)
+	}
+	value := int32(number)
+	return &value, nil
+}
@@ -12,7 +12,8 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
-	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/futil"
 )
 
 const siaUnixGroup = "athenz"
@@ -29,59 +30,26 @@ func UpdateFile(fileName string, contents []byte, uid, gid int, perm os.FileMode
 		log.Printf("Contents is empty. Skipping writing to file %s\n", fileName)
 		return nil
 	}
-	// if the original file does not exist then we
-	// just write the contents to the given file
-	// directly
-	_, err := os.Stat(fileName)
-	if err != nil && os.IsNotExist(err) {
-		log.Printf("Updating file %s...\n", fileName)
-		err = os.WriteFile(fileName, contents, perm)
-		if err != nil {
-			log.Printf("Unable to write new file %s, err: %v\n", fileName, err)
-			return err
-		}
-	} else {
-		timeNano := time.Now().UnixNano()
-		// write the new contents to a temporary file
-		newFileName := fmt.Sprintf("%s.tmp%d", fileName, timeNano)
-		log.Printf("Writing contents to temporary file %s...\n", newFileName)
-		err = os.WriteFile(newFileName, contents, perm)
-		if err != nil {
-			log.Printf("Unable to write new file %s, err: %v\n", newFileName, err)
-			return err
-		}
-		// move the contents of the old file to a backup file
-		bakFileName := fmt.Sprintf("%s.bak%d", fileName, timeNano)
-		log.Printf("Renaming original file %s to backup file %s...\n", fileName, bakFileName)
-		err = os.Rename(fileName, bakFileName)
-		if err != nil {
-			log.Printf("Unable to rename file %s to %s, err: %v\n", fileName, bakFileName, err)
-			return err
-		}
-		// move the new contents to the original location
-		log.Printf("Renaming temporary file %s to requested file %s...\n", newFileName, fileName)
-		err = os.Rename(newFileName, fileName)
-		if err != nil {
-			log.Printf("Unable to rename file %s to %s, err: %v\n", newFileName, fileName, err)
-			// before returning try to restore the original file
-			os.Rename(bakFileName, fileName)
-			return err
-		}
-		// remove the temporary backup file
-		log.Printf("Removing backup file %s...\n", bakFileName)
-		os.Remove(bakFileName)
+	// the contents are written and synced to a temporary file
+	// which is then renamed over the original file so the file
+	// has either its old or new contents if we crash
+	log.Printf("Updating file %s...\n", fileName)
+	err := futil.WriteFiles([]futil.File{OwnedFile(fileName, contents, uid, gid, perm)}, "", nil)
+	if err != nil {
+		log.Printf("Unable to update file %s, err: %v\n", fileName, err)
+		return err
 	}
+	return nil
+}
+
+// fileOwner returns the ownership of the files written by the agent
+// which is only changed if it differs from the user running the agent
+func fileOwner(uid, gid int) (int, int) {
 	currentUid, currentGid := uidGidForUser("")
 	if currentUid != uid || currentGid != gid {
-		log.Printf("Changing file %s ownership to %d:%d...\n", fileName, uid, gid)
-		err = os.Chown(fileName, uid, gid)
-		if err != nil {
-			log.Printf("Cannot chown file %s to %d:%d, err: %v\n", fileName, uid, gid, err)
-			return err
-		}
+		return uid, gid
 	}
-
-	return nil
+	return -1, -1
 }
 
 func SvcAttrs(username, groupname string) (int, int, int) {
@@ -133,6 +101,29 @@ func UidGidForUserGroup(username, groupname string) (int, int) {
 	return uid, gid
 }
 
+// UserGroupIds returns the uid of the user and the gid of the group. The id
+// is -1 if the name is empty. Unlike SvcAttrs, an error is returned if the
+// user or group does not exist instead of falling back to the defaults.
+func UserGroupIds(username, groupname string) (int, int, error) {
+	uid, gid := -1, -1
+	if username != "" {
+		out, err := exec.Command(GetUtilPath("id"), "-u", username).Output()
+		if err != nil {
+			return -1, -1, fmt.Errorf("unknown user: %s", username)
+		}
+		uid, err = strconv.Atoi(strings.Trim(string(out), "\n\r "))
+		if err != nil {
+			return -1, -1, fmt.Errorf("unexpected uid format for user %s: %s", username, string(out))
+		}
+	}
+	if groupname != "" {
+		if gid = gidForGroup(groupname); gid == -1 {
+			return -1, -1, fmt.Errorf("unknown group: %s", groupname)
+		}
+	}
+	return uid, gid, nil
+}
+
 func gidForGroup(groupname string) int {
 	//shelling out to id is used here because the os/user package
 	//requires cgo, which doesn't cross-compile. we can use getent group
@@ -249,3 +240,11 @@ func SyscallSetGid(gid int) error {
 func SyscallSetUid(uid int) error {
 	return syscall.Setuid(uid)
 }
+
+// FileOwner returns the uid and gid of the owner of the given file
+func FileOwner(info os.FileInfo) (int, int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return -1, -1
+}
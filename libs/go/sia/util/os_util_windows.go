@@ -5,7 +5,8 @@ import (
 	"io"
 	"log"
 	"os"
-	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/futil"
 )
 
 const SshSupport = false
@@ -21,51 +22,24 @@ func UpdateFile(fileName string, contents []byte, uid, gid int, perm os.FileMode
 		log.Printf("Contents is empty. Skipping writing to file %s\n", fileName)
 		return nil
 	}
-	// if the original file does not exists then we
-	// we just write the contents to the given file
-	// directly
-	_, err := os.Stat(fileName)
-	if err != nil && os.IsNotExist(err) {
-		log.Printf("Updating file %s...\n", fileName)
-		err = os.WriteFile(fileName, contents, perm)
-		if err != nil {
-			log.Printf("Unable to write new file %s, err: %v\n", fileName, err)
-			return err
-		}
-	} else {
-		timeNano := time.Now().UnixNano()
-		// write the new contents to a temporary file
-		newFileName := fmt.Sprintf("%s.tmp%d", fileName, timeNano)
-		log.Printf("Writing contents to temporary file %s...\n", newFileName)
-		err = os.WriteFile(newFileName, contents, perm)
-		if err != nil {
-			log.Printf("Unable to write new file %s, err: %v\n", newFileName, err)
-			return err
-		}
-		// move the contents of the old file to a backup file
-		bakFileName := fmt.Sprintf("%s.bak%d", fileName, timeNano)
-		log.Printf("Renaming original file %s to backup file %s...\n", fileName, bakFileName)
-		err = os.Rename(fileName, bakFileName)
-		if err != nil {
-			log.Printf("Unable to rename file %s to %s, err: %v\n", fileName, bakFileName, err)
-			return err
-		}
-		// move the new contents to the original location
-		log.Printf("Renaming temporary file %s to requested file %s...\n", newFileName, fileName)
-		err = os.Rename(newFileName, fileName)
-		if err != nil {
-			log.Printf("Unable to rename file %s to %s, err: %v\n", newFileName, fileName, err)
-			// before returning try to restore the original file
-			os.Rename(bakFileName, fileName)
-			return err
-		}
-		// remove the temporary backup file
-		log.Printf("Removing backup file %s...\n", bakFileName)
-		os.Remove(bakFileName)
+	// the contents are written and synced to a temporary file
+	// which is then renamed over the original file so the file
+	// has either its old or new contents if we crash
+	log.Printf("Updating file %s...\n", fileName)
+	err := futil.WriteFiles([]futil.File{OwnedFile(fileName, contents, uid, gid, perm)}, "", nil)
+	if err != nil {
+		log.Printf("Unable to update file %s, err: %v\n", fileName, err)
+		return err
 	}
 	return nil
 }
 
+// fileOwner returns the ownership of the files written by the agent.
+// The file ownership is not changed on windows.
+func fileOwner(uid, gid int) (int, int) {
+	return -1, -1
+}
+
 func SvcAttrs(username, groupname string) (int, int, int) {
 	return 0, 0, 0440
 }
@@ -74,6 +48,10 @@ func UidGidForUserGroup(username, groupname string) (int, int) {
 	return 0, 0
 }
 
+func UserGroupIds(username, groupname string) (int, int, error) {
+	return 0, 0, nil
+}
+
 func SetupSIADirs(siaMainDir, siaLinkDir string, ownerUid, ownerGid int) error {
 	// Create the certs directory, if it doesn't exist
 	certDir := fmt.Sprintf("%s/certs", siaMainDir)
@@ -102,3 +80,7 @@ func SyscallSetGid(gid int) error {
 func SyscallSetUid(uid int) error {
 	return nil
 }
+
+func FileOwner(info os.FileInfo) (int, int) {
+	return -1, -1
+}
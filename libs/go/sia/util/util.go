@@ -18,6 +18,10 @@ package util
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -70,6 +74,78 @@ type SSHKeyReq struct {
 
 const JwkConfFile = "athenz.conf"
 
+// KeyType identifies the algorithm and size of the private keys
+// generated for service and role certificates
+type KeyType int
+
+const (
+	RSA2048 KeyType = iota
+	RSA4096
+	ECDSAP256
+	ECDSAP384
+	Ed25519
+)
+
+// DEFAULT_KEY_TYPE is used when the key type is not configured
+const DEFAULT_KEY_TYPE = RSA2048
+
+var keyTypeToString = map[KeyType]string{
+	RSA2048:   "rsa-2048",
+	RSA4096:   "rsa-4096",
+	ECDSAP256: "ecdsa-p256",
+	ECDSAP384: "ecdsa-p384",
+	Ed25519:   "ed25519",
+}
+
+func (keyType KeyType) String() string {
+	return keyTypeToString[keyType]
+}
+
+// ParseKeyType returns the key type for the given config value.
+// An empty value returns the default rsa-2048 key type.
+func ParseKeyType(value string) (KeyType, error) {
+	if value == "" {
+		return DEFAULT_KEY_TYPE, nil
+	}
+	for keyType, name := range keyTypeToString {
+		if strings.EqualFold(name, value) {
+			return keyType, nil
+		}
+	}
+	return DEFAULT_KEY_TYPE, fmt.Errorf("unknown key type: %s, expected one of rsa-2048, rsa-4096, ecdsa-p256, ecdsa-p384, ed25519", value)
+}
+
+// GetKeyType returns the key type for the given private key
+func GetKeyType(key crypto.Signer) (KeyType, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		switch k.N.BitLen() {
+		case 2048:
+			return RSA2048, nil
+		case 4096:
+			return RSA4096, nil
+		}
+		return DEFAULT_KEY_TYPE, fmt.Errorf("unsupported rsa key size: %d", k.N.BitLen())
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return ECDSAP256, nil
+		case elliptic.P384():
+			return ECDSAP384, nil
+		}
+		return DEFAULT_KEY_TYPE, fmt.Errorf("unsupported ecdsa curve: %s", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return Ed25519, nil
+	}
+	return DEFAULT_KEY_TYPE, fmt.Errorf("unsupported private key type: %T", key)
+}
+
+// KeyMatchesType returns true if the given private key is of the requested key type
+func KeyMatchesType(key crypto.Signer, keyType KeyType) bool {
+	currentType, err := GetKeyType(key)
+	return err == nil && currentType == keyType
+}
+
 func SplitRoleName(roleName string) (string, string, error) {
 	tmp := strings.Split(roleName, ":role.")
 	if len(tmp) != 2 {
@@ -127,6 +203,39 @@ func ZtsClient(ztsUrl, ztsServerName string, keyFile, certFile, caCertFile strin
 	}
 }
 
+// ZtsClientFromPEM returns a zts client authenticated with the private key
+// and certificate in pem format that have not been stored in files yet
+func ZtsClientFromPEM(ztsUrl, ztsServerName string, keyPem, certPem []byte, caCertFile string) (*zts.ZTSClient, error) {
+	log.Printf("ZTS Client: url: %s\n", ztsUrl)
+	if strings.HasPrefix(ztsUrl, "http://") {
+		client := zts.NewClient(ztsUrl, &http.Transport{Proxy: http.ProxyFromEnvironment})
+		return &client, nil
+	}
+	var caPem []byte
+	if caCertFile != "" {
+		log.Printf("ZTS Client: CA certificate file: %s\n", caCertFile)
+		var err error
+		caPem, err = os.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	config, err := tlsConfigurationFromPEM(keyPem, certPem, caPem)
+	if err != nil {
+		return nil, err
+	}
+	if ztsServerName != "" {
+		log.Printf("ZTS Client: Server Name: %s\n", ztsServerName)
+		config.ServerName = ztsServerName
+	}
+	tr := &http.Transport{
+		TLSClientConfig: config,
+		Proxy:           http.ProxyFromEnvironment,
+	}
+	client := zts.NewClient(ztsUrl, tr)
+	return &client, nil
+}
+
 func tlsConfiguration(keyfile, certfile, cafile string) (*tls.Config, error) {
 	var capem []byte
 	var keypem []byte
@@ -173,7 +282,7 @@ func tlsConfigurationFromPEM(keypem, certpem, capem []byte) (*tls.Config, error)
 	return config, nil
 }
 
-func GenerateX509CSR(key *rsa.PrivateKey, csrDetails CertReqDetails) (string, error) {
+func GenerateX509CSR(key crypto.Signer, csrDetails CertReqDetails) (string, error) {
 	//note: RFC 6125 states that if the SAN (Subject Alternative Name)
 	//exists, it is used, not the CN. So, we will always put the Athenz
 	//name in the CN (it is *not* a DNS domain name), and put the host
@@ -196,7 +305,7 @@ func GenerateX509CSR(key *rsa.PrivateKey, csrDetails CertReqDetails) (string, er
 	}
 	template := x509.CertificateRequest{
 		Subject:            subj,
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		SignatureAlgorithm: signatureAlgorithm(key),
 	}
 	if len(csrDetails.IpList) != 0 {
 		template.IPAddresses = make([]net.IP, 0)
@@ -223,20 +332,83 @@ func GenerateX509CSR(key *rsa.PrivateKey, csrDetails CertReqDetails) (string, er
 	return buf.String(), nil
 }
 
+func signatureAlgorithm(key crypto.Signer) x509.SignatureAlgorithm {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return x509.SHA256WithRSA
+	case *ecdsa.PrivateKey:
+		if k.Curve == elliptic.P384() {
+			return x509.ECDSAWithSHA384
+		}
+		return x509.ECDSAWithSHA256
+	case ed25519.PrivateKey:
+		return x509.PureEd25519
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
 func GenerateKeyPair(bits int) (*rsa.PrivateKey, error) {
-	log.Println("Generating RSA 2048 bit private key...")
+	log.Printf("Generating RSA %d bit private key...\n", bits)
 	return rsa.GenerateKey(rand.Reader, bits)
 }
 
-func GetPEMBlock(privateKey *rsa.PrivateKey) []byte {
-	block := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+// GenerateKey generates a new private key of the requested key type
+func GenerateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case RSA2048:
+		return GenerateKeyPair(2048)
+	case RSA4096:
+		return GenerateKeyPair(4096)
+	case ECDSAP256:
+		log.Println("Generating ECDSA P-256 private key...")
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		log.Println("Generating ECDSA P-384 private key...")
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case Ed25519:
+		log.Println("Generating Ed25519 private key...")
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unknown key type: %d", keyType)
+}
+
+// GetPEMBlock returns the PEM encoding of the private key. RSA keys
+// are encoded in PKCS#1, ECDSA keys in SEC 1 and Ed25519 keys in PKCS#8
+// format. If the key cannot be encoded, nil is returned.
+func GetPEMBlock(privateKey crypto.Signer) []byte {
+	var block *pem.Block
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+	case *ecdsa.PrivateKey:
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			log.Printf("Unable to marshal ecdsa private key: %v\n", err)
+			return nil
+		}
+		block = &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: keyBytes,
+		}
+	default:
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			log.Printf("Unable to marshal private key: %v\n", err)
+			return nil
+		}
+		block = &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: keyBytes,
+		}
 	}
 	return pem.EncodeToMemory(block)
 }
 
-func PrivatePem(privateKey *rsa.PrivateKey) string {
+func PrivatePem(privateKey crypto.Signer) string {
 	block := GetPEMBlock(privateKey)
 	return string(block)
 }
@@ -248,20 +420,40 @@ func FileExists(path string) bool {
 	return true
 }
 
-func PrivateKeyFromFile(filename string) (*rsa.PrivateKey, error) {
+func PrivateKeyFromFile(filename string) (crypto.Signer, error) {
 	log.Printf("Reading private key from %s...\n", filename)
 	pemBytes, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	return PrivateKeyFromPEMBytes(pemBytes)
+}
+
+// PrivateKeyFromPEMBytes parses a PKCS#1 rsa, SEC 1 ecdsa or
+// PKCS#8 encoded private key
+func PrivateKeyFromPEMBytes(pemBytes []byte) (crypto.Signer, error) {
 	block, _ := pem.Decode(pemBytes)
 	if block == nil {
 		return nil, fmt.Errorf("no PEM block found")
 	}
-	return x509.ParsePKCS1PrivateKey(block.Bytes)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+	return signer, nil
 }
 
-func GenerateSvcCertCSR(key *rsa.PrivateKey, countryName, orgName, domain, service, commonName, instanceId, provider string, ztsDomains []string, wildCardDnsName, hostnameDnsName, instanceIdSanDNS bool) (string, error) {
+func GenerateSvcCertCSR(key crypto.Signer, countryName, orgName, domain, service, commonName, instanceId, provider string, ztsDomains []string, wildCardDnsName, hostnameDnsName, instanceIdSanDNS bool) (string, error) {
 
 	log.Println("Generating X.509 Service Certificate CSR...")
 
@@ -312,7 +504,7 @@ func GenerateSvcCertCSR(key *rsa.PrivateKey, countryName, orgName, domain, servi
 	return GenerateX509CSR(key, csrDetails)
 }
 
-func GenerateRoleCertCSR(key *rsa.PrivateKey, countryName, orgName, domain, service, roleName, instanceId, provider, emailDomain string) (string, error) {
+func GenerateRoleCertCSR(key crypto.Signer, countryName, orgName, domain, service, roleName, instanceId, provider, emailDomain string) (string, error) {
 
 	log.Println("Generating Role Certificate CSR...")
 
@@ -360,14 +552,8 @@ func GenerateSSHHostCSR(sshPubKeyFile string, domain, service, ip string, ztsAws
 	}
 	identity := domain + "." + service
 	transId := fmt.Sprintf("%x", time.Now().Unix())
-	hyphenDomain := strings.Replace(domain, ".", "-", -1)
-	principals := []string{}
-	for _, ztsDomain := range ztsAwsDomains {
-		host := fmt.Sprintf("%s.%s.%s", service, hyphenDomain, ztsDomain)
-		principals = append(principals, host)
-	}
 	req := &SSHKeyReq{
-		Principals: principals,
+		Principals: SSHHostPrincipals(domain, service, ztsAwsDomains),
 		Pubkey:     string(pubkey),
 		Reqip:      ip,
 		Requser:    identity,
@@ -381,6 +567,18 @@ func GenerateSSHHostCSR(sshPubKeyFile string, domain, service, ip string, ztsAws
 	return string(csr), err
 }
 
+// SSHHostPrincipals returns the principals requested in the ssh host
+// certificate for the given service in each of the zts domains
+func SSHHostPrincipals(domain, service string, ztsDomains []string) []string {
+	hyphenDomain := strings.Replace(domain, ".", "-", -1)
+	principals := []string{}
+	for _, ztsDomain := range ztsDomains {
+		host := fmt.Sprintf("%s.%s.%s", service, hyphenDomain, ztsDomain)
+		principals = append(principals, host)
+	}
+	return principals
+}
+
 func AppendUri(uriList []*url.URL, uriValue string) []*url.URL {
 	uri, err := url.Parse(uriValue)
 	if err == nil {
@@ -429,9 +627,12 @@ func ExtractServiceName(arn, comp string) (string, string, error) {
 	return profile[:idx], profile[idx+1:], nil
 }
 
-func PrivateKey(keyFile string, rotateKey bool) (*rsa.PrivateKey, error) {
+// PrivateKey returns the private key stored in the given file. A new key
+// of the requested type is generated if rotation is requested, the file
+// does not exist or the existing key is not of the requested type.
+func PrivateKey(keyFile string, keyType KeyType, rotateKey bool) (crypto.Signer, error) {
 	if rotateKey == true || !FileExists(keyFile) {
-		key, err := GenerateKeyPair(2048)
+		key, err := GenerateKey(keyType)
 		if err != nil {
 			return nil, fmt.Errorf("cannot generate private key err: %v", err)
 		}
@@ -442,9 +643,30 @@ func PrivateKey(keyFile string, rotateKey bool) (*rsa.PrivateKey, error) {
 		log.Printf("Unable to read private key from %s, err: %v\n", keyFile, err)
 		return nil, err
 	}
+	if !KeyMatchesType(key, keyType) {
+		log.Printf("Private key %s does not match configured key type %s, generating new key...\n", keyFile, keyType)
+		key, err = GenerateKey(keyType)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate private key err: %v", err)
+		}
+	}
 	return key, err
 }
 
+// KeyFileMatchesType returns true if the private key stored in
+// the given file is of the requested key type
+func KeyFileMatchesType(keyFile string, keyType KeyType) bool {
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return false
+	}
+	key, err := PrivateKeyFromPEMBytes(pemBytes)
+	if err != nil {
+		return false
+	}
+	return KeyMatchesType(key, keyType)
+}
+
 func EnsureBackUpDir(backUpDir string) error {
 	if !FileExists(backUpDir) {
 		err := os.MkdirAll(backUpDir, 0755)
@@ -663,10 +885,17 @@ func getCertKeyFileName(file, keyDir, certDir, keyPrefix, certPrefix string) (st
 	}
 }
 
-func SaveCertKey(key, cert []byte, file, keyPrefix, certPrefix string, uid, gid, fileMode int, createKey, rotateKey bool, keyDir, certDir, backupDir string) error {
+func SaveCertKey(key, cert []byte, file, keyPrefix, certPrefix string, uid, gid, fileMode int, createKey, rotateKey bool, keyType KeyType, keyDir, certDir, backupDir string) error {
 
 	certFile, keyFile := getCertKeyFileName(file, keyDir, certDir, keyPrefix, certPrefix)
 
+	// if the key type has been changed in the configuration, the
+	// existing key must be replaced even if rotation is not enabled
+	if !rotateKey && FileExists(keyFile) && !KeyFileMatchesType(keyFile, keyType) {
+		log.Printf("existing key file: %s does not match key type %s, replacing key\n", keyFile, keyType)
+		rotateKey = true
+	}
+
 	// perform validation of x509KeyPair pair match before writing to disk
 	x509KeyPair, err := tls.X509KeyPair(cert, key)
 	if err != nil {
@@ -677,62 +906,57 @@ func SaveCertKey(key, cert []byte, file, keyPrefix, certPrefix string, uid, gid,
 		return fmt.Errorf("x509KeyPair and key for: %s unable to parse cert, error: %v", keyPrefix, err)
 	}
 
-	backUpKeyFile := fmt.Sprintf("%s/%s.key.pem", backupDir, keyPrefix)
-	backUpCertFile := fmt.Sprintf("%s/%s.cert.pem", backupDir, certPrefix)
-
-	if rotateKey {
-		err = EnsureBackUpDir(backupDir)
-		if err != nil {
-			return err
-		}
-		// taking back up of key and cert
-		log.Printf("taking back up of cert: %s to %s and key: %s to %s\n", certFile, backUpCertFile, keyFile, backUpKeyFile)
-		err = CopyCertKeyFile(keyFile, backUpKeyFile, certFile, backUpCertFile, 0400)
-		if err != nil {
-			return err
-		}
-		//write the new key and x509KeyPair to disk
-		log.Printf("writing new key file: %s to disk\n", keyFile)
-		err = UpdateFile(keyFile, key, uid, gid, os.FileMode(fileMode))
-		if err != nil {
-			return err
-		}
-	} else if createKey && !FileExists(keyFile) {
-		//write the new key and x509KeyPair to disk
+	var files []futil.File
+	if rotateKey || (createKey && !FileExists(keyFile)) {
 		log.Printf("writing new key file: %s to disk\n", keyFile)
-		err = UpdateFile(keyFile, key, uid, gid, os.FileMode(fileMode))
-		if err != nil {
-			return err
-		}
+		files = append(files, OwnedFile(keyFile, key, uid, gid, os.FileMode(fileMode)))
 	} else if FileExists(keyFile) {
 		UpdateKey(keyFile, uid, gid)
 	}
+	files = append(files, OwnedFile(certFile, cert, uid, gid, 0444))
+	return writeCertKeyFiles(files, keyFile, certFile, backupDir)
+}
 
-	err = UpdateFile(certFile, cert, uid, gid, os.FileMode(0444))
-	if err != nil {
-		return err
+// WriteCertKey writes the new private key and its certificate as a single
+// unit. If the key does not match the certificate or any of the files cannot
+// be replaced, the existing key and certificate are restored from backupDir.
+func WriteCertKey(key, cert []byte, keyFile, certFile string, uid, gid int, keyPerm os.FileMode, backupDir string) error {
+	files := []futil.File{
+		OwnedFile(keyFile, key, uid, gid, keyPerm),
+		OwnedFile(certFile, cert, uid, gid, 0444),
 	}
+	return writeCertKeyFiles(files, keyFile, certFile, backupDir)
+}
 
-	// perform 2nd validation of x509KeyPair pair match after writing to disk
-	x509KeyPair, err = tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		log.Printf("x509KeyPair: %s, key: %s do not match, error: %v\n", certFile, keyFile, err)
-		err = CopyCertKeyFile(backUpKeyFile, keyFile, backUpCertFile, certFile, fileMode)
-		if err != nil {
+// writeCertKeyFiles replaces the key and certificate files after verifying
+// that the staged certificate matches the staged or the existing key
+func writeCertKeyFiles(files []futil.File, keyFile, certFile, backupDir string) error {
+	if backupDir != "" {
+		if err := EnsureBackUpDir(backupDir); err != nil {
 			return err
 		}
 	}
-
-	_, err = x509.ParseCertificate(x509KeyPair.Certificate[0])
-	if err != nil {
-		log.Printf("x509KeyPair: %s, key: %s, unable to parse cert, error: %v\n", certFile, keyFile, err)
-		err = CopyCertKeyFile(backUpKeyFile, keyFile, backUpCertFile, certFile, fileMode)
+	return futil.WriteFiles(files, backupDir, func(staged map[string]string) error {
+		stagedKey, ok := staged[keyFile]
+		if !ok {
+			stagedKey = keyFile
+		}
+		x509KeyPair, err := tls.LoadX509KeyPair(staged[certFile], stagedKey)
 		if err != nil {
-			return err
+			return fmt.Errorf("x509KeyPair: %s, key: %s do not match, error: %v", certFile, keyFile, err)
 		}
-	}
+		_, err = x509.ParseCertificate(x509KeyPair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("x509KeyPair: %s, key: %s, unable to parse cert, error: %v", certFile, keyFile, err)
+		}
+		return nil
+	})
+}
 
-	return nil
+// OwnedFile returns the file to be written with the given ownership
+func OwnedFile(fileName string, contents []byte, uid, gid int, perm os.FileMode) futil.File {
+	fileUid, fileGid := fileOwner(uid, gid)
+	return futil.File{Name: fileName, Data: contents, Perm: perm, Uid: fileUid, Gid: fileGid}
 }
 
 func ParseServiceSpiffeUri(uri string) (string, string) {
@@ -747,6 +971,10 @@ func ParseCASpiffeUri(uri string) (string, string) {
 	return parseSpiffeUri(uri, "/ca/")
 }
 
+func ParseAccessTokenSpiffeUri(uri string) (string, string) {
+	return parseSpiffeUri(uri, "/at/")
+}
+
 func parseSpiffeUri(uri, objType string) (string, string) {
 	if !strings.HasPrefix(uri, "spiffe://") {
 		return "", ""
@@ -17,9 +17,12 @@
 package util
 
 import (
+	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"math/big"
 	"os"
 	"os/user"
 	"strconv"
@@ -198,7 +201,7 @@ func TestPrivateKeySupport(test *testing.T) {
 		test.Errorf("Unable to save private key file - %v", err)
 		return
 	}
-	key, err = PrivateKeyFromFile(fileName)
+	_, err = PrivateKeyFromFile(fileName)
 	os.Remove(fileName)
 	if err != nil {
 		test.Errorf("Unable to read private key file - %v", err)
@@ -206,6 +209,74 @@ func TestPrivateKeySupport(test *testing.T) {
 	}
 }
 
+func TestParseKeyType(test *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		keyType KeyType
+		valid   bool
+	}{
+		{"empty", "", RSA2048, true},
+		{"rsa-2048", "rsa-2048", RSA2048, true},
+		{"rsa-4096", "rsa-4096", RSA4096, true},
+		{"ecdsa-p256", "ecdsa-p256", ECDSAP256, true},
+		{"ecdsa-p384", "ECDSA-P384", ECDSAP384, true},
+		{"ed25519", "ed25519", Ed25519, true},
+		{"unknown", "dsa-1024", RSA2048, false},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			keyType, err := ParseKeyType(tt.value)
+			assert.Equal(t, tt.valid, err == nil)
+			assert.Equal(t, tt.keyType, keyType)
+		})
+	}
+}
+
+func TestGenerateKeyTypes(test *testing.T) {
+	for _, keyType := range []KeyType{RSA2048, ECDSAP256, ECDSAP384, Ed25519} {
+		test.Run(keyType.String(), func(t *testing.T) {
+			key, err := GenerateKey(keyType)
+			require.Nil(t, err)
+			assert.True(t, KeyMatchesType(key, keyType))
+
+			// verify the pem encoding can be parsed back into the same key type
+			fileName := fmt.Sprintf("%s/key.pem", t.TempDir())
+			err = os.WriteFile(fileName, GetPEMBlock(key), 0400)
+			require.Nil(t, err)
+			assert.True(t, KeyFileMatchesType(fileName, keyType))
+			assert.False(t, KeyFileMatchesType(fileName, RSA4096))
+
+			// the csr must be signed with the matching algorithm
+			csr, err := GenerateSvcCertCSR(key, "US", "", "domain", "service", "domain.service", "instance001", "Athenz", []string{"athenz.cloud"}, false, false, false)
+			require.Nil(t, err)
+			block, _ := pem.Decode([]byte(csr))
+			certReq, err := x509.ParseCertificateRequest(block.Bytes)
+			require.Nil(t, err)
+			assert.Nil(t, certReq.CheckSignature())
+			assert.Equal(t, signatureAlgorithm(key), certReq.SignatureAlgorithm)
+		})
+	}
+}
+
+func TestPrivateKeyTypeChange(test *testing.T) {
+	key, err := GenerateKey(RSA2048)
+	require.Nil(test, err)
+	fileName := fmt.Sprintf("%s/key.pem", test.TempDir())
+	err = os.WriteFile(fileName, GetPEMBlock(key), 0400)
+	require.Nil(test, err)
+
+	// same key type returns the existing key
+	newKey, err := PrivateKey(fileName, RSA2048, false)
+	require.Nil(test, err)
+	assert.Equal(test, GetPEMBlock(key), GetPEMBlock(newKey))
+
+	// different key type generates a new key
+	newKey, err = PrivateKey(fileName, ECDSAP256, false)
+	require.Nil(test, err)
+	assert.True(test, KeyMatchesType(newKey, ECDSAP256))
+}
+
 func TestGenerateSvcCertCSR(test *testing.T) {
 
 	key, err := GenerateKeyPair(2048)
@@ -935,6 +1006,31 @@ func TestParseCASpiffeUri(test *testing.T) {
 	}
 }
 
+func TestParseAccessTokenSpiffeUri(test *testing.T) {
+
+	tests := []struct {
+		name   string
+		uri    string
+		domain string
+		token  string
+	}{
+		{"valid", "spiffe://athenz/at/readers", "athenz", "readers"},
+		{"not-valid1", "spiffe://athenz/ra/readers", "", ""},
+		{"not-valid2", "spiffe://athenz/at/", "", ""},
+	}
+	for _, tt := range tests {
+		test.Run(tt.name, func(t *testing.T) {
+			domain, token := ParseAccessTokenSpiffeUri(tt.uri)
+			if domain != tt.domain {
+				test.Errorf("invalid domain returned - expected: %s, received %s", tt.domain, domain)
+			}
+			if token != tt.token {
+				test.Errorf("invalid token returned - expected: %s, received %s", tt.token, token)
+			}
+		})
+	}
+}
+
 func TestNonce(test *testing.T) {
 	nonce1, err := Nonce()
 	if err != nil {
@@ -1015,3 +1111,67 @@ func TestReadWriteAthenzJwkConf(t *testing.T) {
 	a.Equal(rdl.TimestampFromEpoch(100).Millis(), modTime.Millis())
 
 }
+
+func generateCertKey(test *testing.T) ([]byte, []byte) {
+	key, err := GenerateKey(ECDSAP256)
+	require.Nil(test, err)
+	template := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "sports.api"},
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.Nil(test, err)
+	return []byte(PrivatePem(key)), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestWriteCertKey(test *testing.T) {
+	siaDir := test.TempDir()
+	backupDir := siaDir + "/backup"
+	keyFile := siaDir + "/sports.api.key.pem"
+	certFile := siaDir + "/sports.api.cert.pem"
+	uid, gid := ExecIdCommand("-u"), ExecIdCommand("-g")
+
+	key1, cert1 := generateCertKey(test)
+	require.Nil(test, WriteCertKey(key1, cert1, keyFile, certFile, uid, gid, 0440, backupDir))
+	key2, cert2 := generateCertKey(test)
+	require.Nil(test, WriteCertKey(key2, cert2, keyFile, certFile, uid, gid, 0440, backupDir))
+
+	data, err := os.ReadFile(keyFile)
+	require.Nil(test, err)
+	assert.Equal(test, key2, data)
+	// the backups are removed once the files are replaced
+	backups, err := os.ReadDir(backupDir)
+	require.Nil(test, err)
+	assert.Empty(test, backups)
+
+	// a key that does not match the certificate is never written
+	assert.NotNil(test, WriteCertKey(key1, cert2, keyFile, certFile, uid, gid, 0440, backupDir))
+	data, err = os.ReadFile(keyFile)
+	require.Nil(test, err)
+	assert.Equal(test, key2, data)
+}
+
+func TestSaveCertKeyExistingKeyMismatch(test *testing.T) {
+	siaDir := test.TempDir()
+	uid, gid := ExecIdCommand("-u"), ExecIdCommand("-g")
+
+	key1, cert1 := generateCertKey(test)
+	err := SaveCertKey(key1, cert1, "", "sports.api", "sports.api", uid, gid, 0440, true, false, ECDSAP256, siaDir, siaDir, siaDir+"/backup")
+	require.Nil(test, err)
+
+	// without key rotation the new certificate must match the existing key
+	key2, cert2 := generateCertKey(test)
+	err = SaveCertKey(key2, cert2, "", "sports.api", "sports.api", uid, gid, 0440, true, false, ECDSAP256, siaDir, siaDir, siaDir+"/backup")
+	assert.NotNil(test, err)
+	data, err := os.ReadFile(siaDir + "/sports.api.cert.pem")
+	require.Nil(test, err)
+	assert.Equal(test, cert1, data)
+
+	err = SaveCertKey(key2, cert2, "", "sports.api", "sports.api", uid, gid, 0440, true, true, ECDSAP256, siaDir, siaDir, siaDir+"/backup")
+	require.Nil(test, err)
+	data, err = os.ReadFile(siaDir + "/sports.api.key.pem")
+	require.Nil(test, err)
+	assert.Equal(test, key2, data)
+}
@@ -18,7 +18,7 @@ package sia
 
 import (
 	"encoding/json"
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
 	"github.com/AthenZ/athenz/libs/go/sia/util"
 	"log"
 	"os"
@@ -19,14 +19,14 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
 	"github.com/AthenZ/athenz/libs/go/sia/util"
 	"github.com/AthenZ/athenz/provider/aws/sia-ec2"
 	"log"
 	"os"
 	"strings"
 
-	"github.com/AthenZ/athenz/libs/go/sia/aws/agent"
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
 )
 
 // Following can be set by the build script using LDFLAGS
@@ -90,38 +90,46 @@ func main() {
 		log.Fatalf("Unable to extract document details: %v\n", err)
 	}
 
-	config, configAccount, accessProfileConfig, err := sia.GetEC2Config(*pConf, *accessProfileConf, *ec2MetaEndPoint, *useRegionalSTS, region, account)
-	if err != nil {
-		log.Fatalf("Unable to formulate configuration objects, error: %v\n", err)
-	}
+	//the same function is used to reload the configuration
+	//when the agent receives the SIGHUP signal
+	newOptions := func() (*options.Options, error) {
+		config, configAccount, accessProfileConfig, err := sia.GetEC2Config(*pConf, *accessProfileConf, *ec2MetaEndPoint, *useRegionalSTS, region, account)
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate configuration objects, error: %v", err)
+		}
 
-	opts, err := options.NewOptions(config, configAccount, accessProfileConfig, siaMainDir, Version, *useRegionalSTS, region)
-	if err != nil {
-		log.Fatalf("Unable to formulate options, error: %v\n", err)
-	}
+		opts, err := options.NewOptions(config, configAccount, accessProfileConfig, siaMainDir, Version, *useRegionalSTS, region)
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate options, error: %v", err)
+		}
 
-	opts.Ssh = false
-	opts.EC2Document = string(document)
-	opts.EC2Signature = string(signature)
-	opts.ZTSCACertFile = *ztsCACert
-	opts.ZTSServerName = *ztsServerName
-	opts.ZTSAWSDomains = strings.Split(*dnsDomains, ",")
-	opts.Provider = fmt.Sprintf("%s.%s", *providerPrefix, region)
-
-	//check to see if this is ecs on ec2 and update instance id
-	//for ec2 instances we also need to set the start time so
-	//can check the expiry check if requested
-	taskId := sia.GetECSOnEC2TaskId()
-	if taskId != "" {
-		opts.InstanceId = taskId
-	} else {
-		opts.EC2StartTime = startTime
-		opts.InstanceId = instanceId
+		opts.Ssh = false
+		opts.ZTSCACertFile = *ztsCACert
+		opts.ZTSServerName = *ztsServerName
+		opts.ZTSDomains = strings.Split(*dnsDomains, ",")
+
+		//check to see if this is ecs on ec2 and update instance id
+		//for ec2 instances we also need to set the start time so
+		//can check the expiry check if requested
+		taskId := sia.GetECSOnEC2TaskId()
+		if taskId != "" {
+			opts.InstanceId = taskId
+		} else {
+			opts.EC2StartTime = startTime
+			opts.InstanceId = instanceId
+		}
+		opts.Provider = sia.NewProvider(fmt.Sprintf("%s.%s", *providerPrefix, region), opts, document, signature)
+
+		if *udsPath != "" {
+			opts.SDSUdsPath = *udsPath
+		}
+		return opts, nil
 	}
 
-	if *udsPath != "" {
-		opts.SDSUdsPath = *udsPath
+	opts, err := newOptions()
+	if err != nil {
+		log.Fatalf("%v\n", err)
 	}
 
-	agent.RunAgent(*cmd, siaMainDir, ztsUrl, opts)
+	agent.RunAgentWithReload(*cmd, siaMainDir, ztsUrl, opts, newOptions)
 }
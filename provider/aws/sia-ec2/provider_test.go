@@ -0,0 +1,45 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"testing"
+
+	"github.com/AthenZ/athenz/libs/go/sia/aws/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProvider(t *testing.T) {
+	opts := &options.Options{
+		InstanceId: "i-0123",
+		Region:     "us-west-2",
+		Account:    "123456789012",
+		ZTSDomains: []string{"aws.athenz.cloud"},
+	}
+	p := NewProvider("athenz.aws.us-west-2", opts, []byte("document"), []byte("signature"))
+	assert.Equal(t, "athenz.aws.us-west-2", p.GetName())
+	assert.Equal(t, "aws.athenz.cloud", p.GetSuffix())
+	assert.Equal(t, "123456789012", p.(attestation.Provider).Account)
+	assert.Equal(t, "us-west-2", p.(attestation.Provider).Region)
+	assert.Equal(t, "document", p.(attestation.Provider).Document)
+	assert.Equal(t, "signature", p.(attestation.Provider).Signature)
+
+	uris := p.GetSanUri("sports.api", ip.Opts{})
+	assert.Equal(t, "athenz://instanceid/athenz.aws.us-west-2/i-0123", uris[1].String())
+}
@@ -20,7 +20,7 @@ import (
 	"log"
 	"os"
 
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
 )
 
 func GetEKSPodId() string {
@@ -23,8 +23,8 @@ import (
 	"os"
 	"strings"
 
-	"github.com/AthenZ/athenz/libs/go/sia/aws/agent"
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
 	"github.com/AthenZ/athenz/provider/aws/sia-fargate"
 )
 
@@ -75,26 +75,36 @@ func main() {
 		log.Fatalf("Unable to extract fargate task details: %v\n", err)
 	}
 
-	config, configAccount, err := sia.GetFargateConfig(*pConf, *ecsMetaEndPoint, *useRegionalSTS, account, region)
-	if err != nil {
-		log.Fatalf("Unable to formulate configuration objects, error: %v\n", err)
+	//the same function is used to reload the configuration
+	//when the agent receives the SIGHUP signal
+	newOptions := func() (*options.Options, error) {
+		config, configAccount, err := sia.GetFargateConfig(*pConf, *ecsMetaEndPoint, *useRegionalSTS, account, region)
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate configuration objects, error: %v", err)
+		}
+
+		opts, err := options.NewOptions(config, configAccount, nil, siaMainDir, Version, *useRegionalSTS, region)
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate options, error: %v", err)
+		}
+
+		opts.Ssh = false
+		opts.ZTSCACertFile = *ztsCACert
+		opts.ZTSServerName = *ztsServerName
+		opts.ZTSDomains = strings.Split(*dnsDomains, ",")
+		opts.InstanceId = taskId
+		opts.Provider = sia.NewProvider(fmt.Sprintf("%s.%s", *providerPrefix, region), opts)
+
+		if *udsPath != "" {
+			opts.SDSUdsPath = *udsPath
+		}
+		return opts, nil
 	}
 
-	opts, err := options.NewOptions(config, configAccount, nil, siaMainDir, Version, *useRegionalSTS, region)
+	opts, err := newOptions()
 	if err != nil {
-		log.Fatalf("Unable to formulate options, error: %v\n", err)
-	}
-
-	opts.Ssh = false
-	opts.ZTSCACertFile = *ztsCACert
-	opts.ZTSServerName = *ztsServerName
-	opts.ZTSAWSDomains = strings.Split(*dnsDomains, ",")
-	opts.Provider = fmt.Sprintf("%s.%s", *providerPrefix, region)
-	opts.InstanceId = taskId
-
-	if *udsPath != "" {
-		opts.SDSUdsPath = *udsPath
+		log.Fatalf("%v\n", err)
 	}
 
-	agent.RunAgent(*cmd, siaMainDir, ztsUrl, opts)
+	agent.RunAgentWithReload(*cmd, siaMainDir, ztsUrl, opts, newOptions)
 }
@@ -19,17 +19,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
 	"github.com/AthenZ/athenz/libs/go/sia/util"
 	"github.com/AthenZ/athenz/provider/azure/sia-vm"
 	"github.com/AthenZ/athenz/provider/azure/sia-vm/data/attestation"
 	"github.com/AthenZ/athenz/provider/azure/sia-vm/options"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
-	"time"
 )
 
 var MetaEndPoint = "http://169.254.169.254"
@@ -94,131 +91,17 @@ func main() {
 
 	log.Printf("options: %+v\n", opts)
 
-	data, err := getAttestationData(*ztsResourceUri, identityDocument, opts)
-	if err != nil {
-		log.Fatalf("Unable to formulate attestation data, error: %v\n", err)
-	}
-
-	// for now we're going to rotate once every day
-	// since our server and role certs are valid for
-	// 30 days by default
-	rotationInterval := 24 * 60 * time.Minute
-
 	ztsUrl := fmt.Sprintf("https://%s:4443/zts/v1", *ztsEndPoint)
+	vmProvider := sia.NewProvider(opts, identityDocument, MetaEndPoint, ApiVersion, *ztsResourceUri)
 
-	err = util.SetupSIADirs(siaMainDir, siaLinkDir, -1, -1)
-	if err != nil {
-		log.Fatalf("Unable to setup sia directories, error: %v\n", err)
-	}
-
-	log.Printf("Request SSH Certificates: %t\n", opts.Ssh)
-
-	svcs := options.GetSvcNames(opts.Services)
-
-	switch *cmd {
-	case "rolecert":
-		sia.GetRoleCertificate(ztsUrl,
-			fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, opts.Services[0].Name),
-			fmt.Sprintf("%s/%s.%s.cert.pem", opts.CertDir, opts.Domain, opts.Services[0].Name),
-			opts,
-		)
-	case "post":
-		err := sia.RegisterInstance(data, ztsUrl, identityDocument, opts)
-		if err != nil {
-			log.Fatalf("Register identity failed, err: %v\n", err)
-		}
-		log.Printf("identity registered for services: %s\n", svcs)
-	case "rotate":
-		err = sia.RefreshInstance(data, ztsUrl, identityDocument, opts)
-		if err != nil {
-			log.Fatalf("Refresh identity failed, err: %v\n", err)
-		}
-		log.Printf("Identity successfully refreshed for services: %s\n", svcs)
-	default:
-		// if we already have a cert file then we're not going to
-		// prove our identity since most likely it will not succeed
-		// due to boot time check (this could be just a regular
-		// service restart for any reason). Instead, we'll just skip
-		// over and try to rotate the certs
-
-		initialSetup := true
-		if files, err := ioutil.ReadDir(opts.CertDir); err != nil || len(files) <= 0 {
-			err := sia.RegisterInstance(data, ztsUrl, identityDocument, opts)
-			if err != nil {
-				log.Fatalf("Register identity failed, error: %v\n", err)
-			}
-		} else {
-			initialSetup = false
-			log.Println("Identity certificate file already exists. Retrieving identity details...")
-		}
-		log.Printf("Identity established for services: %s\n", svcs)
-
-		stop := make(chan bool, 1)
-		errors := make(chan error, 1)
-
-		go func() {
-			for {
-				log.Printf("Identity being used: %s\n", opts.Name)
-
-				// if we just did our initial setup there is no point
-				// to refresh the certs again. so we are going to skip
-				// this time around and refresh certs next time
-
-				if !initialSetup {
-					data, err := getAttestationData(*ztsResourceUri, identityDocument, opts)
-					if err != nil {
-						errors <- fmt.Errorf("Cannot get attestation data: %v\n", err)
-						return
-					}
-					err = sia.RefreshInstance(data, ztsUrl, identityDocument, opts)
-					if err != nil {
-						errors <- fmt.Errorf("refresh identity failed, error: %v", err)
-						return
-					}
-					log.Printf("identity successfully refreshed for services: %s\n", svcs)
-				} else {
-					initialSetup = false
-				}
-				sia.GetRoleCertificate(ztsUrl,
-					fmt.Sprintf("%s/%s.%s.key.pem", opts.KeyDir, opts.Domain, opts.Services[0].Name),
-					fmt.Sprintf("%s/%s.%s.cert.pem", opts.CertDir, opts.Domain, opts.Services[0].Name),
-					opts,
-				)
-				select {
-				case <-stop:
-					errors <- nil
-					return
-				case <-time.After(rotationInterval):
-					break
-				}
-			}
-		}()
-
-		go func() {
-			signals := make(chan os.Signal, 2)
-			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
-			sig := <-signals
-			log.Printf("Received signal %v, stopping rotation\n", sig)
-			stop <- true
-		}()
-
-		err = <-errors
+	//the doctor and deregister commands must not make
+	//any changes to the sia directories
+	if !strings.HasPrefix(*cmd, "doctor") && !strings.HasPrefix(*cmd, "deregister") {
+		err = util.SetupSIADirs(siaMainDir, siaLinkDir, -1, -1)
 		if err != nil {
-			log.Printf("%v\n", err)
+			log.Fatalf("Unable to setup sia directories, error: %v\n", err)
 		}
 	}
-	os.Exit(0)
-}
 
-// getAttestationData fetches attestation data for all the services mentioned in the config file
-func getAttestationData(resourceUri string, identityDocument *attestation.IdentityDocument, opts *options.Options) ([]*attestation.Data, error) {
-	var data []*attestation.Data
-	for _, svc := range opts.Services {
-		a, err := attestation.New(opts.Domain, svc.Name, MetaEndPoint, ApiVersion, resourceUri, identityDocument)
-		if err != nil {
-			return nil, err
-		}
-		data = append(data, a)
-	}
-	return data, nil
+	agent.RunAgent(*cmd, siaMainDir, ztsUrl, sia.NewAgentOptions(opts, siaMainDir, vmProvider))
 }
@@ -151,6 +151,18 @@ func StartZtsServer(endPoint string) {
 		}
 	}).Methods("POST")
 
+	router.HandleFunc("/zts/v1/instance/athenz.azure.west2/athenz/{service}/123456789012-vmid", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("instance delete handler called")
+
+		if mux.Vars(r)["service"] != "hockey" {
+			w.WriteHeader(404)
+			io.WriteString(w, `{"code":404,"message":"unknown instance"}`)
+			return
+		}
+		w.WriteHeader(204)
+		log.Println("Successfully processed delete instance request")
+	}).Methods("DELETE")
+
 	router.HandleFunc("/zts/v1/rolecert", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("role certificate handler called")
 
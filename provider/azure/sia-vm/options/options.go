@@ -66,6 +66,7 @@ type Config struct {
 	Accounts       []ConfigAccount          `json:"accounts,omitempty"`        //array of configured accounts
 	SanDnsWildcard bool                     `json:"sandns_wildcard,omitempty"` //san dns wildcard support
 	SanDnsHostname bool                     `json:"sandns_hostname,omitempty"` //san dns hostname support
+	KeyType        string                   `json:"key_type,omitempty"`        //private key type - rsa-2048, rsa-4096, ecdsa-p256, ecdsa-p384, ed25519
 }
 
 // Role contains role details. Attributes are set based on the config values
@@ -111,6 +112,7 @@ type Options struct {
 	CountryName      string
 	SanDnsWildcard   bool
 	SanDnsHostname   bool
+	KeyType          util.KeyType
 }
 
 func initProfileConfig(identityDocument *attestation.IdentityDocument) (*ConfigAccount, error) {
@@ -178,9 +180,14 @@ func NewOptions(bytes []byte, identityDocument *attestation.IdentityDocument, si
 
 	sanDnsWildcard := false
 	sanDnsHostname := false
+	keyType := util.DEFAULT_KEY_TYPE
 	if config != nil {
 		sanDnsWildcard = config.SanDnsWildcard
 		sanDnsHostname = config.SanDnsHostname
+		keyType, err = util.ParseKeyType(config.KeyType)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	var services []Service
@@ -256,6 +263,7 @@ func NewOptions(bytes []byte, identityDocument *attestation.IdentityDocument, si
 		CountryName:      countryName,
 		SanDnsWildcard:   sanDnsWildcard,
 		SanDnsHostname:   sanDnsHostname,
+		KeyType:          keyType,
 	}, nil
 }
 
@@ -18,6 +18,7 @@ package options
 
 import (
 	"fmt"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
 	"github.com/AthenZ/athenz/provider/azure/sia-vm/data/attestation"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -215,6 +216,38 @@ func TestOptionsNoServices(t *testing.T) {
 	assert.True(t, assertService(opts.Services[0], Service{Name: "api", User: "nobody", Uid: getUid("nobody"), Gid: getUserGid("nobody")}))
 }
 
+// TestOptionsWithKeyType test the scenario when the private key type is configured
+func TestOptionsWithKeyType(t *testing.T) {
+	config := `{
+		"version": "1.0.0",
+		"service": "api",
+		"key_type": "ecdsa-p384",
+  		"accounts": [
+  			{
+  			    "domain": "athenz",
+    			"user": "nobody",
+    	  		"account": "123456789012"
+    		}
+  		]
+	}`
+
+	identityDocument := attestation.IdentityDocument{
+		Location:       "west2",
+		SubscriptionId: "123456789012",
+		VmId:           "123456789012-vmid",
+		Tags:           "athenz:athenz.api",
+	}
+
+	ztsAzureDomains := []string{"zts-azure-domain"}
+	opts, e := NewOptions([]byte(config), &identityDocument, "/tmp", "1.0.0", "", "", ztsAzureDomains, "US", "azure.provider")
+	require.Nilf(t, e, "error should not be thrown, error: %v", e)
+	assert.Equal(t, util.ECDSAP384, opts.KeyType)
+
+	config = strings.Replace(config, "ecdsa-p384", "dsa-1024", 1)
+	_, e = NewOptions([]byte(config), &identityDocument, "/tmp", "1.0.0", "", "", ztsAzureDomains, "US", "azure.provider")
+	require.NotNil(t, e, "unknown key type must be rejected")
+}
+
 func assertService(expected Service, actual Service) bool {
 	log.Printf("expected: %+v\n", expected)
 	log.Printf("actual: %+v\n", actual)
@@ -0,0 +1,157 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+	siaoptions "github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/AthenZ/athenz/provider/azure/sia-vm/data/attestation"
+	"github.com/AthenZ/athenz/provider/azure/sia-vm/options"
+)
+
+const sshPubKeyFile = "/etc/ssh/ssh_host_rsa_key.pub"
+const sshCertFile = "/etc/ssh/ssh_host_rsa_key-cert.pub"
+const sshConfigFile = "/etc/ssh/sshd_config"
+
+// Provider is the Azure VM implementation of the host provider. The
+// attestation data carries the managed identity access token for the
+// configured ZTS resource along with the vm details.
+type Provider struct {
+	provider.Base
+	MetaEndPoint     string
+	ApiVersion       string
+	ResourceUri      string
+	IdentityDocument *attestation.IdentityDocument
+}
+
+// NewProvider returns the Azure VM provider for the given vm identity document
+func NewProvider(opts *options.Options, identityDocument *attestation.IdentityDocument, metaEndPoint, apiVersion, resourceUri string) provider.Provider {
+	return Provider{
+		Base: provider.Base{
+			Name:       getProviderName(opts.Provider, identityDocument.Location),
+			InstanceId: identityDocument.VmId,
+			Domains:    opts.ZTSAzureDomains,
+		},
+		MetaEndPoint:     metaEndPoint,
+		ApiVersion:       apiVersion,
+		ResourceUri:      resourceUri,
+		IdentityDocument: identityDocument,
+	}
+}
+
+// AttestationData obtains the access token for the ZTS resource from the
+// managed identity endpoint for the given principal
+func (p Provider) AttestationData(principal string, key crypto.PrivateKey, sigInfo *signature.SignatureInfo) (string, error) {
+	domain, service := util.SplitDomain(principal)
+	data, err := attestation.New(domain, service, p.MetaEndPoint, p.ApiVersion, p.ResourceUri, p.IdentityDocument)
+	if err != nil {
+		return "", err
+	}
+	attestData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(attestData), nil
+}
+
+// NewAgentOptions converts the Azure options into the options used by the
+// sia agent to register and refresh the service and role certificates. The
+// file mode only applies to the private keys (0440) while the agent writes
+// the service, role and CA certificates as world readable (0444) as before.
+func NewAgentOptions(opts *options.Options, siaDir string, azureProvider provider.Provider) *siaoptions.Options {
+	var services []siaoptions.Service
+	for _, svc := range opts.Services {
+		services = append(services, siaoptions.Service{
+			Name:      svc.Name,
+			Filename:  svc.Filename,
+			User:      svc.User,
+			Group:     svc.Group,
+			Uid:       svc.Uid,
+			Gid:       svc.Gid,
+			FileMode:  0440,
+			Threshold: siaoptions.DEFAULT_THRESHOLD,
+		})
+	}
+	var roles []siaoptions.Role
+	for name, role := range opts.Roles {
+		roles = append(roles, siaoptions.Role{
+			Name:      name,
+			Service:   services[0].Name,
+			Filename:  role.Filename,
+			Uid:       services[0].Uid,
+			Gid:       services[0].Gid,
+			FileMode:  0440,
+			Threshold: siaoptions.DEFAULT_THRESHOLD,
+		})
+	}
+	var instanceId, privateIp string
+	if p, ok := azureProvider.(Provider); ok {
+		instanceId = p.InstanceId
+		privateIp = p.IdentityDocument.PrivateIp
+	}
+	return &siaoptions.Options{
+		Provider:                azureProvider,
+		Name:                    opts.Name,
+		User:                    opts.User,
+		Group:                   opts.Group,
+		Domain:                  opts.Domain,
+		Account:                 opts.Account,
+		Zts:                     opts.Zts,
+		Filename:                opts.Filename,
+		InstanceId:              instanceId,
+		Roles:                   roles,
+		SanDnsWildcard:          opts.SanDnsWildcard,
+		SanDnsHostname:          opts.SanDnsHostname,
+		Version:                 opts.Version,
+		ZTSDomains:              opts.ZTSAzureDomains,
+		Services:                services,
+		Ssh:                     opts.Ssh,
+		KeyDir:                  opts.KeyDir,
+		CertDir:                 opts.CertDir,
+		AthenzCACertFile:        opts.AthenzCACertFile,
+		ZTSCACertFile:           opts.ZTSCACertFile,
+		ZTSServerName:           opts.ZTSServerName,
+		BackUpDir:               fmt.Sprintf("%s/backup", siaDir),
+		CertCountryName:         opts.CountryName,
+		SshPubKeyFile:           sshPubKeyFile,
+		SshCertFile:             sshCertFile,
+		SshConfigFile:           sshConfigFile,
+		PrivateIp:               privateIp,
+		RefreshInterval:         24 * 60,
+		TokenDir:                fmt.Sprintf("%s/tokens", siaDir),
+		Threshold:               siaoptions.DEFAULT_THRESHOLD,
+		SshThreshold:            siaoptions.DEFAULT_THRESHOLD,
+		KeyType:                 opts.KeyType,
+		BackoffInitialInterval:  siaoptions.DEFAULT_BACKOFF_INITIAL_INTERVAL,
+		BackoffMaxInterval:      siaoptions.DEFAULT_BACKOFF_MAX_INTERVAL,
+		CertRefreshRatio:        siaoptions.DEFAULT_CERT_REFRESH_RATIO,
+		CertRefreshJitter:       siaoptions.DEFAULT_CERT_REFRESH_JITTER,
+		CABundleRefreshInterval: siaoptions.DEFAULT_CA_BUNDLE_REFRESH_INTERVAL,
+	}
+}
+
+func getProviderName(provider, region string) string {
+	if provider != "" {
+		return provider
+	}
+	return "athenz.azure." + region
+}
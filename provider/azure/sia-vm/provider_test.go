@@ -0,0 +1,256 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/AthenZ/athenz/provider/azure/sia-vm/data/attestation"
+	"github.com/AthenZ/athenz/provider/azure/sia-vm/devel/metamock"
+	"github.com/AthenZ/athenz/provider/azure/sia-vm/devel/ztsmock"
+	"github.com/AthenZ/athenz/provider/azure/sia-vm/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const metaEndPoint = "http://127.0.0.1:5086"
+const ztsUrl = "http://127.0.0.1:5085/zts/v1"
+
+func setup() {
+	go ztsmock.StartZtsServer("127.0.0.1:5085")
+	go metamock.StartMetaServer("127.0.0.1:5086")
+	time.Sleep(3 * time.Second)
+}
+
+func teardown() {}
+
+func TestMain(m *testing.M) {
+	setup()
+	code := m.Run()
+	teardown()
+	os.Exit(code)
+}
+
+func testIdentityDocument() *attestation.IdentityDocument {
+	return &attestation.IdentityDocument{
+		Location:          "west2",
+		Name:              "athenz",
+		ResourceGroupName: "athenz-rg",
+		SubscriptionId:    "123456789012",
+		VmId:              "123456789012-vmid",
+		OsType:            "Linux",
+		Tags:              "athenz:athenz.api",
+		PrivateIp:         "10.0.0.1",
+		PublicIp:          "",
+		Document:          nil,
+	}
+}
+
+func testOptions(siaDir string, services ...string) *options.Options {
+	opts := &options.Options{
+		Domain:           "athenz",
+		KeyDir:           siaDir,
+		CertDir:          siaDir,
+		AthenzCACertFile: fmt.Sprintf("%s/ca.cert.pem", siaDir),
+		ZTSAzureDomains:  []string{"zts-azure-domain"},
+	}
+	for _, name := range services {
+		opts.Services = append(opts.Services, options.Service{
+			Name: name,
+			Uid:  util.ExecIdCommand("-u"),
+			Gid:  util.ExecIdCommand("-g"),
+		})
+	}
+	return opts
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+func TestGetProviderName(test *testing.T) {
+	name := getProviderName("azure.provider", "uswest2")
+	if name != "azure.provider" {
+		test.Errorf("Unable to verify provider with azure.provider name: %s", name)
+		return
+	}
+	name = getProviderName("", "uswest2")
+	if name != "athenz.azure.uswest2" {
+		test.Errorf("Unable to verify provider with uswest2 region name: %s", name)
+		return
+	}
+}
+
+func TestNewProvider(test *testing.T) {
+	opts := testOptions(test.TempDir(), "hockey")
+	vmProvider := NewProvider(opts, testIdentityDocument(), metaEndPoint, "2020-06-01", "https://zts.athenz.io")
+
+	assert.Equal(test, "athenz.azure.west2", vmProvider.GetName())
+	assert.Equal(test, "zts-azure-domain", vmProvider.GetSuffix())
+	assert.Equal(test, "OU=athenz.azure.west2", vmProvider.GetCsrDn().String())
+
+	sanDns := vmProvider.GetSanDns("athenz.hockey", false, false, nil)
+	assert.Equal(test, []string{"hockey.athenz.zts-azure-domain"}, sanDns)
+
+	opts.Provider = "azure.provider"
+	vmProvider = NewProvider(opts, testIdentityDocument(), metaEndPoint, "2020-06-01", "https://zts.athenz.io")
+	assert.Equal(test, "azure.provider", vmProvider.GetName())
+}
+
+func TestAttestationData(test *testing.T) {
+	opts := testOptions(test.TempDir(), "hockey")
+	vmProvider := NewProvider(opts, testIdentityDocument(), metaEndPoint, "2020-06-01", "https://zts.athenz.io")
+
+	data, err := vmProvider.AttestationData("athenz.hockey", nil, nil)
+	require.Nil(test, err, fmt.Sprintf("unable to get attestation data: %v", err))
+
+	var attestData attestation.Data
+	require.Nil(test, json.Unmarshal([]byte(data), &attestData))
+	assert.Equal(test, "test-access-token", attestData.Token)
+	assert.Equal(test, "123456789012-vmid", attestData.VmId)
+	assert.Equal(test, "west2", attestData.Location)
+}
+
+func TestNewAgentOptions(test *testing.T) {
+	siaDir := test.TempDir()
+	opts := testOptions(siaDir, "hockey", "soccer")
+	opts.Ssh = true
+	opts.CountryName = "US"
+	opts.Roles = map[string]options.ConfigRole{
+		"athenz:role.writers": {Filename: "writers.cert.pem"},
+	}
+	vmProvider := NewProvider(opts, testIdentityDocument(), metaEndPoint, "2020-06-01", "https://zts.athenz.io")
+
+	agentOpts := NewAgentOptions(opts, siaDir, vmProvider)
+	assert.Equal(test, vmProvider, agentOpts.Provider)
+	assert.Equal(test, "123456789012-vmid", agentOpts.InstanceId)
+	assert.Equal(test, "10.0.0.1", agentOpts.PrivateIp)
+	assert.Equal(test, []string{"zts-azure-domain"}, agentOpts.ZTSDomains)
+	assert.Equal(test, "US", agentOpts.CertCountryName)
+	assert.Equal(test, sshPubKeyFile, agentOpts.SshPubKeyFile)
+	assert.Equal(test, fmt.Sprintf("%s/backup", siaDir), agentOpts.BackUpDir)
+	assert.True(test, agentOpts.Ssh)
+	require.Equal(test, 2, len(agentOpts.Services))
+	assert.Equal(test, "hockey", agentOpts.Services[0].Name)
+	assert.Equal(test, 0440, agentOpts.Services[0].FileMode)
+	require.Equal(test, 1, len(agentOpts.Roles))
+	assert.Equal(test, "athenz:role.writers", agentOpts.Roles[0].Name)
+	assert.Equal(test, "hockey", agentOpts.Roles[0].Service)
+	assert.Equal(test, "writers.cert.pem", agentOpts.Roles[0].Filename)
+}
+
+func TestRegisterInstance(test *testing.T) {
+	siaDir := test.TempDir()
+
+	opts := testOptions(siaDir, "hockey", "soccer")
+	vmProvider := NewProvider(opts, testIdentityDocument(), metaEndPoint, "2020-06-01", "https://zts.athenz.io")
+
+	err := agent.RegisterInstance(ztsUrl, NewAgentOptions(opts, siaDir, vmProvider), false)
+	assert.Nil(test, err, fmt.Sprintf("unable to register instance: %v", err))
+
+	for _, svc := range []string{"hockey", "soccer"} {
+		keyFile := fmt.Sprintf("%s/athenz.%s.key.pem", siaDir, svc)
+		certFile := fmt.Sprintf("%s/athenz.%s.cert.pem", siaDir, svc)
+		assertFileMode(test, keyFile, 0440)
+		assertFileMode(test, certFile, 0444)
+	}
+	assertFileMode(test, opts.AthenzCACertFile, 0444)
+}
+
+func assertFileMode(test *testing.T, fileName string, mode os.FileMode) {
+	info, err := os.Stat(fileName)
+	require.Nil(test, err, fmt.Sprintf("unable to stat %s: %v", fileName, err))
+	assert.Equal(test, mode, info.Mode().Perm(), fileName)
+}
+
+func TestRefreshInstance(test *testing.T) {
+	siaDir := test.TempDir()
+
+	keyFile := fmt.Sprintf("%s/athenz.hockey.key.pem", siaDir)
+	certFile := fmt.Sprintf("%s/athenz.hockey.cert.pem", siaDir)
+	require.Nil(test, copyFile("devel/data/unit_test_key.pem", keyFile))
+	require.Nil(test, copyFile("devel/data/cert.pem", certFile))
+	require.Nil(test, copyFile("devel/data/ca.cert.pem", fmt.Sprintf("%s/ca.cert.pem", siaDir)))
+
+	opts := testOptions(siaDir, "hockey")
+	vmProvider := NewProvider(opts, testIdentityDocument(), metaEndPoint, "2020-06-01", "https://zts.athenz.io")
+
+	err := agent.RefreshInstance(ztsUrl, NewAgentOptions(opts, siaDir, vmProvider))
+	assert.Nil(test, err, fmt.Sprintf("unable to refresh instance: %v", err))
+
+	oldCert, _ := os.ReadFile("devel/data/cert.pem")
+	newCert, _ := os.ReadFile(certFile)
+	if string(oldCert) == string(newCert) {
+		test.Errorf("Certificate was not refreshed")
+		return
+	}
+	assertFileMode(test, certFile, 0444)
+}
+
+func TestRoleCertificateRequest(test *testing.T) {
+	siaDir := test.TempDir()
+
+	roleCertFile := fmt.Sprintf("%s/testrole.cert.pem", siaDir)
+	require.Nil(test, copyFile("devel/data/unit_test_key.pem", fmt.Sprintf("%s/athenz.hockey.key.pem", siaDir)))
+	require.Nil(test, copyFile("devel/data/cert.pem", fmt.Sprintf("%s/athenz.hockey.cert.pem", siaDir)))
+	require.Nil(test, copyFile("devel/data/ca.cert.pem", fmt.Sprintf("%s/ca.cert.pem", siaDir)))
+
+	opts := testOptions(siaDir, "hockey")
+	opts.Roles = map[string]options.ConfigRole{
+		"athenz:role.writers": {
+			Filename: roleCertFile,
+		},
+	}
+	vmProvider := NewProvider(opts, testIdentityDocument(), metaEndPoint, "2020-06-01", "https://zts.athenz.io")
+
+	result := agent.GetRoleCertificates(ztsUrl, NewAgentOptions(opts, siaDir, vmProvider))
+	if !result {
+		test.Errorf("Unable to get role certificate")
+		return
+	}
+	assertFileMode(test, roleCertFile, 0444)
+}
+
+func TestDeregisterInstance(test *testing.T) {
+	siaDir := test.TempDir()
+
+	opts := testOptions(siaDir, "hockey", "soccer")
+	for _, svc := range opts.Services {
+		keyFile := fmt.Sprintf("%s/athenz.%s.key.pem", siaDir, svc.Name)
+		certFile := fmt.Sprintf("%s/athenz.%s.cert.pem", siaDir, svc.Name)
+		require.Nil(test, copyFile("devel/data/unit_test_key.pem", keyFile))
+		require.Nil(test, copyFile("devel/data/cert.pem", certFile))
+	}
+	vmProvider := NewProvider(opts, testIdentityDocument(), metaEndPoint, "2020-06-01", "https://zts.athenz.io")
+
+	// the identity for soccer is not known to the mock server
+	// so it must be treated as already deregistered
+	err := agent.DeregisterInstance(ztsUrl, NewAgentOptions(opts, siaDir, vmProvider), true)
+	require.Nil(test, err, fmt.Sprintf("unable to deregister instance: %v", err))
+	assert.NoFileExists(test, fmt.Sprintf("%s/athenz.hockey.key.pem", siaDir))
+	assert.NoFileExists(test, fmt.Sprintf("%s/athenz.soccer.cert.pem", siaDir))
+}
@@ -0,0 +1,53 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"log"
+
+	"github.com/AthenZ/athenz/libs/go/sia/gcp/meta"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+)
+
+// GetGCEDetails returns the project, instance id and region of the
+// GCE instance from the metadata server
+func GetGCEDetails(metaEndPoint string) (string, string, string, error) {
+	project, err := meta.GetProject(metaEndPoint)
+	if err != nil {
+		return "", "", "", err
+	}
+	instanceId, err := meta.GetInstanceId(metaEndPoint)
+	if err != nil {
+		return "", "", "", err
+	}
+	return project, instanceId, meta.GetRegion(metaEndPoint), nil
+}
+
+func GetGCEConfig(configFile, metaEndPoint, project string) (*options.Config, *options.ConfigAccount, error) {
+
+	config, configAccount, err := options.InitFileConfig(configFile, metaEndPoint, false, "", project)
+	if err != nil {
+		log.Printf("Unable to process configuration file '%s': %v\n", configFile, err)
+		log.Println("Trying to determine service details from the instance metadata...")
+		configAccount, err = options.InitGCPMetaConfig(metaEndPoint, project)
+		if err != nil {
+			log.Printf("Unable to determine service name: %v\n", err)
+			return config, nil, err
+		}
+	}
+	return config, configAccount, nil
+}
@@ -0,0 +1,91 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/gcp/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/provider/gcp/sia-gce/devel/metamock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const metaEndPoint = "http://127.0.0.1:5087"
+
+func setup() {
+	go metamock.StartMetaServer("127.0.0.1:5087")
+	time.Sleep(3 * time.Second)
+}
+
+func teardown() {}
+
+func TestMain(m *testing.M) {
+	setup()
+	code := m.Run()
+	teardown()
+	os.Exit(code)
+}
+
+func TestGetGCEDetails(t *testing.T) {
+	project, instanceId, region, err := GetGCEDetails(metaEndPoint)
+	require.Nil(t, err)
+	assert.Equal(t, "athenz-project", project)
+	assert.Equal(t, "1234567890123456789", instanceId)
+	assert.Equal(t, "us-west1", region)
+
+	_, _, _, err = GetGCEDetails("http://127.0.0.1:1")
+	assert.NotNil(t, err)
+}
+
+func TestGetGCEConfigNoConfig(t *testing.T) {
+	_, configAccount, err := GetGCEConfig("devel/data/missing_config", metaEndPoint, "athenz-project")
+	require.Nil(t, err)
+	assert.Equal(t, "athenz", configAccount.Domain)
+	assert.Equal(t, "hockey", configAccount.Service)
+	assert.Equal(t, "athenz-project", configAccount.Account)
+}
+
+func TestGetGCEConfigWithConfig(t *testing.T) {
+	config, configAccount, err := GetGCEConfig("devel/data/sia_config", metaEndPoint, "athenz-project")
+	require.Nil(t, err)
+	require.NotNil(t, config)
+	assert.Equal(t, "sports", configAccount.Domain)
+	assert.Equal(t, "api", configAccount.Service)
+	assert.Equal(t, "sports.api", configAccount.Name)
+}
+
+func TestNewProvider(t *testing.T) {
+	opts := &options.Options{
+		InstanceId: "1234567890123456789",
+		ZTSDomains: []string{"gcp.athenz.cloud"},
+	}
+	p := NewProvider("athenz.gcp.us-west1", opts, metaEndPoint, "https://zts.athenz.io:4443/zts/v1")
+	assert.Equal(t, "athenz.gcp.us-west1", p.GetName())
+	assert.Equal(t, "gcp.athenz.cloud", p.GetSuffix())
+	assert.Equal(t, []string{"api.sports.gcp.athenz.cloud"}, p.GetSanDns("sports.api", false, false, nil))
+
+	data, err := p.AttestationData("sports.api", nil, nil)
+	require.Nil(t, err)
+	var attestData attestation.AttestationData
+	require.Nil(t, json.Unmarshal([]byte(data), &attestData))
+	assert.Equal(t, "gce-identity-token-https://zts.athenz.io:4443/zts/v1", attestData.IdentityToken)
+}
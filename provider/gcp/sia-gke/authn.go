@@ -0,0 +1,50 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"log"
+	"os"
+
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+)
+
+func GetGKEPodId() string {
+	podId := os.Getenv("HOSTNAME")
+	if podId == "" {
+		podId = "gkePod"
+	}
+	return podId
+}
+
+func GetGKEConfig(configFile, metaEndPoint, project string) (*options.Config, *options.ConfigAccount, error) {
+
+	config, configAccount, err := options.InitFileConfig(configFile, metaEndPoint, false, "", project)
+	if err != nil {
+		log.Printf("Unable to process configuration file '%s': %v\n", configFile, err)
+		// the environment only carries the agent settings since the
+		// service identity is determined by the workload identity
+		config, _, _ = options.InitEnvConfig(config)
+		log.Println("Trying to determine service details from the workload identity...")
+		configAccount, err = options.InitGCPMetaConfig(metaEndPoint, project)
+		if err != nil {
+			log.Printf("Unable to determine service name: %v\n", err)
+			return config, nil, err
+		}
+	}
+	return config, configAccount, nil
+}
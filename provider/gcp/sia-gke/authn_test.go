@@ -0,0 +1,89 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/gcp/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/gcp/meta"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/provider/gcp/sia-gke/devel/metamock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const metaEndPoint = "http://127.0.0.1:5088"
+
+func setup() {
+	go metamock.StartMetaServer("127.0.0.1:5088")
+	time.Sleep(3 * time.Second)
+}
+
+func teardown() {}
+
+func TestMain(m *testing.M) {
+	setup()
+	code := m.Run()
+	teardown()
+	os.Exit(code)
+}
+
+func TestGetGKEPodId(t *testing.T) {
+	t.Setenv("HOSTNAME", "api-6d4cf56db6-kx2lq")
+	assert.Equal(t, "api-6d4cf56db6-kx2lq", GetGKEPodId())
+	t.Setenv("HOSTNAME", "")
+	assert.Equal(t, "gkePod", GetGKEPodId())
+}
+
+func TestGetGKEConfigNoConfig(t *testing.T) {
+	t.Setenv("ATHENZ_SIA_SANDNS_WILDCARD", "true")
+	config, configAccount, err := GetGKEConfig("devel/data/missing_config", metaEndPoint, "athenz-project")
+	require.Nil(t, err)
+	assert.Equal(t, "sports", configAccount.Domain)
+	assert.Equal(t, "api", configAccount.Service)
+	assert.Equal(t, "sports.api", configAccount.Name)
+	require.NotNil(t, config)
+	assert.True(t, config.SanDnsWildcard)
+	assert.Equal(t, "us-east1", meta.GetRegion(metaEndPoint))
+}
+
+func TestGetGKEConfigNoMeta(t *testing.T) {
+	_, _, err := GetGKEConfig("devel/data/missing_config", "http://127.0.0.1:1", "athenz-project")
+	assert.NotNil(t, err)
+}
+
+func TestNewProvider(t *testing.T) {
+	opts := &options.Options{
+		InstanceId: "api-6d4cf56db6-kx2lq",
+		ZTSDomains: []string{"gcp.athenz.cloud"},
+	}
+	p := NewProvider("athenz.gcp.us-east1", opts, metaEndPoint, "https://zts.athenz.io:4443/zts/v1")
+	assert.Equal(t, "athenz.gcp.us-east1", p.GetName())
+	uris := p.GetSanUri("sports.api", ip.Opts{})
+	assert.Equal(t, "athenz://instanceid/athenz.gcp.us-east1/api-6d4cf56db6-kx2lq", uris[1].String())
+
+	data, err := p.AttestationData("sports.api", nil, nil)
+	require.Nil(t, err)
+	var attestData attestation.AttestationData
+	require.Nil(t, json.Unmarshal([]byte(data), &attestData))
+	assert.Equal(t, "gke-identity-token-https://zts.athenz.io:4443/zts/v1", attestData.IdentityToken)
+}
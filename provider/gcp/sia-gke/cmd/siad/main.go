@@ -0,0 +1,111 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
+	"github.com/AthenZ/athenz/libs/go/sia/gcp/meta"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/provider/gcp/sia-gke"
+)
+
+// Following can be set by the build script using LDFLAGS
+
+var Version string
+
+const siaMainDir = "/var/lib/sia"
+
+func main() {
+	cmd := flag.String("cmd", "", "optional sub command to run")
+	gkeMetaEndPoint := flag.String("meta", "http://169.254.169.254", "meta endpoint")
+	ztsEndPoint := flag.String("zts", "", "Athenz Token Service (ZTS) endpoint")
+	ztsServerName := flag.String("ztsservername", "", "ZTS server name for tls connections (optional)")
+	ztsCACert := flag.String("ztscacert", "", "Athenz Token Service (ZTS) CA certificate file (optional)")
+	dnsDomains := flag.String("dnsdomains", "", "DNS Domains associated with the provider")
+	ztsPort := flag.Int("ztsport", 4443, "Athenz Token Service (ZTS) port number")
+	pConf := flag.String("config", "/etc/sia/sia_config", "The config file to run against")
+	providerPrefix := flag.String("providerprefix", "", "Provider name prefix e.g athenz.gcp")
+	displayVersion := flag.Bool("version", false, "Display version information")
+	udsPath := flag.String("uds", "", "uds path")
+
+	flag.Parse()
+
+	if *displayVersion {
+		fmt.Println(Version)
+		os.Exit(0)
+	}
+
+	log.SetFlags(log.LstdFlags)
+
+	if *ztsEndPoint == "" {
+		log.Fatalln("missing zts argument")
+	}
+	ztsUrl := fmt.Sprintf("https://%s:%d/zts/v1", *ztsEndPoint, *ztsPort)
+
+	if *dnsDomains == "" {
+		log.Fatalln("missing dnsdomains argument")
+	}
+
+	if *providerPrefix == "" {
+		log.Fatalln("missing providerprefix argument")
+	}
+
+	project, err := meta.GetProject(*gkeMetaEndPoint)
+	if err != nil {
+		log.Fatalf("Unable to determine project id: %v\n", err)
+	}
+	region := meta.GetRegion(*gkeMetaEndPoint)
+
+	//the same function is used to reload the configuration
+	//when the agent receives the SIGHUP signal
+	newOptions := func() (*options.Options, error) {
+		config, configAccount, err := sia.GetGKEConfig(*pConf, *gkeMetaEndPoint, project)
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate configuration objects, error: %v", err)
+		}
+
+		opts, err := options.NewOptions(config, configAccount, nil, siaMainDir, Version, false, region)
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate options, error: %v", err)
+		}
+
+		opts.Version = fmt.Sprintf("SIA-GKE %s", Version)
+		opts.Ssh = false
+		opts.ZTSCACertFile = *ztsCACert
+		opts.ZTSServerName = *ztsServerName
+		opts.ZTSDomains = strings.Split(*dnsDomains, ",")
+		opts.InstanceId = sia.GetGKEPodId()
+		opts.Provider = sia.NewProvider(fmt.Sprintf("%s.%s", *providerPrefix, region), opts, *gkeMetaEndPoint, ztsUrl)
+		if *udsPath != "" {
+			opts.SDSUdsPath = *udsPath
+		}
+		return opts, nil
+	}
+
+	opts, err := newOptions()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	agent.RunAgentWithReload(*cmd, siaMainDir, ztsUrl, opts, newOptions)
+}
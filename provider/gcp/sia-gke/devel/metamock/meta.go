@@ -0,0 +1,69 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package metamock
+
+import (
+	"io"
+	"log"
+	"net/http"
+)
+
+// the gke metadata server only exposes the project attributes
+// and the google service account bound to the pod through
+// workload identity
+var (
+	projectId      = "athenz-project"
+	instanceZone   = "projects/123456789012/zones/us-east1-c"
+	serviceAccount = "api@athenz-project.iam.gserviceaccount.com"
+	athenzDomain   = "sports"
+)
+
+func metaHandler(value string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = io.WriteString(w, value)
+	}
+}
+
+func StartMetaServer(EndPoint string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/computeMetadata/v1/project/project-id", metaHandler(projectId))
+	mux.HandleFunc("/computeMetadata/v1/project/attributes/athenz-domain", metaHandler(athenzDomain))
+	mux.HandleFunc("/computeMetadata/v1/instance/zone", metaHandler(instanceZone))
+	mux.HandleFunc("/computeMetadata/v1/instance/service-accounts/default/email", metaHandler(serviceAccount))
+	mux.HandleFunc("/computeMetadata/v1/instance/service-accounts/default/identity", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		audience := r.URL.Query().Get("audience")
+		if audience == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = io.WriteString(w, "gke-identity-token-"+audience)
+	})
+
+	log.Println("Starting Meta Mock listening on: " + EndPoint)
+	err := http.ListenAndServe(EndPoint, mux)
+	if err != nil {
+		log.Fatalf("ListenAndServe: %v\n", err)
+	}
+}
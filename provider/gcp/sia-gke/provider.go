@@ -0,0 +1,39 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"github.com/AthenZ/athenz/libs/go/sia/gcp/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+)
+
+// NewProvider returns the sia agent provider for GKE pods. The attestation
+// data includes the identity token for the google service account bound to
+// the kubernetes service account through workload identity. The instance
+// id and dns domains must already be set in opts.
+func NewProvider(name string, opts *options.Options, metaEndPoint, audience string) provider.Provider {
+	return attestation.Provider{
+		Base: provider.Base{
+			Name:       name,
+			InstanceId: opts.InstanceId,
+			Domains:    opts.ZTSDomains,
+		},
+		MetaEndPoint: metaEndPoint,
+		Audience:     audience,
+	}
+}
@@ -0,0 +1,86 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/AthenZ/athenz/libs/go/sia/k8s/podinfo"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+)
+
+// pod annotations that map the pod to the athenz service identity
+const (
+	DomainAnnotation  = "athenz.io/domain"
+	ServiceAnnotation = "athenz.io/service"
+)
+
+// GetPodId returns the pod uid from the service account token if the
+// token is bound to the pod, otherwise the pod name from the environment
+func GetPodId(claims *podinfo.Claims) string {
+	if claims != nil && claims.PodUid() != "" {
+		return claims.PodUid()
+	}
+	podId := os.Getenv("HOSTNAME")
+	if podId == "" {
+		podId = "k8sPod"
+	}
+	return podId
+}
+
+// GetK8SConfig determines the service identity for the pod. The sia_config
+// file maps the namespace of the pod to a domain through its account entries
+// with the namespace as the account value. Without the config file, the
+// domain and service are taken from the pod annotations and the service
+// defaults to the name of the service account.
+func GetK8SConfig(configFile, annotationsFile string, claims *podinfo.Claims) (*options.Config, *options.ConfigAccount, error) {
+
+	config, configAccount, err := options.InitFileConfig(configFile, "", false, "", claims.Namespace())
+	if err == nil {
+		return config, configAccount, nil
+	}
+	log.Printf("Unable to process configuration file '%s': %v\n", configFile, err)
+
+	// the environment only carries the agent settings since the
+	// service identity is determined by the service account
+	config, _, _ = options.InitEnvConfig(config)
+
+	log.Println("Trying to determine service details from the pod annotations...")
+	annotations, err := podinfo.ReadAnnotations(annotationsFile)
+	if err != nil {
+		log.Printf("Unable to read pod annotations '%s': %v\n", annotationsFile, err)
+		annotations = map[string]string{}
+	}
+	domain := annotations[DomainAnnotation]
+	if domain == "" {
+		return config, nil, fmt.Errorf("unable to determine domain for namespace %s: missing %s annotation", claims.Namespace(), DomainAnnotation)
+	}
+	service := annotations[ServiceAnnotation]
+	if service == "" {
+		service = claims.ServiceAccount()
+	}
+	return config, &options.ConfigAccount{
+		Domain:       domain,
+		Service:      service,
+		Account:      claims.Namespace(),
+		Name:         fmt.Sprintf("%s.%s", domain, service),
+		Threshold:    options.DEFAULT_THRESHOLD,
+		SshThreshold: options.DEFAULT_THRESHOLD,
+	}, nil
+}
@@ -0,0 +1,173 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
+	"github.com/AthenZ/athenz/libs/go/sia/agent/devel/ztsmock"
+	"github.com/AthenZ/athenz/libs/go/sia/k8s/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/k8s/podinfo"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/AthenZ/athenz/provider/k8s/sia-k8s/devel/issuer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const audience = "https://zts.athenz.io"
+
+var tokenIssuer *issuer.Issuer
+
+func setup() {
+	var err error
+	tokenIssuer, err = issuer.New("http://127.0.0.1:5090")
+	if err != nil {
+		panic(err)
+	}
+	go tokenIssuer.StartIssuerServer("127.0.0.1:5090")
+	go ztsmock.StartZtsServer("127.0.0.1:5089")
+	time.Sleep(3 * time.Second)
+}
+
+func teardown() {}
+
+func TestMain(m *testing.M) {
+	setup()
+	code := m.Run()
+	teardown()
+	os.Exit(code)
+}
+
+func writeToken(t *testing.T, dir string) (string, *podinfo.Claims) {
+	tokenFile := filepath.Join(dir, "token")
+	pod := issuer.Pod{Namespace: "sports", ServiceAccount: "api", Name: "api-7d9f", Uid: "pod-uid-1234"}
+	require.Nil(t, tokenIssuer.WriteToken(tokenFile, pod, audience, time.Hour))
+	_, claims, err := podinfo.ReadToken(tokenFile)
+	require.Nil(t, err)
+	return tokenFile, claims
+}
+
+func TestIssuerToken(t *testing.T) {
+	_, claims := writeToken(t, t.TempDir())
+	assert.Equal(t, "sports", claims.Namespace())
+	assert.Equal(t, "api", claims.ServiceAccount())
+	assert.Equal(t, "http://127.0.0.1:5090", claims.Issuer)
+
+	// the published key set must validate the issued tokens
+	resp, err := http.Get("http://127.0.0.1:5090/openid/v1/jwks")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	var jwks jose.JSONWebKeySet
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&jwks))
+	keys := jwks.Key(tokenIssuer.KeyId)
+	require.Equal(t, 1, len(keys))
+
+	token, err := tokenIssuer.Token(issuer.Pod{Namespace: "sports", ServiceAccount: "api"}, audience, time.Hour)
+	require.Nil(t, err)
+	sig, err := jose.ParseSigned(token)
+	require.Nil(t, err)
+	_, err = sig.Verify(keys[0].Key)
+	assert.Nil(t, err)
+}
+
+func TestGetPodId(t *testing.T) {
+	_, claims := writeToken(t, t.TempDir())
+	assert.Equal(t, "pod-uid-1234", GetPodId(claims))
+
+	t.Setenv("HOSTNAME", "api-7d9f")
+	assert.Equal(t, "api-7d9f", GetPodId(&podinfo.Claims{}))
+	t.Setenv("HOSTNAME", "")
+	assert.Equal(t, "k8sPod", GetPodId(nil))
+}
+
+func TestGetK8SConfigWithConfig(t *testing.T) {
+	dir := t.TempDir()
+	_, claims := writeToken(t, dir)
+
+	configFile := filepath.Join(dir, "sia_config")
+	config := `{"version":"1.0.0","service":"backend","accounts":[{"domain":"media","account":"media"},{"domain":"sports.prod","account":"sports"}]}`
+	require.Nil(t, os.WriteFile(configFile, []byte(config), 0644))
+
+	_, configAccount, err := GetK8SConfig(configFile, filepath.Join(dir, "annotations"), claims)
+	require.Nil(t, err)
+	assert.Equal(t, "sports.prod", configAccount.Domain)
+	assert.Equal(t, "backend", configAccount.Service)
+}
+
+func TestGetK8SConfigWithAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	_, claims := writeToken(t, dir)
+
+	annotationsFile := filepath.Join(dir, "annotations")
+	require.Nil(t, os.WriteFile(annotationsFile, []byte(`athenz.io/domain="sports"`+"\n"), 0644))
+
+	_, configAccount, err := GetK8SConfig(filepath.Join(dir, "sia_config"), annotationsFile, claims)
+	require.Nil(t, err)
+	assert.Equal(t, "sports", configAccount.Domain)
+	assert.Equal(t, "api", configAccount.Service)
+	assert.Equal(t, "sports", configAccount.Account)
+
+	require.Nil(t, os.WriteFile(annotationsFile, []byte(`athenz.io/domain="sports"`+"\n"+`athenz.io/service="frontend"`+"\n"), 0644))
+	_, configAccount, err = GetK8SConfig(filepath.Join(dir, "sia_config"), annotationsFile, claims)
+	require.Nil(t, err)
+	assert.Equal(t, "sports.frontend", configAccount.Name)
+}
+
+func TestGetK8SConfigNoDomain(t *testing.T) {
+	dir := t.TempDir()
+	_, claims := writeToken(t, dir)
+
+	_, _, err := GetK8SConfig(filepath.Join(dir, "sia_config"), filepath.Join(dir, "annotations"), claims)
+	assert.NotNil(t, err)
+}
+
+func TestRegisterInstance(t *testing.T) {
+	siaDir := t.TempDir()
+	tokenFile, claims := writeToken(t, siaDir)
+
+	opts := &options.Options{
+		Domain:           "athenz",
+		Services:         []options.Service{{Name: "hockey", Uid: util.ExecIdCommand("-u"), Gid: util.ExecIdCommand("-g"), FileMode: 0400}},
+		KeyDir:           siaDir,
+		CertDir:          siaDir,
+		BackUpDir:        filepath.Join(siaDir, "backup"),
+		AthenzCACertFile: filepath.Join(siaDir, "ca.cert.pem"),
+		ZTSDomains:       []string{"k8s.athenz.cloud"},
+		InstanceId:       GetPodId(claims),
+	}
+	opts.Provider = NewProvider("athenz.k8s.cluster1", opts, tokenFile)
+
+	data, err := opts.Provider.AttestationData("athenz.hockey", nil, nil)
+	require.Nil(t, err)
+	var attestData attestation.AttestationData
+	require.Nil(t, json.Unmarshal([]byte(data), &attestData))
+	assert.NotEqual(t, "", attestData.Token)
+
+	err = agent.RegisterInstance("http://127.0.0.1:5089/zts/v1", opts, false)
+	require.Nil(t, err, fmt.Sprintf("unable to register instance: %v", err))
+	assert.FileExists(t, filepath.Join(siaDir, "athenz.hockey.key.pem"))
+	assert.FileExists(t, filepath.Join(siaDir, "athenz.hockey.cert.pem"))
+}
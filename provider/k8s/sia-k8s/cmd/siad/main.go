@@ -0,0 +1,127 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
+	"github.com/AthenZ/athenz/libs/go/sia/k8s/podinfo"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/provider/k8s/sia-k8s"
+)
+
+// Following can be set by the build script using LDFLAGS
+
+var Version string
+
+func main() {
+	cmd := flag.String("cmd", "", "optional sub command to run")
+	mode := flag.String("mode", "sidecar", "agent mode: init to register and exit, sidecar to keep refreshing")
+	ztsEndPoint := flag.String("zts", "", "Athenz Token Service (ZTS) endpoint")
+	ztsServerName := flag.String("ztsservername", "", "ZTS server name for tls connections (optional)")
+	ztsCACert := flag.String("ztscacert", "", "Athenz Token Service (ZTS) CA certificate file (optional)")
+	dnsDomains := flag.String("dnsdomains", "", "DNS Domains associated with the provider")
+	ztsPort := flag.Int("ztsport", 4443, "Athenz Token Service (ZTS) port number")
+	pConf := flag.String("config", "/etc/sia/sia_config", "The config file to run against")
+	providerPrefix := flag.String("providerprefix", "", "Provider name prefix e.g athenz.k8s")
+	cluster := flag.String("cluster", "", "Name of the kubernetes cluster")
+	tokenFile := flag.String("token", "/var/run/secrets/athenz.io/token", "Projected service account token file")
+	annotationsFile := flag.String("annotations", "/etc/podinfo/annotations", "Pod annotations file projected by the downward api")
+	siaDir := flag.String("siadir", "/var/lib/sia", "Directory for the keys and certificates, typically a shared emptyDir volume")
+	displayVersion := flag.Bool("version", false, "Display version information")
+	udsPath := flag.String("uds", "", "uds path")
+
+	flag.Parse()
+
+	if *displayVersion {
+		fmt.Println(Version)
+		os.Exit(0)
+	}
+
+	log.SetFlags(log.LstdFlags)
+
+	if *ztsEndPoint == "" {
+		log.Fatalln("missing zts argument")
+	}
+	ztsUrl := fmt.Sprintf("https://%s:%d/zts/v1", *ztsEndPoint, *ztsPort)
+
+	if *dnsDomains == "" {
+		log.Fatalln("missing dnsdomains argument")
+	}
+
+	if *providerPrefix == "" {
+		log.Fatalln("missing providerprefix argument")
+	}
+
+	if *cluster == "" {
+		log.Fatalln("missing cluster argument")
+	}
+
+	siaCmd := *cmd
+	switch *mode {
+	case "init":
+		if siaCmd == "" {
+			siaCmd = "init"
+		}
+	case "sidecar":
+	default:
+		log.Fatalf("invalid mode argument: %s\n", *mode)
+	}
+
+	//the same function is used to reload the configuration
+	//when the agent receives the SIGHUP signal
+	newOptions := func() (*options.Options, error) {
+		_, claims, err := podinfo.ReadToken(*tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read service account token, error: %v", err)
+		}
+
+		config, configAccount, err := sia.GetK8SConfig(*pConf, *annotationsFile, claims)
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate configuration objects, error: %v", err)
+		}
+
+		opts, err := options.NewOptions(config, configAccount, nil, *siaDir, Version, false, "")
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate options, error: %v", err)
+		}
+
+		opts.Version = fmt.Sprintf("SIA-K8S %s", Version)
+		opts.Ssh = false
+		opts.ZTSCACertFile = *ztsCACert
+		opts.ZTSServerName = *ztsServerName
+		opts.ZTSDomains = strings.Split(*dnsDomains, ",")
+		opts.InstanceId = sia.GetPodId(claims)
+		opts.Provider = sia.NewProvider(fmt.Sprintf("%s.%s", *providerPrefix, *cluster), opts, *tokenFile)
+		if *udsPath != "" {
+			opts.SDSUdsPath = *udsPath
+		}
+		return opts, nil
+	}
+
+	opts, err := newOptions()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	agent.RunAgentWithReload(siaCmd, *siaDir, ztsUrl, opts, newOptions)
+}
@@ -0,0 +1,145 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package issuer
+
+// package issuer is a fake kubernetes service account token issuer
+// that generates projected tokens with the same claims as the api
+// server and publishes the signing key in the oidc discovery format
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/k8s/podinfo"
+	"github.com/golang-jwt/jwt"
+	"gopkg.in/square/go-jose.v2"
+)
+
+type Issuer struct {
+	Url   string //issuer url included in the iss claim
+	KeyId string //key id of the signing key
+	key   *rsa.PrivateKey
+}
+
+// Pod contains the pod details the token is bound to
+type Pod struct {
+	Namespace      string
+	ServiceAccount string
+	Name           string
+	Uid            string
+}
+
+// New generates a new signing key for the issuer with the given url
+func New(url string) (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Issuer{
+		Url:   url,
+		KeyId: "sia-k8s-test",
+		key:   key,
+	}, nil
+}
+
+// Token returns a signed service account token for the given pod and
+// audience that expires after the given duration
+func (i *Issuer) Token(pod Pod, audience string, expiry time.Duration) (string, error) {
+	now := time.Now()
+	claims := &podinfo.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  audience,
+			ExpiresAt: now.Add(expiry).Unix(),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			Issuer:    i.Url,
+			Subject:   fmt.Sprintf("system:serviceaccount:%s:%s", pod.Namespace, pod.ServiceAccount),
+		},
+		Kubernetes: podinfo.KubernetesClaims{
+			Namespace:      pod.Namespace,
+			Pod:            podinfo.Ref{Name: pod.Name, Uid: pod.Uid},
+			ServiceAccount: podinfo.Ref{Name: pod.ServiceAccount, Uid: "sa-" + pod.Uid},
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = i.KeyId
+	return token.SignedString(i.key)
+}
+
+// WriteToken writes a new token to the given file the same way
+// the kubelet updates the projected token volume
+func (i *Issuer) WriteToken(tokenFile string, pod Pod, audience string, expiry time.Duration) error {
+	token, err := i.Token(pod, audience, expiry)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tokenFile, []byte(token), 0600)
+}
+
+// JWKS returns the public key set to validate the issued tokens
+func (i *Issuer) JWKS() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{
+				Key:       &i.key.PublicKey,
+				KeyID:     i.KeyId,
+				Algorithm: "RS256",
+				Use:       "sig",
+			},
+		},
+	}
+}
+
+// StartIssuerServer serves the oidc discovery document and the
+// key set at the same paths as the kubernetes api server
+func (i *Issuer) StartIssuerServer(endPoint string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, map[string]interface{}{
+			"issuer":                                i.Url,
+			"jwks_uri":                              i.Url + "/openid/v1/jwks",
+			"response_types_supported":              []string{"id_token"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/openid/v1/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, i.JWKS())
+	})
+
+	log.Println("Starting Token Issuer Mock listening on: " + endPoint)
+	err := http.ListenAndServe(endPoint, mux)
+	if err != nil {
+		log.Fatalf("ListenAndServe: %v\n", err)
+	}
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
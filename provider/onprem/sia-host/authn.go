@@ -0,0 +1,131 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/AthenZ/athenz/libs/go/sia/host/hostdoc"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+)
+
+// GetHostDocument reads the host document and returns the parsed document
+// along with the provider name included in the document
+func GetHostDocument(hostDocFile string) (*hostdoc.Doc, string, error) {
+	docBytes, err := os.ReadFile(hostDocFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read host document %s: %v", hostDocFile, err)
+	}
+	doc, providerName, err := hostdoc.NewPlainDoc(docBytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to parse host document %s: %v", hostDocFile, err)
+	}
+	for i, svc := range doc.Services {
+		doc.Services[i] = strings.TrimSpace(svc)
+	}
+	return doc, providerName, nil
+}
+
+// GetSignatureInfo reads the host document signature if one is available
+func GetSignatureInfo(signatureFile string) (*signature.SignatureInfo, error) {
+	if signatureFile == "" || !util.FileExists(signatureFile) {
+		return nil, nil
+	}
+	sigBytes, err := os.ReadFile(signatureFile)
+	if err != nil {
+		return nil, err
+	}
+	var sigInfo signature.SignatureInfo
+	err = json.Unmarshal(sigBytes, &sigInfo)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse host document signature %s: %v", signatureFile, err)
+	}
+	return &sigInfo, nil
+}
+
+// GetInstanceId returns the uuid from the host document, otherwise the hostname
+func GetInstanceId(doc *hostdoc.Doc) string {
+	if doc.Uuid != "" {
+		return doc.Uuid
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Unable to extract instance hostname: %v\n", err)
+		return ""
+	}
+	return hostname
+}
+
+// GetHostConfig determines the service identities for the host. The domain
+// and services are always taken from the host document since those are the
+// only identities the provider attests for the host. The optional sia_config
+// file carries the agent settings, the service attributes and the roles for
+// the account entry matching the domain in the host document.
+func GetHostConfig(configFile string, doc *hostdoc.Doc) (*options.Config, *options.ConfigAccount, error) {
+	if len(doc.Services) == 0 || doc.Services[0] == "" {
+		return nil, nil, fmt.Errorf("no services listed in the host document")
+	}
+	config := &options.Config{}
+	confBytes, err := os.ReadFile(configFile)
+	if err == nil {
+		err = json.Unmarshal(confBytes, config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse configuration file %s: %v", configFile, err)
+		}
+	} else {
+		log.Printf("Unable to read configuration file '%s': %v\n", configFile, err)
+	}
+	config, _, _ = options.InitEnvConfig(config)
+
+	config.Service = doc.Services[0]
+	if len(doc.Services) > 1 || len(config.Services) != 0 {
+		services := map[string]options.ConfigService{}
+		for _, svc := range doc.Services {
+			services[svc] = config.Services[svc]
+		}
+		for svc := range config.Services {
+			if _, ok := services[svc]; !ok {
+				log.Printf("Ignoring service %s not listed in the host document\n", svc)
+			}
+		}
+		config.Services = services
+	}
+
+	configAccount := &options.ConfigAccount{}
+	for _, account := range config.Accounts {
+		if account.Domain == doc.Domain {
+			*configAccount = account
+			break
+		}
+	}
+	configAccount.Domain = doc.Domain
+	configAccount.Service = config.Service
+	configAccount.Name = fmt.Sprintf("%s.%s", configAccount.Domain, configAccount.Service)
+	if configAccount.Threshold == 0 {
+		configAccount.Threshold = options.DEFAULT_THRESHOLD
+	}
+	if configAccount.SshThreshold == 0 {
+		configAccount.SshThreshold = options.DEFAULT_THRESHOLD
+	}
+	return config, configAccount, nil
+}
@@ -0,0 +1,200 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
+	"github.com/AthenZ/athenz/libs/go/sia/host/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/AthenZ/athenz/provider/onprem/sia-host/devel/attestor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	ztsUrl       = "http://127.0.0.1:5091/zts/v1"
+	providerName = "athenz.onprem.dc1"
+	hostDocument = `{"domain":"sports","service":"api,backend","uuid":"80221ad57182449f2af7e1e926fd56dc","ip":["10.196.66.191"],"provider":"athenz.onprem.dc1"}`
+)
+
+var hostAttestor *attestor.Attestor
+
+func setup() {
+	var err error
+	hostAttestor, err = attestor.New(providerName)
+	if err != nil {
+		panic(err)
+	}
+	go hostAttestor.StartZtsServer("127.0.0.1:5091")
+	time.Sleep(3 * time.Second)
+}
+
+func teardown() {}
+
+func TestMain(m *testing.M) {
+	setup()
+	code := m.Run()
+	teardown()
+	os.Exit(code)
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	fileName := filepath.Join(dir, name)
+	require.Nil(t, os.WriteFile(fileName, []byte(content), 0644))
+	return fileName
+}
+
+func writeBootstrapKey(t *testing.T, dir string) (string, crypto.PublicKey) {
+	key, err := util.GenerateKey(util.ECDSAP256)
+	require.Nil(t, err)
+	return writeFile(t, dir, "bootstrap.key.pem", util.PrivatePem(key)), key.Public()
+}
+
+func TestGetHostDocument(t *testing.T) {
+	dir := t.TempDir()
+	doc, docProvider, err := GetHostDocument(writeFile(t, dir, "host_document", `{"domain":"sports","services":"api, backend","uuid":"1234"}`))
+	require.Nil(t, err)
+	assert.Equal(t, "", docProvider)
+	assert.Equal(t, []string{"api", "backend"}, doc.Services)
+
+	_, docProvider, err = GetHostDocument(writeFile(t, dir, "host_document", hostDocument))
+	require.Nil(t, err)
+	assert.Equal(t, providerName, docProvider)
+
+	_, _, err = GetHostDocument(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+	_, _, err = GetHostDocument(writeFile(t, dir, "host_document", `{"service":"api"}`))
+	assert.NotNil(t, err)
+}
+
+func TestGetSignatureInfo(t *testing.T) {
+	dir := t.TempDir()
+	sigInfo, err := GetSignatureInfo(filepath.Join(dir, "missing"))
+	assert.Nil(t, err)
+	assert.Nil(t, sigInfo)
+
+	sigInfo, err = GetSignatureInfo(writeFile(t, dir, "host_document.sig", `{"signature":"sig","keyid":"cluster1"}`))
+	require.Nil(t, err)
+	assert.Equal(t, "cluster1", sigInfo.Keyid)
+
+	_, err = GetSignatureInfo(writeFile(t, dir, "host_document.sig", `sig`))
+	assert.NotNil(t, err)
+}
+
+func TestGetInstanceId(t *testing.T) {
+	doc, _, err := GetHostDocument(writeFile(t, t.TempDir(), "host_document", hostDocument))
+	require.Nil(t, err)
+	assert.Equal(t, "80221ad5-7182-449f-2af7-e1e926fd56dc", GetInstanceId(doc))
+
+	hostname, _ := os.Hostname()
+	doc.Uuid = ""
+	assert.Equal(t, hostname, GetInstanceId(doc))
+}
+
+func TestGetHostConfig(t *testing.T) {
+	dir := t.TempDir()
+	doc, _, err := GetHostDocument(writeFile(t, dir, "host_document", hostDocument))
+	require.Nil(t, err)
+
+	config, configAccount, err := GetHostConfig(filepath.Join(dir, "sia_config"), doc)
+	require.Nil(t, err)
+	assert.Equal(t, "api", config.Service)
+	assert.Equal(t, 2, len(config.Services))
+	assert.Equal(t, "sports.api", configAccount.Name)
+	assert.Equal(t, options.DEFAULT_THRESHOLD, configAccount.Threshold)
+
+	// services not listed in the host document are ignored
+	configFile := writeFile(t, dir, "sia_config", `{"service":"frontend","services":{"frontend":{},"backend":{"filename":"backend.pem"}},"accounts":[{"domain":"media","account":"1"},{"domain":"sports","account":"2","user":"sports","roles":{"sports:role.readers":{}}}]}`)
+	config, configAccount, err = GetHostConfig(configFile, doc)
+	require.Nil(t, err)
+	assert.Equal(t, "api", config.Service)
+	assert.Equal(t, 2, len(config.Services))
+	assert.Equal(t, "backend.pem", config.Services["backend"].Filename)
+	_, ok := config.Services["frontend"]
+	assert.False(t, ok)
+	assert.Equal(t, "sports", configAccount.User)
+	assert.Equal(t, 1, len(configAccount.Roles))
+
+	_, _, err = GetHostConfig(writeFile(t, dir, "sia_config", `{`), doc)
+	assert.NotNil(t, err)
+}
+
+func newOptions(t *testing.T, siaDir string, bootstrap Bootstrap) *options.Options {
+	doc, _, err := GetHostDocument(writeFile(t, siaDir, "host_document", hostDocument))
+	require.Nil(t, err)
+
+	opts := &options.Options{
+		Domain:           doc.Domain,
+		Services:         []options.Service{{Name: "api", Uid: util.ExecIdCommand("-u"), Gid: util.ExecIdCommand("-g"), FileMode: 0400}},
+		KeyDir:           siaDir,
+		CertDir:          siaDir,
+		BackUpDir:        filepath.Join(siaDir, "backup"),
+		AthenzCACertFile: filepath.Join(siaDir, "ca.cert.pem"),
+		ZTSDomains:       []string{"onprem.athenz.cloud"},
+		InstanceId:       GetInstanceId(doc),
+	}
+	opts.Provider = NewProvider(providerName, opts, doc, nil, bootstrap, ztsUrl, ip.Opts{})
+	return opts
+}
+
+func TestRegisterInstanceBootstrapKey(t *testing.T) {
+	siaDir := t.TempDir()
+	keyFile, publicKey := writeBootstrapKey(t, siaDir)
+	hostAttestor.AddBootstrapKey("bootstrap-1", publicKey)
+
+	opts := newOptions(t, siaDir, Bootstrap{Mode: attestation.ModeBootstrapKey, KeyFile: keyFile, KeyId: "bootstrap-1"})
+	err := agent.RegisterInstance(ztsUrl, opts, false)
+	require.Nil(t, err, fmt.Sprintf("unable to register instance: %v", err))
+	assert.FileExists(t, filepath.Join(siaDir, "sports.api.key.pem"))
+	assert.FileExists(t, filepath.Join(siaDir, "sports.api.cert.pem"))
+
+	err = agent.RefreshInstance(ztsUrl, opts)
+	assert.Nil(t, err, fmt.Sprintf("unable to refresh instance: %v", err))
+}
+
+func TestRegisterInstanceUnknownBootstrapKey(t *testing.T) {
+	siaDir := t.TempDir()
+	keyFile, _ := writeBootstrapKey(t, siaDir)
+
+	opts := newOptions(t, siaDir, Bootstrap{Mode: attestation.ModeBootstrapKey, KeyFile: keyFile, KeyId: "bootstrap-2"})
+	err := agent.RegisterInstance(ztsUrl, opts, false)
+	assert.NotNil(t, err)
+	assert.NoFileExists(t, filepath.Join(siaDir, "sports.api.cert.pem"))
+}
+
+func TestRegisterInstanceToken(t *testing.T) {
+	siaDir := t.TempDir()
+	opts := newOptions(t, siaDir, Bootstrap{Mode: attestation.ModeRegisterToken})
+
+	err := agent.RegisterInstance(ztsUrl, opts, false)
+	require.Nil(t, err, fmt.Sprintf("unable to register instance: %v", err))
+	assert.FileExists(t, filepath.Join(siaDir, "sports.api.cert.pem"))
+
+	// a service not listed in the host document is rejected
+	opts.Services[0].Name = "frontend"
+	err = agent.RegisterInstance(ztsUrl, opts, false)
+	assert.NotNil(t, err)
+}
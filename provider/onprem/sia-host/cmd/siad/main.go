@@ -0,0 +1,139 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AthenZ/athenz/libs/go/sia/agent"
+	"github.com/AthenZ/athenz/libs/go/sia/host/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+	"github.com/AthenZ/athenz/provider/onprem/sia-host"
+)
+
+// Following can be set by the build script using LDFLAGS
+
+var Version string
+
+func main() {
+	cmd := flag.String("cmd", "", "optional sub command to run")
+	ztsEndPoint := flag.String("zts", "", "Athenz Token Service (ZTS) endpoint")
+	ztsServerName := flag.String("ztsservername", "", "ZTS server name for tls connections (optional)")
+	ztsCACert := flag.String("ztscacert", "", "Athenz Token Service (ZTS) CA certificate file (optional)")
+	dnsDomains := flag.String("dnsdomains", "", "DNS Domains associated with the provider")
+	ztsPort := flag.Int("ztsport", 4443, "Athenz Token Service (ZTS) port number")
+	pConf := flag.String("config", "/etc/sia/sia_config", "The config file to run against")
+	hostDoc := flag.String("hostdoc", "/var/lib/sia/host_document", "Host document listing the domain, services and ips of the host")
+	hostDocSig := flag.String("hostdocsig", "/var/lib/sia/host_document.sig", "Host document signature file (optional)")
+	providerName := flag.String("provider", "", "Provider name, defaults to the provider in the host document")
+	attestMode := flag.String("attestation", attestation.ModeBootstrapKey, "Attestation mode: key to sign with the bootstrap key, token to use an instance register token")
+	bootstrapKey := flag.String("bootstrapkey", "/var/lib/sia/bootstrap/key.pem", "Pre-provisioned bootstrap private key")
+	bootstrapCert := flag.String("bootstrapcert", "/var/lib/sia/bootstrap/cert.pem", "Pre-provisioned bootstrap certificate, required for the token attestation mode")
+	bootstrapKeyId := flag.String("keyid", "", "Id of the bootstrap key registered with the provider")
+	siaDir := flag.String("siadir", "/var/lib/sia", "Directory for the keys and certificates")
+	displayVersion := flag.Bool("version", false, "Display version information")
+	udsPath := flag.String("uds", "", "uds path")
+
+	flag.Parse()
+
+	if *displayVersion {
+		fmt.Println(Version)
+		os.Exit(0)
+	}
+
+	log.SetFlags(log.LstdFlags)
+
+	if *ztsEndPoint == "" {
+		log.Fatalln("missing zts argument")
+	}
+	ztsUrl := fmt.Sprintf("https://%s:%d/zts/v1", *ztsEndPoint, *ztsPort)
+
+	if *dnsDomains == "" {
+		log.Fatalln("missing dnsdomains argument")
+	}
+
+	switch *attestMode {
+	case attestation.ModeBootstrapKey:
+	case attestation.ModeRegisterToken:
+	default:
+		log.Fatalf("invalid attestation argument: %s\n", *attestMode)
+	}
+
+	//the same function is used to reload the configuration
+	//when the agent receives the SIGHUP signal
+	newOptions := func() (*options.Options, error) {
+		doc, docProvider, err := sia.GetHostDocument(*hostDoc)
+		if err != nil {
+			return nil, err
+		}
+		name := *providerName
+		if name == "" {
+			name = docProvider
+		}
+		if name == "" {
+			return nil, fmt.Errorf("missing provider in host document %s", *hostDoc)
+		}
+		sigInfo, err := sia.GetSignatureInfo(*hostDocSig)
+		if err != nil {
+			return nil, err
+		}
+
+		config, configAccount, err := sia.GetHostConfig(*pConf, doc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate configuration objects, error: %v", err)
+		}
+
+		opts, err := options.NewOptions(config, configAccount, nil, *siaDir, Version, false, "")
+		if err != nil {
+			return nil, fmt.Errorf("unable to formulate options, error: %v", err)
+		}
+
+		excludeIps, err := ip.GetExcludeOpts(filepath.Join(*siaDir, "exclude_ip"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read exclude ip options, error: %v", err)
+		}
+
+		opts.Version = fmt.Sprintf("SIA-HOST %s", Version)
+		opts.ZTSCACertFile = *ztsCACert
+		opts.ZTSServerName = *ztsServerName
+		opts.ZTSDomains = strings.Split(*dnsDomains, ",")
+		opts.InstanceId = sia.GetInstanceId(doc)
+		opts.Provider = sia.NewProvider(name, opts, doc, sigInfo, sia.Bootstrap{
+			Mode:     *attestMode,
+			KeyFile:  *bootstrapKey,
+			CertFile: *bootstrapCert,
+			KeyId:    *bootstrapKeyId,
+		}, ztsUrl, excludeIps)
+		if *udsPath != "" {
+			opts.SDSUdsPath = *udsPath
+		}
+		return opts, nil
+	}
+
+	opts, err := newOptions()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	agent.RunAgentWithReload(*cmd, *siaDir, ztsUrl, opts, newOptions)
+}
@@ -0,0 +1,320 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package attestor
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/AthenZ/athenz/libs/go/sia/host/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/host/hostdoc"
+	"github.com/AthenZ/athenz/libs/go/sia/util"
+	"github.com/gorilla/mux"
+)
+
+// maximum age of the bootstrap key proof accepted by the attestor
+const proofTimeout = 5 * time.Minute
+
+// Attestor is a fake on-prem attestation provider for local testing. It
+// verifies the host document based attestation data against the registered
+// bootstrap keys and the instance register tokens it issued, and signs the
+// service certificates with its own CA. The ZTS server it starts does not
+// authenticate the callers requesting instance register tokens.
+type Attestor struct {
+	Provider      string //provider name expected in the requests
+	CACertPem     string //CA certificate signing the service certificates
+	caKey         crypto.Signer
+	caCert        *x509.Certificate
+	mutex         sync.Mutex
+	bootstrapKeys map[string]crypto.PublicKey
+	tokens        map[string]string
+}
+
+// New returns a new attestor for the given provider with a generated CA
+func New(provider string) (*Attestor, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Athenz On-Prem Test CA"},
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Attestor{
+		Provider:      provider,
+		CACertPem:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		caKey:         caKey,
+		caCert:        caCert,
+		bootstrapKeys: map[string]crypto.PublicKey{},
+		tokens:        map[string]string{},
+	}, nil
+}
+
+// AddBootstrapKey registers the public key of a pre-provisioned bootstrap key
+func (a *Attestor) AddBootstrapKey(keyId string, key crypto.PublicKey) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.bootstrapKeys[keyId] = key
+}
+
+// IssueToken returns a single use instance register token for the given
+// service identity and instance
+func (a *Attestor) IssueToken(domain, service, instanceId string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.tokens[token] = fmt.Sprintf("%s.%s/%s", domain, service, instanceId)
+	return token, nil
+}
+
+// Verify verifies the attestation data for the given csr. The principal must
+// be one of the services listed in the host document, the csr ips must be
+// listed in the host document and the attestation data must include either a
+// valid proof signed by a registered bootstrap key or an issued token.
+func (a *Attestor) Verify(attestData, instanceId string, csr *x509.CertificateRequest) error {
+	var data attestation.AttestationData
+	err := json.Unmarshal([]byte(attestData), &data)
+	if err != nil {
+		return fmt.Errorf("unable to parse attestation data: %v", err)
+	}
+	doc, _, err := hostdoc.NewPlainDoc([]byte(data.Document))
+	if err != nil {
+		return fmt.Errorf("unable to parse host document: %v", err)
+	}
+	if data.Principal != csr.Subject.CommonName {
+		return fmt.Errorf("principal %s does not match csr common name %s", data.Principal, csr.Subject.CommonName)
+	}
+	domain, service := util.SplitDomain(data.Principal)
+	if doc.Domain != domain || !listed(doc.Services, service) {
+		return fmt.Errorf("principal %s is not listed in the host document", data.Principal)
+	}
+	if doc.Uuid != "" && doc.Uuid != instanceId {
+		return fmt.Errorf("instance id %s does not match host document uuid %s", instanceId, doc.Uuid)
+	}
+	for _, csrIp := range csr.IPAddresses {
+		if !doc.Ip[strings.ToLower(csrIp.String())] {
+			return fmt.Errorf("csr ip %s is not listed in the host document", csrIp.String())
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if data.Token != "" {
+		subject, ok := a.tokens[data.Token]
+		if !ok || subject != fmt.Sprintf("%s/%s", data.Principal, instanceId) {
+			return fmt.Errorf("invalid instance register token for principal %s", data.Principal)
+		}
+		delete(a.tokens, data.Token)
+		return nil
+	}
+	bootstrapKey, ok := a.bootstrapKeys[data.KeyId]
+	if !ok {
+		return fmt.Errorf("unknown bootstrap key id: %s", data.KeyId)
+	}
+	if time.Since(time.Unix(data.Timestamp, 0)) > proofTimeout {
+		return fmt.Errorf("attestation proof for principal %s has expired", data.Principal)
+	}
+	return attestation.VerifyProof(&data, csr.PublicKey, bootstrapKey)
+}
+
+// SignCSR verifies the csr signature and returns the signed certificate
+func (a *Attestor) SignCSR(csr *x509.CertificateRequest) (string, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", err
+	}
+	template := &x509.Certificate{
+		Subject:        csr.Subject,
+		SerialNumber:   serialNumber,
+		NotBefore:      time.Now().Add(-15 * time.Minute),
+		NotAfter:       time.Now().Add(24 * time.Hour),
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           csr.URIs,
+		EmailAddresses: csr.EmailAddresses,
+		KeyUsage:       x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.caCert, csr.PublicKey, a.caKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// StartZtsServer starts a ZTS server supporting the instance register,
+// refresh and register token apis backed by the attestor
+func (a *Attestor) StartZtsServer(endPoint string) {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/zts/v1/instance", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("/instance is called")
+
+		var info zts.InstanceRegisterInformation
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		a.issueIdentity(w, http.StatusCreated, string(info.Provider), info.Csr, info.AttestationData)
+	}).Methods("POST")
+
+	router.HandleFunc("/zts/v1/instance/{provider}/{domain}/{service}/{instanceId}", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("instance refresh handler called")
+
+		var info zts.InstanceRefreshInformation
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		a.issueIdentity(w, http.StatusOK, mux.Vars(r)["provider"], info.Csr, info.AttestationData)
+	}).Methods("POST")
+
+	router.HandleFunc("/zts/v1/instance/{provider}/{domain}/{service}/{instanceId}/token", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("instance register token handler called")
+
+		vars := mux.Vars(r)
+		if vars["provider"] != a.Provider {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown provider: %s", vars["provider"]))
+			return
+		}
+		token, err := a.IssueToken(vars["domain"], vars["service"], vars["instanceId"])
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		tokenBytes, err := json.Marshal(&zts.InstanceRegisterToken{
+			Provider:        zts.ServiceName(a.Provider),
+			Domain:          zts.DomainName(vars["domain"]),
+			Service:         zts.SimpleName(vars["service"]),
+			AttestationData: token,
+		})
+		if err == nil {
+			io.WriteString(w, string(tokenBytes))
+		}
+	}).Methods("GET")
+
+	err := http.ListenAndServe(endPoint, router)
+	if err != nil {
+		log.Fatal("ListenAndServe: ", err)
+	}
+}
+
+func (a *Attestor) issueIdentity(w http.ResponseWriter, status int, provider, csrPem, attestData string) {
+	if provider != a.Provider {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown provider: %s", provider))
+		return
+	}
+	csr, err := decodeCSR(csrPem)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	instanceId := csrInstanceId(csr, provider)
+	if err := a.Verify(attestData, instanceId, csr); err != nil {
+		log.Printf("Unable to verify attestation data: %v\n", err)
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	cert, err := a.SignCSR(csr)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	identityBytes, err := json.Marshal(&zts.InstanceIdentity{
+		Provider:              zts.ServiceName(provider),
+		Name:                  zts.ServiceName(csr.Subject.CommonName),
+		InstanceId:            zts.PathElement(instanceId),
+		X509Certificate:       cert,
+		X509CertificateSigner: a.CACertPem,
+	})
+	if err == nil {
+		w.WriteHeader(status)
+		io.WriteString(w, string(identityBytes))
+		log.Printf("Successfully issued identity for %s\n", csr.Subject.CommonName)
+	}
+}
+
+// csrInstanceId returns the instance id from the athenz://instanceid/<provider>/<id> uri
+func csrInstanceId(csr *x509.CertificateRequest, provider string) string {
+	prefix := fmt.Sprintf("/%s/", provider)
+	for _, uri := range csr.URIs {
+		if uri.Scheme == "athenz" && uri.Host == "instanceid" && strings.HasPrefix(uri.Path, prefix) {
+			return strings.TrimPrefix(uri.Path, prefix)
+		}
+	}
+	return ""
+}
+
+func decodeCSR(csrPem string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPem))
+	if block == nil {
+		return nil, fmt.Errorf("cannot parse CSR (empty pem)")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return csr, csr.CheckSignature()
+}
+
+func listed(services []string, service string) bool {
+	for _, svc := range services {
+		if strings.TrimSpace(svc) == service {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	errBytes, _ := json.Marshal(map[string]interface{}{"code": status, "message": err.Error()})
+	w.Write(errBytes)
+}
@@ -0,0 +1,58 @@
+//
+// Copyright The Athenz Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sia
+
+import (
+	"github.com/AthenZ/athenz/libs/go/sia/host/attestation"
+	"github.com/AthenZ/athenz/libs/go/sia/host/hostdoc"
+	"github.com/AthenZ/athenz/libs/go/sia/host/ip"
+	"github.com/AthenZ/athenz/libs/go/sia/host/provider"
+	"github.com/AthenZ/athenz/libs/go/sia/host/signature"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
+)
+
+// Bootstrap holds the pre-provisioned credentials used to attest the host
+type Bootstrap struct {
+	Mode     string //attestation mode - key or token
+	KeyFile  string //bootstrap private key
+	CertFile string //bootstrap certificate, required for the token mode
+	KeyId    string //id of the bootstrap key registered with the provider
+}
+
+// NewProvider returns the sia agent provider for on-prem hosts. The
+// attestation data includes the host document along with the proof signed
+// by the bootstrap key or the instance register token issued by ZTS. The
+// instance id, dns domains and ZTS settings must already be set in opts.
+func NewProvider(name string, opts *options.Options, doc *hostdoc.Doc, sigInfo *signature.SignatureInfo, bootstrap Bootstrap, ztsUrl string, excludeIps ip.Opts) provider.Provider {
+	return attestation.Provider{
+		Base: provider.Base{
+			Name:       name,
+			InstanceId: opts.InstanceId,
+			Domains:    opts.ZTSDomains,
+		},
+		Doc:               doc,
+		SigInfo:           sigInfo,
+		Mode:              bootstrap.Mode,
+		BootstrapKeyFile:  bootstrap.KeyFile,
+		BootstrapCertFile: bootstrap.CertFile,
+		BootstrapKeyId:    bootstrap.KeyId,
+		ZTSUrl:            ztsUrl,
+		ZTSServerName:     opts.ZTSServerName,
+		ZTSCACertFile:     opts.ZTSCACertFile,
+		ExcludeIps:        excludeIps,
+	}
+}
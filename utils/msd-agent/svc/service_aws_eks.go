@@ -5,8 +5,8 @@ package svc
 
 import (
 	"github.com/AthenZ/athenz/libs/go/athenz-common/log"
-	"github.com/AthenZ/athenz/libs/go/sia/aws/options"
 	"github.com/AthenZ/athenz/libs/go/sia/aws/stssession"
+	"github.com/AthenZ/athenz/libs/go/sia/options"
 	"github.com/AthenZ/athenz/provider/aws/sia-eks"
 )
 